@@ -0,0 +1,221 @@
+package analysis
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/token"
+	"sort"
+	"strings"
+)
+
+// 解析で見つかった、実行されないコードや使われない変数などの問題一つ分。
+type Diagnostic struct {
+	Pos     token.Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// プログラムを実行せずに調べ、間違いの可能性がある箇所をソースコード上の順番で返す。
+//
+//   - return、throwの後にあって実行されない文
+//   - 条件がtrue、false、nullのリテラルで、実行されないifのブロック
+//   - 関数の中でletしたのに使われない変数。_ から始まる名前は除く
+//
+// トップレベルの変数は、importした側やREPLから使われるかもしれないので調べない。
+func Analyze(program *ast.Program) []Diagnostic {
+	a := &analyzer{}
+	a.analyze(program)
+	sort.SliceStable(a.diagnostics, func(i, j int) bool {
+		return a.diagnostics[i].Pos.Offset < a.diagnostics[j].Pos.Offset
+	})
+	return a.diagnostics
+}
+
+type analyzer struct {
+	diagnostics []Diagnostic
+}
+
+func (a *analyzer) report(pos token.Position, format string, args ...interface{}) {
+	a.diagnostics = append(a.diagnostics, Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+func (a *analyzer) analyze(program *ast.Program) {
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Program:
+			a.checkUnreachable(n.Statements)
+		case *ast.BlockStatement:
+			a.checkUnreachable(n.Statements)
+		case *ast.IfExpression:
+			a.checkCondition(n)
+		case *ast.FunctionLiteral:
+			for _, let := range unusedLets(n) {
+				a.report(let.Pos(), "%s is declared but never used", let.Name.Value)
+			}
+		}
+		return true
+	})
+}
+
+func (a *analyzer) checkUnreachable(stmts []ast.Statement) {
+	if i := terminatorIndex(stmts); i >= 0 && i+1 < len(stmts) && stmts[i+1] != nil {
+		a.report(stmts[i+1].Pos(), "unreachable code")
+	}
+}
+
+func (a *analyzer) checkCondition(ie *ast.IfExpression) {
+	value, ok := constantCondition(ie.Condition)
+	if !ok {
+		return
+	}
+	if !value {
+		a.report(ie.Consequence.Pos(), "condition is always false; block is never executed")
+	} else if ie.Alternative != nil {
+		a.report(ie.Alternative.Pos(), "condition is always true; else block is never executed")
+	}
+}
+
+// returnかthrowの文の位置。なければ-1。それより後の文は実行されない。
+func terminatorIndex(stmts []ast.Statement) int {
+	for i, stmt := range stmts {
+		switch stmt.(type) {
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+			return i
+		}
+	}
+	return -1
+}
+
+// 条件がリテラルで、評価しなくても真偽が決まる場合はその値とtrueを返す。
+// 数値や文字列の真偽は評価器の設定で変わりうるので、true、false、nullとその否定だけを扱う。
+func constantCondition(exp ast.Expression) (value bool, ok bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.NullLiteral:
+		return false, true
+	case *ast.PrefixExpression:
+		if exp.Operator == "!" {
+			value, ok := constantCondition(exp.Right)
+			return !value, ok
+		}
+	}
+	return false, false
+}
+
+// 関数の本体で直接letしたのに、関数の中(内側の関数も含む)のどこからも参照されない変数。
+func unusedLets(fl *ast.FunctionLiteral) []*ast.LetStatement {
+	var lets []*ast.LetStatement
+	declared := make(map[*ast.Identifier]bool)
+	ast.Inspect(fl.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.ClassStatement:
+			// 内側の関数のletは、その関数で調べる
+			return false
+		case *ast.LetStatement:
+			if !strings.HasPrefix(n.Name.Value, "_") {
+				lets = append(lets, n)
+				declared[n.Name] = true
+			}
+		}
+		return true
+	})
+	if len(lets) == 0 {
+		return nil
+	}
+
+	used := make(map[string]bool)
+	ast.Inspect(fl.Body, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok && !declared[ident] {
+			used[ident.Value] = true
+		}
+		return true
+	})
+
+	var unused []*ast.LetStatement
+	for _, let := range lets {
+		if !used[let.Name.Value] {
+			unused = append(unused, let)
+		}
+	}
+	return unused
+}
+
+// Analyzeで見つかる実行されないコードと使われない変数を取り除いたプログラムを返す。
+// programはREPLなどで共有されているかもしれないので、ast.Cloneしたものを書き換える。
+// 評価の結果が変わらないように、次の場合は取り除かない。
+//
+//   - 使われない変数の値が、関数呼び出しなどの副作用があるかもしれない式の場合
+//   - 使われない変数のletがブロックの最後の文で、ブロックの値になる場合
+//
+// 実行されないifのブロックは、ifの値がnullのまま変わらないように空のブロックにする。
+func Eliminate(program *ast.Program) *ast.Program {
+	program = ast.Clone(program).(*ast.Program)
+
+	// 先に関数ごとの使われない変数を集めておき、文を取り除く時に使う
+	unused := make(map[*ast.LetStatement]bool)
+	ast.Inspect(program, func(n ast.Node) bool {
+		if fl, ok := n.(*ast.FunctionLiteral); ok {
+			for _, let := range unusedLets(fl) {
+				if isPure(let.Value) {
+					unused[let] = true
+				}
+			}
+		}
+		return true
+	})
+
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Program:
+			n.Statements = eliminateStatements(n.Statements, unused)
+		case *ast.BlockStatement:
+			n.Statements = eliminateStatements(n.Statements, unused)
+		case *ast.IfExpression:
+			if value, ok := constantCondition(n.Condition); ok {
+				if !value {
+					n.Consequence = &ast.BlockStatement{Token: n.Consequence.Token, EndToken: n.Consequence.EndToken}
+				} else {
+					n.Alternative = nil
+				}
+			}
+		}
+		return true
+	})
+	return program
+}
+
+func eliminateStatements(stmts []ast.Statement, unused map[*ast.LetStatement]bool) []ast.Statement {
+	if i := terminatorIndex(stmts); i >= 0 {
+		stmts = stmts[:i+1]
+	}
+	out := make([]ast.Statement, 0, len(stmts))
+	for i, stmt := range stmts {
+		if let, ok := stmt.(*ast.LetStatement); ok && unused[let] && i < len(stmts)-1 {
+			continue
+		}
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// 評価しても副作用のない式かどうか。リテラルと関数リテラルだけを副作用がないものとする。
+// 変数の参照も、変数がなければエラーになるので取り除かない。
+func isPure(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral, *ast.BigIntLiteral, *ast.FloatLiteral, *ast.StringLiteral,
+		*ast.Boolean, *ast.NullLiteral, *ast.FunctionLiteral:
+		return true
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			if !isPure(el) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 1; x;", nil},
+		{"fn() { return 1; 2; 3 }", []string{"1:18: unreachable code"}},
+		{"throw 1; puts(1);", []string{"1:10: unreachable code"}},
+		{"if (false) { 1 }", []string{"1:12: condition is always false; block is never executed"}},
+		{"if (!false) { 1 } else { 2 }", []string{"1:24: condition is always true; else block is never executed"}},
+		{"if (null) { 1 }", []string{"1:11: condition is always false; block is never executed"}},
+		{"if (true) { 1 }", nil},
+		{"if (x) { 1 } else { 2 }", nil},
+		{"fn() { let a = 1; let b = 2; b }", []string{"1:8: a is declared but never used"}},
+		// 内側の関数から使われていれば使われている。_ から始まる名前と、トップレベルの変数は調べない
+		{"fn() { let a = 1; fn() { a } }", nil},
+		{"fn() { let _a = 1; }", nil},
+		{"let a = 1;", nil},
+		{"fn() { let a = 1; fn() { let b = 2; } }", []string{"1:8: a is declared but never used", "1:26: b is declared but never used"}},
+		{"class P(x) { fn get() { let y = 1; self.x } }", []string{"1:25: y is declared but never used"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, d := range Analyze(parse(t, tt.input)) {
+			got = append(got, d.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("diagnostics of %q wrong.\nexpected=%q\ngot=     %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEliminate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { return 1; 2; 3 }", "fn() { return 1; }"},
+		{"if (false) { 1 } else { 2 }", "if (false) {} else { 2 }"},
+		{"if (true) { 1 } else { 2 }", "if (true) { 1 }"},
+		{"fn() { let a = 1; let b = 2; b }", "fn() { let b = 2; b }"},
+		// 副作用があるかもしれない値と、ブロックの最後のletは残す
+		{"fn() { let a = f(); 1 }", "fn() { let a = f(); 1 }"},
+		{"fn() { let a = 1; }", "fn() { let a = 1; }"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		before := ast.Format(program)
+		got := ast.Format(Eliminate(program))
+		expected := ast.Format(parse(t, tt.expected))
+		if got != expected {
+			t.Errorf("Eliminate(%q) wrong.\nexpected=%q\ngot=     %q", tt.input, expected, got)
+		}
+		// 元のプログラムは書き換えない
+		if ast.Format(program) != before {
+			t.Errorf("Eliminate(%q) modified the original program. got=%q", tt.input, ast.Format(program))
+		}
+	}
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
 	"strings"
 
 	"monkey/token"
@@ -11,6 +12,16 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // ノードの先頭の位置
+	End() token.Position // ノードの直後の位置。文の終わりの ; は含まない
+}
+
+// nの直後の位置。パースに失敗してnがnilの場合はfallbackを返す。
+func endOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	return n.End()
 }
 
 // All statement nodes implement this
@@ -27,6 +38,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []*Comment // プログラム中の全てのコメント。ソースコードに出てくる順に並ぶ
 }
 
 func (p *Program) TokenLiteral() string {
@@ -37,6 +49,21 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+// 空のプログラムの場合はゼロ値を返す。
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 // 各ASTノードの中身を確認する。Statementsたちは自身をデバッグするString()を実装していないといけない。
 func (p *Program) String() string {
 	var out bytes.Buffer
@@ -48,6 +75,19 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+// // <text>
+// コメント。評価には関係ないので文としては扱わず、Program.Commentsにまとめて持つ。
+// 整形やドキュメントの生成で、元のソースコードの位置に戻すために位置の情報を使う。
+type Comment struct {
+	Token token.Token // the token.COMMENT token
+	Text  string      // // を含むコメントの文字列
+}
+
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+func (c *Comment) String() string       { return c.Text }
+func (c *Comment) Pos() token.Position  { return c.Token.Pos() }
+func (c *Comment) End() token.Position  { return c.Token.End }
+
 // -------------------
 // Statements
 // -------------------
@@ -60,6 +100,8 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos() }
+func (ls *LetStatement) End() token.Position  { return endOf(ls.Value, ls.Name.End()) }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -76,6 +118,121 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// let [<identifier>, <identifier>, ...] = <expression>;
+// let {<identifier>, <identifier>, ...} = <expression>;
+// 一つの式の評価結果から、複数の変数をまとめて束縛する。
+type LetDestructureStatement struct {
+	Token   token.Token // the token.LET token
+	Pattern Expression  // *ArrayPattern or *HashPattern
+	Value   Expression
+}
+
+func (ls *LetDestructureStatement) statementNode()       {}
+func (ls *LetDestructureStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetDestructureStatement) Pos() token.Position  { return ls.Token.Pos() }
+func (ls *LetDestructureStatement) End() token.Position  { return endOf(ls.Value, ls.Pattern.End()) }
+func (ls *LetDestructureStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ls.TokenLiteral() + " ")
+	out.WriteString(ls.Pattern.String())
+	out.WriteString(" = ")
+
+	if ls.Value != nil {
+		out.WriteString(ls.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// [<identifier>, <identifier>, ...<identifier>]
+// 配列の要素を先頭から順に変数に束縛する。 ... がついた変数には残りの要素を配列として束縛する。
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []*Identifier
+	Rest     *Identifier // 残りの要素を受け取る変数。ない場合はnil
+	EndToken token.Token // the ']' token
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos() }
+func (ap *ArrayPattern) End() token.Position  { return ap.EndToken.End }
+func (ap *ArrayPattern) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+// {<identifier>, <identifier>, ...}
+// 変数名と同じ名前の文字列のキーの値を、その変数に束縛する。
+type HashPattern struct {
+	Token    token.Token // the '{' token
+	Keys     []*Identifier
+	EndToken token.Token // the '}' token
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos() }
+func (hp *HashPattern) End() token.Position  { return hp.EndToken.End }
+func (hp *HashPattern) String() string {
+	var out bytes.Buffer
+
+	keys := []string{}
+	for _, k := range hp.Keys {
+		keys = append(keys, k.String())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(keys, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// const <identifier> = <expression>;
+// letと同じく値を束縛するが、束縛した後に再代入することはできない。
+type ConstStatement struct {
+	Token token.Token // the token.CONST token
+	Name  *Identifier
+	Value Expression
+}
+
+func (cs *ConstStatement) statementNode()       {}
+func (cs *ConstStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ConstStatement) Pos() token.Position  { return cs.Token.Pos() }
+func (cs *ConstStatement) End() token.Position  { return endOf(cs.Value, cs.Name.End()) }
+func (cs *ConstStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(cs.TokenLiteral() + " ")
+	out.WriteString(cs.Name.String())
+	out.WriteString(" = ")
+
+	if cs.Value != nil {
+		out.WriteString(cs.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
 // return <expression>;
 type ReturnStatement struct {
 	Token       token.Token // the 'return' token
@@ -84,6 +241,8 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos() }
+func (rs *ReturnStatement) End() token.Position  { return endOf(rs.ReturnValue, rs.Token.End) }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -98,6 +257,30 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// throw <expression>;
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos() }
+func (ts *ThrowStatement) End() token.Position  { return endOf(ts.Value, ts.Token.End) }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
 	Expression Expression
@@ -105,6 +288,8 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos() }
+func (es *ExpressionStatement) End() token.Position  { return endOf(es.Expression, es.Token.End) }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -112,17 +297,90 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 
+// for (<identifier> in <expression>) <block statement>
+// 配列、ハッシュ、rangeなど、Iterableなオブジェクトの要素を一つずつ取り出してブロックを評価する。
+type ForInStatement struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier // 要素を束縛する変数名
+	Iterable Expression  // in の右側の式。評価の結果がIterableなオブジェクトになる式であればなんでもいい
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) Pos() token.Position  { return fs.Token.Pos() }
+func (fs *ForInStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// class <identifier>(<field>, ...) { fn <identifier>(<parameters>) <block statement> ... }
+// letと同じく、クラス名にクラスを束縛する。クラスを関数のように呼び出すと、フィールドに引数を入れたインスタンスができる。
+type ClassStatement struct {
+	Token    token.Token        // the 'class' token
+	Name     *Identifier        // クラス名
+	Fields   []*Identifier      // フィールド名。インスタンスを作る時の引数の順番でもある
+	Methods  []*FunctionLiteral // メソッド。Nameにメソッド名が入る
+	EndToken token.Token        // 閉じの }
+}
+
+func (cs *ClassStatement) statementNode()       {}
+func (cs *ClassStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ClassStatement) Pos() token.Position  { return cs.Token.Pos() }
+func (cs *ClassStatement) End() token.Position  { return cs.EndToken.End }
+func (cs *ClassStatement) String() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, f := range cs.Fields {
+		fields = append(fields, f.String())
+	}
+
+	out.WriteString("class ")
+	out.WriteString(cs.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString(") {")
+	for _, m := range cs.Methods {
+		out.WriteString(" fn " + m.Name)
+		out.WriteString(strings.TrimPrefix(m.String(), m.TokenLiteral()))
+	}
+	out.WriteString(" }")
+
+	return out.String()
+}
+
 // -------------------
 // Expressions
 // -------------------
 // 変数束縛の名前、関数の名前などのユーザー定義文字列はIdentifierになる
 type Identifier struct {
-	Token token.Token // the token.IDENT token
-	Value string      // ユーザー定義の文字列がここに入る
+	Token   token.Token // the token.IDENT token
+	Value   string      // ユーザー定義の文字列がここに入る
+	Binding *Binding    // 関数のローカル変数として解決された場所。グローバル変数や、解決されていない場合はnil
+}
+
+// resolverパッケージが決めた、関数のローカル変数の場所。
+// Depthは何個外側の関数のスコープに束縛されているか、Slotはそのスコープの何番目の変数か。
+// 評価するときに、スコープを名前で辿らずに変数を取り出せる。
+type Binding struct {
+	Depth int
+	Slot  int
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos() }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 func (i *Identifier) String() string       { return i.Value }
 
 type Boolean struct {
@@ -132,8 +390,21 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos() }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
+// null。評価するといつでも同じNULLオブジェクトになる。
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) Pos() token.Position  { return nl.Token.Pos() }
+func (nl *NullLiteral) End() token.Position  { return nl.Token.End }
+func (nl *NullLiteral) String() string       { return nl.Token.Literal }
+
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64 // 実際の値がここに入る。Token.Literalには文字列で数値が入っているので変換した上で入れる
@@ -141,8 +412,33 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos() }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// int64に収まらない整数のリテラル。 99999999999999999999 のように書くと、評価したときにBigIntになる。
+type BigIntLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bl *BigIntLiteral) expressionNode()      {}
+func (bl *BigIntLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BigIntLiteral) Pos() token.Position  { return bl.Token.Pos() }
+func (bl *BigIntLiteral) End() token.Position  { return bl.Token.End }
+func (bl *BigIntLiteral) String() string       { return bl.Token.Literal }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos() }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, ex: !
 	Operator string      // ! or -
@@ -151,6 +447,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos() }
+func (pe *PrefixExpression) End() token.Position  { return endOf(pe.Right, pe.Token.End) }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -171,6 +469,8 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position  { return endOf(ie.Right, ie.Token.End) }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -193,6 +493,13 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos() }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -209,13 +516,159 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// match (<subject>) { <pattern> => <expression>, <pattern> => <expression>, _ => <expression> }
+// ifと同じく式なので、マッチしたアームの評価結果がmatch式の値になる。
+type MatchExpression struct {
+	Token    token.Token // The 'match' token
+	Subject  Expression  // 比較される値
+	Arms     []*MatchArm // 上から順に比較される
+	EndToken token.Token // the '}' token
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos() }
+func (me *MatchExpression) End() token.Position  { return me.EndToken.End }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+
+	out.WriteString("match (")
+	out.WriteString(me.Subject.String())
+	out.WriteString(") { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// <pattern> => <expression>
+// <pattern> if <guard> => <expression>
+// Bodyには => の右側の式を一文だけ持つBlockStatement、もしくは { } で囲まれたブロックが入る。
+//
+// パターンには次のものが書ける。
+//   - _ はどんな値にもマッチし、何も束縛しない
+//   - 変数名はどんな値にもマッチし、その値を変数に束縛する
+//   - [x, y] は要素数が同じ配列に、[x, ...rest] は要素数が足りる配列にマッチし、要素をそれぞれのパターンと比べる
+//   - {name: n} はそのキーを持つハッシュにマッチし、キーの値をパターンと比べる。ほかのキーがあってもいい
+//   - それ以外の式（1 や "a"、1 + 1 など）は評価して、値が等しければマッチする
+//
+// パターンの中にも、パターンを入れ子にして書ける。
+type MatchArm struct {
+	Token   token.Token // The '=>' token
+	Pattern Expression  // 比較する値。 _ の場合はどんな値にもマッチする
+	Guard   Expression  // if の条件。パターンにマッチしても条件が偽ならこのアームは選ばれない。ない場合はnil
+	Body    *BlockStatement
+}
+
+// _ のアームはどんな値にもマッチするデフォルトのアーム。
+func (ma *MatchArm) IsWildcard() bool {
+	ident, ok := ma.Pattern.(*Identifier)
+	return ok && ident.Value == "_" && ma.Guard == nil
+}
+
+// パターンにマッチしたときに束縛される変数。
+func (ma *MatchArm) Bindings() []*Identifier {
+	return patternBindings(ma.Pattern, nil)
+}
+
+func patternBindings(pattern Expression, idents []*Identifier) []*Identifier {
+	switch pattern := pattern.(type) {
+	case *Identifier:
+		if pattern.Value != "_" {
+			idents = append(idents, pattern)
+		}
+	case *SpreadExpression:
+		idents = patternBindings(pattern.Value, idents)
+	case *ArrayLiteral:
+		for _, el := range pattern.Elements {
+			idents = patternBindings(el, idents)
+		}
+	case *HashLiteral:
+		for _, value := range pattern.Pairs {
+			idents = patternBindings(value, idents)
+		}
+	}
+	return idents
+}
+
+// matchの配列のパターンの要素と、最後の要素が ...rest ならその変数のパターン。 ...rest がなければnil。
+func SplitRestPattern(pattern *ArrayLiteral) ([]Expression, Expression) {
+	elements := pattern.Elements
+	if n := len(elements); n > 0 {
+		if spread, ok := elements[n-1].(*SpreadExpression); ok {
+			return elements[:n-1], spread.Value
+		}
+	}
+	return elements, nil
+}
+
+func (ma *MatchArm) Pos() token.Position { return ma.Pattern.Pos() }
+func (ma *MatchArm) End() token.Position { return ma.Body.End() }
+
+func (ma *MatchArm) String() string {
+	if ma.Guard != nil {
+		return ma.Pattern.String() + " if " + ma.Guard.String() + " => " + ma.Body.String()
+	}
+	return ma.Pattern.String() + " => " + ma.Body.String()
+}
+
+// try <block statement> catch (<identifier>) <block statement>
+// ifと同じく式。例外が発生しなければtryのブロック、発生すればcatchのブロックの評価結果が値になる。
+type TryExpression struct {
+	Token     token.Token // The 'try' token
+	Block     *BlockStatement
+	Parameter *Identifier // catchした値を束縛する変数名
+	Handler   *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos() }
+func (te *TryExpression) End() token.Position  { return te.Handler.End() }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	out.WriteString(" catch (")
+	out.WriteString(te.Parameter.String())
+	out.WriteString(") ")
+	out.WriteString(te.Handler.String())
+
+	return out.String()
+}
+
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
+	EndToken   token.Token // the } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+
+// match式のアームの => expr のように { } がないブロックは、中の文の位置を使う。
+func (bs *BlockStatement) Pos() token.Position {
+	if bs.Token.Type != token.LBRACE && len(bs.Statements) > 0 {
+		return bs.Statements[0].Pos()
+	}
+	return bs.Token.Pos()
+}
+
+func (bs *BlockStatement) End() token.Position {
+	if bs.EndToken.Type == token.RBRACE {
+		return bs.EndToken.End
+	}
+	if len(bs.Statements) > 0 {
+		return bs.Statements[len(bs.Statements)-1].End()
+	}
+	return bs.Token.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -232,11 +685,16 @@ func (bs *BlockStatement) String() string {
 type FunctionLiteral struct {
 	Token      token.Token   // The 'fn' token
 	Parameters []*Identifier // 引数があってもいい。 (<IDENT>, <IDENT>, <IDENT>, ...) なくてもいい ()
+	Rest       *Identifier   // fn(x, ...rest) の rest。可変長引数がない場合はnil
 	Body       *BlockStatement
+	Name       string   // fn <identifier>() {} の形で宣言された関数の名前。関数リテラルの場合は空文字
+	Slots      []string // 関数のスコープに束縛されるローカル変数の名前。Bindingのslotの順番に並ぶ。resolverが設定する
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos() }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -244,6 +702,9 @@ func (fl *FunctionLiteral) String() string {
 	for _, p := range fl.Parameters {
 		params = append(params, p.String())
 	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
 
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
@@ -254,6 +715,54 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// <identifier> = <expression>
+// 宣言済みの変数への再代入。式なので、評価結果は代入した値になる。
+type AssignExpression struct {
+	Token token.Token // the '=' token
+	Name  *Identifier
+	Value Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Name.Pos() }
+func (ae *AssignExpression) End() token.Position  { return endOf(ae.Value, ae.Token.End) }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Name.String())
+	out.WriteString(" = ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// <expression>.<identifier> = <expression>
+// メンバーへの代入。代入できるのは、埋め込む側のgoのプログラムから渡された構造体(GoValue)のフィールドだけ。
+type PropertyAssignExpression struct {
+	Token  token.Token // the '=' token
+	Target *PropertyExpression
+	Value  Expression
+}
+
+func (pa *PropertyAssignExpression) expressionNode()      {}
+func (pa *PropertyAssignExpression) TokenLiteral() string { return pa.Token.Literal }
+func (pa *PropertyAssignExpression) Pos() token.Position  { return pa.Target.Pos() }
+func (pa *PropertyAssignExpression) End() token.Position  { return endOf(pa.Value, pa.Token.End) }
+func (pa *PropertyAssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(pa.Target.String())
+	out.WriteString(" = ")
+	out.WriteString(pa.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // 関数の呼び出し。３パターンある。
 // <expression>()
 // <expression>(<expression>)
@@ -267,10 +776,24 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	EndToken  token.Token // The ')' token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return ce.EndToken.End }
+
+// スタックトレースに表示する、呼び出した関数の名前。名前やメソッド名で呼び出された場合はその名前、それ以外は<anonymous>になる。
+func (ce *CallExpression) CalleeName() string {
+	switch function := ce.Function.(type) {
+	case *Identifier:
+		return function.Value
+	case *PropertyExpression:
+		return function.Property.Value
+	}
+	return "<anonymous>"
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -287,6 +810,19 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// ...<expression>
+// 関数呼び出しの引数と配列リテラルの要素の中でだけ使える。配列の要素を展開する。
+type SpreadExpression struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) Pos() token.Position  { return se.Token.Pos() }
+func (se *SpreadExpression) End() token.Position  { return endOf(se.Value, se.Token.End) }
+func (se *SpreadExpression) String() string       { return "..." + se.Value.String() }
+
 // 文字列も式。（評価すれば文字列が返ってくるので式）
 type StringLiteral struct {
 	Token token.Token
@@ -295,15 +831,20 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos() }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 type ArrayLiteral struct {
 	Token    token.Token  // the '[' token
 	Elements []Expression // 配列の中は式だったらなんでも入れれる。
+	EndToken token.Token  // the ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos() }
+func (al *ArrayLiteral) End() token.Position  { return al.EndToken.End }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -325,18 +866,25 @@ func (al *ArrayLiteral) String() string {
 // myArray[2 + 1]
 // returnArray()[1]
 type IndexExpression struct {
-	Token token.Token // The [ token
-	Left  Expression  // 添字の対象となるもの。[ の左にあるもの。Elementsを持つnodeであればなんでもいい。
-	Index Expression  // 添字。[] の中身。評価の結果、最終的にIntegerとなる式であればなんでもいい
+	Token    token.Token // The [ token
+	Left     Expression  // 添字の対象となるもの。[ の左にあるもの。Elementsを持つnodeであればなんでもいい。
+	Index    Expression  // 添字。[] の中身。評価の結果、最終的にIntegerとなる式であればなんでもいい
+	Optional bool        // left?.[index] の形。Leftがnullの場合はエラーにせずnullを返す
+	EndToken token.Token // The ] token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return ie.EndToken.End }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
 	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("[")
 	out.WriteString(ie.Index.String())
 	out.WriteString("])")
@@ -344,16 +892,49 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// <expression>?.<identifier>
+// ハッシュのプロパティアクセス。 hash?.name は hash["name"] と同じ値になる。
+// Optionalの場合、Leftがnullならエラーにせずnullを返す。
+type PropertyExpression struct {
+	Token    token.Token // The ?. or . token
+	Left     Expression
+	Property *Identifier
+	Optional bool
+}
+
+func (pe *PropertyExpression) expressionNode()      {}
+func (pe *PropertyExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PropertyExpression) Pos() token.Position  { return pe.Left.Pos() }
+func (pe *PropertyExpression) End() token.Position  { return pe.Property.End() }
+func (pe *PropertyExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(pe.Left.String())
+	if pe.Optional {
+		out.WriteString("?.")
+	} else {
+		out.WriteString(".")
+	}
+	out.WriteString(pe.Property.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // { <expression>:<expression>, <expression>:<expression>, ... }
 // キー、値ともに、式を受け入れる。
 // キーは式を評価した結果、文字列、整数、真偽値になるようなものならOK。
 type HashLiteral struct {
-	Token token.Token               // the '{' token
-	Pairs map[Expression]Expression // キーバリューの組み合わせを配列でもつ
+	Token    token.Token               // the '{' token
+	Pairs    map[Expression]Expression // キーバリューの組み合わせを配列でもつ
+	EndToken token.Token               // the '}' token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos() }
+func (hl *HashLiteral) End() token.Position  { return hl.EndToken.End }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -0,0 +1,174 @@
+package ast
+
+import "math/big"
+
+// ノードを深くコピーする。子のノードも全て新しく作るので、コピーを書き換えても元のノードには影響しない。
+// マクロの展開や最適化でASTを書き換える時に、REPLで共有している元のプログラムを壊さないために使う。
+// トークンは値なのでそのままコピーする。
+func Clone(node Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		program := &Program{Statements: cloneStatements(n.Statements)}
+		for _, c := range n.Comments {
+			program.Comments = append(program.Comments, &Comment{Token: c.Token, Text: c.Text})
+		}
+		return program
+	case *Comment:
+		return &Comment{Token: n.Token, Text: n.Text}
+
+	// Statements
+	case *LetStatement:
+		return &LetStatement{Token: n.Token, Name: cloneIdentifier(n.Name), Value: cloneExpression(n.Value)}
+	case *LetDestructureStatement:
+		return &LetDestructureStatement{Token: n.Token, Pattern: cloneExpression(n.Pattern), Value: cloneExpression(n.Value)}
+	case *ConstStatement:
+		return &ConstStatement{Token: n.Token, Name: cloneIdentifier(n.Name), Value: cloneExpression(n.Value)}
+	case *ClassStatement:
+		cs := &ClassStatement{Token: n.Token, Name: cloneIdentifier(n.Name), Fields: cloneIdentifiers(n.Fields),
+			EndToken: n.EndToken}
+		for _, m := range n.Methods {
+			cs.Methods = append(cs.Methods, Clone(m).(*FunctionLiteral))
+		}
+		return cs
+	case *ReturnStatement:
+		return &ReturnStatement{Token: n.Token, ReturnValue: cloneExpression(n.ReturnValue)}
+	case *ThrowStatement:
+		return &ThrowStatement{Token: n.Token, Value: cloneExpression(n.Value)}
+	case *ExpressionStatement:
+		return &ExpressionStatement{Token: n.Token, Expression: cloneExpression(n.Expression)}
+	case *ForInStatement:
+		return &ForInStatement{Token: n.Token, Variable: cloneIdentifier(n.Variable),
+			Iterable: cloneExpression(n.Iterable), Body: cloneBlock(n.Body)}
+	case *BlockStatement:
+		return &BlockStatement{Token: n.Token, Statements: cloneStatements(n.Statements), EndToken: n.EndToken}
+
+	// Expressions
+	case *Identifier:
+		return &Identifier{Token: n.Token, Value: n.Value, Binding: n.Binding}
+	case *Boolean:
+		return &Boolean{Token: n.Token, Value: n.Value}
+	case *NullLiteral:
+		return &NullLiteral{Token: n.Token}
+	case *IntegerLiteral:
+		return &IntegerLiteral{Token: n.Token, Value: n.Value}
+	case *BigIntLiteral:
+		return &BigIntLiteral{Token: n.Token, Value: new(big.Int).Set(n.Value)}
+	case *FloatLiteral:
+		return &FloatLiteral{Token: n.Token, Value: n.Value}
+	case *StringLiteral:
+		return &StringLiteral{Token: n.Token, Value: n.Value}
+	case *ArrayPattern:
+		return &ArrayPattern{Token: n.Token, Elements: cloneIdentifiers(n.Elements),
+			Rest: cloneIdentifier(n.Rest), EndToken: n.EndToken}
+	case *HashPattern:
+		return &HashPattern{Token: n.Token, Keys: cloneIdentifiers(n.Keys), EndToken: n.EndToken}
+	case *PrefixExpression:
+		return &PrefixExpression{Token: n.Token, Operator: n.Operator, Right: cloneExpression(n.Right)}
+	case *InfixExpression:
+		return &InfixExpression{Token: n.Token, Left: cloneExpression(n.Left), Operator: n.Operator,
+			Right: cloneExpression(n.Right)}
+	case *IfExpression:
+		return &IfExpression{Token: n.Token, Condition: cloneExpression(n.Condition),
+			Consequence: cloneBlock(n.Consequence), Alternative: cloneBlock(n.Alternative)}
+	case *MatchExpression:
+		arms := make([]*MatchArm, 0, len(n.Arms))
+		for _, arm := range n.Arms {
+			arms = append(arms, &MatchArm{Token: arm.Token, Pattern: cloneExpression(arm.Pattern),
+				Guard: cloneExpression(arm.Guard), Body: cloneBlock(arm.Body)})
+		}
+		return &MatchExpression{Token: n.Token, Subject: cloneExpression(n.Subject), Arms: arms, EndToken: n.EndToken}
+	case *TryExpression:
+		return &TryExpression{Token: n.Token, Block: cloneBlock(n.Block), Parameter: cloneIdentifier(n.Parameter),
+			Handler: cloneBlock(n.Handler)}
+	case *FunctionLiteral:
+		return &FunctionLiteral{Token: n.Token, Parameters: cloneIdentifiers(n.Parameters),
+			Rest: cloneIdentifier(n.Rest), Body: cloneBlock(n.Body), Name: n.Name,
+			Slots: append([]string(nil), n.Slots...)}
+	case *AssignExpression:
+		return &AssignExpression{Token: n.Token, Name: cloneIdentifier(n.Name), Value: cloneExpression(n.Value)}
+	case *PropertyAssignExpression:
+		return &PropertyAssignExpression{Token: n.Token, Target: Clone(n.Target).(*PropertyExpression),
+			Value: cloneExpression(n.Value)}
+	case *CallExpression:
+		return &CallExpression{Token: n.Token, Function: cloneExpression(n.Function),
+			Arguments: cloneExpressions(n.Arguments), EndToken: n.EndToken}
+	case *SpreadExpression:
+		return &SpreadExpression{Token: n.Token, Value: cloneExpression(n.Value)}
+	case *ArrayLiteral:
+		return &ArrayLiteral{Token: n.Token, Elements: cloneExpressions(n.Elements), EndToken: n.EndToken}
+	case *IndexExpression:
+		return &IndexExpression{Token: n.Token, Left: cloneExpression(n.Left), Index: cloneExpression(n.Index),
+			Optional: n.Optional, EndToken: n.EndToken}
+	case *PropertyExpression:
+		return &PropertyExpression{Token: n.Token, Left: cloneExpression(n.Left),
+			Property: cloneIdentifier(n.Property), Optional: n.Optional}
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		for key, value := range n.Pairs {
+			pairs[cloneExpression(key)] = cloneExpression(value)
+		}
+		return &HashLiteral{Token: n.Token, Pairs: pairs, EndToken: n.EndToken}
+	}
+
+	return nil
+}
+
+// 以下は、nilのままにしておきたい箇所（パースに失敗した箇所、elseのないif など）で
+// 型付きのnilが入ったインターフェースにならないようにするためのもの。
+func cloneExpression(exp Expression) Expression {
+	if exp == nil {
+		return nil
+	}
+	return Clone(exp).(Expression)
+}
+
+func cloneIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	return Clone(ident).(*Identifier)
+}
+
+func cloneBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return Clone(block).(*BlockStatement)
+}
+
+func cloneIdentifiers(idents []*Identifier) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+	out := make([]*Identifier, 0, len(idents))
+	for _, ident := range idents {
+		out = append(out, cloneIdentifier(ident))
+	}
+	return out
+}
+
+func cloneExpressions(exps []Expression) []Expression {
+	if exps == nil {
+		return nil
+	}
+	out := make([]Expression, 0, len(exps))
+	for _, exp := range exps {
+		out = append(out, cloneExpression(exp))
+	}
+	return out
+}
+
+func cloneStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	out := make([]Statement, 0, len(stmts))
+	for _, stmt := range stmts {
+		if stmt == nil {
+			out = append(out, nil)
+			continue
+		}
+		out = append(out, Clone(stmt).(Statement))
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package ast
+
+import (
+	"testing"
+
+	"monkey/token"
+)
+
+func TestClone(t *testing.T) {
+	// let f = fn(x) { if (x) { [x, {"a": -x}] } }; f(1)[0];
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  ident("f"),
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{ident("x")},
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &IfExpression{
+							Condition: ident("x"),
+							Consequence: &BlockStatement{Statements: []Statement{
+								&ExpressionStatement{Expression: &ArrayLiteral{Elements: []Expression{
+									ident("x"),
+									&HashLiteral{Pairs: map[Expression]Expression{
+										&StringLiteral{Value: "a"}: &PrefixExpression{Operator: "-", Right: ident("x")},
+									}},
+								}}},
+							}},
+						}},
+					}},
+				},
+			},
+			&ExpressionStatement{Expression: &IndexExpression{
+				Left:  &CallExpression{Function: ident("f"), Arguments: []Expression{integer(1)}},
+				Index: integer(0),
+			}},
+		},
+	}
+
+	clone := Clone(program).(*Program)
+
+	if clone.String() != program.String() {
+		t.Fatalf("clone differs. expected=%q, got=%q", program.String(), clone.String())
+	}
+
+	// 元のノードとコピーのノードで同じポインタを共有していないこと
+	original := map[Node]bool{}
+	Inspect(program, func(n Node) bool {
+		if n != nil {
+			original[n] = true
+		}
+		return true
+	})
+	count := 0
+	Inspect(clone, func(n Node) bool {
+		if n == nil {
+			return true
+		}
+		count++
+		if original[n] {
+			t.Errorf("node %T (%s) is shared with the original", n, n.String())
+		}
+		return true
+	})
+	if count != len(original) {
+		t.Errorf("wrong number of nodes. want=%d, got=%d", len(original), count)
+	}
+
+	// elseのないifはnilのまま
+	ifExp := clone.Statements[0].(*LetStatement).Value.(*FunctionLiteral).Body.Statements[0].(*ExpressionStatement).Expression.(*IfExpression)
+	if ifExp.Alternative != nil {
+		t.Errorf("Alternative should be nil. got=%+v", ifExp.Alternative)
+	}
+
+	// コピーを書き換えても元は変わらない
+	before := program.String()
+	clone.Statements[0].(*LetStatement).Name.Value = "g"
+	ifExp.Condition = &Boolean{Value: false, Token: token.Token{Literal: "false"}}
+	if program.String() != before {
+		t.Errorf("original was modified. expected=%q, got=%q", before, program.String())
+	}
+}
@@ -0,0 +1,230 @@
+package ast
+
+// 二つのノードが構造として等しいかどうかを判定する。
+// トークン（位置やリテラルの書き方）は比較しない。 {name: 1} と {"name": 1} のように書き方が違っても、同じASTであれば等しい。
+// パース結果をString()で比較すると、ハッシュの順番や括弧のつけ方に左右されるので、テストなどではこちらを使う。
+// コメントはプログラムの意味に関係ないので比較しない。
+func Equal(a, b Node) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	switch x := a.(type) {
+	case *Program:
+		y, ok := b.(*Program)
+		return ok && equalStatements(x.Statements, y.Statements)
+	case *Comment:
+		y, ok := b.(*Comment)
+		return ok && x.Text == y.Text
+
+	// Statements
+	case *LetStatement:
+		y, ok := b.(*LetStatement)
+		return ok && equalIdentifier(x.Name, y.Name) && equalExpression(x.Value, y.Value)
+	case *PropertyAssignExpression:
+		y, ok := b.(*PropertyAssignExpression)
+		return ok && equalExpression(x.Target, y.Target) && equalExpression(x.Value, y.Value)
+	case *LetDestructureStatement:
+		y, ok := b.(*LetDestructureStatement)
+		return ok && equalExpression(x.Pattern, y.Pattern) && equalExpression(x.Value, y.Value)
+	case *ConstStatement:
+		y, ok := b.(*ConstStatement)
+		return ok && equalIdentifier(x.Name, y.Name) && equalExpression(x.Value, y.Value)
+	case *ClassStatement:
+		y, ok := b.(*ClassStatement)
+		if !ok || !equalIdentifier(x.Name, y.Name) || !equalIdentifiers(x.Fields, y.Fields) ||
+			len(x.Methods) != len(y.Methods) {
+			return false
+		}
+		for i := range x.Methods {
+			if !Equal(x.Methods[i], y.Methods[i]) {
+				return false
+			}
+		}
+		return true
+	case *ReturnStatement:
+		y, ok := b.(*ReturnStatement)
+		return ok && equalExpression(x.ReturnValue, y.ReturnValue)
+	case *ThrowStatement:
+		y, ok := b.(*ThrowStatement)
+		return ok && equalExpression(x.Value, y.Value)
+	case *ExpressionStatement:
+		y, ok := b.(*ExpressionStatement)
+		return ok && equalExpression(x.Expression, y.Expression)
+	case *ForInStatement:
+		y, ok := b.(*ForInStatement)
+		return ok && equalIdentifier(x.Variable, y.Variable) && equalExpression(x.Iterable, y.Iterable) &&
+			equalBlock(x.Body, y.Body)
+	case *BlockStatement:
+		y, ok := b.(*BlockStatement)
+		return ok && equalStatements(x.Statements, y.Statements)
+
+	// Expressions
+	case *Identifier:
+		y, ok := b.(*Identifier)
+		return ok && x.Value == y.Value
+	case *Boolean:
+		y, ok := b.(*Boolean)
+		return ok && x.Value == y.Value
+	case *NullLiteral:
+		_, ok := b.(*NullLiteral)
+		return ok
+	case *IntegerLiteral:
+		y, ok := b.(*IntegerLiteral)
+		return ok && x.Value == y.Value
+	case *BigIntLiteral:
+		y, ok := b.(*BigIntLiteral)
+		return ok && x.Value.Cmp(y.Value) == 0
+	case *FloatLiteral:
+		y, ok := b.(*FloatLiteral)
+		return ok && x.Value == y.Value
+	case *StringLiteral:
+		y, ok := b.(*StringLiteral)
+		return ok && x.Value == y.Value
+	case *ArrayPattern:
+		y, ok := b.(*ArrayPattern)
+		return ok && equalIdentifiers(x.Elements, y.Elements) && equalIdentifier(x.Rest, y.Rest)
+	case *HashPattern:
+		y, ok := b.(*HashPattern)
+		return ok && equalIdentifiers(x.Keys, y.Keys)
+	case *PrefixExpression:
+		y, ok := b.(*PrefixExpression)
+		return ok && x.Operator == y.Operator && equalExpression(x.Right, y.Right)
+	case *InfixExpression:
+		y, ok := b.(*InfixExpression)
+		return ok && x.Operator == y.Operator && equalExpression(x.Left, y.Left) && equalExpression(x.Right, y.Right)
+	case *IfExpression:
+		y, ok := b.(*IfExpression)
+		return ok && equalExpression(x.Condition, y.Condition) && equalBlock(x.Consequence, y.Consequence) &&
+			equalBlock(x.Alternative, y.Alternative)
+	case *MatchExpression:
+		y, ok := b.(*MatchExpression)
+		if !ok || !equalExpression(x.Subject, y.Subject) || len(x.Arms) != len(y.Arms) {
+			return false
+		}
+		for i := range x.Arms {
+			if !equalExpression(x.Arms[i].Pattern, y.Arms[i].Pattern) || !equalExpression(x.Arms[i].Guard, y.Arms[i].Guard) ||
+				!equalBlock(x.Arms[i].Body, y.Arms[i].Body) {
+				return false
+			}
+		}
+		return true
+	case *TryExpression:
+		y, ok := b.(*TryExpression)
+		return ok && equalBlock(x.Block, y.Block) && equalIdentifier(x.Parameter, y.Parameter) &&
+			equalBlock(x.Handler, y.Handler)
+	case *FunctionLiteral:
+		y, ok := b.(*FunctionLiteral)
+		return ok && x.Name == y.Name && equalIdentifiers(x.Parameters, y.Parameters) &&
+			equalIdentifier(x.Rest, y.Rest) && equalBlock(x.Body, y.Body)
+	case *AssignExpression:
+		y, ok := b.(*AssignExpression)
+		return ok && equalIdentifier(x.Name, y.Name) && equalExpression(x.Value, y.Value)
+	case *CallExpression:
+		y, ok := b.(*CallExpression)
+		return ok && equalExpression(x.Function, y.Function) && equalExpressions(x.Arguments, y.Arguments)
+	case *SpreadExpression:
+		y, ok := b.(*SpreadExpression)
+		return ok && equalExpression(x.Value, y.Value)
+	case *ArrayLiteral:
+		y, ok := b.(*ArrayLiteral)
+		return ok && equalExpressions(x.Elements, y.Elements)
+	case *IndexExpression:
+		y, ok := b.(*IndexExpression)
+		return ok && x.Optional == y.Optional && equalExpression(x.Left, y.Left) && equalExpression(x.Index, y.Index)
+	case *PropertyExpression:
+		y, ok := b.(*PropertyExpression)
+		return ok && x.Optional == y.Optional && equalExpression(x.Left, y.Left) &&
+			equalIdentifier(x.Property, y.Property)
+	case *HashLiteral:
+		y, ok := b.(*HashLiteral)
+		return ok && equalPairs(x.Pairs, y.Pairs)
+	}
+
+	return false
+}
+
+// 以下は、型付きのnil（elseのないifの Alternative など）をNodeにするとnilと判定できなくなるのを避けるためのもの。
+func equalExpression(a, b Expression) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return Equal(a, b)
+}
+
+func equalIdentifier(a, b *Identifier) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Value == b.Value
+}
+
+func equalBlock(a, b *BlockStatement) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return equalStatements(a.Statements, b.Statements)
+}
+
+func equalIdentifiers(a, b []*Identifier) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalIdentifier(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalExpressions(a, b []Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalExpression(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStatements(a, b []Statement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) {
+			return false
+		}
+		if a[i] != nil && !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ハッシュのペアはmapなので順番が決まっていない。
+// aのペアごとに、bの中からまだ使っていない等しいペアを探す。
+func equalPairs(a, b map[Expression]Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	used := map[Expression]bool{}
+	for ak, av := range a {
+		found := false
+		for bk, bv := range b {
+			if !used[bk] && equalExpression(ak, bk) && equalExpression(av, bv) {
+				used[bk] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package ast
+
+import (
+	"testing"
+
+	"monkey/token"
+)
+
+func TestEqual(t *testing.T) {
+	str := func(tokenType token.TokenType, v string) *StringLiteral {
+		return &StringLiteral{Token: token.Token{Type: tokenType, Literal: v, Line: 3}, Value: v}
+	}
+	hash := func(keys ...string) *HashLiteral {
+		pairs := map[Expression]Expression{}
+		for i, k := range keys {
+			pairs[str(token.STRING, k)] = integer(int64(i))
+		}
+		return &HashLiteral{Pairs: pairs}
+	}
+
+	tests := []struct {
+		a, b     Node
+		expected bool
+	}{
+		{ident("x"), ident("x"), true},
+		{ident("x"), ident("y"), false},
+		{ident("x"), str(token.STRING, "x"), false},
+		{integer(1), &IntegerLiteral{Token: token.Token{Literal: "1", Line: 5, Column: 2}, Value: 1}, true},
+		// トークンの種類や位置は比較しない
+		{str(token.IDENT, "a"), str(token.STRING, "a"), true},
+		{
+			&InfixExpression{Left: integer(1), Operator: "+", Right: integer(2)},
+			&InfixExpression{Left: integer(1), Operator: "+", Right: integer(2)},
+			true,
+		},
+		{
+			&InfixExpression{Left: integer(1), Operator: "+", Right: integer(2)},
+			&InfixExpression{Left: integer(1), Operator: "-", Right: integer(2)},
+			false,
+		},
+		{&IfExpression{Condition: ident("x"), Consequence: &BlockStatement{}}, &IfExpression{Condition: ident("x"), Consequence: &BlockStatement{}}, true},
+		{
+			&IfExpression{Condition: ident("x"), Consequence: &BlockStatement{}},
+			&IfExpression{Condition: ident("x"), Consequence: &BlockStatement{}, Alternative: &BlockStatement{}},
+			false,
+		},
+		{&FunctionLiteral{Parameters: []*Identifier{ident("a")}, Body: &BlockStatement{}}, &FunctionLiteral{Parameters: []*Identifier{ident("a")}, Rest: ident("b"), Body: &BlockStatement{}}, false},
+		// ハッシュのペアの順番は関係ない
+		{hash("a", "b", "c"), hash("a", "b", "c"), true},
+		{hash("a", "b"), hash("b", "a"), false},
+		{hash("a"), hash("a", "b"), false},
+		{&Program{Statements: []Statement{&ExpressionStatement{Expression: ident("x")}}}, &Program{Statements: []Statement{&ExpressionStatement{Expression: ident("x")}}}, true},
+		{&Program{}, &Program{Statements: []Statement{&ExpressionStatement{Expression: ident("x")}}}, false},
+		{nil, nil, true},
+		{ident("x"), nil, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d] - Equal wrong. expected=%t, got=%t", i, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package ast
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"monkey/token"
+)
+
+// 1段のインデント
+const indent = "  "
+
+// ノードをインデントされたMonkeyのソースコードにする。monkey fmt の元になるもの。
+// String()はデバッグ用で、全てを一行にまとめたり { } を省いたりするので、パースし直せる形にはならない。
+// Formatの結果はパースし直すと同じASTになる。
+//
+// 書式のルール
+// - 文は一行に一つ。let、const、return、throw と式文は ; で終わる。ブロックで終わる if、match、try と関数宣言は ; をつけない
+// - ブロックの中は1段インデントする
+// - 括弧は優先順位や結合性を変えるために必要な場所にだけつける
+// - 元のソースコードで文の間に空行があった場合は、一行の空行にまとめて残す
+// - Programのコメントは、文の前の行か、文と同じ行の後ろに書く。式の途中にあるコメントは、次の文の前に移る
+// - ただし配列とハッシュのリテラルの中にコメントがある場合は、要素を一行に一つずつ書き、コメントは要素の前の行か後ろに残す
+func Format(node Node) string {
+	f := &formatter{}
+	if program, ok := node.(*Program); ok {
+		f.comments = program.Comments
+	}
+	f.node(node)
+	return f.out.String()
+}
+
+type formatter struct {
+	out      bytes.Buffer
+	depth    int
+	comments []*Comment // まだ書いていないコメント
+	lastLine int        // 最後に書いた文かコメントの、元のソースコードでの行番号
+}
+
+func (f *formatter) write(s string) {
+	f.out.WriteString(s)
+}
+
+func (f *formatter) newline() {
+	f.write("\n")
+	f.write(strings.Repeat(indent, f.depth))
+}
+
+func (f *formatter) node(node Node) {
+	switch n := node.(type) {
+	case *Program:
+		f.statements(n.Statements, int(^uint(0)>>1)) // 残りのコメントは全て最後に書く
+		if f.out.Len() > 0 {
+			f.write("\n")
+		}
+	case *BlockStatement:
+		f.block(n)
+	case Statement:
+		f.statement(n)
+	case Expression:
+		f.expression(n)
+	}
+}
+
+// 文を一行ずつ書く。
+// 文の前にあるコメントはその文の前の行に、文と同じ行にあるコメントは文の後ろに書く。
+// endより前にある残りのコメント（ブロックの最後の文の後にあるコメントなど）は、最後の文の後に書く。
+func (f *formatter) statements(stmts []Statement, end int) {
+	first := true
+	// 二つ目以降の要素の前で改行する。元のソースコードで空行があった場合は空行を一つ入れる。
+	separate := func(line int) {
+		if !first {
+			if f.lastLine > 0 && line > f.lastLine+1 {
+				f.write("\n")
+			}
+			f.newline()
+		}
+		first = false
+	}
+
+	for i, stmt := range stmts {
+		for f.commentBefore(stmt.Pos().Offset) {
+			c := f.nextComment()
+			separate(c.Pos().Line)
+			f.write(c.Text)
+			f.lastLine = c.Pos().Line
+		}
+
+		separate(stmt.Pos().Line)
+		f.statement(stmt)
+		// if (x) { a } の次の文が ( や [ で始まると、関数呼び出しや添字としてつながってしまうので ; で区切る。
+		if i < len(stmts)-1 && endsWithBlock(stmt) && startsWithBracket(stmts[i+1]) {
+			f.write(";")
+		}
+		f.lastLine = stmt.End().Line
+
+		if len(f.comments) > 0 && f.comments[0].Pos().Line == f.lastLine && f.comments[0].Pos().Offset >= stmt.End().Offset {
+			f.write(" " + f.nextComment().Text)
+		}
+	}
+
+	for f.commentBefore(end) {
+		c := f.nextComment()
+		separate(c.Pos().Line)
+		f.write(c.Text)
+		f.lastLine = c.Pos().Line
+	}
+}
+
+// まだ書いていないコメントのうち、先頭のものがoffsetより前にあるかどうか。
+func (f *formatter) commentBefore(offset int) bool {
+	return len(f.comments) > 0 && f.comments[0].Pos().Offset < offset
+}
+
+func (f *formatter) nextComment() *Comment {
+	c := f.comments[0]
+	f.comments = f.comments[1:]
+	return c
+}
+
+// ; をつけずにブロックで終わる式文
+func endsWithBlock(stmt Statement) bool {
+	es, ok := stmt.(*ExpressionStatement)
+	if !ok {
+		return false
+	}
+	switch es.Expression.(type) {
+	case *IfExpression, *MatchExpression, *TryExpression:
+		return true
+	}
+	return false
+}
+
+func startsWithBracket(stmt Statement) bool {
+	s := Format(stmt)
+	return strings.HasPrefix(s, "(") || strings.HasPrefix(s, "[")
+}
+
+func (f *formatter) statement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *LetStatement:
+		// fn name() {} で宣言された関数は、宣言の形に戻す。
+		if fn, ok := s.Value.(*FunctionLiteral); ok && fn.Name != "" && fn.Name == s.Name.Value {
+			f.write("fn " + fn.Name)
+			f.functionSignature(fn)
+			return
+		}
+		f.write("let " + s.Name.Value + " = ")
+		f.expression(s.Value)
+		f.write(";")
+	case *LetDestructureStatement:
+		f.write("let ")
+		f.expression(s.Pattern)
+		f.write(" = ")
+		f.expression(s.Value)
+		f.write(";")
+	case *ConstStatement:
+		f.write("const " + s.Name.Value + " = ")
+		f.expression(s.Value)
+		f.write(";")
+	case *ClassStatement:
+		fields := []string{}
+		for _, field := range s.Fields {
+			fields = append(fields, field.Value)
+		}
+		f.write("class " + s.Name.Value + "(" + strings.Join(fields, ", ") + ") {")
+		f.depth++
+		for _, m := range s.Methods {
+			f.newline()
+			f.write("fn " + m.Name)
+			f.functionSignature(m)
+		}
+		f.depth--
+		if len(s.Methods) > 0 {
+			f.newline()
+		}
+		f.write("}")
+	case *ReturnStatement:
+		f.write("return")
+		if s.ReturnValue != nil {
+			f.write(" ")
+			f.expression(s.ReturnValue)
+		}
+		f.write(";")
+	case *ThrowStatement:
+		f.write("throw ")
+		f.expression(s.Value)
+		f.write(";")
+	case *ForInStatement:
+		f.write("for (" + s.Variable.Value + " in ")
+		f.expression(s.Iterable)
+		f.write(") ")
+		f.block(s.Body)
+	case *BlockStatement:
+		f.block(s)
+	case *ExpressionStatement:
+		f.expression(s.Expression)
+		if !endsWithBlock(s) {
+			f.write(";")
+		}
+	}
+}
+
+func (f *formatter) block(block *BlockStatement) {
+	// } より前にあるコメントはこのブロックの中のもの。
+	// match式のアームの => expr のような { } のないブロックの場合は、中にコメントはない。
+	end := -1
+	if block.EndToken.Type == token.RBRACE {
+		end = block.EndToken.Offset
+	}
+
+	if len(block.Statements) == 0 && !f.commentBefore(end) {
+		f.write("{}")
+		return
+	}
+
+	f.write("{")
+	f.depth++
+	f.newline()
+	f.statements(block.Statements, end)
+	f.depth--
+	f.newline()
+	f.write("}")
+}
+
+// 式の優先順位。パーサーの優先順位と同じ並び。
+// astはparserに依存できないので、ここで持っておく。
+const (
+	precUnknown = iota // 追加された演算子など、優先順位がわからないもの
+	precAssign
+	precNullish
+	precEquals
+	precLessGreater
+	precSum
+	precProduct
+	precPrefix
+	precPostfix // 関数呼び出し、添字、リテラルなど、括弧がいらないもの
+)
+
+var operatorPrecedences = map[string]int{
+	"??": precNullish,
+	"==": precEquals,
+	"!=": precEquals,
+	"<":  precLessGreater,
+	">":  precLessGreater,
+	"+":  precSum,
+	"-":  precSum,
+	"*":  precProduct,
+	"/":  precProduct,
+	"%":  precProduct,
+}
+
+func precedenceOf(exp Expression) int {
+	switch e := exp.(type) {
+	case *AssignExpression, *PropertyAssignExpression:
+		return precAssign
+	case *InfixExpression:
+		return operatorPrecedences[e.Operator]
+	case *PrefixExpression:
+		return precPrefix
+	}
+	return precPostfix
+}
+
+// 優先順位がminより低い式は括弧で囲む。
+func (f *formatter) operand(exp Expression, min int) {
+	if precedenceOf(exp) < min {
+		f.write("(")
+		f.expression(exp)
+		f.write(")")
+		return
+	}
+	f.expression(exp)
+}
+
+func (f *formatter) expression(exp Expression) {
+	switch e := exp.(type) {
+	case nil:
+	case *Identifier:
+		f.write(e.Value)
+	case *IntegerLiteral:
+		f.write(strconv.FormatInt(e.Value, 10))
+	case *BigIntLiteral:
+		f.write(e.Value.String())
+	case *FloatLiteral:
+		f.write(formatFloat(e.Value))
+	case *StringLiteral:
+		f.write(`"` + e.Value + `"`)
+	case *Boolean:
+		f.write(strconv.FormatBool(e.Value))
+	case *NullLiteral:
+		f.write("null")
+	case *PrefixExpression:
+		f.write(e.Operator)
+		f.operand(e.Right, precPrefix)
+	case *InfixExpression:
+		f.infix(e)
+	case *AssignExpression:
+		// = は右結合なので右側に括弧はいらない。
+		f.write(e.Name.Value + " = ")
+		f.expression(e.Value)
+	case *PropertyAssignExpression:
+		f.expression(e.Target)
+		f.write(" = ")
+		f.expression(e.Value)
+	case *SpreadExpression:
+		f.write("...")
+		f.operand(e.Value, precPrefix)
+	case *CallExpression:
+		f.operand(e.Function, precPostfix)
+		f.write("(")
+		f.expressions(e.Arguments)
+		f.write(")")
+	case *IndexExpression:
+		f.operand(e.Left, precPostfix)
+		if e.Optional {
+			f.write("?.")
+		}
+		f.write("[")
+		f.expression(e.Index)
+		f.write("]")
+	case *PropertyExpression:
+		f.operand(e.Left, precPostfix)
+		if e.Optional {
+			f.write("?.")
+		} else {
+			f.write(".")
+		}
+		f.write(e.Property.Value)
+	case *ArrayLiteral:
+		if f.commentBetween(e.Pos().Offset, e.EndToken.Offset) {
+			f.multiline("[", "]", e.Pos().Offset, e.EndToken.Offset, e.Elements, f.expression, Expression.End)
+			return
+		}
+		f.write("[")
+		f.expressions(e.Elements)
+		f.write("]")
+	case *HashLiteral:
+		f.hash(e)
+	case *ArrayPattern:
+		names := []string{}
+		for _, el := range e.Elements {
+			names = append(names, el.Value)
+		}
+		if e.Rest != nil {
+			names = append(names, "..."+e.Rest.Value)
+		}
+		f.write("[" + strings.Join(names, ", ") + "]")
+	case *HashPattern:
+		names := []string{}
+		for _, k := range e.Keys {
+			names = append(names, k.Value)
+		}
+		f.write("{" + strings.Join(names, ", ") + "}")
+	case *FunctionLiteral:
+		f.write("fn")
+		f.functionSignature(e)
+	case *IfExpression:
+		f.write("if (")
+		f.expression(e.Condition)
+		f.write(") ")
+		f.block(e.Consequence)
+		if e.Alternative != nil {
+			f.write(" else ")
+			f.block(e.Alternative)
+		}
+	case *TryExpression:
+		f.write("try ")
+		f.block(e.Block)
+		f.write(" catch (" + e.Parameter.Value + ") ")
+		f.block(e.Handler)
+	case *MatchExpression:
+		f.match(e)
+	}
+}
+
+func (f *formatter) expressions(exps []Expression) {
+	for i, exp := range exps {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expression(exp)
+	}
+}
+
+// 左結合の演算子は右側、右結合の演算子は左側に同じ優先順位の式が来たら括弧で囲む。
+// 1 - (2 - 3) と (a ?? b) ?? c はそのままだと括弧の位置が変わってしまうので。
+func (f *formatter) infix(e *InfixExpression) {
+	prec := operatorPrecedences[e.Operator]
+	if prec == precUnknown {
+		// 優先順位がわからない演算子は、どう結合されるかわからないので演算子を含む式は全て括弧で囲む。
+		f.operand(e.Left, precPostfix)
+		f.write(" " + e.Operator + " ")
+		f.operand(e.Right, precPostfix)
+		return
+	}
+
+	left, right := prec, prec+1
+	if e.Operator == "??" {
+		left, right = prec+1, prec
+	}
+	f.operand(e.Left, left)
+	f.write(" " + e.Operator + " ")
+	f.operand(e.Right, right)
+}
+
+func (f *formatter) functionSignature(fn *FunctionLiteral) {
+	params := []string{}
+	for _, p := range fn.Parameters {
+		params = append(params, p.Value)
+	}
+	if fn.Rest != nil {
+		params = append(params, "..."+fn.Rest.Value)
+	}
+	f.write("(" + strings.Join(params, ", ") + ") ")
+	f.block(fn.Body)
+}
+
+func (f *formatter) hash(hl *HashLiteral) {
+	keys := SortedHashKeys(hl)
+	pair := func(key Expression) {
+		f.hashKey(key)
+		f.write(": ")
+		f.expression(hl.Pairs[key])
+	}
+	if f.commentBetween(hl.Pos().Offset, hl.EndToken.Offset) {
+		// ハッシュの要素の終わりは値の終わり
+		endOf := func(key Expression) token.Position { return hl.Pairs[key].End() }
+		f.multiline("{", "}", hl.Pos().Offset, hl.EndToken.Offset, keys, pair, endOf)
+		return
+	}
+
+	f.write("{")
+	for i, key := range keys {
+		if i > 0 {
+			f.write(", ")
+		}
+		pair(key)
+	}
+	f.write("}")
+}
+
+func (f *formatter) hashKey(key Expression) {
+	switch k := key.(type) {
+	case *StringLiteral:
+		// {name: 1} と書かれたキーはそのまま書く。
+		if k.Token.Type == token.IDENT {
+			f.write(k.Value)
+		} else {
+			f.expression(k)
+		}
+	case *Identifier:
+		// 変数の値をキーにする場合は [ ] で囲まないと文字列のキーになってしまう。
+		f.write("[" + k.Value + "]")
+	default:
+		f.expression(k)
+	}
+}
+
+// 中にコメントがある配列やハッシュのリテラルは、要素を一行に一つずつ , をつけて書く。
+// 要素の前の行にあるコメントはその要素の前の行に、要素と同じ行にあるコメントは要素の , の後ろに書く。
+// 一行にまとめると、コメントがリテラルの外の次の文の前に移ってしまうので。
+// elementsは配列の要素かハッシュのキーで、writeで一つ分を書き、endOfで一つ分の終わりの位置を返す。
+func (f *formatter) multiline(
+	open, close string,
+	start, end int,
+	elements []Expression,
+	write func(Expression),
+	endOf func(Expression) token.Position,
+) {
+	f.write(open)
+	f.depth++
+	for i, el := range elements {
+		for f.commentBetween(start, el.Pos().Offset) {
+			f.newline()
+			f.write(f.takeComment(start).Text)
+		}
+		f.newline()
+		write(el)
+		f.write(",")
+
+		last := endOf(el)
+		next := end
+		if i < len(elements)-1 {
+			next = elements[i+1].Pos().Offset
+		}
+		if j := f.commentIndex(start); j < len(f.comments) {
+			c := f.comments[j]
+			if c.Pos().Offset >= last.Offset && c.Pos().Offset < next && c.Pos().Line == last.Line {
+				f.write(" " + f.takeComment(start).Text)
+			}
+		}
+	}
+	for f.commentBetween(start, end) {
+		f.newline()
+		f.write(f.takeComment(start).Text)
+	}
+	f.depth--
+	f.newline()
+	f.write(close)
+}
+
+// まだ書いていないコメントのうち、startより後にある最初のものの位置。
+// 式の途中にあってまだ書いていないコメントが、startより前に残っていることがある。
+func (f *formatter) commentIndex(start int) int {
+	i := 0
+	for i < len(f.comments) && f.comments[i].Pos().Offset <= start {
+		i++
+	}
+	return i
+}
+
+// startとendの間に、まだ書いていないコメントがあるかどうか。
+func (f *formatter) commentBetween(start, end int) bool {
+	i := f.commentIndex(start)
+	return i < len(f.comments) && f.comments[i].Pos().Offset < end
+}
+
+// startより後にある最初のコメントを取り出す。
+func (f *formatter) takeComment(start int) *Comment {
+	i := f.commentIndex(start)
+	c := f.comments[i]
+	f.comments = append(f.comments[:i:i], f.comments[i+1:]...)
+	return c
+}
+
+func (f *formatter) match(me *MatchExpression) {
+	f.write("match (")
+	f.expression(me.Subject)
+	f.write(") {")
+	f.depth++
+	for _, arm := range me.Arms {
+		f.newline()
+		f.expression(arm.Pattern)
+		if arm.Guard != nil {
+			f.write(" if ")
+			f.expression(arm.Guard)
+		}
+		f.write(" => ")
+		f.matchArmBody(arm.Body)
+		f.write(",")
+	}
+	f.depth--
+	f.newline()
+	f.write("}")
+}
+
+// { } で書かれたアームはブロックのまま、 => expr の形のアームは式だけを書く。
+// ただし式がハッシュリテラルの場合は { から始まるとブロックと区別がつかないので、ブロックとして書く。
+func (f *formatter) matchArmBody(body *BlockStatement) {
+	if body.Token.Type != token.LBRACE && len(body.Statements) == 1 {
+		if stmt, ok := body.Statements[0].(*ExpressionStatement); ok {
+			if _, isHash := stmt.Expression.(*HashLiteral); !isHash {
+				f.expression(stmt.Expression)
+				return
+			}
+		}
+	}
+	f.block(body)
+}
+
+// 小数を書く。整数と区別がつくように、 2.0 のような値でも小数点をつける。
+func formatFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
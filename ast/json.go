@@ -0,0 +1,500 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"monkey/token"
+)
+
+// ASTをJSONにする。
+// 各ノードは {"Node": "<ノードの型名>", "Token": {...}, <フィールド名>: <値>, ...} というオブジェクトになる。
+// フィールド名はgoの構造体のフィールド名そのまま。子のノードも同じ形で入れ子になる。
+// 他の言語で書かれたツールにASTを渡したり、パース結果をファイルにキャッシュしたりするのに使う。
+func Encode(node Node) ([]byte, error) {
+	return json.Marshal(encodeNode(node))
+}
+
+// Encodeで作ったJSONからASTを組み立て直す。
+func Decode(data []byte) (Node, error) {
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return decodeNode(raw)
+}
+
+type jsonObject map[string]interface{}
+
+func encodeNode(node Node) interface{} {
+	switch n := node.(type) {
+	case *Program:
+		comments := []interface{}{}
+		for _, c := range n.Comments {
+			comments = append(comments, encodeNode(c))
+		}
+		return jsonObject{"Node": "Program", "Statements": encodeStatements(n.Statements), "Comments": comments}
+	case *Comment:
+		return jsonObject{"Node": "Comment", "Token": n.Token, "Text": n.Text}
+
+	// Statements
+	case *LetStatement:
+		return jsonObject{"Node": "LetStatement", "Token": n.Token,
+			"Name": encodeIdentifier(n.Name), "Value": encodeNode(n.Value)}
+	case *LetDestructureStatement:
+		return jsonObject{"Node": "LetDestructureStatement", "Token": n.Token,
+			"Pattern": encodeNode(n.Pattern), "Value": encodeNode(n.Value)}
+	case *ConstStatement:
+		return jsonObject{"Node": "ConstStatement", "Token": n.Token,
+			"Name": encodeIdentifier(n.Name), "Value": encodeNode(n.Value)}
+	case *ClassStatement:
+		methods := []interface{}{}
+		for _, m := range n.Methods {
+			methods = append(methods, encodeNode(m))
+		}
+		return jsonObject{"Node": "ClassStatement", "Token": n.Token, "Name": encodeIdentifier(n.Name),
+			"Fields": encodeIdentifiers(n.Fields), "Methods": methods, "EndToken": n.EndToken}
+	case *ReturnStatement:
+		return jsonObject{"Node": "ReturnStatement", "Token": n.Token, "ReturnValue": encodeNode(n.ReturnValue)}
+	case *ThrowStatement:
+		return jsonObject{"Node": "ThrowStatement", "Token": n.Token, "Value": encodeNode(n.Value)}
+	case *ExpressionStatement:
+		return jsonObject{"Node": "ExpressionStatement", "Token": n.Token, "Expression": encodeNode(n.Expression)}
+	case *ForInStatement:
+		return jsonObject{"Node": "ForInStatement", "Token": n.Token, "Variable": encodeIdentifier(n.Variable),
+			"Iterable": encodeNode(n.Iterable), "Body": encodeBlock(n.Body)}
+	case *BlockStatement:
+		return jsonObject{"Node": "BlockStatement", "Token": n.Token,
+			"Statements": encodeStatements(n.Statements), "EndToken": n.EndToken}
+
+	// Expressions
+	case *Identifier:
+		return jsonObject{"Node": "Identifier", "Token": n.Token, "Value": n.Value}
+	case *Boolean:
+		return jsonObject{"Node": "Boolean", "Token": n.Token, "Value": n.Value}
+	case *NullLiteral:
+		return jsonObject{"Node": "NullLiteral", "Token": n.Token}
+	case *IntegerLiteral:
+		return jsonObject{"Node": "IntegerLiteral", "Token": n.Token, "Value": n.Value}
+	case *BigIntLiteral:
+		return jsonObject{"Node": "BigIntLiteral", "Token": n.Token, "Value": n.Value}
+	case *FloatLiteral:
+		return jsonObject{"Node": "FloatLiteral", "Token": n.Token, "Value": n.Value}
+	case *StringLiteral:
+		return jsonObject{"Node": "StringLiteral", "Token": n.Token, "Value": n.Value}
+	case *ArrayPattern:
+		return jsonObject{"Node": "ArrayPattern", "Token": n.Token, "Elements": encodeIdentifiers(n.Elements),
+			"Rest": encodeIdentifier(n.Rest), "EndToken": n.EndToken}
+	case *HashPattern:
+		return jsonObject{"Node": "HashPattern", "Token": n.Token, "Keys": encodeIdentifiers(n.Keys),
+			"EndToken": n.EndToken}
+	case *PrefixExpression:
+		return jsonObject{"Node": "PrefixExpression", "Token": n.Token, "Operator": n.Operator,
+			"Right": encodeNode(n.Right)}
+	case *InfixExpression:
+		return jsonObject{"Node": "InfixExpression", "Token": n.Token, "Left": encodeNode(n.Left),
+			"Operator": n.Operator, "Right": encodeNode(n.Right)}
+	case *IfExpression:
+		return jsonObject{"Node": "IfExpression", "Token": n.Token, "Condition": encodeNode(n.Condition),
+			"Consequence": encodeBlock(n.Consequence), "Alternative": encodeBlock(n.Alternative)}
+	case *MatchExpression:
+		arms := []interface{}{}
+		for _, arm := range n.Arms {
+			arms = append(arms, jsonObject{"Token": arm.Token, "Pattern": encodeNode(arm.Pattern),
+				"Guard": encodeNode(arm.Guard), "Body": encodeBlock(arm.Body)})
+		}
+		return jsonObject{"Node": "MatchExpression", "Token": n.Token, "Subject": encodeNode(n.Subject),
+			"Arms": arms, "EndToken": n.EndToken}
+	case *TryExpression:
+		return jsonObject{"Node": "TryExpression", "Token": n.Token, "Block": encodeBlock(n.Block),
+			"Parameter": encodeIdentifier(n.Parameter), "Handler": encodeBlock(n.Handler)}
+	case *FunctionLiteral:
+		return jsonObject{"Node": "FunctionLiteral", "Token": n.Token, "Parameters": encodeIdentifiers(n.Parameters),
+			"Rest": encodeIdentifier(n.Rest), "Body": encodeBlock(n.Body), "Name": n.Name}
+	case *AssignExpression:
+		return jsonObject{"Node": "AssignExpression", "Token": n.Token, "Name": encodeIdentifier(n.Name),
+			"Value": encodeNode(n.Value)}
+	case *PropertyAssignExpression:
+		return jsonObject{"Node": "PropertyAssignExpression", "Token": n.Token, "Target": encodeNode(n.Target),
+			"Value": encodeNode(n.Value)}
+	case *CallExpression:
+		return jsonObject{"Node": "CallExpression", "Token": n.Token, "Function": encodeNode(n.Function),
+			"Arguments": encodeExpressions(n.Arguments), "EndToken": n.EndToken}
+	case *SpreadExpression:
+		return jsonObject{"Node": "SpreadExpression", "Token": n.Token, "Value": encodeNode(n.Value)}
+	case *ArrayLiteral:
+		return jsonObject{"Node": "ArrayLiteral", "Token": n.Token, "Elements": encodeExpressions(n.Elements),
+			"EndToken": n.EndToken}
+	case *IndexExpression:
+		return jsonObject{"Node": "IndexExpression", "Token": n.Token, "Left": encodeNode(n.Left),
+			"Index": encodeNode(n.Index), "Optional": n.Optional, "EndToken": n.EndToken}
+	case *PropertyExpression:
+		return jsonObject{"Node": "PropertyExpression", "Token": n.Token, "Left": encodeNode(n.Left),
+			"Property": encodeIdentifier(n.Property), "Optional": n.Optional}
+	case *HashLiteral:
+		// mapのままだとキーがノードなのでJSONにできない。キーとバリューの組の配列にする。
+		pairs := []interface{}{}
+		for _, key := range SortedHashKeys(n) {
+			pairs = append(pairs, jsonObject{"Key": encodeNode(key), "Value": encodeNode(n.Pairs[key])})
+		}
+		return jsonObject{"Node": "HashLiteral", "Token": n.Token, "Pairs": pairs, "EndToken": n.EndToken}
+	}
+
+	// nil（パースに失敗した箇所など）
+	return nil
+}
+
+// 以下は *Identifier などの型付きのnilをNodeに入れると、nilではないNodeになってしまうのを避けるためのもの。
+func encodeIdentifier(ident *Identifier) interface{} {
+	if ident == nil {
+		return nil
+	}
+	return encodeNode(ident)
+}
+
+func encodeBlock(block *BlockStatement) interface{} {
+	if block == nil {
+		return nil
+	}
+	return encodeNode(block)
+}
+
+func encodeIdentifiers(idents []*Identifier) []interface{} {
+	list := []interface{}{}
+	for _, ident := range idents {
+		list = append(list, encodeIdentifier(ident))
+	}
+	return list
+}
+
+func encodeExpressions(exps []Expression) []interface{} {
+	list := []interface{}{}
+	for _, exp := range exps {
+		list = append(list, encodeNode(exp))
+	}
+	return list
+}
+
+func encodeStatements(stmts []Statement) []interface{} {
+	list := []interface{}{}
+	for _, stmt := range stmts {
+		list = append(list, encodeNode(stmt))
+	}
+	return list
+}
+
+// JSONのオブジェクトからノードのフィールドを取り出す。
+// 途中で失敗した場合は最初のエラーを覚えておき、以降の取り出しは何もしない。
+type decoder struct {
+	fields map[string]json.RawMessage
+	err    error
+}
+
+func (d *decoder) value(key string, v interface{}) {
+	if d.err != nil {
+		return
+	}
+	raw, ok := d.fields[key]
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		d.err = fmt.Errorf("%s: %v", key, err)
+	}
+}
+
+func (d *decoder) token(key string) token.Token {
+	var tok token.Token
+	d.value(key, &tok)
+	return tok
+}
+
+func (d *decoder) string(key string) string {
+	var s string
+	d.value(key, &s)
+	return s
+}
+
+func (d *decoder) bool(key string) bool {
+	var b bool
+	d.value(key, &b)
+	return b
+}
+
+func (d *decoder) node(raw json.RawMessage) Node {
+	if d.err != nil || raw == nil {
+		return nil
+	}
+	node, err := decodeNode(raw)
+	if err != nil {
+		d.err = err
+	}
+	return node
+}
+
+func (d *decoder) expression(key string) Expression {
+	node := d.node(d.fields[key])
+	if node == nil {
+		return nil
+	}
+	exp, ok := node.(Expression)
+	if !ok {
+		d.err = fmt.Errorf("%s: %T is not an expression", key, node)
+	}
+	return exp
+}
+
+func (d *decoder) identifier(key string) *Identifier {
+	node := d.node(d.fields[key])
+	if node == nil {
+		return nil
+	}
+	ident, ok := node.(*Identifier)
+	if !ok {
+		d.err = fmt.Errorf("%s: %T is not an identifier", key, node)
+	}
+	return ident
+}
+
+func (d *decoder) property(key string) *PropertyExpression {
+	node := d.node(d.fields[key])
+	if node == nil {
+		return nil
+	}
+	prop, ok := node.(*PropertyExpression)
+	if !ok {
+		d.err = fmt.Errorf("%s: %T is not a property expression", key, node)
+	}
+	return prop
+}
+
+func (d *decoder) block(key string) *BlockStatement {
+	node := d.node(d.fields[key])
+	if node == nil {
+		return nil
+	}
+	block, ok := node.(*BlockStatement)
+	if !ok {
+		d.err = fmt.Errorf("%s: %T is not a block statement", key, node)
+	}
+	return block
+}
+
+// 配列のフィールドを、要素ごとのデコーダーとして取り出す。
+func (d *decoder) list(key string) []*decoder {
+	var raws []json.RawMessage
+	d.value(key, &raws)
+
+	list := []*decoder{}
+	for _, raw := range raws {
+		el := &decoder{fields: map[string]json.RawMessage{"": raw}}
+		list = append(list, el)
+	}
+	return list
+}
+
+func (d *decoder) identifiers(key string) []*Identifier {
+	idents := []*Identifier{}
+	for _, el := range d.list(key) {
+		idents = append(idents, el.identifier(""))
+		d.absorb(el)
+	}
+	return idents
+}
+
+func (d *decoder) expressions(key string) []Expression {
+	exps := []Expression{}
+	for _, el := range d.list(key) {
+		exps = append(exps, el.expression(""))
+		d.absorb(el)
+	}
+	return exps
+}
+
+func (d *decoder) statements(key string) []Statement {
+	stmts := []Statement{}
+	for _, el := range d.list(key) {
+		node := el.node(el.fields[""])
+		d.absorb(el)
+		stmt, ok := node.(Statement)
+		if !ok {
+			if d.err == nil {
+				d.err = fmt.Errorf("%s: %T is not a statement", key, node)
+			}
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// 要素のデコーダーで起きたエラーを引き継ぐ。
+func (d *decoder) absorb(el *decoder) {
+	if d.err == nil {
+		d.err = el.err
+	}
+}
+
+// 配列の要素がオブジェクトの場合（MatchArmやハッシュのペア）に、そのフィールドを読むデコーダーを作る。
+func (d *decoder) object() *decoder {
+	el := &decoder{}
+	if raw, ok := d.fields[""]; ok {
+		if err := json.Unmarshal(raw, &el.fields); err != nil {
+			el.err = err
+		}
+	}
+	return el
+}
+
+func decodeNode(raw json.RawMessage) (Node, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	// null
+	if fields == nil {
+		return nil, nil
+	}
+
+	d := &decoder{fields: fields}
+	var node Node
+
+	switch kind := d.string("Node"); kind {
+	case "Program":
+		program := &Program{Statements: d.statements("Statements")}
+		for _, el := range d.list("Comments") {
+			comment := el.object()
+			program.Comments = append(program.Comments, &Comment{Token: comment.token("Token"), Text: comment.string("Text")})
+			d.absorb(el)
+			d.absorb(comment)
+		}
+		node = program
+	case "Comment":
+		node = &Comment{Token: d.token("Token"), Text: d.string("Text")}
+
+	// Statements
+	case "LetStatement":
+		node = &LetStatement{Token: d.token("Token"), Name: d.identifier("Name"), Value: d.expression("Value")}
+	case "LetDestructureStatement":
+		node = &LetDestructureStatement{Token: d.token("Token"), Pattern: d.expression("Pattern"),
+			Value: d.expression("Value")}
+	case "ConstStatement":
+		node = &ConstStatement{Token: d.token("Token"), Name: d.identifier("Name"), Value: d.expression("Value")}
+	case "ClassStatement":
+		cs := &ClassStatement{Token: d.token("Token"), Name: d.identifier("Name"), Fields: d.identifiers("Fields"),
+			EndToken: d.token("EndToken")}
+		for _, exp := range d.expressions("Methods") {
+			fn, ok := exp.(*FunctionLiteral)
+			if !ok {
+				if d.err == nil {
+					d.err = fmt.Errorf("Methods: %T is not a function literal", exp)
+				}
+				continue
+			}
+			cs.Methods = append(cs.Methods, fn)
+		}
+		node = cs
+	case "ReturnStatement":
+		node = &ReturnStatement{Token: d.token("Token"), ReturnValue: d.expression("ReturnValue")}
+	case "ThrowStatement":
+		node = &ThrowStatement{Token: d.token("Token"), Value: d.expression("Value")}
+	case "ExpressionStatement":
+		node = &ExpressionStatement{Token: d.token("Token"), Expression: d.expression("Expression")}
+	case "ForInStatement":
+		node = &ForInStatement{Token: d.token("Token"), Variable: d.identifier("Variable"),
+			Iterable: d.expression("Iterable"), Body: d.block("Body")}
+	case "BlockStatement":
+		node = &BlockStatement{Token: d.token("Token"), Statements: d.statements("Statements"),
+			EndToken: d.token("EndToken")}
+
+	// Expressions
+	case "Identifier":
+		node = &Identifier{Token: d.token("Token"), Value: d.string("Value")}
+	case "Boolean":
+		node = &Boolean{Token: d.token("Token"), Value: d.bool("Value")}
+	case "NullLiteral":
+		node = &NullLiteral{Token: d.token("Token")}
+	case "IntegerLiteral":
+		var value int64
+		d.value("Value", &value)
+		node = &IntegerLiteral{Token: d.token("Token"), Value: value}
+	case "BigIntLiteral":
+		value := new(big.Int)
+		d.value("Value", value)
+		node = &BigIntLiteral{Token: d.token("Token"), Value: value}
+	case "FloatLiteral":
+		var value float64
+		d.value("Value", &value)
+		node = &FloatLiteral{Token: d.token("Token"), Value: value}
+	case "StringLiteral":
+		node = &StringLiteral{Token: d.token("Token"), Value: d.string("Value")}
+	case "ArrayPattern":
+		node = &ArrayPattern{Token: d.token("Token"), Elements: d.identifiers("Elements"),
+			Rest: d.identifier("Rest"), EndToken: d.token("EndToken")}
+	case "HashPattern":
+		node = &HashPattern{Token: d.token("Token"), Keys: d.identifiers("Keys"), EndToken: d.token("EndToken")}
+	case "PrefixExpression":
+		node = &PrefixExpression{Token: d.token("Token"), Operator: d.string("Operator"), Right: d.expression("Right")}
+	case "InfixExpression":
+		node = &InfixExpression{Token: d.token("Token"), Left: d.expression("Left"),
+			Operator: d.string("Operator"), Right: d.expression("Right")}
+	case "IfExpression":
+		node = &IfExpression{Token: d.token("Token"), Condition: d.expression("Condition"),
+			Consequence: d.block("Consequence"), Alternative: d.block("Alternative")}
+	case "MatchExpression":
+		me := &MatchExpression{Token: d.token("Token"), Subject: d.expression("Subject"), EndToken: d.token("EndToken")}
+		for _, el := range d.list("Arms") {
+			arm := el.object()
+			me.Arms = append(me.Arms, &MatchArm{Token: arm.token("Token"), Pattern: arm.expression("Pattern"),
+				Guard: arm.expression("Guard"), Body: arm.block("Body")})
+			d.absorb(el)
+			d.absorb(arm)
+		}
+		node = me
+	case "TryExpression":
+		node = &TryExpression{Token: d.token("Token"), Block: d.block("Block"),
+			Parameter: d.identifier("Parameter"), Handler: d.block("Handler")}
+	case "FunctionLiteral":
+		node = &FunctionLiteral{Token: d.token("Token"), Parameters: d.identifiers("Parameters"),
+			Rest: d.identifier("Rest"), Body: d.block("Body"), Name: d.string("Name")}
+	case "AssignExpression":
+		node = &AssignExpression{Token: d.token("Token"), Name: d.identifier("Name"), Value: d.expression("Value")}
+	case "PropertyAssignExpression":
+		node = &PropertyAssignExpression{Token: d.token("Token"), Target: d.property("Target"), Value: d.expression("Value")}
+	case "CallExpression":
+		node = &CallExpression{Token: d.token("Token"), Function: d.expression("Function"),
+			Arguments: d.expressions("Arguments"), EndToken: d.token("EndToken")}
+	case "SpreadExpression":
+		node = &SpreadExpression{Token: d.token("Token"), Value: d.expression("Value")}
+	case "ArrayLiteral":
+		node = &ArrayLiteral{Token: d.token("Token"), Elements: d.expressions("Elements"),
+			EndToken: d.token("EndToken")}
+	case "IndexExpression":
+		node = &IndexExpression{Token: d.token("Token"), Left: d.expression("Left"), Index: d.expression("Index"),
+			Optional: d.bool("Optional"), EndToken: d.token("EndToken")}
+	case "PropertyExpression":
+		node = &PropertyExpression{Token: d.token("Token"), Left: d.expression("Left"),
+			Property: d.identifier("Property"), Optional: d.bool("Optional")}
+	case "HashLiteral":
+		hl := &HashLiteral{Token: d.token("Token"), Pairs: make(map[Expression]Expression),
+			EndToken: d.token("EndToken")}
+		for _, el := range d.list("Pairs") {
+			pair := el.object()
+			key := pair.expression("Key")
+			hl.Pairs[key] = pair.expression("Value")
+			d.absorb(el)
+			d.absorb(pair)
+		}
+		node = hl
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown node type: %q", kind)
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
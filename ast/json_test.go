@@ -0,0 +1,123 @@
+package ast
+
+import (
+	"testing"
+
+	"monkey/token"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	// let f = fn(x, ...rest) { if (x) { return rest[0]; } else { {"a": [1, -x]} } };
+	// match (f(1)) { 1 => null, _ => try { throw "e"; } catch (e) { e } };
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Line: 1, Column: 1},
+				Name:  ident("f"),
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{ident("x")},
+					Rest:       ident("rest"),
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &IfExpression{
+							Token:     token.Token{Type: token.IF, Literal: "if"},
+							Condition: ident("x"),
+							Consequence: &BlockStatement{Statements: []Statement{
+								&ReturnStatement{
+									Token:       token.Token{Type: token.RETURN, Literal: "return"},
+									ReturnValue: &IndexExpression{Left: ident("rest"), Index: integer(0)},
+								},
+							}},
+							Alternative: &BlockStatement{Statements: []Statement{
+								&ExpressionStatement{Expression: &HashLiteral{Pairs: map[Expression]Expression{
+									&StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a"}, Value: "a"}: &ArrayLiteral{
+										Elements: []Expression{integer(1), &PrefixExpression{Operator: "-", Right: ident("x")}},
+									},
+								}}},
+							}},
+						}},
+					}},
+				},
+			},
+			&ExpressionStatement{Expression: &MatchExpression{
+				Token:   token.Token{Type: token.MATCH, Literal: "match"},
+				Subject: &CallExpression{Function: ident("f"), Arguments: []Expression{integer(1)}},
+				Arms: []*MatchArm{
+					{Pattern: integer(1), Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &NullLiteral{Token: token.Token{Type: token.NULL, Literal: "null"}}},
+					}}},
+					{Pattern: ident("_"), Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &TryExpression{
+							Token: token.Token{Type: token.TRY, Literal: "try"},
+							Block: &BlockStatement{Statements: []Statement{
+								&ThrowStatement{
+									Token: token.Token{Type: token.THROW, Literal: "throw"},
+									Value: &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "e"}, Value: "e"},
+								},
+							}},
+							Parameter: ident("e"),
+							Handler:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("e")}}},
+						}},
+					}}},
+				},
+			}},
+		},
+	}
+
+	data, err := Encode(program)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	node, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	decoded, ok := node.(*Program)
+	if !ok {
+		t.Fatalf("decoded node is not *Program. got=%T", node)
+	}
+	if decoded.String() != program.String() {
+		t.Errorf("decoded program wrong.\nexpected=%q\ngot=%q", program.String(), decoded.String())
+	}
+
+	let := decoded.Statements[0].(*LetStatement)
+	if let.Token.Line != 1 || let.Token.Column != 1 {
+		t.Errorf("token position was not kept. got=%d:%d", let.Token.Line, let.Token.Column)
+	}
+	if fn := let.Value.(*FunctionLiteral); fn.Rest == nil || fn.Rest.Value != "rest" {
+		t.Errorf("rest parameter was not kept. got=%+v", fn.Rest)
+	}
+
+	// もう一度エンコードすると同じJSONになる
+	again, err := Encode(decoded)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("re-encoded JSON differs.\nexpected=%s\ngot=%s", data, again)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"Node": "Foo"}`, `unknown node type: "Foo"`},
+		{`{"Node": "LetStatement", "Name": {"Node": "IntegerLiteral", "Value": 1}}`, "Name: *ast.IntegerLiteral is not an identifier"},
+		{`{"Node": "Program", "Statements": [{"Node": "Identifier", "Value": "x"}]}`, "Statements: *ast.Identifier is not a statement"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.input))
+		if err == nil {
+			t.Errorf("expected error for %s", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package ast
+
+import (
+	"strconv"
+	"strings"
+
+	"monkey/token"
+)
+
+// ノードをLispのS式の形にする。 let x = 1 + 2 * 3; は (let x (+ 1 (* 2 3))) になる。
+// String()と違って、どの演算子がどの式を引数にとっているかが括弧で一目でわかるので、
+// パーサーのテストでの比較や、優先順位による構文解析の説明に使う。
+// Programの場合は、文ごとに一行ずつ並べる。
+func Sexpr(node Node) string {
+	switch n := node.(type) {
+	case *Program:
+		stmts := []string{}
+		for _, s := range n.Statements {
+			stmts = append(stmts, Sexpr(s))
+		}
+		return strings.Join(stmts, "\n")
+
+	// Statements
+	case *LetStatement:
+		return list("let", n.Name.Value, sexprOf(n.Value))
+	case *LetDestructureStatement:
+		return list("let", sexprOf(n.Pattern), sexprOf(n.Value))
+	case *ConstStatement:
+		return list("const", n.Name.Value, sexprOf(n.Value))
+	case *ClassStatement:
+		methods := []string{}
+		for _, m := range n.Methods {
+			methods = append(methods, sexprOf(m))
+		}
+		return list("class", n.Name.Value, list("", identifierNames(n.Fields)...), list("", methods...))
+	case *ReturnStatement:
+		if n.ReturnValue == nil {
+			return list("return")
+		}
+		return list("return", sexprOf(n.ReturnValue))
+	case *ThrowStatement:
+		return list("throw", sexprOf(n.Value))
+	case *ExpressionStatement:
+		return sexprOf(n.Expression)
+	case *ForInStatement:
+		return list("for", n.Variable.Value, sexprOf(n.Iterable), Sexpr(n.Body))
+	case *BlockStatement:
+		return list("block", sexprs(n.Statements)...)
+
+	// Expressions
+	case *Identifier:
+		return n.Value
+	case *IntegerLiteral:
+		return strconv.FormatInt(n.Value, 10)
+	case *BigIntLiteral:
+		return n.Value.String()
+	case *FloatLiteral:
+		return formatFloat(n.Value)
+	case *StringLiteral:
+		return strconv.Quote(n.Value)
+	case *Boolean:
+		return strconv.FormatBool(n.Value)
+	case *NullLiteral:
+		return "null"
+	case *PrefixExpression:
+		return list(n.Operator, sexprOf(n.Right))
+	case *InfixExpression:
+		return list(n.Operator, sexprOf(n.Left), sexprOf(n.Right))
+	case *AssignExpression:
+		return list("=", n.Name.Value, sexprOf(n.Value))
+	case *PropertyAssignExpression:
+		return list("=", sexprOf(n.Target), sexprOf(n.Value))
+	case *SpreadExpression:
+		return list("...", sexprOf(n.Value))
+	case *ArrayPattern:
+		elements := identifierNames(n.Elements)
+		if n.Rest != nil {
+			elements = append(elements, list("...", n.Rest.Value))
+		}
+		return list("array-pattern", elements...)
+	case *HashPattern:
+		return list("hash-pattern", identifierNames(n.Keys)...)
+	case *IfExpression:
+		if n.Alternative == nil {
+			return list("if", sexprOf(n.Condition), Sexpr(n.Consequence))
+		}
+		return list("if", sexprOf(n.Condition), Sexpr(n.Consequence), Sexpr(n.Alternative))
+	case *MatchExpression:
+		arms := []string{sexprOf(n.Subject)}
+		for _, arm := range n.Arms {
+			if arm.Guard != nil {
+				arms = append(arms, list("=>", sexprOf(arm.Pattern), list("if", sexprOf(arm.Guard)), armBody(arm.Body)))
+				continue
+			}
+			arms = append(arms, list("=>", sexprOf(arm.Pattern), armBody(arm.Body)))
+		}
+		return list("match", arms...)
+	case *TryExpression:
+		return list("try", Sexpr(n.Block), n.Parameter.Value, Sexpr(n.Handler))
+	case *FunctionLiteral:
+		params := identifierNames(n.Parameters)
+		if n.Rest != nil {
+			params = append(params, list("...", n.Rest.Value))
+		}
+		if n.Name != "" {
+			return list("fn", n.Name, list("", params...), Sexpr(n.Body))
+		}
+		return list("fn", list("", params...), Sexpr(n.Body))
+	case *CallExpression:
+		args := []string{sexprOf(n.Function)}
+		for _, a := range n.Arguments {
+			args = append(args, sexprOf(a))
+		}
+		return list("call", args...)
+	case *ArrayLiteral:
+		elements := []string{}
+		for _, el := range n.Elements {
+			elements = append(elements, sexprOf(el))
+		}
+		return list("array", elements...)
+	case *IndexExpression:
+		if n.Optional {
+			return list("?.index", sexprOf(n.Left), sexprOf(n.Index))
+		}
+		return list("index", sexprOf(n.Left), sexprOf(n.Index))
+	case *PropertyExpression:
+		if n.Optional {
+			return list("?.", sexprOf(n.Left), n.Property.Value)
+		}
+		return list(".", sexprOf(n.Left), n.Property.Value)
+	case *HashLiteral:
+		pairs := []string{}
+		for _, key := range SortedHashKeys(n) {
+			pairs = append(pairs, list("", sexprOf(key), sexprOf(n.Pairs[key])))
+		}
+		return list("hash", pairs...)
+	}
+
+	return ""
+}
+
+// パースに失敗してnilになっている箇所は () にする。
+func sexprOf(node Node) string {
+	if node == nil {
+		return "()"
+	}
+	return Sexpr(node)
+}
+
+func sexprs(stmts []Statement) []string {
+	out := []string{}
+	for _, s := range stmts {
+		out = append(out, Sexpr(s))
+	}
+	return out
+}
+
+// (head arg1 arg2 ...) を作る。headが空の場合は (arg1 arg2 ...) になる。
+func list(head string, args ...string) string {
+	elements := args
+	if head != "" {
+		elements = append([]string{head}, args...)
+	}
+	return "(" + strings.Join(elements, " ") + ")"
+}
+
+func identifierNames(idents []*Identifier) []string {
+	names := []string{}
+	for _, ident := range idents {
+		names = append(names, ident.Value)
+	}
+	return names
+}
+
+// => expr の形で書かれたアームは式だけを、 { } で書かれたアームはブロックを返す。
+func armBody(body *BlockStatement) string {
+	if body.Token.Type != token.LBRACE && len(body.Statements) == 1 {
+		return Sexpr(body.Statements[0])
+	}
+	return Sexpr(body)
+}
@@ -0,0 +1,207 @@
+package ast
+
+import "sort"
+
+// Walkで辿るノードごとに呼ばれる。
+// Visitが返したVisitorでそのノードの子を辿る。nilを返した場合は子を辿らない。
+// 子を全て辿り終わったら、w.Visit(nil) が呼ばれる。
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// ASTを深さ優先で辿る。
+// まず v.Visit(node) を呼び、返ってきたVisitorがnilでなければnodeの子を順番に辿る。
+// 子の順番はソースコード上に出てくる順。
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	// Program & Statements
+	case *Program:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+		for _, c := range n.Comments {
+			Walk(v, c)
+		}
+
+	case *LetStatement:
+		Walk(v, n.Name)
+		walkIfNotNil(v, n.Value)
+
+	case *LetDestructureStatement:
+		Walk(v, n.Pattern)
+		walkIfNotNil(v, n.Value)
+
+	case *ConstStatement:
+		Walk(v, n.Name)
+		walkIfNotNil(v, n.Value)
+
+	case *ClassStatement:
+		Walk(v, n.Name)
+		for _, f := range n.Fields {
+			Walk(v, f)
+		}
+		for _, m := range n.Methods {
+			Walk(v, m)
+		}
+
+	case *ReturnStatement:
+		walkIfNotNil(v, n.ReturnValue)
+
+	case *ThrowStatement:
+		walkIfNotNil(v, n.Value)
+
+	case *ExpressionStatement:
+		walkIfNotNil(v, n.Expression)
+
+	case *ForInStatement:
+		Walk(v, n.Variable)
+		Walk(v, n.Iterable)
+		Walk(v, n.Body)
+
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+
+	// Expressions
+	case *Identifier, *Boolean, *NullLiteral, *IntegerLiteral, *BigIntLiteral, *FloatLiteral, *StringLiteral, *Comment:
+		// 子を持たない
+
+	case *ArrayPattern:
+		for _, el := range n.Elements {
+			Walk(v, el)
+		}
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+
+	case *HashPattern:
+		for _, k := range n.Keys {
+			Walk(v, k)
+		}
+
+	case *PrefixExpression:
+		walkIfNotNil(v, n.Right)
+
+	case *InfixExpression:
+		Walk(v, n.Left)
+		walkIfNotNil(v, n.Right)
+
+	case *IfExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Consequence)
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+
+	case *MatchExpression:
+		Walk(v, n.Subject)
+		// MatchArmはNodeではないので、パターンとボディを直接辿る。
+		for _, arm := range n.Arms {
+			Walk(v, arm.Pattern)
+			if arm.Guard != nil {
+				Walk(v, arm.Guard)
+			}
+			Walk(v, arm.Body)
+		}
+
+	case *TryExpression:
+		Walk(v, n.Block)
+		Walk(v, n.Parameter)
+		Walk(v, n.Handler)
+
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+		Walk(v, n.Body)
+
+	case *AssignExpression:
+		Walk(v, n.Name)
+		walkIfNotNil(v, n.Value)
+
+	case *PropertyAssignExpression:
+		Walk(v, n.Target)
+		walkIfNotNil(v, n.Value)
+
+	case *CallExpression:
+		Walk(v, n.Function)
+		for _, a := range n.Arguments {
+			Walk(v, a)
+		}
+
+	case *SpreadExpression:
+		walkIfNotNil(v, n.Value)
+
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			Walk(v, el)
+		}
+
+	case *IndexExpression:
+		Walk(v, n.Left)
+		walkIfNotNil(v, n.Index)
+
+	case *PropertyExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Property)
+
+	case *HashLiteral:
+		// Pairsはgoのmapなので順番が決まっていない。ソースコード上の位置で並べてから辿る。
+		for _, key := range SortedHashKeys(n) {
+			Walk(v, key)
+			walkIfNotNil(v, n.Pairs[key])
+		}
+	}
+
+	v.Visit(nil)
+}
+
+// パースに失敗した箇所はnilになっていることがあるので、nilの場合は辿らない。
+func walkIfNotNil(v Visitor, node Node) {
+	if node != nil {
+		Walk(v, node)
+	}
+}
+
+// ハッシュリテラルのキーをソースコード上に出てきた順に並べて返す。
+func SortedHashKeys(hl *HashLiteral) []Expression {
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for key := range hl.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Pos().Offset < keys[j].Pos().Offset
+	})
+	return keys
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// ASTを深さ優先で辿り、ノードごとにfを呼ぶ。
+// fがfalseを返した場合はそのノードの子を辿らない。子を辿り終わった後にf(nil)が呼ばれる。
+// ex: 全ての識別子を集める
+//
+//	ast.Inspect(program, func(n ast.Node) bool {
+//		if ident, ok := n.(*ast.Identifier); ok {
+//			names = append(names, ident.Value)
+//		}
+//		return true
+//	})
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
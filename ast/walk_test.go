@@ -0,0 +1,99 @@
+package ast
+
+import (
+	"fmt"
+	"testing"
+
+	"monkey/token"
+)
+
+func TestWalk(t *testing.T) {
+	// let add = fn(x, y) { x + y; }; add(1, [2]);
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  ident("add"),
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{ident("x"), ident("y")},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{
+								Expression: &InfixExpression{Left: ident("x"), Operator: "+", Right: ident("y")},
+							},
+						},
+					},
+				},
+			},
+			&ExpressionStatement{
+				Expression: &CallExpression{
+					Function:  ident("add"),
+					Arguments: []Expression{integer(1), &ArrayLiteral{Elements: []Expression{integer(2)}}},
+				},
+			},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(n Node) bool {
+		if n != nil {
+			visited = append(visited, fmt.Sprintf("%T", n))
+		}
+		return true
+	})
+
+	expected := []string{
+		"*ast.Program",
+		"*ast.LetStatement",
+		"*ast.Identifier",
+		"*ast.FunctionLiteral",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.InfixExpression",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.ExpressionStatement",
+		"*ast.CallExpression",
+		"*ast.Identifier",
+		"*ast.IntegerLiteral",
+		"*ast.ArrayLiteral",
+		"*ast.IntegerLiteral",
+	}
+
+	if len(visited) != len(expected) {
+		t.Fatalf("wrong number of nodes visited. want=%d, got=%d (%v)",
+			len(expected), len(visited), visited)
+	}
+	for i, e := range expected {
+		if visited[i] != e {
+			t.Errorf("visited[%d] wrong. want=%s, got=%s", i, e, visited[i])
+		}
+	}
+
+	// falseを返すと子は辿らない
+	var names []string
+	Inspect(program, func(n Node) bool {
+		if _, ok := n.(*FunctionLiteral); ok {
+			return false
+		}
+		if ident, ok := n.(*Identifier); ok {
+			names = append(names, ident.Value)
+		}
+		return true
+	})
+
+	if fmt.Sprint(names) != "[add add]" {
+		t.Errorf("names wrong. got=%v", names)
+	}
+}
+
+func ident(name string) *Identifier {
+	return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+func integer(v int64) *IntegerLiteral {
+	return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: fmt.Sprint(v)}, Value: v}
+}
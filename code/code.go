@@ -0,0 +1,263 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// バイトコードの命令の並び。一つの命令はオペコード1バイトと、その後に続くオペランドからなる。
+type Instructions []byte
+
+// 命令の種類。
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota // 定数プールのオペランド番目の定数をスタックに積む
+	OpPop                    // スタックの一番上を捨てる。式文の値を捨てるのに使う
+
+	// 二項演算。スタックの上の二つを取り出し、計算結果を積む
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpInfix // RegisterInfixOperatorで追加された演算子。オペランドは演算子の文字列の定数の番号
+
+	// 前置演算。スタックの一番上を取り出し、計算結果を積む
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpJump          // オペランドの位置へジャンプする
+	OpJumpNotTruthy // スタックの一番上を取り出し、truthyでなければオペランドの位置へジャンプする
+	OpJumpNotNull   // スタックの一番上がnullでなければ、それを残したままオペランドの位置へジャンプする。nullなら取り除く
+	OpJumpNull      // スタックの一番上がnullなら、それを残したままオペランドの位置へジャンプする。 ?.[] で添字を評価せずにnullにするのに使う
+
+	OpGetGlobal  // オペランド番目のグローバル変数をスタックに積む
+	OpSetGlobal  // スタックの一番上を取り出し、オペランド番目のグローバル変数に束縛する
+	OpGetLocal   // オペランド番目のローカル変数をスタックに積む
+	OpSetLocal   // スタックの一番上を取り出し、オペランド番目のローカル変数に束縛する
+	OpGetFree    // 一つ目のオペランドの数だけ外側の関数の、二つ目のオペランド番目のローカル変数をスタックに積む
+	OpSetFree    // スタックの一番上を取り出し、OpGetFreeと同じ場所の変数に束縛する
+	OpGetBuiltin // オペランドの文字列の定数の名前の組み込み関数をスタックに積む
+
+	OpArray // スタックの上からオペランドの数の要素を取り出し、配列を作る
+	OpHash  // スタックの上からオペランドの数(キーと値の合計)の要素を取り出し、ハッシュを作る
+	OpIndex // スタックの上の二つを配列(ハッシュ)と添字として取り出し、要素を積む
+
+	OpGetProperty // スタックの一番上の値の、オペランドの文字列の定数の名前のメンバーを積む。二つ目のオペランドが1なら ?. でのアクセス
+
+	OpCall        // スタックの上からオペランドの数の引数と、その下の関数を取り出して呼び出す
+	OpReturnValue // スタックの一番上を戻り値として関数から戻る
+	OpReturn      // nullを戻り値として関数から戻る
+	OpClosure     // オペランド番目の定数の関数から、実行中の関数のローカル変数を外側のスコープとして持つクロージャを作る
+
+	OpGetIter  // スタックの一番上の値を取り出し、そのイテレータを積む
+	OpIterNext // スタックの一番上のイテレータの次の要素を積む。要素がなければイテレータを取り除いてオペランドの位置へジャンプする
+
+	// 保存済みのバイトコードの番号が変わらないように、後から追加した命令は最後に並べる
+
+	OpSetProperty // スタックの上の二つを値と代入先として取り出し、代入先のオペランドの文字列の定数の名前のメンバーに代入して、値を積む
+
+	OpThrow  // スタックの一番上を取り出し、例外としてthrowする
+	OpTry    // catchのブロックの位置をオペランドにして、実行中の関数にtryのハンドラを積む。エラーか例外が起きると、catchした値を積んでそこへジャンプする
+	OpEndTry // tryのブロックを最後まで実行したので、OpTryで積んだハンドラを取り除く
+
+	OpSpread      // スタックの一番上を取り出し、その要素を展開するための値を積む。 ...arr に使う
+	OpSpreadArray // OpArrayと同じだが、OpSpreadで積んだ値は要素を展開して詰める
+	OpSpreadCall  // OpCallと同じだが、OpSpreadで積んだ値は要素を展開して引数にする
+
+	OpDestructureArray // スタックの一番上の配列を取り出し、先頭からオペランドの数の要素を、最初の要素が一番上になるように積む。二つ目のオペランドが1なら、その下に残りの要素の配列も積む
+	OpDestructureHash  // スタックの上からオペランドの数のキーの名前と、その下のハッシュを取り出し、キーの値を最初のキーのものが一番上になるように積む
+
+	OpMatchArray // OpDestructureArrayと同じだが、要素の数がちょうど合う配列でなければ何も積まずに三つ目のオペランドの位置へジャンプする
+	OpMatchHash  // OpDestructureHashと同じだが、キーの値を積むのは全てのキーがあるハッシュの場合だけ。そうでなければ二つ目のオペランドの位置へジャンプする
+	OpMatchValue // スタックの上の二つを取り出し、matchのパターンとして等しくなければオペランドの位置へジャンプする
+
+	OpClass // オペランドの文字列の定数の名前のクラスを作る。スタックには二つ目のオペランドの数のフィールド名と、三つ目のオペランドの数のメソッドの名前とクロージャの組が積まれている
+)
+
+// 命令を一行に一つずつ、位置と名前、オペランドで表す。
+//
+//	0000 OpConstant 0
+//	0003 OpGetFree 1 2
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), len(def.OperandWidths))
+	}
+
+	parts := []string{def.Name}
+	for _, o := range operands {
+		parts = append(parts, strconv.Itoa(o))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 命令の名前と、オペランドごとのバイト数。
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:         {"OpAdd", []int{}},
+	OpSub:         {"OpSub", []int{}},
+	OpMul:         {"OpMul", []int{}},
+	OpDiv:         {"OpDiv", []int{}},
+	OpMod:         {"OpMod", []int{}},
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+	OpInfix:       {"OpInfix", []int{2}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
+	OpJumpNull:      {"OpJumpNull", []int{2}},
+
+	OpGetGlobal:  {"OpGetGlobal", []int{2}},
+	OpSetGlobal:  {"OpSetGlobal", []int{2}},
+	OpGetLocal:   {"OpGetLocal", []int{1}},
+	OpSetLocal:   {"OpSetLocal", []int{1}},
+	OpGetFree:    {"OpGetFree", []int{1, 1}},
+	OpSetFree:    {"OpSetFree", []int{1, 1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{2}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	OpGetProperty: {"OpGetProperty", []int{2, 1}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpClosure:     {"OpClosure", []int{2}},
+
+	OpGetIter:  {"OpGetIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	OpSetProperty: {"OpSetProperty", []int{2}},
+
+	OpThrow:  {"OpThrow", []int{}},
+	OpTry:    {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+
+	OpSpread:      {"OpSpread", []int{}},
+	OpSpreadArray: {"OpSpreadArray", []int{2}},
+	OpSpreadCall:  {"OpSpreadCall", []int{1}},
+
+	OpDestructureArray: {"OpDestructureArray", []int{2, 1}},
+	OpDestructureHash:  {"OpDestructureHash", []int{2}},
+
+	OpMatchArray: {"OpMatchArray", []int{2, 1, 2}},
+	OpMatchHash:  {"OpMatchHash", []int{2, 2}},
+	OpMatchValue: {"OpMatchValue", []int{2}},
+
+	OpClass: {"OpClass", []int{2, 1, 1}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// オペコードとオペランドから命令を作る。オペランドはビッグエンディアンで詰める。
+// 未定義のオペコードの場合は空の命令を返す。
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+	return instruction
+}
+
+// Makeの逆。命令のオペランドを読み、読んだバイト数と一緒に返す。insはオペコードの次から始まる。
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
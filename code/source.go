@@ -0,0 +1,27 @@
+package code
+
+import (
+	"monkey/token"
+	"sort"
+)
+
+// 命令と、その命令を出力したノードの対応。
+type Source struct {
+	Offset int            // 命令の位置
+	Pos    token.Position // 命令を出力したノードの位置
+	Call   string         // 関数呼び出しの命令なら、呼び出した式の名前。エラーの呼び出しの履歴に使う
+}
+
+// 命令の並びのソースコード上の位置。命令の位置の順に並ぶ。
+// 位置が変わる命令にだけSourceを置くので、命令の位置はその命令以前で最後のSourceから分かる。
+// VMは実行時のエラーに、評価器と同じ位置と呼び出しの履歴を付けるのに使う。
+type SourceMap []Source
+
+// offsetの位置の命令のSource。分からなければゼロ値。
+func (m SourceMap) Lookup(offset int) Source {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return Source{}
+	}
+	return m[i-1]
+}
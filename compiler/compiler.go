@@ -0,0 +1,1026 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+	"strings"
+)
+
+// コンパイルした結果。VMはこれを実行する。
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Globals      []string       // グローバル変数の名前。番号の順に並ぶ
+	Sources      code.SourceMap // トップレベルの命令のソースコード上の位置。実行時のエラーの位置に使う
+}
+
+// コンパイルした結果を読める形でwに書き出す。定数、グローバル変数、トップレベルの命令の順に並べる。
+// 関数の定数は、その関数の命令を字下げして続ける。
+func (b *Bytecode) Dump(w io.Writer) error {
+	var out bytes.Buffer
+
+	out.WriteString("constants:\n")
+	for i, constant := range b.Constants {
+		if str, ok := constant.(*object.String); ok {
+			fmt.Fprintf(&out, "%4d %s %q\n", i, str.Type(), str.Value)
+			continue
+		}
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			fmt.Fprintf(&out, "%4d %s %s\n", i, constant.Type(), constant.Inspect())
+			continue
+		}
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		fmt.Fprintf(&out, "%4d %s %s (params=%d, locals=%d", i, fn.Type(), name, fn.NumParameters, fn.NumLocals)
+		if fn.Rest {
+			out.WriteString(", rest")
+		}
+		out.WriteString(")\n")
+		for _, line := range strings.SplitAfter(fn.Instructions.String(), "\n") {
+			if line != "" {
+				out.WriteString("       " + line)
+			}
+		}
+	}
+
+	out.WriteString("globals:\n")
+	for i, name := range b.Globals {
+		fmt.Fprintf(&out, "%4d %s\n", i, name)
+	}
+
+	out.WriteString("instructions:\n")
+	out.WriteString(b.Instructions.String())
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// 最後に出力した命令。ifの値を残すためにOpPopを取り除いたり、関数の最後の式を戻り値にしたりするのに使う。
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// 関数一つ分のコンパイルの途中の状態。関数リテラルをコンパイルするたびに積む。
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	constLocals         map[string]bool // この関数でconstで束縛したローカル変数
+	locals              []string        // ローカル変数の名前。resolverが決めたものの後に、matchの途中の値を入れておく変数が続く
+	sources             code.SourceMap
+}
+
+// ASTをバイトコードにコンパイルする。
+// 関数のローカル変数の場所はresolverが決めたものをそのまま使うので、パーサーが解決したASTを渡すこと。
+type Compiler struct {
+	constants   []object.Object
+	names       map[string]int // 組み込み関数やプロパティの名前の定数の番号。同じ名前は同じ定数を使う
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	pos token.Position // コンパイル中のノードの位置。出力した命令に対応付ける
+}
+
+func New() *Compiler {
+	return NewWithState(NewSymbolTable(), []object.Object{})
+}
+
+// 前回のコンパイルのグローバル変数と定数を引き継いでコンパイルする。REPLで一行ずつコンパイルするのに使う。
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	c := &Compiler{
+		constants:   constants,
+		names:       make(map[string]int),
+		symbolTable: s,
+		scopes:      []CompilationScope{{constLocals: make(map[string]bool)}},
+	}
+	for i, constant := range constants {
+		if str, ok := constant.(*object.String); ok {
+			c.names[str.Value] = i
+		}
+	}
+	return c
+}
+
+// nodeをコンパイルする。出力した命令には、実行時のエラーの位置としてnodeの位置を対応付ける。
+// 評価器がエラーに一番内側のノードの位置を付けるのと同じく、子のノードの命令には子のノードの位置を対応付ける。
+func (c *Compiler) Compile(node ast.Node) error {
+	outer := c.pos
+	c.pos = node.Pos()
+	err := c.compile(node)
+	c.pos = outer
+	return err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		// 関数の中から後で定義されるグローバル変数を参照できるように、先に全て定義しておく
+		declareGlobals(c.symbolTable, node)
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		if err := c.compileFunctionValue(node.Value, node.Name.Value); err != nil {
+			return err
+		}
+		return c.storeBinding(node.Name, false)
+
+	case *ast.ConstStatement:
+		if err := c.compileFunctionValue(node.Value, node.Name.Value); err != nil {
+			return err
+		}
+		return c.storeBinding(node.Name, true)
+
+	case *ast.LetDestructureStatement:
+		return c.compileDestructure(node)
+
+	case *ast.ClassStatement:
+		return c.compileClass(node)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ForInStatement:
+		return c.compileForIn(node)
+
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(object.NewInteger(node.Value)))
+	case *ast.BigIntLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		return c.compileInfix(node)
+
+	case *ast.IfExpression:
+		return c.compileIf(node)
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
+	case *ast.Identifier:
+		return c.loadIdentifier(node)
+
+	case *ast.AssignExpression:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if err := c.assign(node.Name); err != nil {
+			return err
+		}
+		// 代入式の値は代入した値
+		return c.loadIdentifier(node.Name)
+
+	case *ast.ArrayLiteral:
+		spread, err := c.compileExpressions(node.Elements)
+		if err != nil {
+			return err
+		}
+		if spread {
+			c.emit(code.OpSpreadArray, len(node.Elements))
+		} else {
+			c.emit(code.OpArray, len(node.Elements))
+		}
+
+	case *ast.HashLiteral:
+		// ソースコード上の順番で評価する
+		keys := ast.SortedHashKeys(node)
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(keys)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		// a?.[i] は、aがnullならiを評価せずにnullになる
+		jumpPos := -1
+		if node.Optional {
+			jumpPos = c.emit(code.OpJumpNull, 9999)
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+		if node.Optional {
+			c.changeOperand(jumpPos, len(c.currentInstructions()))
+		}
+
+	case *ast.PropertyExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.emit(code.OpGetProperty, c.nameConstant(node.Property.Value), boolOperand(node.Optional))
+
+	case *ast.PropertyAssignExpression:
+		if err := c.Compile(node.Target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetProperty, c.nameConstant(node.Target.Property.Value))
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, node.Name)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		if len(node.Arguments) > 255 {
+			return fmt.Errorf("%s: too many arguments (max 255)", node.Pos())
+		}
+		spread, err := c.compileExpressions(node.Arguments)
+		if err != nil {
+			return err
+		}
+		op := code.OpCall
+		if spread {
+			op = code.OpSpreadCall
+		}
+		// エラーの呼び出しの履歴に評価器と同じ名前が出るように、呼び出した式の名前も記録する
+		c.addSource(code.Source{Offset: c.emit(op, len(node.Arguments)), Pos: c.pos, Call: node.CalleeName()})
+
+	// ...arr は関数呼び出しの引数と配列リテラルの要素の中で、compileExpressionsが展開する
+	case *ast.SpreadExpression:
+		return fmt.Errorf("%s: spread operator not allowed here: %s", node.Pos(), node.String())
+
+	default:
+		return unsupported(node)
+	}
+
+	return nil
+}
+
+// コンパイルできないノードのエラー。
+func unsupported(node ast.Node) error {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+	return fmt.Errorf("%s: %s is not supported by the compiler", node.Pos(), name)
+}
+
+func boolOperand(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 式を順にコンパイルして積む。 ...arr があればtrueを返す。
+// ...arr は要素の数がコンパイルした時点ではわからないので、OpSpreadで展開する値にして積み、
+// OpSpreadArrayとOpSpreadCallが実行するときに展開する。
+func (c *Compiler) compileExpressions(exps []ast.Expression) (bool, error) {
+	spread := false
+	for _, exp := range exps {
+		if s, ok := exp.(*ast.SpreadExpression); ok {
+			if err := c.Compile(s.Value); err != nil {
+				return false, err
+			}
+			// 評価器と同じく、展開できない値のエラーは ...arr を囲む式の位置にする
+			c.emit(code.OpSpread)
+			spread = true
+			continue
+		}
+		if err := c.Compile(exp); err != nil {
+			return false, err
+		}
+	}
+	return spread, nil
+}
+
+// let f = fn() {} の関数リテラルには、エラーメッセージなどのために束縛する名前を付ける。
+func (c *Compiler) compileFunctionValue(value ast.Expression, name string) error {
+	if fl, ok := value.(*ast.FunctionLiteral); ok && fl.Name == "" {
+		return c.compileFunction(fl, name)
+	}
+	return c.Compile(value)
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+func (c *Compiler) compileInfix(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	// a ?? b は、aがnullでなければbを評価しない
+	if node.Operator == "??" {
+		jumpPos := c.emit(code.OpJumpNotNull, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	if op, ok := infixOpcodes[node.Operator]; ok {
+		c.emit(op)
+	} else {
+		// RegisterInfixOperatorで追加された演算子は、実行時に名前で探す
+		c.emit(code.OpInfix, c.nameConstant(node.Operator))
+	}
+	return nil
+}
+
+func (c *Compiler) compileIf(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// ジャンプ先はブロックをコンパイルするまでわからないので、仮の値を入れておいて後で書き換える
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// ブロックの最後の式の値をスタックに残す。最後が式でなければnullを残す。
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	// 空のブロックなら、直前のmatchのパターンのOpPopを取り除いてしまわないようにする
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// for (x in iterable) { body }
+//
+//	     <iterable>
+//	     OpGetIter
+//	loop:OpIterNext end
+//	     <xに束縛>
+//	     <body>
+//	     OpJump loop
+//	end: OpNull  (for文の値はnull)
+//	     OpPop
+func (c *Compiler) compileForIn(node *ast.ForInStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpGetIter)
+
+	loopPos := len(c.currentInstructions())
+	iterNextPos := c.emit(code.OpIterNext, 9999)
+	if err := c.storeBinding(node.Variable, false); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loopPos)
+	c.changeOperand(iterNextPos, len(c.currentInstructions()))
+
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// try { block } catch (e) { handler }
+//
+//	       OpTry catch
+//	       <block>
+//	       OpEndTry
+//	       OpJump end
+//	catch: <eに束縛>  (catchした値が積まれている)
+//	       <handler>
+//	end:
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 9999)
+	if err := c.compileBlockValue(node.Block); err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+	if err := c.storeBinding(node.Parameter, false); err != nil {
+		return err
+	}
+	if err := c.compileBlockValue(node.Handler); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// let [a, b, ...rest] = value; と let {a, b} = value;
+// 値を取り出す命令が最初の変数の値を一番上にして積むので、変数の順に束縛する。
+func (c *Compiler) compileDestructure(node *ast.LetDestructureStatement) error {
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+
+	var idents []*ast.Identifier
+	switch pattern := node.Pattern.(type) {
+	case *ast.ArrayPattern:
+		idents = pattern.Elements
+		if pattern.Rest != nil {
+			idents = append(append([]*ast.Identifier{}, idents...), pattern.Rest)
+		}
+		c.emit(code.OpDestructureArray, len(pattern.Elements), boolOperand(pattern.Rest != nil))
+	case *ast.HashPattern:
+		idents = pattern.Keys
+		for _, key := range pattern.Keys {
+			c.emit(code.OpConstant, c.nameConstant(key.Value))
+		}
+		c.emit(code.OpDestructureHash, len(pattern.Keys))
+	default:
+		return fmt.Errorf("%s: unknown pattern: %s", node.Pattern.Pos(), node.Pattern.String())
+	}
+
+	for _, ident := range idents {
+		if err := c.storeBinding(ident, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// class Name { fields; fn method() {} }
+// フィールド名と、メソッドの名前とクロージャの組を積んでOpClassでクラスを作る。
+// メソッドはresolverが決めたとおり、selfだけのスコープの内側の関数としてコンパイルする。
+// selfのスコープは、VMがメソッドを呼び出すときにevaluator.MethodClosureで作る。
+func (c *Compiler) compileClass(node *ast.ClassStatement) error {
+	if len(node.Fields) > 255 || len(node.Methods) > 255 {
+		return fmt.Errorf("%s: too many fields or methods (max 255)", node.Pos())
+	}
+	for _, field := range node.Fields {
+		c.emit(code.OpConstant, c.nameConstant(field.Value))
+	}
+	for _, m := range node.Methods {
+		c.emit(code.OpConstant, c.nameConstant(m.Name))
+		if err := c.compileFunction(m, node.Name.Value+"."+m.Name); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpClass, c.nameConstant(node.Name.Value), len(node.Fields), len(node.Methods))
+	return c.storeBinding(node.Name, false)
+}
+
+// match (subject) { pattern if guard => body, ... }
+//
+//	      <subject>
+//	      <一時変数sに入れる>
+//	arm:  <sを積む>
+//	      <patternと比べる。合わなければfailへ>
+//	      <パターンの変数に束縛>
+//	      <guard>
+//	      OpJumpNotTruthy next
+//	      <body>
+//	      OpJump end
+//	fail: <スタックに残っている値を捨てる>
+//	next: (次のアーム)
+//	      OpNull  (どのアームにもマッチしなければnull。 _ のアームがあれば、代わりにそのbody)
+//	end:
+//
+// 評価器と同じく、途中までマッチしたパターンの変数を束縛してしまわないように、
+// パターンの変数の値は一時変数に入れておき、最後までマッチしてからまとめて束縛する。
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+	subject := c.temporary()
+	c.storeTemporary(subject)
+
+	var endJumps []int
+	wildcard := false
+	for _, arm := range node.Arms {
+		// _ のアームには必ずマッチするので、その後のアームは比べない
+		if arm.IsWildcard() {
+			if err := c.compileBlockValue(arm.Body); err != nil {
+				return err
+			}
+			wildcard = true
+			break
+		}
+
+		m := &armMatch{pending: 1}
+		c.loadSymbol(subject)
+		if err := c.compilePattern(arm.Pattern, m); err != nil {
+			return err
+		}
+		for _, b := range m.bindings {
+			c.loadSymbol(b.temporary)
+			if err := c.storeBinding(b.ident, false); err != nil {
+				return err
+			}
+		}
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+			m.fails = append(m.fails, failJump{c.emit(code.OpJumpNotTruthy, 9999), 0})
+		}
+		if err := c.compileBlockValue(arm.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		c.patchFails(m)
+	}
+
+	if !wildcard {
+		c.emit(code.OpNull)
+	}
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// matchのアーム一つのパターンをコンパイルしている途中の状態。
+type armMatch struct {
+	pending  int          // パターンと比べるためにスタックに積んである値の数
+	fails    []failJump   // パターンに合わなかった場合のジャンプ
+	bindings []armBinding // パターンの変数と、その値を入れておく一時変数
+}
+
+// パターンに合わなかった場合のジャンプの命令の位置と、そのときスタックに残っている値の数。
+type failJump struct {
+	pos     int
+	pending int
+}
+
+type armBinding struct {
+	ident     *ast.Identifier
+	temporary Symbol
+}
+
+// スタックの一番上の値をpatternと比べる。合えば値を取り除いて先へ進み、合わなければm.failsに記録したジャンプで抜ける。
+// 評価器のmatchPatternと同じく、変数はどんな値にも合い、配列とハッシュのパターンは要素を入れ子のパターンと比べ、
+// それ以外の式は評価した値と等しいかを比べる。
+func (c *Compiler) compilePattern(pattern ast.Expression, m *armMatch) error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		m.pending--
+		if pattern.Value == "_" {
+			c.emit(code.OpPop)
+			return nil
+		}
+		temporary := c.temporary()
+		c.storeTemporary(temporary)
+		m.bindings = append(m.bindings, armBinding{pattern, temporary})
+		return nil
+
+	case *ast.ArrayLiteral:
+		elements, rest := ast.SplitRestPattern(pattern)
+		for _, el := range elements {
+			if _, ok := el.(*ast.SpreadExpression); ok {
+				return fmt.Errorf("%s: ... must be the last element of an array pattern", el.Pos())
+			}
+		}
+		m.pending--
+		m.fail(c.emit(code.OpMatchArray, len(elements), boolOperand(rest != nil), 9999))
+		m.pending += len(elements) + boolOperand(rest != nil)
+		for _, el := range elements {
+			if err := c.compilePattern(el, m); err != nil {
+				return err
+			}
+		}
+		if rest != nil {
+			return c.compilePattern(rest, m)
+		}
+		return nil
+
+	case *ast.HashLiteral:
+		keys := ast.SortedHashKeys(pattern)
+		for _, key := range keys {
+			if err := c.Compile(key); err != nil {
+				return err
+			}
+		}
+		m.pending--
+		m.fail(c.emit(code.OpMatchHash, len(keys), 9999))
+		m.pending += len(keys)
+		for _, key := range keys {
+			if err := c.compilePattern(pattern.Pairs[key], m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := c.Compile(pattern); err != nil {
+		return err
+	}
+	m.pending--
+	m.fail(c.emit(code.OpMatchValue, 9999))
+	return nil
+}
+
+// 位置posのジャンプを、パターンに合わなかった場合のジャンプとして記録する。
+func (m *armMatch) fail(pos int) {
+	m.fails = append(m.fails, failJump{pos, m.pending})
+}
+
+// パターンに合わなかった場合のジャンプ先を決める。スタックに残っている値の数が多いジャンプほど前に飛ばし、
+//
+//	fail2: OpPop
+//	fail1: OpPop
+//	fail0: (次のアーム)
+//
+// のように、残っている値を全て捨ててから次のアームへ進むようにする。
+func (c *Compiler) patchFails(m *armMatch) {
+	max := 0
+	for _, f := range m.fails {
+		if f.pending > max {
+			max = f.pending
+		}
+	}
+	for pending := max; pending >= 0; pending-- {
+		for _, f := range m.fails {
+			if f.pending == pending {
+				c.changeOperand(f.pos, len(c.currentInstructions()))
+			}
+		}
+		if pending > 0 {
+			c.emit(code.OpPop)
+		}
+	}
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+	c.scopes[c.scopeIndex].locals = append([]string{}, node.Slots...)
+
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	// 最後の式の値を戻り値にする
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	locals := c.scopes[c.scopeIndex].locals
+	sources := c.scopes[c.scopeIndex].sources
+	instructions := c.leaveScope()
+
+	fn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     len(locals),
+		NumParameters: len(node.Parameters),
+		Rest:          node.Rest != nil,
+		Name:          name,
+		Locals:        locals,
+		Sources:       sources,
+	}
+	c.emit(code.OpClosure, c.addConstant(fn))
+	return nil
+}
+
+// 識別子がどこに束縛されているかを決める。
+// resolverが関数のローカル変数として解決していればその場所、そうでなければグローバル変数、
+// グローバル変数でもなければ組み込み関数。
+func (c *Compiler) resolve(ident *ast.Identifier) Symbol {
+	if b := ident.Binding; b != nil {
+		if b.Depth == 0 {
+			return Symbol{Name: ident.Value, Scope: LocalScope, Index: b.Slot, Const: c.scopes[c.scopeIndex].constLocals[ident.Value]}
+		}
+		return Symbol{Name: ident.Value, Scope: FreeScope, Index: b.Slot, Depth: b.Depth}
+	}
+	if symbol, ok := c.symbolTable.Resolve(ident.Value); ok {
+		return symbol
+	}
+	return Symbol{Name: ident.Value, Scope: BuiltinScope}
+}
+
+func (c *Compiler) loadIdentifier(ident *ast.Identifier) error {
+	c.loadSymbol(c.resolve(ident))
+	return nil
+}
+
+func (c *Compiler) loadSymbol(symbol Symbol) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, symbol.Depth, symbol.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, c.nameConstant(symbol.Name))
+	}
+}
+
+// letやconst、forで、スタックの一番上の値を識別子に束縛する。
+func (c *Compiler) storeBinding(ident *ast.Identifier, isConst bool) error {
+	symbol := c.resolve(ident)
+	if symbol.Const {
+		return fmt.Errorf("%s: cannot assign to constant: %s", ident.Pos(), ident.Value)
+	}
+	switch symbol.Scope {
+	case LocalScope:
+		if isConst {
+			c.scopes[c.scopeIndex].constLocals[ident.Value] = true
+		}
+		c.emit(code.OpSetLocal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, symbol.Depth, symbol.Index)
+	default:
+		if isConst {
+			symbol = c.symbolTable.DefineConst(ident.Value)
+		} else {
+			symbol = c.symbolTable.Define(ident.Value)
+		}
+		c.emit(code.OpSetGlobal, symbol.Index)
+	}
+	return nil
+}
+
+// 代入式で、スタックの一番上の値を既にある変数に束縛し直す。
+func (c *Compiler) assign(ident *ast.Identifier) error {
+	symbol := c.resolve(ident)
+	if symbol.Scope == BuiltinScope {
+		return fmt.Errorf("%s: identifier not found: %s", ident.Pos(), ident.Value)
+	}
+	return c.storeBinding(ident, false)
+}
+
+// トップレベルで束縛される変数を集めて定義する。関数とクラスの中は辿らない。
+func declareGlobals(s *SymbolTable, program *ast.Program) {
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.ClassStatement:
+			s.Define(n.Name.Value)
+			return false
+		case *ast.LetStatement:
+			s.Define(n.Name.Value)
+		case *ast.ConstStatement:
+			s.Define(n.Name.Value)
+		case *ast.ForInStatement:
+			s.Define(n.Variable.Value)
+		case *ast.TryExpression:
+			if n.Parameter != nil {
+				s.Define(n.Parameter.Value)
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				for _, ident := range arm.Bindings() {
+					s.Define(ident.Value)
+				}
+			}
+		case *ast.ArrayPattern:
+			for _, el := range n.Elements {
+				s.Define(el.Value)
+			}
+			if n.Rest != nil {
+				s.Define(n.Rest.Value)
+			}
+		case *ast.HashPattern:
+			for _, key := range n.Keys {
+				s.Define(key.Value)
+			}
+		}
+		return true
+	})
+}
+
+// matchの途中の値を入れておく、スクリプトからは参照できない変数を作る。
+// 関数の中ならローカル変数を、トップレベルならグローバル変数を一つ増やす。
+func (c *Compiler) temporary() Symbol {
+	if c.scopeIndex == 0 {
+		return c.symbolTable.Define(fmt.Sprintf("<temporary %d>", c.symbolTable.numDefinitions))
+	}
+	scope := &c.scopes[c.scopeIndex]
+	scope.locals = append(scope.locals, "<temporary>")
+	return Symbol{Scope: LocalScope, Index: len(scope.locals) - 1}
+}
+
+// temporaryで作った変数に、スタックの一番上の値を入れる。
+func (c *Compiler) storeTemporary(symbol Symbol) {
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// 名前の文字列の定数の番号。同じ名前には同じ定数を使う。
+func (c *Compiler) nameConstant(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	i := c.addConstant(&object.String{Value: name})
+	c.names[name] = i
+	return i
+}
+
+// 命令を出力し、その位置を返す。
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	c.addSource(code.Source{Offset: pos, Pos: c.pos})
+	return pos
+}
+
+// 命令の位置を記録する。直前の命令と同じ位置なら、その命令のSourceで分かるので記録しない。
+// 同じ命令の位置をもう一度記録した場合は、後から記録したものにする。
+func (c *Compiler) addSource(source code.Source) {
+	scope := &c.scopes[c.scopeIndex]
+	if n := len(scope.sources); n > 0 {
+		last := scope.sources[n-1]
+		if last.Offset == source.Offset {
+			scope.sources[n-1] = source
+			return
+		}
+		if last.Pos == source.Pos && last.Call == source.Call {
+			return
+		}
+	}
+	scope.sources = append(scope.sources, source)
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	sources := c.scopes[c.scopeIndex].sources
+	for len(sources) > 0 && sources[len(sources)-1].Offset >= last.Position {
+		sources = sources[:len(sources)-1]
+	}
+	c.scopes[c.scopeIndex].sources = sources
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// 仮の値を入れておいたジャンプ先などのオペランドを書き換える。オペランドが複数ある命令では、最後のオペランドを書き換える。
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	ins := c.currentInstructions()
+	def, err := code.Lookup(ins[opPos])
+	if err != nil {
+		return
+	}
+	operands, _ := code.ReadOperands(def, ins[opPos+1:])
+	operands[len(operands)-1] = operand
+	c.replaceInstruction(opPos, code.Make(code.Opcode(ins[opPos]), operands...))
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{constLocals: make(map[string]bool)})
+	c.scopeIndex++
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	return instructions
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Globals:      c.symbolTable.Names(),
+		Sources:      c.scopes[c.scopeIndex].sources,
+	}
+}
@@ -0,0 +1,732 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 % 3 * 4",
+			expectedConstants: []interface{}{2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// パーサーに追加された演算子は、名前の定数を使ってOpInfixで評価する
+func TestRegisteredInfixOperator(t *testing.T) {
+	l := lexer.New("2 ** 3")
+	l.RegisterOperator("**", "**")
+	p := parser.New(l)
+	p.RegisterInfixOperator("**", parser.PRODUCT+1, nil)
+	program := p.ParseProgram()
+
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+
+	err := testInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpInfix, 2),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	if err := testConstants([]interface{}{2, 3, "**"}, bytecode.Constants); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 > 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true == false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpFalse),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 13),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null ?? 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNotNull, 7),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// ?.[] は、左辺がnullなら添字を評価せずにnullのまま飛ばす
+			input:             "null?.[1]",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNull, 8),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpIndex),
+				// 0008
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; two;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 関数の中から、後で定義されるグローバル変数を参照できる
+			input: "let f = fn() { x }; let x = 1;",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `len([]); len("")`,
+			expectedConstants: []interface{}{"len", ""},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][0]",
+			expectedConstants: []interface{}{1, 2, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{"b": 1, "a": 2}?.a`,
+			expectedConstants: []interface{}{"b", 1, "a", 2, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpGetProperty, 4, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{}.a = 1",
+			expectedConstants: []interface{}{1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetProperty, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { return 5 + 10 }",
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a, b) { let c = a; c + b }(1, 2)",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a = a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 1, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetFree, 1, 0),
+					code.Make(code.OpGetFree, 1, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestForIn(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpGetIter),
+				// 0007
+				code.Make(code.OpIterNext, 20),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpGetGlobal, 0),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpJump, 7),
+				// 0020
+				code.Make(code.OpNull),
+				// 0021
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionNames(t *testing.T) {
+	program := parse("let add = fn(a, b) { a + b }; fn named() { 1 }")
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var names []string
+	for _, constant := range c.Bytecode().Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			names = append(names, fn.Name)
+		}
+	}
+	if strings.Join(names, ",") != "add,named" {
+		t.Errorf("wrong function names. got=%v", names)
+	}
+}
+
+// 関数呼び出しの命令には、呼び出しの位置と呼び出した式の名前が記録される
+func TestSourceMap(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse("let f = fn(x) { x + 1 };\nf(2)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+
+	var calls []string
+	for _, source := range bytecode.Sources {
+		if source.Call != "" {
+			calls = append(calls, fmt.Sprintf("%s %s", source.Call, source.Pos))
+		}
+	}
+	if strings.Join(calls, ",") != "f 2:1" {
+		t.Errorf("wrong calls. got=%v", calls)
+	}
+
+	// 関数の中の命令の位置は、その関数のSourcesから分かる
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	if pos := fn.Sources.Lookup(len(fn.Instructions) - 1).Pos.String(); pos != "1:17" {
+		t.Errorf("wrong position of the last instruction. got=%s", pos)
+	}
+}
+
+func TestBytecodeDump(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse(`let f = fn(a, ...b) { a }; f("x")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `constants:
+   0 COMPILED_FUNCTION f (params=1, locals=2, rest)
+       0000 OpGetLocal 0
+       0002 OpReturnValue
+   1 STRING "x"
+globals:
+   0 f
+instructions:
+0000 OpClosure 0
+0003 OpSetGlobal 0
+0006 OpGetGlobal 0
+0009 OpConstant 1
+0012 OpCall 1
+0014 OpPop
+`
+
+	var out strings.Builder
+	if err := c.Bytecode().Dump(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong dump.\nwant:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"const x = 1; x = 2;", "1:14: cannot assign to constant: x"},
+		{"fn() { const x = 1; x = 2; }", "1:21: cannot assign to constant: x"},
+		{"y = 1", "1:1: identifier not found: y"},
+		{"match ([1, 2]) { [...xs, x] => x }", "1:19: ... must be the last element of an array pattern"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+
+	a := global.Define("a")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("wrong symbol for a. got=%+v", a)
+	}
+	b := global.DefineConst("b")
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1, Const: true}) {
+		t.Errorf("wrong symbol for b. got=%+v", b)
+	}
+	// 同じ名前を定義し直しても番号は変わらない
+	if again := global.Define("a"); again.Index != 0 {
+		t.Errorf("redefined a has wrong index. got=%d", again.Index)
+	}
+	if _, ok := global.Resolve("c"); ok {
+		t.Errorf("c should not be resolved")
+	}
+	if names := global.Names(); strings.Join(names, ",") != "a,b" {
+		t.Errorf("wrong names. got=%v", names)
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+
+		err = testConstants(tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) error {
+	concatted := concatInstructions(expected)
+
+	if len(actual) != len(concatted) {
+		return fmt.Errorf("wrong instructions length.\nwant=%v\ngot =%v", concatted, actual)
+	}
+
+	for i, ins := range concatted {
+		if actual[i] != ins {
+			return fmt.Errorf("wrong instruction at %d.\nwant=%v\ngot =%v", i, concatted, actual)
+		}
+	}
+
+	return nil
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
+
+func testConstants(expected []interface{}, actual []object.Object) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("wrong number of constants. got=%d, want=%d", len(actual), len(expected))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				return fmt.Errorf("constant %d - object is not Integer %d. got=%s", i, constant, actual[i].Inspect())
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				return fmt.Errorf("constant %d - object is not String %q. got=%s", i, constant, actual[i].Inspect())
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+			if err := testInstructions(constant, fn.Instructions); err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestEncodeDecode(t *testing.T) {
+	c := New()
+	input := `let add = fn(a, ...rest) { a + len(rest) }; let x = 1.5; add(x, "s", 99999999999999999999)`
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytecode); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), Magic) {
+		t.Errorf("encoded bytecode does not start with the magic header")
+	}
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	// 命令と定数、グローバル変数が全て同じなら、ダンプも同じになる
+	var want, got strings.Builder
+	bytecode.Dump(&want)
+	decoded.Dump(&got)
+	if got.String() != want.String() {
+		t.Errorf("decoded bytecode differs.\nwant:\n%s\ngot:\n%s", want.String(), got.String())
+	}
+	// ダンプには出ないソースコード上の位置も復元される
+	if !reflect.DeepEqual(decoded.Sources, bytecode.Sources) {
+		t.Errorf("decoded sources differ. want=%v, got=%v", bytecode.Sources, decoded.Sources)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse("let x = 1; x")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, c.Bytecode()); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	withVersion := append([]byte{}, valid...)
+	withVersion[len(Magic)+1] = Version + 1
+
+	// 最後の命令(OpPop)を定義されていないオペコードにする
+	bad := *c.Bytecode()
+	bad.Instructions = append(code.Instructions{}, bad.Instructions...)
+	bad.Instructions[len(bad.Instructions)-1] = 255
+	var badOpcode bytes.Buffer
+	if err := Encode(&badOpcode, &bad); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte("let x = 1;"), "not a monkey bytecode file"},
+		{withVersion, fmt.Sprintf("unsupported bytecode version %d (want %d)", Version+1, Version)},
+		{valid[:len(valid)-3], "invalid bytecode: unexpected EOF"},
+		{badOpcode.Bytes(), "invalid bytecode: 0009: opcode 255 undefined"},
+	}
+
+	for i, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.input))
+		if err == nil {
+			t.Errorf("tests[%d]: expected an error", i)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("tests[%d]: wrong error. want=%q, got=%q", i, tt.expected, err.Error())
+		}
+	}
+}
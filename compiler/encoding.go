@@ -0,0 +1,381 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"monkey/code"
+	"monkey/object"
+)
+
+// バイトコードのファイルの先頭に置く印。
+var Magic = []byte("MKBC")
+
+// バイトコードのファイルの形式の版。オペコードを変えたり足したりしたら上げる。
+// Decodeは同じ版のファイルしか読まない。
+const Version = 3
+
+// 定数の種類を表す印。
+const (
+	constInteger  byte = 'i'
+	constBigInt   byte = 'b'
+	constFloat    byte = 'f'
+	constString   byte = 's'
+	constFunction byte = 'F'
+)
+
+var ErrNotBytecode = errors.New("not a monkey bytecode file")
+
+// バイトコードをファイルに保存できる形式でwに書き出す。
+//
+//	magic "MKBC"
+//	version uint16
+//	グローバル変数の名前の数と、名前
+//	定数の数と、定数（種類の印 + 値）
+//	トップレベルの命令
+//	トップレベルの命令のソースコード上の位置
+//
+// 数や長さは符号なしのvarintで、整数の定数は符号付きのvarintで書く。
+func Encode(w io.Writer, b *Bytecode) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.write(Magic)
+	e.uint16(Version)
+
+	e.uvarint(uint64(len(b.Globals)))
+	for _, name := range b.Globals {
+		e.string(name)
+	}
+
+	e.uvarint(uint64(len(b.Constants)))
+	for _, constant := range b.Constants {
+		e.constant(constant)
+	}
+
+	e.bytes(b.Instructions)
+	e.sources(b.Sources)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error // 最初に起きたエラー。起きた後は何も書かない
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	binary.BigEndian.PutUint16(e.buf[:], v)
+	e.write(e.buf[:2])
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *encoder) varint(v int64) {
+	e.write(e.buf[:binary.PutVarint(e.buf[:], v)])
+}
+
+func (e *encoder) bytes(p []byte) {
+	e.uvarint(uint64(len(p)))
+	e.write(p)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+// 命令の位置と、ソースコード上の位置、呼び出した式の名前を並べる。
+func (e *encoder) sources(m code.SourceMap) {
+	e.uvarint(uint64(len(m)))
+	for _, source := range m {
+		e.uvarint(uint64(source.Offset))
+		e.uvarint(uint64(source.Pos.Offset))
+		e.uvarint(uint64(source.Pos.Line))
+		e.uvarint(uint64(source.Pos.Column))
+		e.string(source.Call)
+	}
+}
+
+func (e *encoder) constant(obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.write([]byte{constInteger})
+		e.varint(obj.Value)
+	case *object.BigInt:
+		// int64に収まらない整数は、10進数の文字列で書く
+		e.write([]byte{constBigInt})
+		e.string(obj.Value.String())
+	case *object.Float:
+		e.write([]byte{constFloat})
+		binary.BigEndian.PutUint64(e.buf[:], math.Float64bits(obj.Value))
+		e.write(e.buf[:8])
+	case *object.String:
+		e.write([]byte{constString})
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.write([]byte{constFunction})
+		e.string(obj.Name)
+		e.uvarint(uint64(obj.NumParameters))
+		if obj.Rest {
+			e.write([]byte{1})
+		} else {
+			e.write([]byte{0})
+		}
+		e.uvarint(uint64(len(obj.Locals)))
+		for _, name := range obj.Locals {
+			e.string(name)
+		}
+		e.bytes(obj.Instructions)
+		e.sources(obj.Sources)
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("cannot encode constant of type %s", obj.Type())
+		}
+	}
+}
+
+// Encodeで書き出したバイトコードを読む。
+// 壊れたファイルでVMが落ちないように、命令のオペコードと、命令が参照する定数や変数の番号が正しいかも調べる。
+func Decode(r io.Reader) (*Bytecode, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || !bytes.Equal(magic, Magic) {
+		return nil, ErrNotBytecode
+	}
+	if version := d.uint16(); d.err == nil && version != Version {
+		return nil, fmt.Errorf("unsupported bytecode version %d (want %d)", version, Version)
+	}
+
+	b := &Bytecode{}
+	b.Globals = make([]string, d.count())
+	for i := range b.Globals {
+		b.Globals[i] = d.string()
+	}
+	b.Constants = make([]object.Object, d.count())
+	for i := range b.Constants {
+		b.Constants[i] = d.constant()
+	}
+	b.Instructions = d.bytes()
+	b.Sources = d.sources()
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %s", d.err)
+	}
+
+	if err := verify(b.Instructions, b, 0); err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %s", err)
+	}
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := verify(fn.Instructions, b, fn.NumLocals); err != nil {
+				return nil, fmt.Errorf("invalid bytecode: constant %d: %s", i, err)
+			}
+		}
+	}
+	return b, nil
+}
+
+type decoder struct {
+	r   *bufio.Reader
+	err error // 最初に起きたエラー。起きた後は何も読まずにゼロ値を返す
+}
+
+// 壊れたファイルの長さで巨大なスライスを作らないように、数や長さの上限を決めておく。
+const maxLength = 1 << 28
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *decoder) uint16() uint16 {
+	var buf [2]byte
+	if d.err == nil {
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			d.fail(err)
+		}
+	}
+	return binary.BigEndian.Uint16(buf[:])
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return c
+}
+
+func (d *decoder) count() int {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+		return 0
+	}
+	if n > maxLength {
+		d.fail(fmt.Errorf("length %d too large", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	p := make([]byte, d.count())
+	if d.err == nil {
+		if _, err := io.ReadFull(d.r, p); err != nil {
+			d.fail(err)
+		}
+	}
+	return p
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) sources() code.SourceMap {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	m := make(code.SourceMap, n)
+	for i := range m {
+		m[i].Offset = d.count()
+		m[i].Pos.Offset = d.count()
+		m[i].Pos.Line = d.count()
+		m[i].Pos.Column = d.count()
+		m[i].Call = d.string()
+	}
+	return m
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case constInteger:
+		v, err := binary.ReadVarint(d.r)
+		if err != nil {
+			d.fail(err)
+		}
+		return object.NewInteger(v)
+	case constBigInt:
+		s := d.string()
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			d.fail(fmt.Errorf("invalid integer constant %q", s))
+			return nil
+		}
+		return &object.BigInt{Value: n}
+	case constFloat:
+		var buf [8]byte
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			d.fail(err)
+		}
+		return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(buf[:]))}
+	case constString:
+		return &object.String{Value: d.string()}
+	case constFunction:
+		fn := &object.CompiledFunction{Name: d.string()}
+		fn.NumParameters = d.count()
+		fn.Rest = d.byte() == 1
+		fn.Locals = make([]string, d.count())
+		for i := range fn.Locals {
+			fn.Locals[i] = d.string()
+		}
+		fn.NumLocals = len(fn.Locals)
+		fn.Instructions = d.bytes()
+		fn.Sources = d.sources()
+		if d.err == nil && fn.NumParameters+boolOperand(fn.Rest) > fn.NumLocals {
+			d.fail(fmt.Errorf("function %q has more parameters than locals", fn.Name))
+		}
+		return fn
+	default:
+		if d.err == nil {
+			d.fail(fmt.Errorf("unknown constant type %q", tag))
+		}
+		return nil
+	}
+}
+
+// insの命令を一つずつ読み、オペコードが定義されていて、オペランドが定数やグローバル変数、
+// numLocals個のローカル変数の範囲に収まっているかを調べる。
+func verify(ins code.Instructions, b *Bytecode, numLocals int) error {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("%04d: %s", i, err)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fmt.Errorf("%04d: truncated %s", i, def.Name)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		switch code.Opcode(ins[i]) {
+		case code.OpConstant, code.OpClosure, code.OpInfix, code.OpGetBuiltin, code.OpGetProperty, code.OpSetProperty, code.OpClass:
+			if operands[0] >= len(b.Constants) {
+				return fmt.Errorf("%04d: %s refers to missing constant %d", i, def.Name, operands[0])
+			}
+			if err := checkConstantType(code.Opcode(ins[i]), b.Constants[operands[0]]); err != nil {
+				return fmt.Errorf("%04d: %s", i, err)
+			}
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if operands[0] >= len(b.Globals) {
+				return fmt.Errorf("%04d: %s refers to missing global %d", i, def.Name, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("%04d: %s refers to missing local %d", i, def.Name, operands[0])
+			}
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNotNull, code.OpJumpNull, code.OpIterNext, code.OpTry,
+			code.OpMatchArray, code.OpMatchHash, code.OpMatchValue:
+			// ジャンプ先は最後のオペランド
+			if operands[len(operands)-1] > len(ins) {
+				return fmt.Errorf("%04d: %s jumps out of the instructions", i, def.Name)
+			}
+		}
+		i += 1 + read
+	}
+	return nil
+}
+
+// 命令が参照する定数の種類が合っているか。OpClosureは関数、名前を参照する命令は文字列でなければならない。
+func checkConstantType(op code.Opcode, constant object.Object) error {
+	switch op {
+	case code.OpClosure:
+		if _, ok := constant.(*object.CompiledFunction); !ok {
+			return fmt.Errorf("OpClosure refers to %s", constant.Type())
+		}
+	case code.OpInfix, code.OpGetBuiltin, code.OpGetProperty, code.OpSetProperty, code.OpClass:
+		if _, ok := constant.(*object.String); !ok {
+			return fmt.Errorf("name constant is %s", constant.Type())
+		}
+	}
+	return nil
+}
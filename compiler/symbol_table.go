@@ -0,0 +1,64 @@
+package compiler
+
+// 変数がどこに束縛されているか。
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"  // トップレベルの変数。VMのグローバル変数の配列に入る
+	LocalScope   SymbolScope = "LOCAL"   // 実行中の関数のローカル変数
+	FreeScope    SymbolScope = "FREE"    // 外側の関数のローカル変数。クロージャから参照する
+	BuiltinScope SymbolScope = "BUILTIN" // どこにも束縛されていない名前。実行時に組み込み関数を名前で探す
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int  // グローバル変数、ローカル変数の番号
+	Depth int  // FreeScopeの場合に、何個外側の関数の変数か
+	Const bool // constで束縛されたか
+}
+
+// グローバル変数の名前と番号の対応。
+// 関数のローカル変数はresolverがast.Identifier.Bindingに決めた場所を使うので、ここではトップレベルの変数だけを扱う。
+// REPLでは一行ごとにコンパイルするので、同じSymbolTableを使い続けて前の行の変数を参照できるようにする。
+type SymbolTable struct {
+	store          map[string]Symbol
+	numDefinitions int
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+// nameをグローバル変数として定義する。既に定義されていれば、同じ番号のSymbolを返す。
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+	symbol := Symbol{Name: name, Scope: GlobalScope, Index: s.numDefinitions}
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// nameをconstで束縛されたグローバル変数にする。
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Const = true
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	return symbol, ok
+}
+
+// グローバル変数の名前を番号の順に返す。
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for name, symbol := range s.store {
+		names[symbol.Index] = name
+	}
+	return names
+}
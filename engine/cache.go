@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"sync"
+)
+
+// ソースコードのハッシュをキーに、パースしたプログラムとコンパイルしたバイトコードを覚えておく。
+// 同じテンプレートやルールを何度も実行する組み込み先が、毎回字句解析とパースをやり直さずに済む。
+// 評価器もVMもプログラムやバイトコードを書き換えないので、同じものを複数の環境やgoroutineで同時に実行してよい。
+// 複数のgoroutineから使える。
+type Cache struct {
+	max int // 覚えておくソースコードの数。0なら上限なし
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // 最近使った順。溢れたら後ろから捨てる
+}
+
+type cacheEntry struct {
+	key      [sha256.Size]byte
+	program  *ast.Program
+	err      error // パースエラー。エラーになるソースコードも、もう一度パースしないように覚えておく
+	bytecode *compiler.Bytecode
+	cerr     error // コンパイルエラー
+}
+
+// 最近使ったmax個のソースコードを覚えておくCacheを作る。maxが0なら上限なし。
+func NewCache(max int) *Cache {
+	return &Cache{max: max, entries: make(map[[sha256.Size]byte]*list.Element), order: list.New()}
+}
+
+// srcをパースしたプログラムを返す。前に同じsrcをパースしていれば、そのときのプログラムを返す。
+// パースエラーの場合は、全てのエラーを一つにまとめたエラーを返す。
+func (c *Cache) Parse(src string) (*ast.Program, error) {
+	e := c.entry(src)
+	return e.program, e.err
+}
+
+// srcをコンパイルしたバイトコードを返す。パースしたプログラムと同じく、前にコンパイルしていればそれを返す。
+// バイトコードはvm.Newに渡して、毎回新しいグローバル変数で実行する。
+func (c *Cache) Compile(src string) (*compiler.Bytecode, error) {
+	e := c.entry(src)
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.bytecode == nil && e.cerr == nil {
+		comp := compiler.New()
+		if e.cerr = comp.Compile(e.program); e.cerr == nil {
+			e.bytecode = comp.Bytecode()
+		}
+	}
+	return e.bytecode, e.cerr
+}
+
+// 覚えているソースコードの数。
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) entry(src string) *cacheEntry {
+	key := sha256.Sum256([]byte(src))
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cacheEntry)
+	}
+	c.mu.Unlock()
+
+	// パースには時間がかかるので、ロックを外して行う。同時に同じソースコードをパースした場合は、先に覚えた方を使う
+	e := &cacheEntry{key: key}
+	p := parser.New(lexer.New(src))
+	e.program = p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		e.program = nil
+		e.err = errors.New("parse error: " + strings.Join(p.Errors(), "; "))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*cacheEntry)
+	}
+	c.entries[key] = c.order.PushFront(e)
+	if c.max > 0 && c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return e
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/vm"
+	"strings"
+)
+
+// エンジンの名前。
+const (
+	Eval = "eval" // ASTを辿って評価する評価器
+	VM   = "vm"   // バイトコードにコンパイルしてVMで実行する
+)
+
+// 選べるエンジンの名前。
+var Names = []string{Eval, VM}
+
+// プログラムを実行するもの。評価器とVMのどちらでも、同じプログラムは同じ結果になる。
+type Engine interface {
+	// programを実行し、評価器のEvalと同じくプログラムの値を返す。
+	// 続けて実行した場合は、前の実行でトップレベルに束縛した変数を参照できる。
+	// 実行している間はctxを使い、ctxがキャンセルされると "evaluation interrupted" のエラーで中断する。
+	Run(ctx context.Context, program *ast.Program) object.Object
+
+	// トップレベルの変数nameの値。束縛されていなければfalse。
+	Get(name string) (object.Object, bool)
+
+	// トップレベルの変数nameにvalueを束縛する。次の実行からその名前で参照できる。
+	// constで束縛された変数は上書きせず、エラーを返す。
+	Set(name string, value object.Object) error
+}
+
+// nameのエンジンを作る。envの組み込み関数と出力先を使って実行する。
+func New(name string, env *object.Environment) (Engine, error) {
+	switch name {
+	case Eval:
+		return &evalEngine{env: env}, nil
+	case VM:
+		return &vmEngine{env: env, symbols: compiler.NewSymbolTable()}, nil
+	}
+	return nil, fmt.Errorf("unknown engine %q (want %s)", name, strings.Join(Names, " or "))
+}
+
+type evalEngine struct {
+	env *object.Environment
+}
+
+func (e *evalEngine) Run(ctx context.Context, program *ast.Program) object.Object {
+	return evaluator.EvalContext(ctx, program, e.env)
+}
+
+func (e *evalEngine) Get(name string) (object.Object, bool) {
+	return e.env.Get(name)
+}
+
+func (e *evalEngine) Set(name string, value object.Object) error {
+	if err, ok := e.env.Set(name, value).(*object.Error); ok {
+		return errors.New(err.Message)
+	}
+	return nil
+}
+
+// トップレベルの変数はenvではなくVMのグローバル変数に束縛されるので、
+// 次の実行のためにシンボルテーブルと定数、グローバル変数の値を持ち越す。
+type vmEngine struct {
+	env       *object.Environment
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+	globals   []object.Object
+}
+
+func (e *vmEngine) Run(ctx context.Context, program *ast.Program) object.Object {
+	c := compiler.NewWithState(e.symbols, e.constants)
+	if err := c.Compile(program); err != nil {
+		return &object.Error{Message: "compile error: " + err.Error()}
+	}
+	bytecode := c.Bytecode()
+	e.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobals(bytecode, e.globals)
+	machine.SetEnvironment(e.env.WithContext(ctx))
+	result := machine.Run()
+	e.globals = machine.Globals()
+	return result
+}
+
+func (e *vmEngine) Get(name string) (object.Object, bool) {
+	symbol, ok := e.symbols.Resolve(name)
+	if !ok || symbol.Index >= len(e.globals) || e.globals[symbol.Index] == nil {
+		return nil, false
+	}
+	return e.globals[symbol.Index], true
+}
+
+// 次の実行でコンパイラがこの名前をグローバル変数として参照するように、シンボルテーブルにも定義する。
+func (e *vmEngine) Set(name string, value object.Object) error {
+	if symbol, ok := e.symbols.Resolve(name); ok && symbol.Const {
+		return errors.New("cannot assign to constant: " + name)
+	}
+	symbol := e.symbols.Define(name)
+	if symbol.Index >= len(e.globals) {
+		e.globals = append(e.globals, make([]object.Object, symbol.Index+1-len(e.globals))...)
+	}
+	e.globals[symbol.Index] = value
+	return nil
+}
@@ -0,0 +1,383 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testdata/conformance の各スクリプトを全てのエンジンで実行し、同じ .out の内容になるかを調べる。
+// .out はスクリプトの出力に続けて、プログラムの値があれば "=> 値" の行を書いたもの。
+// エラーはInspectで書くので、メッセージだけでなく位置と呼び出しの履歴も同じになる必要がある。
+// 評価器とVMの片方だけを変更して結果が食い違った場合は、ここで気付ける。
+func TestConformance(t *testing.T) {
+	testConformance(t, context.Background())
+}
+
+// 配列とハッシュをVectorとMapにしても、スクリプトの結果は変わらない
+func TestConformancePersistent(t *testing.T) {
+	testConformance(t, evaluator.WithPersistentCollections(context.Background()))
+}
+
+// testdata/conformance の各スクリプトを、ctxの設定で全てのエンジンで実行する。
+func testConformance(t *testing.T, ctx context.Context) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.monkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no conformance scripts found")
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := ioutil.ReadFile(strings.TrimSuffix(file, ".monkey") + ".out")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range Names {
+			got := runScriptContext(t, ctx, name, string(src))
+			if got != string(expected) {
+				t.Errorf("%s (%s): wrong result.\nwant:\n%s\ngot:\n%s", filepath.Base(file), name, expected, got)
+			}
+		}
+	}
+}
+
+// ifの条件が true でも false でもなければ、どちらのエンジンでも同じエラーになる
+func TestStrictConditions(t *testing.T) {
+	ctx := evaluator.WithStrictConditions(context.Background())
+
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"if (1 < 2) { 10 } else { 20 }", "=> 10\n"},
+		{"if (5) { 10 }", "=> ERROR: condition must be BOOLEAN, got INTEGER (at 1:1)\n"},
+		{"let xs = []; if (xs) { 10 }", "=> ERROR: condition must be BOOLEAN, got ARRAY (at 1:14)\n"},
+		{"!0", "=> ERROR: operand of ! must be BOOLEAN, got INTEGER (at 1:1)\n"},
+	}
+	for _, tt := range tests {
+		for _, name := range Names {
+			if got := runScriptContext(t, ctx, name, tt.src); got != tt.expected {
+				t.Errorf("%s (%s): got %q, want %q", tt.src, name, got, tt.expected)
+			}
+		}
+	}
+}
+
+func runScript(t *testing.T, name, src string) string {
+	t.Helper()
+	return runScriptContext(t, context.Background(), name, src)
+}
+
+// ctxの設定で、srcをnameのエンジンで実行した出力と値。
+func runScriptContext(t *testing.T, ctx context.Context, name, src string) string {
+	t.Helper()
+	return runScriptEnv(t, ctx, name, object.NewEnvironment(), src)
+}
+
+// envの組み込み関数で、srcをnameのエンジンで実行した出力と値。
+func runScriptEnv(t *testing.T, ctx context.Context, name string, env *object.Environment, src string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	env.SetOutput(&out)
+	e, err := New(name, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	if result := e.Run(ctx, program); result != nil {
+		out.WriteString("=> " + result.Inspect() + "\n")
+	}
+	return out.String()
+}
+
+// 評価器のテストの入力を全てのエンジンで実行し、評価器と同じ出力と値になるかを調べる。
+// testdata/conformance のスクリプトより多くの言語の機能を、評価器のテストを追加するだけで比べられる。
+// VMがまだ評価器と同じにならない入力は testdata/conformance/known_gaps.txt に理由と一緒に書いておく。
+// そこに書いた入力が同じ結果になった場合も、一覧から消すように失敗する。
+func TestEvaluatorCorpus(t *testing.T) {
+	gaps := knownGaps(t)
+	for _, input := range evaluatorCorpus(t) {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			continue // パースのエラーのテスト
+		}
+
+		want := runCorpusInput(t, Eval, input)
+		got := runCorpusInput(t, VM, input)
+		switch _, known := gaps[input]; {
+		case got != want && !known:
+			t.Errorf("%q (vm): wrong result.\nwant:\n%s\ngot:\n%s", input, want, got)
+		case got == want && known:
+			t.Errorf("%q (vm): listed in known_gaps.txt but gives the same result", input)
+		}
+		delete(gaps, input)
+	}
+	for input := range gaps {
+		t.Errorf("%q: listed in known_gaps.txt but not in the evaluator tests", input)
+	}
+}
+
+// 評価器のテストの表に書かれた入力。表の各行の最初の文字列リテラルを、テストのソースコードから取り出す。
+func evaluatorCorpus(t *testing.T) []string {
+	file, err := goparser.ParseFile(gotoken.NewFileSet(), filepath.Join("..", "evaluator", "evaluator_test.go"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inputs []string
+	seen := map[string]bool{}
+	goast.Inspect(file, func(node goast.Node) bool {
+		// 型を省略した複合リテラルが表の行になる。{"1 + 2", 3} など
+		row, ok := node.(*goast.CompositeLit)
+		if !ok || row.Type != nil || len(row.Elts) == 0 {
+			return true
+		}
+		lit, ok := row.Elts[0].(*goast.BasicLit)
+		if !ok || lit.Kind != gotoken.STRING {
+			return true
+		}
+		if input, err := strconv.Unquote(lit.Value); err == nil && !seen[input] {
+			seen[input] = true
+			inputs = append(inputs, input)
+		}
+		return true
+	})
+	if len(inputs) == 0 {
+		t.Fatal("no inputs found in the evaluator tests")
+	}
+	return inputs
+}
+
+// testdata/conformance/known_gaps.txt の入力。
+// 一行に一つ、Goの文字列リテラルで書く。空行と # で始まる行は、理由を書くためのコメント。
+func knownGaps(t *testing.T) map[string]bool {
+	src, err := ioutil.ReadFile(filepath.Join("testdata", "conformance", "known_gaps.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gaps := map[string]bool{}
+	for i, line := range strings.Split(string(src), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		input, err := strconv.Unquote(line)
+		if err != nil {
+			t.Fatalf("known_gaps.txt:%d: %s", i+1, err)
+		}
+		gaps[input] = true
+	}
+	return gaps
+}
+
+// 評価器のテストの入力を、ファイルやネットワーク、コマンドの実行を禁止して実行する。
+// 終わらない入力があっても止まるように、操作の数も制限する。
+func runCorpusInput(t *testing.T, name, input string) string {
+	t.Helper()
+
+	registry := evaluator.NewBuiltinRegistry(evaluator.Builtins)
+	evaluator.RegisterFileBuiltins(registry, evaluator.DenyAllFiles)
+	evaluator.RegisterHTTPBuiltins(registry, evaluator.DenyAllNetwork)
+	evaluator.RegisterExecBuiltins(registry, evaluator.DenyAllExec)
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+
+	return runScriptEnv(t, evaluator.WithStepLimit(context.Background(), 100000), name, env, input)
+}
+
+// 続けて実行すると、前の実行で束縛した変数を参照できる
+func TestRunKeepsGlobals(t *testing.T) {
+	for _, name := range Names {
+		e, err := New(name, object.NewEnvironment())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range []string{"let x = 2;", "let double = fn(n) { n * x };"} {
+			e.Run(context.Background(), parser.New(lexer.New(input)).ParseProgram())
+		}
+		result := e.Run(context.Background(), parser.New(lexer.New("double(21)")).ParseProgram())
+		if result == nil || result.Inspect() != "42" {
+			t.Errorf("%s: wrong result. got=%v", name, result)
+		}
+	}
+}
+
+// 前の実行のContextがキャンセルされても、その実行で定義した関数は次の実行のContextで呼び出せる
+func TestRunAfterCancel(t *testing.T) {
+	for _, name := range Names {
+		e, err := New(name, object.NewEnvironment())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		e.Run(ctx, parser.New(lexer.New("let inc = fn(x) { x + 1 };")).ParseProgram())
+		cancel()
+
+		result := e.Run(context.Background(), parser.New(lexer.New("map([1, 2], inc)")).ParseProgram())
+		if result == nil || result.Inspect() != "[2, 3]" {
+			t.Errorf("%s: wrong result. got=%v", name, result)
+		}
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	for _, name := range Names {
+		e, err := New(name, object.NewEnvironment())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		program := parser.New(lexer.New("let f = fn(n) { f(n + 1) }; f(0)")).ParseProgram()
+		result, ok := e.Run(ctx, program).(*object.Error)
+		if !ok || result.Message != "evaluation interrupted: context canceled" {
+			t.Errorf("%s: wrong result. got=%v", name, result)
+		}
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	_, err := New("jit", object.NewEnvironment())
+	if err == nil || err.Error() != `unknown engine "jit" (want eval or vm)` {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+// 同じソースコードは一度しかパースせず、同じプログラムを何度でも実行できる
+func TestCacheParse(t *testing.T) {
+	c := NewCache(0)
+	src := "let add = fn(a, b) { a + b }; add(1, 2)"
+
+	first, err := c.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := c.Parse(src)
+	if first != second {
+		t.Errorf("same source was parsed twice")
+	}
+	if other, _ := c.Parse("1 + 2"); other == first {
+		t.Errorf("different source returned the same program")
+	}
+
+	for i := 0; i < 2; i++ {
+		result := evaluator.Eval(first, object.NewEnvironment())
+		if result == nil || result.Inspect() != "3" {
+			t.Errorf("wrong result. got=%v", result)
+		}
+	}
+}
+
+func TestCacheParseError(t *testing.T) {
+	c := NewCache(0)
+	for i := 0; i < 2; i++ {
+		program, err := c.Parse("let = 1;")
+		if program != nil || err == nil || !strings.HasPrefix(err.Error(), "parse error: ") {
+			t.Errorf("wrong result. got=%v, %v", program, err)
+		}
+	}
+	if c.Len() != 1 {
+		t.Errorf("parse error was not cached. got=%d", c.Len())
+	}
+	if _, err := c.Compile("let = 1;"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// 上限を超えると、最も長く使っていないソースコードから捨てる
+func TestCacheEviction(t *testing.T) {
+	c := NewCache(2)
+	a, _ := c.Parse("1")
+	c.Parse("2")
+	c.Parse("1")
+	c.Parse("3")
+
+	if c.Len() != 2 {
+		t.Errorf("wrong length. got=%d", c.Len())
+	}
+	if again, _ := c.Parse("1"); again != a {
+		t.Errorf("recently used source was evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("wrong length. got=%d", c.Len())
+	}
+}
+
+func TestCacheCompile(t *testing.T) {
+	c := NewCache(0)
+	src := "let x = 20; let f = fn() { x + 1 }; f() * 2"
+
+	first, err := c.Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := c.Compile(src)
+	if first != second {
+		t.Errorf("same source was compiled twice")
+	}
+
+	for i := 0; i < 2; i++ {
+		result := vm.New(first).Run()
+		if result == nil || result.Inspect() != "42" {
+			t.Errorf("wrong result. got=%v", result)
+		}
+	}
+
+	if _, err := c.Compile("match ([1, 2]) { [...xs, x] => x }"); err == nil {
+		t.Errorf("expected a compile error")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := NewCache(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := fmt.Sprintf("%d * 2", i%3)
+			program, err := c.Parse(src)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			result := evaluator.Eval(program, object.NewEnvironment())
+			if result == nil || result.Inspect() != fmt.Sprint(i%3*2) {
+				t.Errorf("wrong result. got=%v", result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.Len() != 3 {
+		t.Errorf("wrong length. got=%d", c.Len())
+	}
+}
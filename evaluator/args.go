@@ -0,0 +1,179 @@
+package evaluator
+
+import (
+	"fmt"
+	"monkey/object"
+	"strings"
+)
+
+// 組み込み関数の引数一つ分の指定。Nameはエラーメッセージに使う型の名前。
+type Param struct {
+	Name     string
+	Accept   func(object.Object) bool
+	optional bool
+	variadic bool
+}
+
+// 型を指定して引数を受け取る。
+func paramOf(t object.ObjectType) Param {
+	return Param{
+		Name:   string(t),
+		Accept: func(obj object.Object) bool { return obj.Type() == t },
+	}
+}
+
+var (
+	ANY     = Param{Name: "ANY", Accept: func(object.Object) bool { return true }}
+	ARRAY   = paramOf(object.ARRAY_OBJ)
+	HASH    = paramOf(object.HASH_OBJ)
+	SET     = paramOf(object.SET_OBJ)
+	STRING  = paramOf(object.STRING_OBJ)
+	INTEGER = paramOf(object.INTEGER_OBJ)
+	REGEX   = paramOf(object.REGEX_OBJ)
+	// string_builder()で作ったもの
+	STRING_BUILDER = paramOf(object.STRING_BUILDER_OBJ)
+	// 整数(IntegerとBigInt)と小数
+	NUMBER = Param{Name: "NUMBER", Accept: isNumber}
+	// for-inで回せるもの
+	ITERABLE = Param{Name: "ITERABLE", Accept: func(obj object.Object) bool {
+		_, ok := obj.(object.Iterable)
+		return ok
+	}}
+	// applyFunctionで呼び出せるもの
+	FUNCTION = Param{Name: "FUNCTION", Accept: func(obj object.Object) bool {
+		switch obj.(type) {
+		case *object.Function, *object.Builtin, *object.BoundMethod, *object.Class, object.Callable:
+			return true
+		}
+		return false
+	}}
+)
+
+// どれかの指定に合えば受け取る。 oneOf(STRING, REGEX) のエラーメッセージは "expected STRING or REGEX" になる。
+func oneOf(params ...Param) Param {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+	}
+	return Param{
+		Name: strings.Join(names, " or "),
+		Accept: func(obj object.Object) bool {
+			for _, p := range params {
+				if p.Accept(obj) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// 省略できる引数。省略できる引数の後に、省略できない引数を置くことはできない。
+func optional(p Param) Param {
+	p.optional = true
+	return p
+}
+
+// 0個以上の同じ種類の引数。最後の引数にだけ使える。
+func variadic(p Param) Param {
+	p.variadic = true
+	return p
+}
+
+// 同じ指定をn個並べる。
+func repeatParam(p Param, n int) []Param {
+	params := make([]Param, n)
+	for i := range params {
+		params[i] = p
+	}
+	return params
+}
+
+// builtin("first", args(ARRAY)) のように、引数の指定を並べる。
+func args(params ...Param) []Param {
+	return params
+}
+
+// 組み込み関数の名前と引数の指定。
+// builtin("first", args(ARRAY)).Fn(...) のように、引数を確かめてから関数を呼ぶBuiltinを作る。
+// 引数の数が違う場合は "first: wrong number of arguments. got=2, want=1"、
+// 型が違う場合は "first: expected ARRAY, got INTEGER at argument 1" のエラーになる。
+type BuiltinSpec struct {
+	name   string
+	params []Param
+}
+
+func builtin(name string, params []Param) *BuiltinSpec {
+	return &BuiltinSpec{name: name, params: params}
+}
+
+// 引数を確かめて、指定に合わなければエラーを返す。
+func (s *BuiltinSpec) Check(args []object.Object) *object.Error {
+	min, max := s.arity()
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return newError("%s: wrong number of arguments. got=%d, want%s", s.name, len(args), wantArity(min, max))
+	}
+
+	for i, arg := range args {
+		p := s.params[len(s.params)-1]
+		if i < len(s.params) {
+			p = s.params[i]
+		}
+		if !p.Accept(arg) {
+			return newError("%s: expected %s, got %s at argument %d", s.name, p.Name, arg.Type(), i+1)
+		}
+	}
+	return nil
+}
+
+// 受け取れる引数の数。上限がない場合はmaxが-1になる。
+func (s *BuiltinSpec) arity() (min, max int) {
+	for _, p := range s.params {
+		switch {
+		case p.variadic:
+			return min, -1
+		case !p.optional:
+			min++
+		}
+	}
+	return min, len(s.params)
+}
+
+// "=1"、"=1 or 2"、"=1, 2 or 3"、" at least 1" のように、受け取れる引数の数を書く。
+func wantArity(min, max int) string {
+	switch {
+	case max < 0:
+		return fmt.Sprintf(" at least %d", min)
+	case min == max:
+		return fmt.Sprintf("=%d", min)
+	}
+	counts := make([]string, 0, max-min+1)
+	for n := min; n <= max; n++ {
+		counts = append(counts, fmt.Sprint(n))
+	}
+	return "=" + strings.Join(counts[:len(counts)-1], ", ") + " or " + counts[len(counts)-1]
+}
+
+// 引数を確かめてからfnを呼ぶBuiltinを作る。
+func (s *BuiltinSpec) Fn(fn object.BuiltinFunction) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if err := s.Check(args); err != nil {
+				return err
+			}
+			return fn(args...)
+		},
+	}
+}
+
+// Fnと同じく引数を確かめてから、呼び出し元の環境を受け取るfnを呼ぶBuiltinを作る。
+func (s *BuiltinSpec) FnEnv(fn object.EnvBuiltinFunction) *object.Builtin {
+	return &object.Builtin{
+		FnEnv: func(env *object.Environment, args ...object.Object) object.Object {
+			if err := s.Check(args); err != nil {
+				return err
+			}
+			return fn(env, args...)
+		},
+	}
+}
@@ -1,50 +1,110 @@
 package evaluator
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"monkey/object"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 )
 
-var builtins = map[string]*object.Builtin{
-	"puts": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+// 最初からBuiltinsに登録されている組み込み関数
+var defaultBuiltins = map[string]*object.Builtin{
+	// puts(values...) で値を一つずつ改行して、環境の出力先(Environment.Output)に出力する。
+	"puts": builtin("puts", args(variadic(ANY))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			values, err := inspectArgs("puts", args)
+			if err != nil {
+				return err
+			}
+			out := env.Output()
+			for _, value := range values {
+				fmt.Fprintln(out, value)
 			}
 
 			return NULL
 		},
-	},
-	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
+	),
+	// print(values...) で値を空白で区切って出力する。putsと違い最後に改行しない。
+	"print": builtin("print", args(variadic(ANY))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			values, err := inspectArgs("print", args)
+			if err != nil {
+				return err
 			}
+			io.WriteString(env.Output(), strings.Join(values, " "))
 
+			return NULL
+		},
+	),
+	// eputs(values...) でputsと同じように、環境のエラーの出力先(Environment.ErrorOutput)に出力する。
+	"eputs": builtin("eputs", args(variadic(ANY))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			values, err := inspectArgs("eputs", args)
+			if err != nil {
+				return err
+			}
+			out := env.ErrorOutput()
+			for _, value := range values {
+				fmt.Fprintln(out, value)
+			}
+
+			return NULL
+		},
+	),
+	// input() で環境の入力元(Environment.Input)から一行読み、改行を除いた文字列を返す。入力が終わっていればnull。
+	// input("name? ") のように渡した文字列は、読む前に改行せずに出力する。
+	"input": builtin("input", args(optional(STRING))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) == 1 {
+				io.WriteString(env.Output(), args[0].(*object.String).Value)
+			}
+			// 最後の行が改行で終わっていなくても、その行は返す
+			line, err := env.Input().ReadString('\n')
+			if err == io.EOF && line == "" {
+				return NULL
+			}
+			if err != nil && err != io.EOF {
+				return newError("input: %s", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			return &object.String{Value: strings.TrimSuffix(line, "\r")}
+		},
+	),
+	"len": builtin("len", args(oneOf(ARRAY_LIKE, STRING, SET, HASH_LIKE, paramOf(object.RANGE_OBJ)))).Fn(
+		func(args ...object.Object) object.Object {
 			// goのlenをそのまま使う
 			switch arg := args[0].(type) {
 			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
+				return object.NewInteger(int64(len(arg.Elements)))
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			default:
-				return newError("argument to `len` not supported, got %s",
-					args[0].Type())
+				return object.NewInteger(int64(len(arg.Value)))
+			case *object.Set:
+				return object.NewInteger(int64(len(arg.Keys)))
+			case *object.Hash:
+				return object.NewInteger(int64(len(arg.Pairs)))
+			case *object.Range:
+				return object.NewInteger(arg.Len())
+			case *object.Vector:
+				return object.NewInteger(int64(arg.Len()))
 			}
+
+			// Map。型はbuiltinの指定で確かめてある
+			return object.NewInteger(int64(args[0].(*object.Map).Len()))
 		},
-	},
-	"first": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s",
-					args[0].Type())
+	),
+	"first": builtin("first", args(ARRAY_LIKE)).Fn(
+		func(args ...object.Object) object.Object {
+			if v, ok := args[0].(*object.Vector); ok {
+				if v.Len() > 0 {
+					return v.Get(0)
+				}
+				return NULL
 			}
-
 			arr := args[0].(*object.Array)
 			if len(arr.Elements) > 0 {
 				return arr.Elements[0]
@@ -52,18 +112,15 @@ var builtins = map[string]*object.Builtin{
 
 			return NULL
 		},
-	},
-	"last": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s",
-					args[0].Type())
+	),
+	"last": builtin("last", args(ARRAY_LIKE)).Fn(
+		func(args ...object.Object) object.Object {
+			if v, ok := args[0].(*object.Vector); ok {
+				if v.Len() > 0 {
+					return v.Get(v.Len() - 1)
+				}
+				return NULL
 			}
-
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
 			if length > 0 {
@@ -72,19 +129,10 @@ var builtins = map[string]*object.Builtin{
 
 			return NULL
 		},
-	},
+	),
 	// 与えられた配列の最初の一つを除いた 新しい配列 を返す。
-	"rest": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s",
-					args[0].Type())
-			}
-
+	"rest": builtin("rest", args(ARRAY)).Fn(
+		func(args ...object.Object) object.Object {
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
 			if length > 0 {
@@ -96,18 +144,13 @@ var builtins = map[string]*object.Builtin{
 
 			return NULL
 		},
-	},
-	"push": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
-					len(args))
+	),
+	"push": builtin("push", args(ARRAY_LIKE, ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			// Vectorは元のVectorと構造を共有した新しいVectorを作るので、コピーしない
+			if v, ok := args[0].(*object.Vector); ok {
+				return v.Push(args[1])
 			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s",
-					args[0].Type())
-			}
-
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
 
@@ -118,10 +161,509 @@ var builtins = map[string]*object.Builtin{
 
 			return &object.Array{Elements: newElements}
 		},
-	},
+	),
+	// push!(arr, value) で、新しい配列を作らずにarrの最後にvalueを追加し、arrを返す。
+	// 名前が ! で終わる組み込み関数は、引数の配列そのものを変更する。
+	"push!": builtin("push!", args(ARRAY, ANY)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			arr := args[0].(*object.Array)
+			if err := allocate(env, arrayElementSize); err != nil {
+				return err
+			}
+			arr.Elements = append(arr.Elements, args[1])
+			return arr
+		},
+	),
+	// pop!(arr) で、arrの最後の要素を取り除いて返す。空ならnull。
+	"pop!": builtin("pop!", args(ARRAY)).Fn(
+		func(args ...object.Object) object.Object {
+			arr := args[0].(*object.Array)
+			length := len(arr.Elements)
+			if length == 0 {
+				return NULL
+			}
+			last := arr.Elements[length-1]
+			arr.Elements[length-1] = nil // 取り除いた要素を参照し続けないようにする
+			arr.Elements = arr.Elements[:length-1]
+			return last
+		},
+	),
+	// insert!(arr, index, value) で、arrのindexの位置にvalueを挿入し、arrを返す。indexがlen(arr)なら最後に追加する。
+	"insert!": builtin("insert!", args(ARRAY, INTEGER, ANY)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			arr := args[0].(*object.Array)
+			index := args[1].(*object.Integer).Value
+			if index < 0 || index > int64(len(arr.Elements)) {
+				return newError("insert!: index %d out of range for array of length %d", index, len(arr.Elements))
+			}
+			if err := allocate(env, arrayElementSize); err != nil {
+				return err
+			}
+			arr.Elements = append(arr.Elements, nil)
+			copy(arr.Elements[index+1:], arr.Elements[index:])
+			arr.Elements[index] = args[2]
+			return arr
+		},
+	),
+	// remove!(arr, index) で、arrのindexの位置の要素を取り除いて返す。
+	"remove!": builtin("remove!", args(ARRAY, INTEGER)).Fn(
+		func(args ...object.Object) object.Object {
+			arr := args[0].(*object.Array)
+			index := args[1].(*object.Integer).Value
+			length := len(arr.Elements)
+			if index < 0 || index >= int64(length) {
+				return newError("remove!: index %d out of range for array of length %d", index, length)
+			}
+			removed := arr.Elements[index]
+			copy(arr.Elements[index:], arr.Elements[index+1:])
+			arr.Elements[length-1] = nil
+			arr.Elements = arr.Elements[:length-1]
+			return removed
+		},
+	),
+	// set() で空のセット、 set([1, 2, 2]) で配列などのIterableなオブジェクトの要素からセットを作る。
+	"set": builtin("set", args(optional(ITERABLE))).Fn(
+		func(args ...object.Object) object.Object {
+			set := object.NewSet()
+			if len(args) == 0 {
+				return set
+			}
+
+			iterable := args[0].(object.Iterable)
+			for _, element := range object.Collect(iterable.Iterator()) {
+				if !set.Add(element) {
+					return newError("unusable as set element: %s", element.Type())
+				}
+			}
+
+			return set
+		},
+	),
+	// range(stop) で0からstopの手前まで、range(start, stop) でstartからstopの手前まで、
+	// range(start, stop, step) でstepずつ数えるRangeを作る。要素は配列にせず、for-inなどで取り出すたびに計算する。
+	"range": builtin("range", args(INTEGER, optional(INTEGER), optional(INTEGER))).Fn(
+		func(args ...object.Object) object.Object {
+			values := make([]int64, len(args))
+			for i, arg := range args {
+				values[i] = arg.(*object.Integer).Value
+			}
+
+			r := &object.Range{Start: 0, Step: 1}
+			switch len(values) {
+			case 1:
+				r.Stop = values[0]
+			case 2:
+				r.Start, r.Stop = values[0], values[1]
+			case 3:
+				r.Start, r.Stop, r.Step = values[0], values[1], values[2]
+			}
+			if r.Step == 0 {
+				return newError("step of `range` must not be zero")
+			}
+			return r
+		},
+	),
+	// enumerate(arr) で各要素を [番号, 要素] の組にして取り出すIterableを作る。
+	// for (pair in enumerate(arr)) { pair[0] ... } のように使う。配列以外のIterableなオブジェクトも受け取れる。
+	"enumerate": builtin("enumerate", args(ITERABLE)).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.Enumerate{Source: args[0].(object.Iterable)}
+		},
+	),
+	// error("message") でエラーの値を作る。作っただけでは評価は中断されない。tryでcatchした組み込みのエラーと同じ型になる。
+	"error": builtin("error", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.ErrorValue{Err: &object.Error{Message: args[0].(*object.String).Value}}
+		},
+	),
+	"is_error": builtin("is_error", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			return nativeBoolToBooleanObject(args[0].Type() == object.ERROR_VALUE_OBJ)
+		},
+	),
+	// regex("pattern") で正規表現をコンパイルする。
+	// re_から始まる組み込み関数はパターンの文字列も受け取れるが、同じパターンを何度も使う場合はこちらで一度だけコンパイルしておく。
+	"regex": builtin("regex", args(oneOf(STRING, REGEX))).Fn(
+		func(args ...object.Object) object.Object {
+			return toRegex("regex", args[0])
+		},
+	),
+	// re_match(pattern, str) 文字列の中にパターンに一致する部分があるかどうか。
+	"re_match": builtin("re_match", args(oneOf(STRING, REGEX), STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			re, err := regexArg("re_match", args[0])
+			if err != nil {
+				return err
+			}
+			return nativeBoolToBooleanObject(re.MatchString(args[1].(*object.String).Value))
+		},
+	),
+	// re_find_all(pattern, str) パターンに一致する部分を全て配列で返す。
+	"re_find_all": builtin("re_find_all", args(oneOf(STRING, REGEX), STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			re, err := regexArg("re_find_all", args[0])
+			if err != nil {
+				return err
+			}
+
+			matches := re.FindAllString(args[1].(*object.String).Value, -1)
+			elements := make([]object.Object, len(matches))
+			for i, m := range matches {
+				elements[i] = &object.String{Value: m}
+			}
+			return &object.Array{Elements: elements}
+		},
+	),
+	// re_replace(pattern, str, replacement) パターンに一致する部分を全て置き換える。
+	// replacementの中では $1 や ${name} でキャプチャした部分を参照できる。
+	"re_replace": builtin("re_replace", args(oneOf(STRING, REGEX), STRING, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			re, err := regexArg("re_replace", args[0])
+			if err != nil {
+				return err
+			}
+			s := stringValues(args[1:])
+			return &object.String{Value: re.ReplaceAllString(s[0], s[1])}
+		},
+	),
+	// json_encode(value) 値をJSONの文字列にする。
+	// json_encode(value, "  ") のようにインデントを渡すと、設定ファイルなどに書きやすいように改行とインデントを入れる。
+	"json_encode": builtin("json_encode", args(ANY, optional(STRING))).Fn(
+		func(args ...object.Object) object.Object {
+			data, err := object.ToJSON(args[0])
+			if err != nil {
+				return newError("%s", err)
+			}
+			if len(args) == 2 {
+				var out bytes.Buffer
+				json.Indent(&out, data, "", args[1].(*object.String).Value)
+				data = out.Bytes()
+			}
+			return &object.String{Value: string(data)}
+		},
+	),
+	// json_decode(str) JSONの文字列を値にする。
+	"json_decode": builtin("json_decode", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			obj, err := object.FromJSON([]byte(args[0].(*object.String).Value))
+			if err != nil {
+				return newError("invalid JSON: %s", err)
+			}
+			return obj
+		},
+	),
+	// contains(collection, value)
+	// セットとハッシュは要素（キー）に含まれるか、配列は等しい要素があるかどうか。
+	"contains": builtin("contains", args(oneOf(SET, STRING, HASH_LIKE, ARRAY_LIKE), ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			switch coll := args[0].(type) {
+			case *object.Set:
+				return nativeBoolToBooleanObject(coll.Contains(args[1]))
+			// 文字列の場合は部分文字列かどうか
+			case *object.String:
+				sub, ok := args[1].(*object.String)
+				if !ok {
+					return newError("contains: expected STRING, got %s at argument 2",
+						args[1].Type())
+				}
+				return nativeBoolToBooleanObject(strings.Contains(coll.Value, sub.Value))
+			case *object.Hash:
+				key, ok := args[1].(object.Hashable)
+				if !ok {
+					return FALSE
+				}
+				_, exists := coll.Pairs[key.HashKey()]
+				return nativeBoolToBooleanObject(exists)
+			case *object.Map:
+				key, ok := args[1].(object.Hashable)
+				if !ok {
+					return FALSE
+				}
+				_, exists := coll.Get(key.HashKey())
+				return nativeBoolToBooleanObject(exists)
+			}
+
+			// 配列。型はbuiltinの指定で確かめてある
+			for _, element := range iterableElements(args[0]) {
+				if objectsEqual(element, args[1]) {
+					return TRUE
+				}
+			}
+			return FALSE
+		},
+	),
+	"union": builtin("union", args(SET, SET)).Fn(
+		func(args ...object.Object) object.Object {
+			return setOperation(args, func(inA, inB bool) bool { return inA || inB })
+		},
+	),
+	"intersection": builtin("intersection", args(SET, SET)).Fn(
+		func(args ...object.Object) object.Object {
+			return setOperation(args, func(inA, inB bool) bool { return inA && inB })
+		},
+	),
+	"difference": builtin("difference", args(SET, SET)).Fn(
+		func(args ...object.Object) object.Object {
+			return setOperation(args, func(inA, inB bool) bool { return inA && !inB })
+		},
+	),
+	// map(arr, fn) で、各要素にfnを適用した結果の配列を作る。配列以外のIterableなオブジェクトも受け取れる。
+	"map": builtin("map", args(ITERABLE, FUNCTION)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			elements := iterableElements(args[0])
+
+			result := make([]object.Object, len(elements))
+			for i, el := range elements {
+				mapped := applyFunction(env, args[1], []object.Object{el})
+				if isError(mapped) {
+					return mapped
+				}
+				result[i] = mapped
+			}
+			return &object.Array{Elements: result}
+		},
+	),
+	// filter(arr, fn) で、fnがtruthyな値を返した要素だけの配列を作る。
+	"filter": builtin("filter", args(ITERABLE, FUNCTION)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			elements := iterableElements(args[0])
+
+			result := []object.Object{}
+			for _, el := range elements {
+				keep := applyFunction(env, args[1], []object.Object{el})
+				if isError(keep) {
+					return keep
+				}
+				if isTruthy(keep) {
+					result = append(result, el)
+				}
+			}
+			return &object.Array{Elements: result}
+		},
+	),
+	// reduce(arr, initial, fn) で、fn(これまでの結果, 要素)を先頭から順に適用した結果を返す。
+	"reduce": builtin("reduce", args(ITERABLE, ANY, FUNCTION)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			elements := iterableElements(args[0])
+
+			result := args[1]
+			for _, el := range elements {
+				result = applyFunction(env, args[2], []object.Object{result, el})
+				if isError(result) {
+					return result
+				}
+			}
+			return result
+		},
+	),
+	// sort(arr) で要素をobject.Compareの順序で並べた新しい配列を作る。元の配列は変更しない。
+	// sort(arr, fn(a, b) {...}) のように比較する関数を渡すと、その順序で並べる。
+	// 比較する関数は、aをbより前にするなら true (または負の整数)を返す。等しい要素の順番は変わらない。
+	"sort": builtin("sort", args(ITERABLE, optional(FUNCTION))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			elements := iterableElements(args[0])
+
+			sorted := make([]object.Object, len(elements))
+			copy(sorted, elements)
+
+			var sortErr object.Object
+			less := func(a, b object.Object) bool {
+				if len(args) == 1 {
+					cmp, ok := object.Compare(a, b)
+					if !ok {
+						sortErr = newError("cannot compare %s and %s", a.Type(), b.Type())
+					}
+					return cmp < 0
+				}
+				result := applyFunction(env, args[1], []object.Object{a, b})
+				switch result := result.(type) {
+				case *object.Boolean:
+					return result.Value
+				case *object.Integer:
+					return result.Value < 0
+				default:
+					if isError(result) {
+						sortErr = result
+					} else {
+						sortErr = newError("comparator of `sort` must return BOOLEAN or INTEGER, got %s", result.Type())
+					}
+					return false
+				}
+			}
+			sort.SliceStable(sorted, func(i, j int) bool {
+				// エラーが起きた後は比較しない
+				if sortErr != nil {
+					return false
+				}
+				return less(sorted[i], sorted[j])
+			})
+			if sortErr != nil {
+				return sortErr
+			}
+
+			return &object.Array{Elements: sorted}
+		},
+	),
+	// memo(fn) で、引数ごとに結果を覚えておく関数を作る。同じ引数で呼び出すと、fnを呼ばずに覚えた結果を返す。
+	// 引数は型とHashKeyで比べる。配列のようにHashableでない引数がある呼び出しと、エラーになった呼び出しは覚えない。
+	"memo": builtin("memo", args(FUNCTION)).Fn(
+		func(args ...object.Object) object.Object {
+			return memoize(args[0])
+		},
+	),
+	// keys(hash) でキーの配列、values(hash) で値の配列を、追加された順番で返す。
+	"keys": builtin("keys", args(HASH)).Fn(
+		func(args ...object.Object) object.Object {
+			return hashElements(args[0].(*object.Hash), func(pair object.HashPair) object.Object { return pair.Key })
+		},
+	),
+	"values": builtin("values", args(HASH)).Fn(
+		func(args ...object.Object) object.Object {
+			return hashElements(args[0].(*object.Hash), func(pair object.HashPair) object.Object { return pair.Value })
+		},
+	),
+	// has_key(hash, key) でキーがあるかどうかを返す。
+	"has_key": builtin("has_key", args(HASH_LIKE, ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			if m, ok := args[0].(*object.Map); ok {
+				_, exists := m.Get(key.HashKey())
+				return nativeBoolToBooleanObject(exists)
+			}
+			_, exists := args[0].(*object.Hash).Pairs[key.HashKey()]
+			return nativeBoolToBooleanObject(exists)
+		},
+	),
+	// delete(hash, key) でキーを取り除いた新しいハッシュを作る。pushと同じく元のハッシュは変更しない。
+	"delete": builtin("delete", args(HASH_LIKE, ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			key, ok := args[1].(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			if m, ok := args[0].(*object.Map); ok {
+				return m.Delete(key.HashKey())
+			}
+			result := copyHash(args[0].(*object.Hash))
+			result.Delete(key.HashKey())
+			return result
+		},
+	),
+	// merge(a, b) でaにbのペアを追加した新しいハッシュを作る。同じキーがある場合はbの値になる。
+	// aがMapなら、aと構造を共有した新しいMapにbのペアを追加する。
+	"merge": builtin("merge", args(HASH_LIKE, HASH_LIKE)).Fn(
+		func(args ...object.Object) object.Object {
+			if m, ok := args[0].(*object.Map); ok {
+				for _, pair := range orderedPairs(args[1]) {
+					m, _ = m.Set(pair.Key, pair.Value)
+				}
+				return m
+			}
+			result := copyHash(args[0].(*object.Hash))
+			for _, pair := range orderedPairs(args[1]) {
+				result.Set(pair.Key, pair.Value)
+			}
+			return result
+		},
+	),
+}
+
+// puts、print、eputsで表示する、引数の文字列。入れ子が深すぎて表示できない引数があれば何も出力せずにエラーにする。
+func inspectArgs(name string, args []object.Object) ([]string, *object.Error) {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		value, err := object.InspectChecked(arg)
+		if err != nil {
+			return nil, newError("%s: %s", name, err)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// ハッシュかMapのペアを追加された順番に返す。
+func orderedPairs(obj object.Object) []object.HashPair {
+	if m, ok := obj.(*object.Map); ok {
+		return m.OrderedPairs()
+	}
+	return obj.(*object.Hash).OrderedPairs()
+}
+
+func hashElements(hash *object.Hash, element func(object.HashPair) object.Object) object.Object {
+	pairs := hash.OrderedPairs()
+	elements := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = element(pair)
+	}
+	return &object.Array{Elements: elements}
+}
+
+// ペアの順番を保ったままハッシュをコピーする。
+func copyHash(hash *object.Hash) *object.Hash {
+	result := object.NewHash()
+	for _, pair := range hash.OrderedPairs() {
+		result.Set(pair.Key, pair.Value)
+	}
+	return result
+}
+
+// 配列などのIterableなオブジェクトの要素を取り出す。argがIterableであることはITERABLEで確かめておくこと。
+func iterableElements(arg object.Object) []object.Object {
+	return object.Collect(arg.(object.Iterable).Iterator())
+}
+
+// 正規表現の文字列か、コンパイル済みのRegexを受け取ってRegexを返す。
+// パターンが正しくない場合はエラーを返す。
+func toRegex(name string, arg object.Object) object.Object {
+	switch arg := arg.(type) {
+	case *object.Regex:
+		return arg
+	case *object.String:
+		re, err := regexp.Compile(arg.Value)
+		if err != nil {
+			return newError("invalid regex: %s", err)
+		}
+		return &object.Regex{Value: re}
+	default:
+		return newError("%s: expected STRING or REGEX, got %s", name, arg.Type())
+	}
+}
+
+// re_から始まる組み込み関数の、1番目の引数のパターン。文字列ならここでコンパイルする。
+func regexArg(name string, arg object.Object) (*regexp.Regexp, object.Object) {
+	re := toRegex(name, arg)
+	if isError(re) {
+		return nil, re
+	}
+	return re.(*object.Regex).Value, nil
+}
+
+// union, intersection, differenceの共通部分。
+// 二つのセットの要素を a, b の順に見ていき、keepがtrueを返す要素だけを集めた新しいセットを作る。引数のセットは変更しない。
+func setOperation(args []object.Object, keep func(inA, inB bool) bool) object.Object {
+	a, b := args[0].(*object.Set), args[1].(*object.Set)
+
+	result := object.NewSet()
+	for _, key := range a.Keys {
+		if _, inB := b.Elements[key]; keep(true, inB) {
+			result.Add(a.Elements[key])
+		}
+	}
+	for _, key := range b.Keys {
+		if _, inA := a.Elements[key]; !inA && keep(false, true) {
+			result.Add(b.Elements[key])
+		}
+	}
+	return result
 }
 
 // 上記の組み込み関数を使えば、こんな感じのイテレータ関数も定義することができる。
+// （mapとreduceは、今は組み込み関数としても用意している）
 
 //let map = fn(arr, f) {
 //	let iter = fn(arr, accumulated) {
@@ -148,3 +690,41 @@ var builtins = map[string]*object.Builtin{
 //let sum = fn(arr) {
 //	reduce(arr, 0, fn(initial, el) { initial + el });
 //};
+
+func memoize(fn object.Object) *object.Builtin {
+	var mu sync.Mutex
+	cache := map[string]object.Object{}
+
+	return &object.Builtin{
+		FnEnv: func(env *object.Environment, args ...object.Object) object.Object {
+			// ハッシュのキーでは 1 と 1.0 が同じになるが、fnには違う値なので、値の型もキーに含める
+			var key strings.Builder
+			for _, arg := range args {
+				hashable, ok := arg.(object.Hashable)
+				if !ok {
+					// 配列などは後から中身が変わるかもしれないので、覚えずにそのまま呼び出す
+					return applyFunction(env, fn, args)
+				}
+				hashed := hashable.HashKey()
+				fmt.Fprintf(&key, "%s:%s:%d,", arg.Type(), hashed.Type, hashed.Value)
+			}
+
+			mu.Lock()
+			result, ok := cache[key.String()]
+			mu.Unlock()
+			if ok {
+				return result
+			}
+
+			// fnが自分自身を再帰的に呼び出すこともあるので、呼び出している間はロックしない
+			result = applyFunction(env, fn, args)
+			if isError(result) {
+				return result
+			}
+			mu.Lock()
+			cache[key.String()] = result
+			mu.Unlock()
+			return result
+		},
+	}
+}
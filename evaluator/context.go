@@ -0,0 +1,152 @@
+package evaluator
+
+import (
+	"context"
+	"monkey/ast"
+	"monkey/object"
+	"sync/atomic"
+)
+
+// ctxを使ってnodeを評価する。ctxがキャンセルされたりタイムアウトしたりすると、
+// ループの一周ごとと関数呼び出しごとに調べて評価を中断し、"evaluation interrupted" のエラーを返す。
+// 終わらないループや再帰を、プロセスを止めずに打ち切るのに使う。
+// envは変更せず、envの束縛を共有してContextだけをctxにした環境で評価する。
+// なので同じenvを複数のgoroutineから、それぞれのContextで評価できる。
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	env = env.WithContext(ctx)
+	if err := checkInterrupted(env); err != nil {
+		return err
+	}
+	return Eval(node, env)
+}
+
+type stepLimitKey struct{}
+
+// 評価した操作の数と、その上限。
+type stepLimit struct {
+	max  int64
+	used int64 // 複数のgoroutineから数えられるので、atomicに読み書きする
+}
+
+// 関数呼び出しとループの一周を一つの操作として数え、maxを超えたら "script exceeded N operations" のエラーで
+// 評価を中断するContextを返す。EvalContextやEnvironment.SetContextで使う。
+// 信頼できないスクリプトを、サーバーなどで決まった量だけ実行させるのに使う。
+// 操作の数は返したContextごとに数えるので、同じContextで何度評価しても合計がmaxまでになる。
+func WithStepLimit(ctx context.Context, max int64) context.Context {
+	return context.WithValue(ctx, stepLimitKey{}, &stepLimit{max: max})
+}
+
+// ctxで評価した操作の数。WithStepLimitで作ったContextでなければ0。
+func StepsUsed(ctx context.Context) int64 {
+	if limit, ok := ctx.Value(stepLimitKey{}).(*stepLimit); ok {
+		return atomic.LoadInt64(&limit.used)
+	}
+	return 0
+}
+
+// 関数呼び出しやループの一周の前に呼び、操作を一つ数える。
+// 操作の数が上限を超えたか、envのContextがキャンセルされていればエラーを返す。
+func step(env *object.Environment) *object.Error {
+	ctx := env.Context()
+	if limit, ok := ctx.Value(stepLimitKey{}).(*stepLimit); ok {
+		atomic.AddInt64(&limit.used, 1)
+	}
+	return interrupted(ctx)
+}
+
+type memoryLimitKey struct{}
+
+// 確保したメモリのおおよその量と、その上限。
+type memoryLimit struct {
+	max  int64
+	used int64 // stepLimitと同じく、atomicに読み書きする
+}
+
+// 作った配列やハッシュ、文字列のおおよそのバイト数を数え、maxを超えたら "script exceeded memory limit of N bytes" のエラーで
+// 評価を中断するContextを返す。使わなくなった値の分は減らないので、評価の間に確保した量の合計を制限することになる。
+// push(a, a) を繰り返すようなスクリプトで、組み込み先のプロセスのメモリを使い果たさないようにするのに使う。
+func WithMemoryLimit(ctx context.Context, max int64) context.Context {
+	return context.WithValue(ctx, memoryLimitKey{}, &memoryLimit{max: max})
+}
+
+// ctxで評価した間に確保したメモリのおおよそのバイト数。WithMemoryLimitで作ったContextでなければ0。
+func MemoryUsed(ctx context.Context) int64 {
+	if limit, ok := ctx.Value(memoryLimitKey{}).(*memoryLimit); ok {
+		return atomic.LoadInt64(&limit.used)
+	}
+	return 0
+}
+
+// 作った値objの大きさを数える。上限を超えた場合はobjの代わりにエラーを返す。
+// 要素はそれぞれ作られたときに数えているので、配列やハッシュは要素の数の分だけを数える。
+func allocated(env *object.Environment, obj object.Object) object.Object {
+	var size int64
+	switch obj := obj.(type) {
+	case *object.String:
+		size = int64(len(obj.Value))
+	case *object.Array:
+		size = int64(len(obj.Elements)) * arrayElementSize
+	case *object.Hash:
+		size = int64(len(obj.Pairs)) * hashEntrySize
+	case *object.Set:
+		size = int64(len(obj.Elements)) * hashEntrySize
+	case *object.Instance:
+		size = int64(len(obj.Fields)) * hashEntrySize
+	// VectorとMapは元の値と構造を共有しているので、増えた要素一つ分だけを数える
+	case *object.Vector:
+		size = arrayElementSize
+	case *object.Map:
+		size = hashEntrySize
+	default:
+		return obj
+	}
+
+	if err := allocate(env, size); err != nil {
+		return err
+	}
+	return obj
+}
+
+// sizeバイトを確保したものとして数える。上限を超えた場合はエラーを返す。
+// push! のように既にある値を大きくした場合は、allocatedではなくこれで増えた分だけを数える。
+func allocate(env *object.Environment, size int64) *object.Error {
+	limit, ok := env.Context().Value(memoryLimitKey{}).(*memoryLimit)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt64(&limit.used, size) > limit.max {
+		return memoryLimitError(limit)
+	}
+	return nil
+}
+
+// 配列の要素一つとハッシュのエントリ一つのおおよそのバイト数
+const (
+	arrayElementSize = 16
+	hashEntrySize    = 64
+)
+
+func memoryLimitError(limit *memoryLimit) *object.Error {
+	return newError("script exceeded memory limit of %d bytes", limit.max)
+}
+
+// envのContextがキャンセルされているか、操作の数やメモリが上限を超えていればエラーを返す。
+func checkInterrupted(env *object.Environment) *object.Error {
+	return interrupted(env.Context())
+}
+
+// キャンセルされていないContextのDone()はブロックするので、selectのdefaultですぐに抜ける。
+func interrupted(ctx context.Context) *object.Error {
+	if limit, ok := ctx.Value(stepLimitKey{}).(*stepLimit); ok && atomic.LoadInt64(&limit.used) > limit.max {
+		return newError("script exceeded %d operations", limit.max)
+	}
+	if limit, ok := ctx.Value(memoryLimitKey{}).(*memoryLimit); ok && atomic.LoadInt64(&limit.used) > limit.max {
+		return memoryLimitError(limit)
+	}
+	select {
+	case <-ctx.Done():
+		return newError("evaluation interrupted: %s", ctx.Err())
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+// 型を変換する組み込み関数。Builtinsに登録される。
+// 変換できない値を渡した場合はエラーになる。
+var conversionBuiltins = map[string]*object.Builtin{
+	// int(x) で整数にする。
+	// 小数は0の方向に切り捨て、文字列は10進数として読む。trueは1、falseは0になる。int64に収まらなければBigIntになる。
+	"int": builtin("int", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return arg
+			case *object.Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return conversionError(arg, "INTEGER")
+				}
+				n, _ := big.NewFloat(arg.Value).Int(nil)
+				return bigIntToObject(n)
+			case *object.String:
+				n, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+				if !ok {
+					return conversionError(arg, "INTEGER")
+				}
+				return bigIntToObject(n)
+			case *object.Boolean:
+				if arg.Value {
+					return object.NewInteger(1)
+				}
+				return object.NewInteger(0)
+			default:
+				return conversionError(arg, "INTEGER")
+			}
+		},
+	),
+	// float(x) で小数にする。文字列は小数として読む。trueは1.0、falseは0.0になる。
+	"float": builtin("float", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			switch arg := args[0].(type) {
+			case *object.Float:
+				return arg
+			case *object.Integer, *object.BigInt:
+				return &object.Float{Value: toFloat(arg)}
+			case *object.String:
+				f, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return conversionError(arg, "FLOAT")
+				}
+				return &object.Float{Value: f}
+			case *object.Boolean:
+				if arg.Value {
+					return &object.Float{Value: 1}
+				}
+				return &object.Float{Value: 0}
+			default:
+				return conversionError(arg, "FLOAT")
+			}
+		},
+	),
+	// str(x) で文字列にする。putsで表示されるものと同じになる。
+	"str": builtin("str", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			if str, ok := args[0].(*object.String); ok {
+				return str
+			}
+			value, err := object.InspectChecked(args[0])
+			if err != nil {
+				return newError("str: %s", err)
+			}
+			return &object.String{Value: value}
+		},
+	),
+	// bool(x) で真偽値にする。ifの条件と同じく、nullとfalse以外はtrueになる。
+	"bool": builtin("bool", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			return nativeBoolToBooleanObject(isTruthy(args[0]))
+		},
+	),
+	// type(x) で値の型の名前を "INTEGER" や "STRING" のような文字列で返す。
+	// match (type(x)) { "INTEGER" => ..., "STRING" => ... } のように、型で処理を分けるのに使う。
+	// 大きな整数も "INTEGER"、vmのクロージャも "FUNCTION" になり、実装の違いは見えない。
+	"type": builtin("type", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			return object.NewString(string(typeName(args[0])))
+		},
+	),
+}
+
+func typeName(obj object.Object) object.ObjectType {
+	switch t := obj.Type(); t {
+	case object.BIGINT_OBJ:
+		return object.INTEGER_OBJ
+	case object.CLOSURE_OBJ, object.COMPILED_FUNCTION_OBJ:
+		return object.FUNCTION_OBJ
+	default:
+		return t
+	}
+}
+
+func conversionError(arg object.Object, to object.ObjectType) *object.Error {
+	if str, ok := arg.(*object.String); ok {
+		return newError("cannot convert %q to %s", str.Value, to)
+	}
+	return newError("cannot convert %s to %s", arg.Type(), to)
+}
@@ -0,0 +1,106 @@
+package evaluator
+
+import "monkey/object"
+
+// 値をコピーする組み込み関数。Builtinsに登録される。
+// 配列、ハッシュ、セット、インスタンス以外の値は変更できないので、コピーせずにそのまま返す。
+var copyBuiltins = map[string]*object.Builtin{
+	// copy(x) で、中に入っている配列やハッシュまで全てコピーした値を返す。
+	// 同じ配列を二か所から参照している場合は、コピーでも同じ一つの配列を参照する。
+	"copy": builtin("copy", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			c, err := deepCopy(args[0], make(map[object.Object]object.Object), 0)
+			if err != nil {
+				return newError("copy: %s", err)
+			}
+			return c
+		},
+	),
+	// clone(x) で、一番外側だけをコピーした値を返す。要素はコピー元と同じものを参照する。
+	"clone": builtin("clone", args(ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			return shallowCopy(args[0], func(el object.Object) object.Object { return el })
+		},
+	),
+}
+
+// copied には、コピー済みの値とそのコピーを記録しておく。
+// depthはobjが入っている入れ物の数で、object.MaxNestingより深い値はコピーせずにエラーにする。
+func deepCopy(obj object.Object, copied map[object.Object]object.Object, depth int) (object.Object, error) {
+	if c, ok := copied[obj]; ok {
+		return c, nil
+	}
+	switch obj.(type) {
+	case *object.Array, *object.Hash, *object.Set, *object.Instance:
+	default:
+		return obj, nil
+	}
+	if depth > object.MaxNesting {
+		return nil, object.ErrTooDeep
+	}
+
+	// 要素をコピーする前にcopiedに入れておくことで、自分自身を含む配列も無限にコピーせずに済む
+	c := shallowCopy(obj, nil)
+	copied[obj] = c
+	var err error
+	fillCopy(c, obj, func(el object.Object) object.Object {
+		if err != nil {
+			return el
+		}
+		var elc object.Object
+		if elc, err = deepCopy(el, copied, depth+1); err != nil {
+			return el
+		}
+		return elc
+	})
+	return c, err
+}
+
+// objの一番外側をコピーし、要素をelementで変換したものにする。elementがnilの場合は空の入れ物だけを作る。
+func shallowCopy(obj object.Object, element func(object.Object) object.Object) object.Object {
+	var c object.Object
+	switch obj := obj.(type) {
+	case *object.Array:
+		c = &object.Array{Elements: make([]object.Object, len(obj.Elements))}
+	case *object.Hash:
+		c = object.NewHash()
+	case *object.Set:
+		c = object.NewSet()
+	case *object.Instance:
+		c = &object.Instance{Class: obj.Class, Fields: make(map[string]object.Object, len(obj.Fields))}
+	default:
+		return obj
+	}
+	if element != nil {
+		fillCopy(c, obj, element)
+	}
+	return c
+}
+
+// shallowCopyで作った空の入れ物cに、objの要素をelementで変換して入れる。
+func fillCopy(c, obj object.Object, element func(object.Object) object.Object) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements := c.(*object.Array).Elements
+		for i, el := range obj.Elements {
+			elements[i] = element(el)
+		}
+	case *object.Hash:
+		// キーは文字列などの変更できない値なので、コピーしない
+		hash := c.(*object.Hash)
+		for _, pair := range obj.OrderedPairs() {
+			hash.Set(pair.Key, element(pair.Value))
+		}
+	case *object.Set:
+		// セットの要素も変更できない値だけなので、順番を保ってそのまま入れる
+		set := c.(*object.Set)
+		for _, key := range obj.Keys {
+			set.Add(obj.Elements[key])
+		}
+	case *object.Instance:
+		fields := c.(*object.Instance).Fields
+		for name, value := range obj.Fields {
+			fields[name] = element(value)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package evaluator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/object"
+	"sort"
+	"strings"
+)
+
+// プログラムのどの文が何回実行されたかを数えるTracer。
+// NewCoverageで作ってWithTracerでContextに設定し、同じプログラムを評価した後にWriteLCOVやWriteAnnotatedで結果を書き出す。
+// 数えるのはNewCoverageに渡したプログラムの文だけで、importしたファイルなど他のプログラムの文は数えない。
+type Coverage struct {
+	Filename   string
+	counts     map[ast.Statement]int
+	statements []ast.Statement // ソースコード上の順番
+}
+
+// programの文を数えるCoverageを作る。filenameはレポートに書くファイル名。
+// ブロック({ ... })は文を入れる入れ物なので数えず、その中の文を数える。
+func NewCoverage(filename string, program *ast.Program) *Coverage {
+	c := &Coverage{Filename: filename, counts: make(map[ast.Statement]int)}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.BlockStatement:
+		case ast.Statement:
+			c.counts[node] = 0
+			c.statements = append(c.statements, node)
+		}
+		return true
+	})
+	sort.SliceStable(c.statements, func(i, j int) bool {
+		return c.statements[i].Pos().Offset < c.statements[j].Pos().Offset
+	})
+	return c
+}
+
+func (c *Coverage) Enter(node ast.Node, env *object.Environment) {
+	stmt, ok := node.(ast.Statement)
+	if !ok {
+		return
+	}
+	if _, tracked := c.counts[stmt]; tracked {
+		c.counts[stmt]++
+	}
+}
+
+func (c *Coverage) Exit(node ast.Node, result object.Object) {}
+
+// 行番号から、その行で始まる文が実行された回数へのmap。文のない行は含まない。
+// 一行に複数の文がある場合は、一番多く実行された文の回数にする。
+func (c *Coverage) Lines() map[int]int {
+	lines := make(map[int]int)
+	for _, stmt := range c.statements {
+		line := stmt.Pos().Line
+		if count, ok := lines[line]; !ok || c.counts[stmt] > count {
+			lines[line] = c.counts[stmt]
+		}
+	}
+	return lines
+}
+
+// 一度でも実行された文の割合(0〜100)。文が一つもなければ100。
+func (c *Coverage) Percent() float64 {
+	if len(c.statements) == 0 {
+		return 100
+	}
+	covered := 0
+	for _, stmt := range c.statements {
+		if c.counts[stmt] > 0 {
+			covered++
+		}
+	}
+	return float64(covered) * 100 / float64(len(c.statements))
+}
+
+// lcovの形式で行ごとの実行回数を書き出す。genhtmlやエディタの拡張機能で表示できる。
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	lines := c.Lines()
+	numbers := make([]int, 0, len(lines))
+	for line := range lines {
+		numbers = append(numbers, line)
+	}
+	sort.Ints(numbers)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "TN:\nSF:%s\n", c.Filename)
+	hit := 0
+	for _, line := range numbers {
+		fmt.Fprintf(&b, "DA:%d,%d\n", line, lines[line])
+		if lines[line] > 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", len(numbers), hit)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ソースコードの各行の先頭に実行回数を付けて書き出す。gcovと同じく、
+// 実行されなかった文の行は #####、文のない行は - にする。
+//
+//	    1: let f = fn(x) {
+//	#####:   puts(x)
+//	    -: };
+func (c *Coverage) WriteAnnotated(w io.Writer, src string) error {
+	lines := c.Lines()
+	bw := bufio.NewWriter(w)
+	for i, text := range strings.Split(strings.TrimSuffix(src, "\n"), "\n") {
+		count, ok := lines[i+1]
+		switch {
+		case !ok:
+			fmt.Fprintf(bw, "%5s: %s\n", "-", text)
+		case count == 0:
+			fmt.Fprintf(bw, "%5s: %s\n", "#####", text)
+		default:
+			fmt.Fprintf(bw, "%5d: %s\n", count, text)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,225 @@
+package evaluator
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/object"
+	"strings"
+)
+
+type builtinDoc struct {
+	Signature string
+	Doc       string
+}
+
+// 組み込み関数の使い方。help()と、REPLの:helpで表示される。
+// 登録簿に登録されるときに、SignatureとDocが空のBuiltinに設定される。
+var builtinDocs = map[string]builtinDoc{
+	// builtins.go
+	"puts":         {"puts(values...)", "Print each value on its own line."},
+	"print":        {"print(values...)", "Print the values separated by spaces, without a trailing newline."},
+	"eputs":        {"eputs(values...)", "Print each value on its own line to the error output."},
+	"input":        {"input(prompt?)", "Print prompt, then read a line of input without its line ending, or null at the end of input."},
+	"len":          {"len(x)", "Return the length of a string (in bytes), array, hash, set or range."},
+	"first":        {"first(arr)", "Return the first element of an array, or null if it is empty."},
+	"last":         {"last(arr)", "Return the last element of an array, or null if it is empty."},
+	"rest":         {"rest(arr)", "Return a new array without the first element, or null if it is empty."},
+	"push":         {"push(arr, value)", "Return a new array with value appended."},
+	"push!":        {"push!(arr, value)", "Append value to arr in place and return arr."},
+	"pop!":         {"pop!(arr)", "Remove and return the last element of arr, or null if it is empty."},
+	"insert!":      {"insert!(arr, index, value)", "Insert value into arr at index in place and return arr."},
+	"remove!":      {"remove!(arr, index)", "Remove and return the element of arr at index."},
+	"set":          {"set(iterable?)", "Create a set, optionally from the elements of an iterable."},
+	"range":        {"range(stop) / range(start, stop, step?)", "Create a lazy range of integers from start up to, but not including, stop."},
+	"enumerate":    {"enumerate(iterable)", "Iterate over [index, element] pairs of an iterable."},
+	"error":        {"error(message)", "Create an error value. It does not stop evaluation until it is thrown."},
+	"is_error":     {"is_error(value)", "Report whether value is an error value."},
+	"regex":        {"regex(pattern)", "Compile a regular expression."},
+	"re_match":     {"re_match(pattern, str)", "Report whether str contains a match of pattern."},
+	"re_find_all":  {"re_find_all(pattern, str)", "Return all matches of pattern in str."},
+	"re_replace":   {"re_replace(pattern, str, replacement)", "Replace all matches of pattern in str."},
+	"json_encode":  {"json_encode(value, indent?)", "Encode value as a JSON string, optionally indented."},
+	"json_decode":  {"json_decode(str)", "Decode a JSON string into a value."},
+	"contains":     {"contains(collection, value)", "Report whether an array, hash, set or string contains value."},
+	"union":        {"union(a, b)", "Return the union of two sets."},
+	"intersection": {"intersection(a, b)", "Return the intersection of two sets."},
+	"difference":   {"difference(a, b)", "Return the elements of set a that are not in set b."},
+	"map":          {"map(iterable, fn)", "Return an array of fn applied to each element."},
+	"filter":       {"filter(iterable, fn)", "Return an array of the elements for which fn returns a truthy value."},
+	"reduce":       {"reduce(iterable, initial, fn)", "Fold the elements from the left with fn(acc, element)."},
+	"sort":         {"sort(arr, fn?)", "Return a sorted copy of arr, optionally ordered by a comparator fn(a, b)."},
+	"memo":         {"memo(fn)", "Return a function that caches the results of fn by its arguments."},
+	"keys":         {"keys(hash)", "Return the keys of a hash in insertion order."},
+	"values":       {"values(hash)", "Return the values of a hash in insertion order."},
+	"has_key":      {"has_key(hash, key)", "Report whether hash has key."},
+	"delete":       {"delete(hash, key)", "Return a new hash without key."},
+	"merge":        {"merge(a, b)", "Return a new hash with the pairs of b added to a."},
+
+	// strings.go
+	"split":          {"split(str, sep)", "Split str around each occurrence of sep."},
+	"join":           {"join(arr, sep)", "Concatenate an array of strings with sep between them."},
+	"trim":           {"trim(str)", "Remove leading and trailing white space."},
+	"upper":          {"upper(str)", "Convert str to upper case."},
+	"lower":          {"lower(str)", "Convert str to lower case."},
+	"replace":        {"replace(str, old, new)", "Replace all occurrences of old with new."},
+	"starts_with":    {"starts_with(str, prefix)", "Report whether str begins with prefix."},
+	"ends_with":      {"ends_with(str, suffix)", "Report whether str ends with suffix."},
+	"index_of":       {"index_of(str_or_arr, value)", "Return the index of the first occurrence of value, or -1."},
+	"chars":          {"chars(str)", "Split str into single-character strings."},
+	"format":         {"format(template, values...)", "Replace each {} in template with the next value."},
+	"printf":         {"printf(template, values...)", "Print a formatted string without a trailing newline."},
+	"string_builder": {"string_builder()", "Create a builder; sb.append(values...) adds text and sb.build() returns the string."},
+
+	// conversions.go
+	"int":   {"int(x)", "Convert x to an integer."},
+	"float": {"float(x)", "Convert x to a float."},
+	"str":   {"str(x)", "Convert x to a string."},
+	"bool":  {"bool(x)", "Convert x to a boolean using the truthiness of if."},
+	"type":  {"type(x)", "Return the name of the type of x, such as \"INTEGER\" or \"STRING\"."},
+
+	// math.go
+	"abs":   {"abs(x)", "Return the absolute value of x."},
+	"min":   {"min(values...) / min(iterable)", "Return the smallest value."},
+	"max":   {"max(values...) / max(iterable)", "Return the largest value."},
+	"pow":   {"pow(x, y)", "Return x to the power of y."},
+	"sqrt":  {"sqrt(x)", "Return the square root of x."},
+	"floor": {"floor(x)", "Round x down to an integer."},
+	"ceil":  {"ceil(x)", "Round x up to an integer."},
+	"round": {"round(x)", "Round x to the nearest integer, halves away from zero."},
+
+	// time.go
+	"now":         {"now()", "Return the current time in Unix milliseconds."},
+	"clock":       {"clock()", "Return monotonic milliseconds since the interpreter started."},
+	"sleep":       {"sleep(ms)", "Pause for ms milliseconds, or until the evaluation is cancelled."},
+	"format_time": {"format_time(ts, layout)", "Format Unix milliseconds as UTC using a Go time layout."},
+
+	// os.go
+	"env":      {"env(name)", "Return the value of an environment variable, or null if it is unset."},
+	"set_env":  {"set_env(name, value)", "Set an environment variable."},
+	"args":     {"args()", "Return the arguments passed to the script."},
+	"exit":     {"exit(code?)", "Stop the program with an exit status (0 by default)."},
+	"platform": {"platform()", "Return the name of the operating system."},
+
+	// file.go
+	"read_file":   {"read_file(path)", "Return the contents of a file."},
+	"read_lines":  {"read_lines(path)", "Return the lines of a file without line endings."},
+	"write_file":  {"write_file(path, data)", "Overwrite a file with data, creating it if needed."},
+	"append_file": {"append_file(path, data)", "Append data to a file, creating it if needed."},
+	"file_exists": {"file_exists(path)", "Report whether a file exists."},
+
+	// exec.go
+	"exec": {"exec(cmd, args...)", "Run a command and return {out, err, code}."},
+
+	// testing.go
+	"assert":    {"assert(cond, msg?)", "Stop with an error unless cond is truthy."},
+	"assert_eq": {"assert_eq(actual, expected)", "Stop with an error unless the values are equal."},
+	"test":      {"test(name, fn)", "Register a test to be run by run_tests()."},
+	"run_tests": {"run_tests()", "Run the registered tests and return {passed, failed}."},
+
+	// meta.go
+	"eval":  {"eval(code, fresh?)", "Evaluate code in the current environment, or a fresh one."},
+	"parse": {"parse(code)", "Parse code and return its AST as nested hashes."},
+
+	// copy.go
+	"copy":  {"copy(x)", "Return a deep copy of arrays, hashes, sets and instances."},
+	"clone": {"clone(x)", "Return a shallow copy that shares its elements with x."},
+
+	// import.go
+	"import": {"import(path)", "Evaluate a file once and return a module of its top-level bindings."},
+
+	// docs.go
+	"help": {"help(name?)", "Describe a builtin, or list all builtins."},
+
+	// http.go
+	"http.get":  {"http.get(url, headers?)", "Send a GET request and return {status, body, headers}."},
+	"http.post": {"http.post(url, body, headers?)", "Send a POST request and return {status, body, headers}."},
+}
+
+// 名前とドキュメントが設定されていなければ設定する。
+func document(name string, b *object.Builtin) {
+	if b.Name == "" {
+		b.Name = name
+	}
+	if b.Signature == "" && b.Doc == "" {
+		if doc, ok := builtinDocs[name]; ok {
+			b.Signature, b.Doc = doc.Signature, doc.Doc
+		}
+	}
+}
+
+// 組み込み関数の使い方を "len(x)\n    Return ..." の形で返す。
+func describeBuiltin(name string, obj object.Object) string {
+	b, ok := obj.(*object.Builtin)
+	if !ok {
+		return fmt.Sprintf("%s = %s", name, obj.Inspect())
+	}
+	signature := b.Signature
+	if signature == "" {
+		signature = name + "(...)"
+	}
+	if b.Doc == "" {
+		return signature
+	}
+	return signature + "\n    " + b.Doc
+}
+
+// envで使える組み込み関数のうち、nameの使い方を返す。そのような組み込み関数がなければfalse。
+// "json.encode" のように . で区切った名前は、モジュールのメンバーを探す。
+func DescribeBuiltin(env *object.Environment, name string) (string, bool) {
+	if i := strings.Index(name, "."); i > 0 {
+		obj, ok := lookupBuiltin(env, name[:i])
+		module, isModule := obj.(*object.Module)
+		if !ok || !isModule {
+			return "", false
+		}
+		member, ok := module.Env.Get(name[i+1:])
+		if !ok {
+			return "", false
+		}
+		return describeBuiltin(name, member), true
+	}
+
+	obj, ok := lookupBuiltin(env, name)
+	if !ok {
+		return "", false
+	}
+	return describeBuiltin(name, obj), true
+}
+
+// envで使える組み込み関数の使い方を、名前の順に全て並べる。
+func HelpText(env *object.Environment) string {
+	registry := Builtins
+	if r, ok := env.Builtins().(*BuiltinRegistry); ok {
+		registry = r
+	}
+
+	var out bytes.Buffer
+	for _, name := range registry.Names() {
+		if obj, ok := registry.LookupBuiltin(name); ok {
+			out.WriteString(describeBuiltin(name, obj) + "\n")
+		}
+	}
+	return out.String()
+}
+
+// help() で使える組み込み関数の一覧を、help("len") でlenの使い方を文字列で返す。
+// help(len) のように組み込み関数そのものを渡してもいい。
+var helpBuiltin = builtin("help", args(optional(oneOf(STRING, paramOf(object.BUILTIN_OBJ))))).FnEnv(
+	func(env *object.Environment, args ...object.Object) object.Object {
+		if len(args) == 0 {
+			return &object.String{Value: HelpText(env)}
+		}
+
+		switch arg := args[0].(type) {
+		case *object.String:
+			text, ok := DescribeBuiltin(env, arg.Value)
+			if !ok {
+				return newError("no builtin named %s", arg.Value)
+			}
+			return &object.String{Value: text}
+		default:
+			b := arg.(*object.Builtin)
+			return &object.String{Value: describeBuiltin(b.Name, b)}
+		}
+	},
+)
@@ -2,18 +2,34 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/ast"
 	"monkey/object"
+	"sync"
 )
 
 // null、true、falseはどのコンテキストでも同じもの。
 // 毎回objectを生成する必要はないので、Evalではここのポインタを参照させて返すようにする。
+// objectパッケージの中で作る値（FromJSONなど）とも同じものになるよう、objectパッケージのものを使う。
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
+// 割り切れない整数同士の割り算の結果をどうするか。WithIntegerDivisionでContextに設定する。
+type DivisionMode int
+
+const (
+	TruncatedDivision DivisionMode = iota // 5 / 2 は 2。小数点以下を切り捨てる（デフォルト）
+	FloatDivision                         // 5 / 2 は 2.5。割り切れる場合は今まで通り整数になる
+)
+
+// 関数呼び出しの深さの上限。超えると "stack overflow" のエラーになる。
+// 評価はgoの再帰で行うので、上限を上げすぎるとgoのスタックが足りなくなる。
+var MaxCallDepth = 10000
+
 // ASTを辿っていき、評価する。
 // 末端のノードであることが確定しているIntegerやBoolなどは自身のノードの値を返す。
 // 配下にノードを持つノードの場合(Expressionとか)は、再帰的にEvalを呼び出し続ける。
@@ -25,22 +41,38 @@ var (
 // envについて
 // env は変数への値の束縛に使う。
 // envはmap構造になっていて、LetStatementの評価がされるたびに更新されていく。
+//
+// エラーの位置について
+// newErrorで作ったエラーには位置が入っていないので、最初にエラーを返したノード、つまり一番内側のノードの位置を付ける。
+//
+// envのContextにWithTracerでTracerが設定されていれば、ノードを評価する前後にEnterとExitを呼ぶ。
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	tracer := settingsOf(env).tracer
+	if tracer != nil {
+		tracer.Enter(node, env)
+	}
+	result := evalNode(node, env)
+	if err, ok := result.(*object.Error); ok && err.Pos.Line == 0 {
+		err.Pos = node.Pos()
+	}
+	if tracer != nil {
+		tracer.Exit(node, result)
+	}
+	return result
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// --------------
 	// Statements（評価の結果、値を返さない）
 	// --------------
 	case *ast.Program:
-		//fmt.Println("Program--------------")
 		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		//fmt.Println("ExpressionStatement--------------")
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
-		//fmt.Println("BlockStatement--------------")
 		return evalBlockStatement(node, env)
 	case *ast.ReturnStatement:
-		//fmt.Println("ReturnStatement--------------")
 		val := Eval(node.ReturnValue, env) // ReturnValueはExpressionなので、Eval内ではExpressionStatementが実行される。
 		if isError(val) {
 			return val
@@ -48,61 +80,133 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		// ReturnStatementが来たら、returnの右側の式を評価して、その値を返す。なので、return文の後に何か書いていても評価されない。
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
-		//fmt.Println("LetStatement--------------")
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val) // 評価結果をletで宣言したIDENTに束縛させる
+		nameFunction(node.Value, val, node.Name.Value)
+		// 評価結果をletで宣言したIDENTに束縛させる。同じスコープのconstは上書きできないのでエラーになる。
+		if result := env.Set(node.Name.Value, val); isError(result) {
+			return result
+		}
+	case *ast.LetDestructureStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if err := bindPattern(node.Pattern, val, env); err != nil {
+			return err
+		}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		// throwされた値はExceptionで包んで、catchされるまで呼び出し元へ伝播させる。
+		return &object.Exception{Value: val, Pos: node.Pos()}
+	case *ast.ConstStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		nameFunction(node.Value, val, node.Name.Value)
+		// letと違い、再代入できない束縛として記録する
+		if result := env.SetConst(node.Name.Value, val); isError(result) {
+			return result
+		}
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+	case *ast.ClassStatement:
+		if result := env.Set(node.Name.Value, evalClassStatement(node, env)); isError(result) {
+			return result
+		}
 
 	// --------------
 	// Expressions（評価の結果、値を返す）
 	// --------------
 	case *ast.IntegerLiteral:
-		//fmt.Println("IntegerLiteral--------------")
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
+	case *ast.BigIntLiteral:
+		return &object.BigInt{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
-		//fmt.Println("StringLiteral--------------")
-		return &object.String{Value: node.Value}
+		return object.NewString(node.Value)
 	case *ast.Boolean:
-		//fmt.Println("Boolean--------------")
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
 	case *ast.PrefixExpression: // ! or -
-		//fmt.Println("PrefixExpression--------------")
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(env, node.Operator, right)
 	case *ast.InfixExpression:
-		//fmt.Println("InfixExpression--------------")
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
+		// null合体演算子 ?? は左側がnullでなければ右側を評価せずに左側の値を返す。
+		if node.Operator == "??" {
+			if left != NULL {
+				return left
+			}
+			return Eval(node.Right, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		// 文字列の結合などで作った値は、WithMemoryLimitの上限に数える
+		return allocated(env, evalInfixExpression(env, node.Operator, left, right))
+	case *ast.AssignExpression:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		// letと違い、新しい束縛は作らない。宣言されていない変数、constで宣言された変数への代入はエラー。
+		// ローカル変数として解決されていれば、その場所を直接更新する
+		if b := node.Name.Binding; b != nil {
+			if _, ok := env.AssignAt(b.Depth, b.Slot, val); ok {
+				return val
+			}
+		}
+		if env.IsConst(node.Name.Value) {
+			return newError("cannot assign to constant: " + node.Name.Value)
+		}
+		if _, ok := env.Assign(node.Name.Value, val); !ok {
+			return newError("identifier not found: " + node.Name.Value)
+		}
+		return val
+	case *ast.PropertyAssignExpression:
+		left := Eval(node.Target.Left, env)
+		if isError(left) {
+			return left
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return setProperty(left, node.Target.Property.Value, val)
 	case *ast.IfExpression:
-		//fmt.Println("IfExpression--------------")
 		return evalIfExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	// 変数に束縛された値をenvから確認し、返す。
 	// 束縛されている変数が見つからなかった場合は組み込み関数を探し、Builtinオブジェクトを返す。
 	case *ast.Identifier:
-		//fmt.Println("Identifier--------------")
 		return evalIdentifier(node, env)
 	// ユーザー定義の関数の関数オブジェクトの生成
 	case *ast.FunctionLiteral:
-		//fmt.Println("FunctionLiteral--------------")
 		params := node.Parameters
 		body := node.Body
 		// Envには関数を定義した場所のスコープがはいる
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Rest: node.Rest, Env: env, Body: body, Slots: node.Slots, Name: node.Name}
 	// 関数呼び出し
 	case *ast.CallExpression:
-		//fmt.Println("CallExpression--------------")
 		// Functionオブジェクトの取得。ここのEvalの処理は、関数がユーザー定義か、組み組みかの違いにより、再帰の流れが異なってくる。
 		// ＜ユーザー定義の関数の場合＞
 		//   parseの結果、node.Functionには、FunctionLiteralのExpressionが入っている。
@@ -126,18 +230,24 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 		// functionはユーザー定義の関数(object.Function)の場合と、組み込み関数の場合(object.Builtin)がある。
 		// applyFunctionのなかでどちらなのか確認し処理をする。
-		return applyFunction(function, args)
+		result := applyFunction(env, function, args)
+		// 関数の中で発生したエラーには、呼び出し元をさかのぼれるように、この呼び出しをスタックに積んでおく。
+		if err, ok := result.(*object.Error); ok {
+			err.Stack = append(err.Stack, object.StackFrame{
+				Function: node.CalleeName(),
+				Pos:      node.Pos(),
+			})
+		}
+		return result
 	case *ast.ArrayLiteral:
-		//fmt.Println("ArrayLiteral--------------")
 		elements := evalExpressions(node.Elements, env)
 		// evalExpressionsの処理内ではElementsのいずれかでエラーが発生するとそのエラーのみが返ってくる。でそのエラーを返す。
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return literal(env, allocated(env, &object.Array{Elements: elements}))
 	// 添字アクセス。添字アクセスは配列とハッシュがある。
 	case *ast.IndexExpression:
-		//fmt.Println("IndexExpression--------------")
 		// 添字の対象になる式を評価する。
 		// ・配列の場合
 		// 　Leftの式は最終的に、Evalの case *ast.ArrayLiteral: の分岐を経て object.Array になり、leftに入る。
@@ -147,6 +257,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(left) {
 			return left
 		}
+		// left?.[index] の形の場合、leftがnullならエラーにせずnullを返す。添字の式も評価しない。
+		if node.Optional && left == NULL {
+			return NULL
+		}
 
 		// 添字の式を評価する。
 		// ・配列の場合
@@ -161,8 +275,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		//fmt.Println("HashLiteral--------------")
-		return evalHashLiteral(node, env)
+		return literal(env, allocated(env, evalHashLiteral(node, env)))
+	case *ast.PropertyExpression:
+		return evalPropertyExpression(node, env)
+	// ...arr は関数呼び出しの引数と配列リテラルの要素の中で、evalExpressionsが展開する。
+	// それ以外の場所に現れた場合はエラー。
+	case *ast.SpreadExpression:
+		return newError("spread operator not allowed here: %s", node.String())
 	}
 
 	return nil
@@ -175,10 +294,10 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func evalPrefixExpression(env *object.Environment, operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
-		return evalBangOperatorExpression(right)
+		return evalBangOperatorExpression(env, right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
@@ -187,7 +306,10 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 // 前置演算子で ! が現れたら 右側の 式 の結果を反転させる
-func evalBangOperatorExpression(right object.Object) object.Object {
+func evalBangOperatorExpression(env *object.Environment, right object.Object) object.Object {
+	if settingsOf(env).strict && right != TRUE && right != FALSE {
+		return newError("operand of ! must be BOOLEAN, got %s", right.Type())
+	}
 	switch right {
 	case TRUE:
 		return FALSE
@@ -211,25 +333,68 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	// - の前置演算子を置けるのは、右側がintegerの時だけ。
+	// - の前置演算子を置けるのは、右側が数値の時だけ。
 	// このルールに反してたらエラー
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	case *object.BigInt:
+		return bigIntToObject(new(big.Int).Neg(right.Value))
+	case *object.Integer:
+		// -9223372036854775808 の符号を反転するとint64に収まらない
+		if right.Value == math.MinInt64 {
+			return bigIntToObject(new(big.Int).Neg(big.NewInt(right.Value)))
+		}
+		return object.NewInteger(-right.Value) // 整数のprefixに - をつけたIntegerオブジェクトを返す
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value} // 整数のprefixに - をつけたIntegerオブジェクトを返す
 }
 
 func evalInfixExpression(
+	env *object.Environment,
+	operator string,
+	left, right object.Object,
+) object.Object {
+	result := evalBuiltinInfixExpression(env, operator, left, right)
+	// 組み込みの演算子で扱えない組み合わせだけ、RegisterInfixOperatorで追加された評価関数を試す。
+	if unhandledOperator(result) {
+		if fn, ok := lookupInfixOperator(env, operator); ok {
+			if added := fn(left, right); added != nil {
+				return added
+			}
+		}
+	}
+	return result
+}
+
+func evalBuiltinInfixExpression(
+	env *object.Environment,
 	operator string,
 	left, right object.Object,
 ) object.Object {
 	switch {
+	// 大小の比較は、数値、文字列、真偽値のどれでもobject.Compareの順序に従う。
+	case operator == "<" || operator == ">":
+		if cmp, ok := object.Compare(left, right); ok {
+			return nativeBoolToBooleanObject((operator == "<" && cmp < 0) || (operator == ">" && cmp > 0))
+		}
+		if left.Type() != right.Type() {
+			return newError("type mismatch: %s %s %s",
+				left.Type(), operator, right.Type())
+		}
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	// 二項演算の左右が数値なら
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		// 四則演算 or 比較の評価をする
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(settingsOf(env).division, operator, left, right)
+	// 片方がint64に収まらない整数なら、big.Intで計算する
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(settingsOf(env).division, operator, toBigInt(left), toBigInt(right))
+	// 片方が小数なら、もう片方の整数も小数にしてから計算する。 1 + 1.5 は 2.5
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
 	// 文字列結合なら
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
@@ -253,25 +418,53 @@ func evalInfixExpression(
 }
 
 func evalIntegerInfixExpression(
+	division DivisionMode,
 	operator string,
 	left, right object.Object,
 ) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	// 計算結果がint64に収まらない場合は、big.Intで計算し直す。
+	// fib(100) のような大きな数がオーバーフローして黙って負の数になったりしないように。
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		result := leftVal + rightVal
+		if (leftVal > 0 && rightVal > 0 && result < 0) || (leftVal < 0 && rightVal < 0 && result >= 0) {
+			return evalBigIntInfixExpression(division, operator, big.NewInt(leftVal), big.NewInt(rightVal))
+		}
+		return object.NewInteger(result)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		result := leftVal - rightVal
+		if (leftVal >= 0 && rightVal < 0 && result < 0) || (leftVal < 0 && rightVal > 0 && result >= 0) {
+			return evalBigIntInfixExpression(division, operator, big.NewInt(leftVal), big.NewInt(rightVal))
+		}
+		return object.NewInteger(result)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		result := leftVal * rightVal
+		if leftVal != 0 && (result/leftVal != rightVal || (leftVal == -1 && rightVal == math.MinInt64)) {
+			return evalBigIntInfixExpression(division, operator, big.NewInt(leftVal), big.NewInt(rightVal))
+		}
+		return object.NewInteger(result)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
-	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
-	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		// 0で割るとgoがpanicしてプロセスごと落ちてしまうので、エラーにする。
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		// -9223372036854775808 / -1 だけはint64に収まらない
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(division, operator, big.NewInt(leftVal), big.NewInt(rightVal))
+		}
+		if division == FloatDivision && leftVal%rightVal != 0 {
+			return &object.Float{Value: float64(leftVal) / float64(rightVal)}
+		}
+		return object.NewInteger(leftVal / rightVal)
+	case "%":
+		// 余りの符号は左側と同じになる。 -7 % 2 は -1
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(leftVal % rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -281,19 +474,126 @@ func evalIntegerInfixExpression(
 	}
 }
 
+func evalBigIntInfixExpression(division DivisionMode, operator string, leftVal, rightVal *big.Int) object.Object {
+	switch operator {
+	case "+":
+		return bigIntToObject(new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return bigIntToObject(new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return bigIntToObject(new(big.Int).Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		// Quoは0の方向に切り捨てるので、int64の割り算と同じ結果になる。
+		quo, rem := new(big.Int).QuoRem(leftVal, rightVal, new(big.Int))
+		if division == FloatDivision && rem.Sign() != 0 {
+			f, _ := new(big.Rat).SetFrac(leftVal, rightVal).Float64()
+			return &object.Float{Value: f}
+		}
+		return bigIntToObject(quo)
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		// Remの余りの符号も、int64の % と同じく左側と同じになる。
+		return bigIntToObject(new(big.Int).Rem(leftVal, rightVal))
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", object.BIGINT_OBJ, operator, object.BIGINT_OBJ)
+	}
+}
+
+// int64に収まる場合はIntegerに戻す。
+func bigIntToObject(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return object.NewInteger(value.Int64())
+	}
+	return &object.BigInt{Value: value}
+}
+
+// IntegerかBigInt
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+// 整数をbig.Intにする。isIntegerで整数であることを確かめてから呼ぶ。
+func toBigInt(obj object.Object) *big.Int {
+	if i, ok := obj.(*object.Integer); ok {
+		return big.NewInt(i.Value)
+	}
+	return obj.(*object.BigInt).Value
+}
+
+func evalFloatInfixExpression(operator string, leftVal, rightVal float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		// 整数と同じく、0で割った場合は無限大ではなくエラーにする。
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, operator, object.FLOAT_OBJ)
+	}
+}
+
+// 整数か小数か
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+// 数値をfloat64にする。isNumberで数値であることを確かめてから呼ぶ。
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	}
+	return obj.(*object.Float).Value
+}
+
 func evalStringInfixExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	// 文字列は + の結合のみサポートする。文字列同士の引き算や ==、!= の比較などは対応していない。
-	if operator != "+" {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	// 文字列は + の結合と比較をサポートする。文字列同士の引き算などは対応していない。
+	// < と > はevalInfixExpressionでobject.Compareを使って評価する。
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
-
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
 }
 
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
@@ -305,8 +605,11 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
-		case *object.Error:
+		case *object.Error, *object.Exit:
 			return result
+		// 最後までcatchされなかった例外はエラーとしてプログラムを終了させる。
+		case *object.Exception:
+			return uncaught(result)
 		}
 	}
 
@@ -334,7 +637,7 @@ func evalBlockStatement(
 		// このプログラムは1を返すべきなプログラム。
 		// このプログラムの return 10 で ReturnValue.Value をもし返してしまった場合、10はIntegerなので、次のEvalで処理されるのは
 		// case *ast.IntegerLiteral:
-		//	 return &object.Integer{Value: node.Value}
+		//	 return object.NewInteger(node.Value)
 		// の部分となり、Eval関数の再帰的な処理が終わってしまう。上記のプログラムが返す値は本来 1 のはずだが、10となってしまう。
 		// この evalBlockStatement 関数内で ReturnValueオブジェクトが現れた際、
 		// オブジェクトをアンラップせずにそのまま返すことでEvalの再帰処理を止めることがなくなるので、ネストしたブロックでもちゃんと評価できるようになる。
@@ -342,9 +645,10 @@ func evalBlockStatement(
 		// あとは、評価の結果が Error オブジェクトだった時もそれを結果として返す必要がある。
 		// block内の返り値となりうる値は returnした値 か 発生したエラー なので、
 		// if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ { という条件になる。
+		// throwされた例外(Exception)もエラーと同じく、catchされるまでそのまま返す。exit()(Exit)も同じ。
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || isError(result) {
 				return result
 			}
 		}
@@ -362,8 +666,12 @@ func evalIfExpression(
 	if isError(condition) {
 		return condition
 	}
+	truthy, err := evalCondition(env, condition)
+	if err != nil {
+		return err
+	}
 
-	if isTruthy(condition) {
+	if truthy {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
@@ -372,15 +680,468 @@ func evalIfExpression(
 	}
 }
 
+// let [a, b] = <expression>; や let {name, age} = <expression>; の束縛。
+// 要素やキーが足りない場合、その変数にはNULLを束縛する。
+// 束縛できない値だった場合はErrorオブジェクトを返す。
+func bindPattern(
+	pattern ast.Expression,
+	val object.Object,
+	env *object.Environment,
+) *object.Error {
+	var idents []*ast.Identifier
+	var values []object.Object
+	var err *object.Error
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		idents = pattern.Elements
+		if pattern.Rest != nil {
+			idents = append(append([]*ast.Identifier{}, idents...), pattern.Rest)
+		}
+		values, err = destructureArray(env, val, len(pattern.Elements), pattern.Rest != nil)
+	case *ast.HashPattern:
+		idents = pattern.Keys
+		keys := make([]string, len(pattern.Keys))
+		for i, ident := range pattern.Keys {
+			keys[i] = ident.Value
+		}
+		values, err = destructureHash(val, keys)
+	default:
+		return newError("unknown pattern: %s", pattern.String())
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, ident := range idents {
+		if err := bind(env, ident.Value, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valの先頭からn個の要素と、restなら残りの要素の配列を返す。要素が足りなければNULLで埋める。
+// valが配列でなければエラー。
+func destructureArray(env *object.Environment, val object.Object, n int, rest bool) ([]object.Object, *object.Error) {
+	if v, ok := val.(*object.Vector); ok {
+		val = v.ToArray()
+	}
+	array, ok := val.(*object.Array)
+	if !ok {
+		return nil, newError("cannot destructure %s as ARRAY", val.Type())
+	}
+
+	values := make([]object.Object, n)
+	for i := range values {
+		values[i] = NULL
+		if i < len(array.Elements) {
+			values[i] = array.Elements[i]
+		}
+	}
+	if rest {
+		remaining := []object.Object{}
+		if len(array.Elements) > n {
+			remaining = append(remaining, array.Elements[n:]...)
+		}
+		values = append(values, literal(env, &object.Array{Elements: remaining}))
+	}
+	return values, nil
+}
+
+// valのkeysの値を返す。ないキーの値はNULL。valがハッシュでなければエラー。
+func destructureHash(val object.Object, keys []string) ([]object.Object, *object.Error) {
+	if m, ok := val.(*object.Map); ok {
+		val = m.ToHash()
+	}
+	hash, ok := val.(*object.Hash)
+	if !ok {
+		return nil, newError("cannot destructure %s as HASH", val.Type())
+	}
+
+	values := make([]object.Object, len(keys))
+	for i, key := range keys {
+		values[i] = NULL
+		if pair, ok := hash.Pairs[object.NewString(key).HashKey()]; ok {
+			values[i] = pair.Value
+		}
+	}
+	return values, nil
+}
+
+// env.Setでconstを上書きしようとした場合のエラーを*object.Errorとして返す。
+func bind(env *object.Environment, name string, val object.Object) *object.Error {
+	if err, ok := env.Set(name, val).(*object.Error); ok {
+		return err
+	}
+	return nil
+}
+
+// try <block statement> catch (<identifier>) <block statement>
+// tryのブロックの評価中に発生したエラーと例外をcatchのブロックで受け止める。
+// throwされた例外の場合はthrowされた値を、組み込みのエラーの場合はerror()で作るのと同じエラーの値を変数に束縛する。
+func evalTryExpression(
+	te *ast.TryExpression,
+	env *object.Environment,
+) object.Object {
+	result := Eval(te.Block, env)
+
+	value, ok := caught(env, result)
+	if !ok {
+		return result
+	}
+	if err := bind(env, te.Parameter.Value, value); err != nil {
+		return err
+	}
+	return Eval(te.Handler, env)
+}
+
+// resultがtryでcatchできるものなら、catchの変数に束縛する値を返す。
+// 評価の中断とexit()はcatchできない。catchしてしまうと、中断したいループを続けられてしまう。
+func caught(env *object.Environment, result object.Object) (object.Object, bool) {
+	switch result := result.(type) {
+	case *object.Exception:
+		return result.Value, true
+	case *object.Error:
+		if checkInterrupted(env) != nil {
+			return nil, false
+		}
+		return &object.ErrorValue{Err: result}, true
+	}
+	return nil, false
+}
+
+// catchされなかった例外のエラー。位置はthrowした位置。
+func uncaught(ex *object.Exception) *object.Error {
+	err := newError("uncaught exception: %s", ex.Value.Inspect())
+	err.Pos = ex.Pos
+	return err
+}
+
+// 文字列のパターンだけのmatch式で、アームを上から順に比べる代わりに使う表。
+// match (type(x)) { "INTEGER" => ..., "STRING" => ..., _ => ... } のような型による分岐を、一回の表引きで行う。
+type matchTable struct {
+	arms     map[string]int // 文字列と、それに最初にマッチするアームの番号
+	fallback int            // 表にない値の場合に選ぶ _ のアームの番号。ない場合は-1
+}
+
+// match式ごとに作った表。表を作れないmatch式にはnilを入れておく。
+// ASTは実行時の状態を持たないので、評価器の側でノードをキーにして覚えておく。
+var matchTables sync.Map // *ast.MatchExpression -> *matchTable
+
+// 全てのアームがガードのない文字列リテラルか _ の場合に、アームを選ぶ表を返す。それ以外の場合はnil。
+// 表はmatch式ごとに最初に評価したときに一度だけ作る。
+func matchTableOf(me *ast.MatchExpression) *matchTable {
+	if table, ok := matchTables.Load(me); ok {
+		return table.(*matchTable)
+	}
+	table := newMatchTable(me)
+	matchTables.Store(me, table)
+	return table
+}
+
+func newMatchTable(me *ast.MatchExpression) *matchTable {
+	table := &matchTable{arms: make(map[string]int), fallback: -1}
+	for i, arm := range me.Arms {
+		if arm.IsWildcard() {
+			// _ より後のアームが選ばれることはない
+			table.fallback = i
+			break
+		}
+		str, ok := arm.Pattern.(*ast.StringLiteral)
+		if !ok || arm.Guard != nil {
+			return nil
+		}
+		if _, ok := table.arms[str.Value]; !ok {
+			table.arms[str.Value] = i
+		}
+	}
+	if len(table.arms) == 0 {
+		return nil
+	}
+	return table
+}
+
+// match (<subject>) { <pattern> => <expression>, ... }
+// アームを上から順に比較し、最初にマッチしたアームのみを評価する。
+// パターンの変数は、マッチしたアームのガードとボディを評価する前に束縛する。forやtryと同じく、囲む関数のスコープに束縛される。
+// どのアームにもマッチしなかった場合はNULLを返す。（elseのないifと同じ）
+func evalMatchExpression(
+	me *ast.MatchExpression,
+	env *object.Environment,
+) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	// 文字列のパターンだけなら、表から一回でアームを選ぶ
+	if table := matchTableOf(me); table != nil {
+		i := table.fallback
+		if str, ok := subject.(*object.String); ok {
+			if arm, ok := table.arms[str.Value]; ok {
+				i = arm
+			}
+		}
+		if i < 0 {
+			return NULL
+		}
+		return Eval(me.Arms[i].Body, env)
+	}
+
+	for _, arm := range me.Arms {
+		if arm.IsWildcard() {
+			return Eval(arm.Body, env)
+		}
+
+		// 途中までマッチしたパターンの変数を束縛してしまわないように、最後までマッチしてからまとめて束縛する
+		var bindings []patternBinding
+		matched, err := matchPattern(arm.Pattern, subject, env, &bindings)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		for _, b := range bindings {
+			if err := bind(env, b.name, b.value); err != nil {
+				return err
+			}
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, env)
+			if isError(guard) {
+				return guard
+			}
+			truthy, err := evalCondition(env, guard)
+			if err != nil {
+				return err
+			}
+			if !truthy {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, env)
+	}
+
+	return NULL
+}
+
+// パターンにマッチしたときに束縛する変数と値。
+type patternBinding struct {
+	name  string
+	value object.Object
+}
+
+// valがmatchのアームのパターンにマッチするか。マッチした場合に束縛する変数をbindingsに追加する。
+// パターンに書かれた式の評価がエラーになった場合はエラーを返す。
+func matchPattern(
+	pattern ast.Expression,
+	val object.Object,
+	env *object.Environment,
+	bindings *[]patternBinding,
+) (bool, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			*bindings = append(*bindings, patternBinding{pattern.Value, val})
+		}
+		return true, nil
+
+	case *ast.ArrayLiteral:
+		// 最後の要素が ...rest なら、残りの要素をrestに束縛する
+		elements, rest := ast.SplitRestPattern(pattern)
+		values, ok := matchArray(env, val, len(elements), rest != nil)
+		if !ok {
+			return false, nil
+		}
+
+		for i, el := range elements {
+			if _, ok := el.(*ast.SpreadExpression); ok {
+				return false, newError("... must be the last element of an array pattern")
+			}
+			matched, err := matchPattern(el, values[i], env, bindings)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		if rest != nil {
+			return matchPattern(rest, values[len(elements)], env, bindings)
+		}
+		return true, nil
+
+	case *ast.HashLiteral:
+		if !isHash(val) {
+			return false, nil
+		}
+
+		for keyNode, valueNode := range pattern.Pairs {
+			key := Eval(keyNode, env)
+			if isError(key) {
+				return false, key
+			}
+			values, ok, kerr := matchHash(val, []object.Object{key})
+			if kerr != nil {
+				return false, kerr
+			}
+			if !ok {
+				return false, nil
+			}
+			matched, err := matchPattern(valueNode, values[0], env, bindings)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	// それ以外の式は評価して、値を比べる
+	expected := Eval(pattern, env)
+	if isError(expected) {
+		return false, expected
+	}
+	return objectsEqual(val, expected), nil
+}
+
+// valがちょうどn個の要素の配列（restならn個以上）なら、先頭からn個の要素と、restなら残りの要素の配列を返す。
+func matchArray(env *object.Environment, val object.Object, n int, rest bool) ([]object.Object, bool) {
+	if v, ok := val.(*object.Vector); ok {
+		val = v.ToArray()
+	}
+	array, ok := val.(*object.Array)
+	if !ok || len(array.Elements) < n || (!rest && len(array.Elements) != n) {
+		return nil, false
+	}
+
+	values := append([]object.Object{}, array.Elements[:n]...)
+	if rest {
+		remaining := append([]object.Object{}, array.Elements[n:]...)
+		values = append(values, literal(env, &object.Array{Elements: remaining}))
+	}
+	return values, true
+}
+
+func isHash(val object.Object) bool {
+	switch val.(type) {
+	case *object.Hash, *object.Map:
+		return true
+	}
+	return false
+}
+
+// valが全てのkeysを持つハッシュなら、キーの値を返す。ハッシュのキーに使えない値がkeysにあればエラー。
+func matchHash(val object.Object, keys []object.Object) ([]object.Object, bool, *object.Error) {
+	if m, ok := val.(*object.Map); ok {
+		val = m.ToHash()
+	}
+	hash, ok := val.(*object.Hash)
+	if !ok {
+		return nil, false, nil
+	}
+
+	values := make([]object.Object, len(keys))
+	for i, key := range keys {
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, false, newError("unusable as hash key: %s", key.Type())
+		}
+		pair, ok := hash.Pairs[hashKey.HashKey()]
+		if !ok {
+			return nil, false, nil
+		}
+		values[i] = pair.Value
+	}
+	return values, true, nil
+}
+
+// 二つのオブジェクトが同じ値かどうか。
+// 数値は整数と小数の区別なく値で比較する。Hashableなオブジェクト（文字列、真偽値）は型と値で比較し、それ以外はポインタで比較する。
+func objectsEqual(a, b object.Object) bool {
+	// 整数と小数は値が同じなら等しい。 1 と 1.0 は等しい。
+	if isInteger(a) && isInteger(b) {
+		return toBigInt(a).Cmp(toBigInt(b)) == 0
+	}
+	if isNumber(a) && isNumber(b) {
+		return toFloat(a) == toFloat(b)
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	ah, ok := a.(object.Hashable)
+	if !ok {
+		return a == b
+	}
+	bh, ok := b.(object.Hashable)
+	if !ok {
+		return false
+	}
+
+	return ah.HashKey() == bh.HashKey()
+}
+
+// for (<identifier> in <expression>) <block statement>
+// ifのブロックと同じく、ループの本体は新しいスコープを作らずに現在のenvで評価する。
+// なので、ループ変数やループ内でletした変数はループの外からも参照できる。
+func evalForInStatement(
+	fs *ast.ForInStatement,
+	env *object.Environment,
+) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	it, ok := iterable.(object.Iterable)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	iter := it.Iterator()
+	for {
+		element, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		// 一周ごとに、EvalContextのContextがキャンセルされていないか、WithStepLimitの上限を超えていないか調べる
+		if err := step(env); err != nil {
+			return err
+		}
+		if err := bind(env, fs.Variable.Value, element); err != nil {
+			return err
+		}
+
+		// evalBlockStatementと同じく、ReturnValueとErrorはアンラップせずにそのまま返してループを抜ける。
+		result := Eval(fs.Body, env)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || isError(result) {
+				return result
+			}
+		}
+	}
+
+	return NULL
+}
+
 func evalIdentifier(
 	node *ast.Identifier,
 	env *object.Environment,
 ) object.Object {
+	// resolverがローカル変数として解決していれば、スコープを名前で辿らずに取り出す。
+	// まだ束縛されていない場合は、これまで通り名前で外側のスコープを探す。
+	if b := node.Binding; b != nil {
+		if val, ok := env.GetAt(b.Depth, b.Slot); ok {
+			return val
+		}
+	}
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := lookupBuiltin(env, node.Value); ok {
 		return builtin
 	}
 
@@ -396,6 +1157,16 @@ func evalExpressions(
 
 	// 引数は左から順に評価される。
 	for _, e := range exps {
+		// ...arr の場合は、arrの要素を一つずつ展開して詰める。
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			elements := evalSpreadExpression(spread, env)
+			if len(elements) == 1 && isError(elements[0]) {
+				return elements
+			}
+			result = append(result, elements...)
+			continue
+		}
+
 		evaluated := Eval(e, env)
 		// 各要素のいずれかでerrorが発生しようものなら、後続の要素の評価はせず、発生したエラーのみを返す。
 		if isError(evaluated) {
@@ -407,19 +1178,104 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// ...<expression> の展開。配列に限らず、Iterableなオブジェクトであれば展開できる。
+// evalExpressionsと同じく、エラーが発生した場合はそのエラーのみを返す。
+func evalSpreadExpression(
+	spread *ast.SpreadExpression,
+	env *object.Environment,
+) []object.Object {
+	value := Eval(spread.Value, env)
+	if isError(value) {
+		return []object.Object{value}
+	}
+
+	elements, err := spreadElements(value)
+	if err != nil {
+		return []object.Object{err}
+	}
+	return elements
+}
+
+// ...value で展開する要素。
+func spreadElements(value object.Object) ([]object.Object, *object.Error) {
+	iterable, ok := value.(object.Iterable)
+	if !ok {
+		return nil, newError("cannot spread %s", value.Type())
+	}
+	return object.Collect(iterable.Iterator()), nil
+}
+
+// envは呼び出した場所の環境。ユーザー定義の関数は自身が定義された環境で評価するので使わず、
+// 環境が必要な組み込み関数(FnEnv)にだけ渡す。
+// envのContextにWithCallHooksでフックが設定されていれば、呼び出しの前後にフックを呼ぶ。
+// WithTracerで設定したTracerがCallTracerを実装していれば、呼び出しの前後にCallとReturnを呼ぶ。
+func applyFunction(env *object.Environment, fn object.Object, args []object.Object) object.Object {
+	if hooks := callHooks(env); hooks != nil {
+		return applyWithHooks(env, hooks, fn, args)
+	}
+	return traceFunction(env, fn, args)
+}
+
+func traceFunction(env *object.Environment, fn object.Object, args []object.Object) object.Object {
+	if tracer, ok := settingsOf(env).tracer.(CallTracer); ok {
+		tracer.Call(fn, args)
+		result := callFunction(env, fn, args)
+		tracer.Return(fn, result)
+		return result
+	}
+	return callFunction(env, fn, args)
+}
+
+func callFunction(env *object.Environment, fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	// ユーザー定義の関数なら
 	case *object.Function:
 		// 関数が実行される時は、現在の環境で評価するのではなく、Functionオブジェクトが持っているEnvで評価する。
 		// Functionオブジェクトが持っているEnvは、その関数が定義された時の環境への参照。
 		// まとめると関数は「自身が定義された環境で評価する」
+		// 引数が足りない場合はエラー。可変長引数のない関数に余分に渡された引数は無視する。
+		if len(args) < len(fn.Parameters) {
+			return newError("wrong number of arguments. got=%d, want=%d",
+				len(args), len(fn.Parameters))
+		}
+		// EvalContextのContextがキャンセルされているか、WithStepLimitの上限を超えていれば、呼び出す前に評価を中断する
+		if err := step(env); err != nil {
+			return err
+		}
+		// 呼び出しが深すぎる場合は、goのスタックを使い果たしてプロセスごと落ちる前にエラーにする
+		depth := env.CallDepth() + 1
+		if depth > MaxCallDepth {
+			return newError("stack overflow: maximum call depth of %d exceeded", MaxCallDepth)
+		}
 		extendedEnv := extendFunctionEnv(fn, args) // 関数定義時の環境と引数の束縛をマージしたenvを作る
-		evaluated := Eval(fn.Body, extendedEnv)    // 現在の環境ではなく、関数が持っている環境で評価する
+		extendedEnv.SetCallDepth(depth)
+		// 関数を定義した場所ではなく、呼び出し元の評価のContextで実行する
+		extendedEnv.SetContext(env.Context())
+		evaluated := Eval(fn.Body, extendedEnv) // 現在の環境ではなく、関数が持っている環境で評価する
+		// 関数の本体で起きたエラーなら、この関数がエラーの場所を囲んでいる関数
+		if err, ok := evaluated.(*object.Error); ok && err.Function == "" {
+			err.Function = functionName(fn)
+		}
 		return unwrapReturnValue(evaluated)
 	// 組み組み関数なら
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return callBuiltin(env, fn, args)
+	case *object.BoundMethod:
+		return applyBoundMethod(env, fn, args)
+	// クラスを呼び出すとインスタンスを作る。引数はフィールドに宣言した順番で入る。
+	// vmのクロージャなど、評価器の外で実行される関数
+	case object.Callable:
+		return fn.Call(env, args)
+	case *object.Class:
+		if len(args) != len(fn.Fields) {
+			return newError("wrong number of arguments. got=%d, want=%d",
+				len(args), len(fn.Fields))
+		}
+		fields := make(map[string]object.Object, len(fn.Fields))
+		for i, name := range fn.Fields {
+			fields[name] = args[i]
+		}
+		return allocated(env, &object.Instance{Class: fn, Fields: fields})
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -438,7 +1294,8 @@ func extendFunctionEnv(
 	// ・envの層が内側に一枚増える。（現在のenvを外側として、内側に層が増える）
 	// ・呼び出された関数内では自身が定義された環境のスコープにアクセス可能
 	// これでクロージャが実現できる（理解があってるかは不安）
-	env := object.NewEnclosedEnvironment(fn.Env)
+	// resolverが決めたローカル変数はslotに束縛される。
+	env := object.NewEnclosedSlotEnvironment(fn.Env, fn.Slots)
 
 	// 引数の値をenvに入れる。
 	// これで、
@@ -450,6 +1307,13 @@ func extendFunctionEnv(
 		env.Set(param.Value, args[paramIdx])
 	}
 
+	// 可変長引数には、通常の引数に束縛されなかった残りの引数を配列にまとめて束縛する。
+	if fn.Rest != nil {
+		rest := make([]object.Object, len(args)-len(fn.Parameters))
+		copy(rest, args[len(fn.Parameters):])
+		env.Set(fn.Rest.Value, literal(env, &object.Array{Elements: rest}))
+	}
+
 	return env
 }
 
@@ -459,6 +1323,23 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.VECTOR_OBJ && index.Type() == object.INTEGER_OBJ:
+		v, idx := left.(*object.Vector), index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(v.Len()) {
+			return NULL
+		}
+		return v.Get(int(idx))
+	case left.Type() == object.MAP_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		if pair, ok := left.(*object.Map).Get(key.HashKey()); ok {
+			return pair.Value
+		}
+		return NULL
+	case left.Type() == object.GO_VALUE_OBJ && index.Type() == object.STRING_OBJ:
+		return evalGoValueMember(left.(*object.GoValue), index.(*object.String).Value)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -481,19 +1362,20 @@ func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
 	// Pairsのmapにはキー、バリュー共にexpressionノードが入っている。
-	for keyNode, valueNode := range node.Pairs {
+	// goのmapは順番が決まらないので、ソースコード上の順番で評価してハッシュに追加する。
+	for _, keyNode := range ast.SortedHashKeys(node) {
+		valueNode := node.Pairs[keyNode]
 		key := Eval(keyNode, env) // expressionをEvalし、String、Boolean、Integerオブジェクトのいずれかが生成される
 		if isError(key) {
 			return key
 		}
 
 		// ハッシュのキーになれるオブジェクトはHashableインタフェースを満たす
-		// String、Boolean、IntegerオブジェクトはいずれもHashableインタフェースを満たしている。
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
+		// String、Boolean、Integer、FloatオブジェクトはいずれもHashableインタフェースを満たしている。
+		if _, ok := key.(object.Hashable); !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
@@ -503,11 +1385,10 @@ func evalHashLiteral(
 		}
 
 		// object.Hash.PairsのmapのキーはHashKey構造体を入れる。
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(key, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 // hashからindexで指定した添字の値を取り出す
@@ -530,6 +1411,102 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
+// <expression>.<identifier> と <expression>?.<identifier>
+// ハッシュのプロパティアクセスは、プロパティ名を文字列のキーとした添字アクセスと同じ。
+// 組み込みの型の値なら、同じ名前の組み込み関数をメソッドとして呼び出せる。
+// モジュールの場合は、モジュールが公開している束縛を参照する。
+func evalPropertyExpression(
+	node *ast.PropertyExpression,
+	env *object.Environment,
+) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Optional && left == NULL {
+		return NULL
+	}
+
+	return evalProperty(env, left, node.Property.Value)
+}
+
+// left.name = val の代入をして、valを返す。代入できるのはGoValueの構造体のフィールドだけ。
+func setProperty(left object.Object, name string, val object.Object) object.Object {
+	if gv, ok := left.(*object.GoValue); ok {
+		return setGoValueField(gv, name, val)
+	}
+	return newError("cannot assign to property %s of %s", name, left.Type())
+}
+
+// leftのnameという名前のメンバー。組み込みの型のメソッドはenvの組み込み関数から探す。
+func evalProperty(env *object.Environment, left object.Object, name string) object.Object {
+	switch left := left.(type) {
+	case *object.Instance:
+		return evalInstanceMember(left, name)
+	case *object.Module:
+		if val, ok := left.Env.Get(name); ok && moduleMemberAllowed(env, left, name, val) {
+			return val
+		}
+		return newError("module %s has no member %s", left.Name, name)
+	case *object.GoValue:
+		return evalGoValueMember(left, name)
+	case *object.ErrorValue:
+		if name == "message" {
+			return &object.String{Value: left.Err.Message}
+		}
+		return newError("%s has no member %s", left.Type(), name)
+	}
+	// ハッシュはキーの値が優先で、同じ名前のキーがなければ h.keys() のようにメソッドになる
+	switch hash := left.(type) {
+	case *object.Hash:
+		if pair, ok := hash.Pairs[object.NewString(name).HashKey()]; ok {
+			return pair.Value
+		}
+		if _, ok := lookupMethod(env, object.HASH_OBJ, name); !ok {
+			return NULL
+		}
+	case *object.Map:
+		if pair, ok := hash.Get(object.NewString(name).HashKey()); ok {
+			return pair.Value
+		}
+		if _, ok := lookupMethod(env, object.MAP_OBJ, name); !ok {
+			return NULL
+		}
+	}
+	if hasMethods(left.Type()) {
+		return evalTypeMethod(env, left, name)
+	}
+	return newError("property access not supported: %s", left.Type())
+}
+
+// クラスのメソッドは、関数リテラルと同じくクラスを宣言した場所のスコープを持つ。
+func evalClassStatement(node *ast.ClassStatement, env *object.Environment) *object.Class {
+	class := &object.Class{Name: node.Name.Value, Methods: make(map[string]object.Object)}
+	for _, field := range node.Fields {
+		class.Fields = append(class.Fields, field.Value)
+	}
+	for _, m := range node.Methods {
+		class.Methods[m.Name] = &object.Function{Parameters: m.Parameters, Rest: m.Rest, Body: m.Body, Env: env, Slots: m.Slots,
+			Name: class.Name + "." + m.Name}
+	}
+	return class
+}
+
+// インスタンスのフィールドかメソッドを参照する。同じ名前ならフィールドが優先される。
+// メソッドはインスタンスと結びつけたBoundMethodとして返す。
+func evalInstanceMember(instance *object.Instance, name string) object.Object {
+	if val, ok := instance.Fields[name]; ok {
+		return val
+	}
+
+	method, ok := instance.Class.Methods[name]
+	if !ok {
+		return newError("%s has no field or method %s", instance.Class.Name, name)
+	}
+	return &object.BoundMethod{Receiver: instance, Method: method, Name: name}
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -538,6 +1515,14 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+// ifの条件として真とみなすか。envのContextがWithStrictConditionsなら、true と false 以外はエラーになる。
+func evalCondition(env *object.Environment, obj object.Object) (bool, *object.Error) {
+	if settingsOf(env).strict && obj != TRUE && obj != FALSE {
+		return false, newError("condition must be BOOLEAN, got %s", obj.Type())
+	}
+	return isTruthy(obj), nil
+}
+
 func isTruthy(obj object.Object) bool {
 	// NULLでもTRUEでもFALSEでもなければtruthyな値、という設計。ex: 10はtruthy
 	switch obj {
@@ -552,13 +1537,34 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// let f = fn() {} のように関数リテラルを束縛した場合は、束縛した名前を関数の名前にする。
+// let g = f のように既にある関数を束縛した場合は名前を変えない。
+func nameFunction(node ast.Expression, val object.Object, name string) {
+	if _, ok := node.(*ast.FunctionLiteral); !ok {
+		return
+	}
+	if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+		fn.Name = name
+	}
+}
+
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// 評価を中断して呼び出し元に伝播させるべきオブジェクトかどうか。
+// 組み込みのエラー(Error)と、throwされた例外(Exception)が該当する。
 func isError(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		// exit()もエラーと同じく、評価を中断してそのまま返していけばプログラムの外まで伝わる。
+		return obj.Type() == object.ERROR_OBJ || obj.Type() == object.EXCEPTION_OBJ || obj.Type() == object.EXIT_OBJ
 	}
 	return false
 }
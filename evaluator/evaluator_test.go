@@ -1,11 +1,24 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"go/types"
+	"io/ioutil"
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -58,6 +71,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"false != true", true},
 		{"(1 < 2) == true", true},
 		{"(1 < 2) == false", false},
+		{"false < true", true},
+		{"true > true", false},
+		{"1 < 1.5", true},
+		{"9223372036854775807 + 1 > 1.5", true},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
 	}
@@ -320,8 +337,8 @@ func TestBuiltinFunctionOfLen(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{`len(1)`, "argument to `len` not supported, got INTEGER"},
-		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`len(1)`, "len: expected ARRAY or STRING or SET or HASH or RANGE, got INTEGER at argument 1"},
+		{`len("one", "two")`, "len: wrong number of arguments. got=2, want=1"},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 	}
@@ -359,7 +376,7 @@ func TestBuiltinFunctionOfFirst(t *testing.T) {
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, nil},
 		{`first(["test"])`, "test"},
-		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`first(1)`, "first: expected ARRAY, got INTEGER at argument 1"},
 	}
 
 	for _, tt := range tests {
@@ -393,7 +410,7 @@ func TestBuiltinFunctionOfLast(t *testing.T) {
 		{`last([1, 2, 3])`, 3},
 		{`last([1, "sample"])`, "sample"},
 		{`last([])`, nil},
-		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+		{`last(1)`, "last: expected ARRAY, got INTEGER at argument 1"},
 	}
 
 	for _, tt := range tests {
@@ -426,7 +443,7 @@ func TestBuiltinFunctionOfRest(t *testing.T) {
 	}{
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, nil},
-		{`rest()`, "wrong number of arguments. got=0, want=1"},
+		{`rest()`, "rest: wrong number of arguments. got=0, want=1"},
 	}
 
 	for _, tt := range tests {
@@ -480,7 +497,7 @@ func TestBuiltinFunctionOfPush(t *testing.T) {
 	}{
 		{`push([], 1)`, []int{1}},
 		{`push([1], 2)`, []int{1, 2}},
-		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, "push: expected ARRAY, got INTEGER at argument 1"},
 	}
 
 	for _, tt := range tests {
@@ -538,6 +555,65 @@ func TestBuiltinFunctionOfPuts(t *testing.T) {
 	}
 }
 
+func TestBuiltinRegistry(t *testing.T) {
+	RegisterBuiltin("answer", func(args ...object.Object) object.Object {
+		return object.NewInteger(42)
+	})
+	defer Builtins.Unregister("answer")
+	testIntegerObject(t, testEval("answer()"), 42)
+
+	// インタプリタごとの登録簿は、標準の組み込み関数を上書きしたり隠したりできる
+	registry := NewBuiltinRegistry(Builtins)
+	registry.Register("len", func(args ...object.Object) object.Object {
+		return object.NewInteger(-1)
+	})
+	registry.Unregister("puts")
+
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+	eval := func(input string) object.Object {
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+
+	testIntegerObject(t, eval("len([1, 2])"), -1)
+	testIntegerObject(t, eval("let f = fn() { len([]) }; f();"), -1)
+	testIntegerObject(t, eval("answer()"), 42)
+	testErrorObject(t, eval(`puts("hi")`), "identifier not found: puts")
+	// 標準の登録簿は変わらない
+	testIntegerObject(t, testEval("len([1, 2])"), 2)
+
+	names := registry.Names()
+	has := func(name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("len") || !has("answer") || has("puts") {
+		t.Errorf("Names wrong. got=%v", names)
+	}
+
+	// Restrictした登録簿からは、許可した名前しか探せない。子の登録簿に登録したものは制限されない
+	restricted := NewBuiltinRegistry(Builtins)
+	restricted.Restrict(func(name string) bool { return name == "len" || name == "string" || name == "string.upper" })
+	child := NewBuiltinRegistry(restricted)
+	child.Register("answer", func(args ...object.Object) object.Object {
+		return object.NewInteger(42)
+	})
+	env = object.NewEnvironment()
+	env.SetBuiltins(child)
+
+	testIntegerObject(t, eval(`len(string.upper("ab")) + answer()`), 44)
+	testErrorObject(t, eval(`puts("hi")`), "identifier not found: puts")
+	testErrorObject(t, eval(`string.lower("A")`), "module string has no member lower")
+	testErrorObject(t, eval(`"A".lower()`), "STRING has no method lower")
+	if names := child.Names(); len(names) != 3 {
+		t.Errorf("Names wrong. got=%v", names)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -619,7 +695,7 @@ func TestHashLiterals(t *testing.T) {
 	input := `let two = "two";
 	{
 		"one": 10 - 9,
-		two: 1 + 1,
+		[two]: 1 + 1,
 		"thr" + "ee": 6 / 2,
 		4: 4,
 		true: 5,
@@ -702,63 +778,3264 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
-func testEval(input string) object.Object {
-	l := lexer.New(input)
-	p := parser.New(l)
-	program := p.ParseProgram()
-	env := object.NewEnvironment()
+func TestForInStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// ループの本体は現在のenvで評価されるので、letで値を積み上げていける。
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; } sum;", 6},
+		{"let sum = 0; for (x in []) { let sum = sum + x; } sum;", 0},
+		{`let sum = 0; for (pair in {"a": 5}) { let sum = pair[1]; } sum;`, 5},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x; } } }; f();", 2},
+		{"for (x in [1, 2, 3]) { x }", nil},
+		{`let s = ""; for (c in "abc") { let s = c + s; } s;`, "cba"},
+		{"for (x in 5) { x }", "not iterable: INTEGER"},
+		{"for (x in [1]) { x + true }", "type mismatch: INTEGER + BOOLEAN"},
+	}
 
-	return Eval(program, env)
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if _, ok := evaluated.(*object.String); ok {
+				testStringObject(t, evaluated, expected)
+				continue
+			}
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
 }
 
-func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
-	result, ok := obj.(*object.Integer)
-	if !ok {
-		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
-		return false
+func TestForInRange(t *testing.T) {
+	input := "let sum = 0; for (i in r) { let sum = sum + i; } sum;"
+
+	tests := []struct {
+		r        *object.Range
+		expected int64
+	}{
+		{&object.Range{Start: 0, Stop: 5, Step: 1}, 10},
+		{&object.Range{Start: 0, Stop: 10, Step: 3}, 18},
+		{&object.Range{Start: 5, Stop: 0, Step: -2}, 9},
+		{&object.Range{Start: 5, Stop: 0, Step: 1}, 0},
 	}
-	if result.Value != expected {
-		t.Errorf("object has wrong value. got=%d, want=%d",
-			result.Value, expected)
-		return false
+
+	for _, tt := range tests {
+		l := lexer.New(input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment()
+		env.Set("r", tt.r)
+
+		testIntegerObject(t, Eval(program, env), tt.expected)
 	}
+}
 
-	return true
+func TestRangeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"range(5)", "range(0, 5)"},
+		{"[...range(5)]", "[0, 1, 2, 3, 4]"},
+		{"[...range(2, 5)]", "[2, 3, 4]"},
+		{"[...range(0, 10, 3)]", "[0, 3, 6, 9]"},
+		{"[...range(5, 0, -2)]", "[5, 3, 1]"},
+		{"[...range(5, 0)]", "[]"},
+		{"len(range(0, 10, 3))", "4"},
+		{"len(range(5, 0, -2))", "3"},
+		{"len(range(5, 0))", "0"},
+		{"let sum = 0; for (i in range(1000000)) { sum = sum + i; } sum;", "499999500000"},
+		{"len(range(1000000000000))", "1000000000000"},
+		{`[...enumerate(["a", "b"])]`, "[[0, a], [1, b]]"},
+		{`[...enumerate("hi")]`, "[[0, h], [1, i]]"},
+		{"let s = 0; for (p in enumerate(range(10, 13))) { s = s + p[0] * p[1]; } s;", "35"},
+		{"enumerate([1])", "enumerate([1])"},
+		{"range(0, 5, 0)", "ERROR: step of `range` must not be zero"},
+		{`range("a")`, "ERROR: range: expected INTEGER, got STRING at argument 1"},
+		{"range()", "ERROR: range: wrong number of arguments. got=0, want=1, 2 or 3"},
+		{"enumerate(1)", "ERROR: enumerate: expected ITERABLE, got INTEGER at argument 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
 }
 
-func testStringObject(t *testing.T, obj object.Object, expected string) bool {
-	result, ok := obj.(*object.String)
+func TestAssertBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"assert(1 == 1)", "null"},
+		{`assert(true, "never")`, "null"},
+		{"assert_eq([1, {\"a\": [2]}], [1, {\"a\": [2]}])", "null"},
+		{"assert_eq(1, 1.0)", "null"},
+		{"assert(false)", "ERROR: assertion failed"},
+		{`assert(1 > 2, "one is not greater")`, "ERROR: assertion failed: one is not greater"},
+		{"assert_eq([1, 2], [1, 3])", "ERROR: assertion failed: [1, 2] != [1, 3]"},
+		{`assert_eq("a", 1)`, "ERROR: assertion failed: a != 1"},
+		{"assert()", "ERROR: assert: wrong number of arguments. got=0, want=1 or 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// assertのエラーには呼び出し元のスタックが積まれる
+	evaluated := testEval("let check = fn(x) { assert(x > 0, \"positive\") }; check(-1);")
+	err, ok := evaluated.(*object.Error)
 	if !ok {
-		t.Errorf("object is not String. got=%T (%+v)", obj, obj)
-		return false
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
 	}
-	if result.Value != expected {
-		t.Errorf("object has wrong value. got=%v, want=%v",
-			result.Value, expected)
-		return false
+	if len(err.Stack) != 2 || err.Stack[0].Function != "assert" || err.Stack[1].Function != "check" {
+		t.Errorf("wrong stack. got=%+v", err.Stack)
 	}
+}
 
-	return true
+func TestTestBuiltins(t *testing.T) {
+	input := `
+test("adds", fn() { assert_eq(1 + 1, 2) });
+test("fails", fn() { assert_eq(1 + 1, 3) });
+test("throws", fn() { throw "boom" });
+run_tests();
+`
+	var out bytes.Buffer
+	suite := NewTestSuite()
+	suite.Out = &out
+	registry := NewBuiltinRegistry(Builtins)
+	RegisterTestBuiltins(registry, suite)
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+
+	evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	if evaluated.Inspect() != "{passed: 1, failed: 2}" {
+		t.Errorf("wrong result. got=%q", evaluated.Inspect())
+	}
+	for _, want := range []string{
+		"PASS adds\n",
+		"FAIL fails\n    ERROR: assertion failed: 2 != 3 (at 3:22 in <anonymous>)\n",
+		"FAIL throws\n    EXCEPTION: boom\n",
+		"1 passed, 2 failed\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q. got=%q", want, out.String())
+		}
+	}
+	if suite.Len() != 0 {
+		t.Errorf("tests were not cleared after run. got=%d", suite.Len())
+	}
+
+	testErrorObject(t, Eval(parser.New(lexer.New(`test("x", 1)`)).ParseProgram(), env),
+		"test: expected FUNCTION, got INTEGER at argument 2")
 }
 
-func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
-	result, ok := obj.(*object.Boolean)
-	if !ok {
-		t.Errorf("object is not Boolean. got=%T (%+v)", obj, obj)
-		return false
+// 複数のgoroutineから同時にテストを登録しても、全て登録される
+func TestTestSuiteConcurrent(t *testing.T) {
+	suite := NewTestSuite()
+	suite.Out = ioutil.Discard
+	registry := NewBuiltinRegistry(Builtins)
+	RegisterTestBuiltins(registry, suite)
+	env := object.NewConcurrentEnvironment()
+	env.SetBuiltins(registry)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Eval(parser.New(lexer.New(`test("t", fn() { assert(true) })`)).ParseProgram(), env)
+		}()
 	}
-	if result.Value != expected {
-		t.Errorf("object has wrong value. got=%t, want=%t",
-			result.Value, expected)
-		return false
+	wg.Wait()
+
+	if passed, failed := suite.Run(env); passed != 20 || failed != 0 {
+		t.Errorf("wrong result. passed=%d, failed=%d", passed, failed)
 	}
-	return true
 }
 
-func testNullObject(t *testing.T, obj object.Object) bool {
-	if obj != NULL {
-		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
-		return false
+func TestExitBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"exit(); 1;", 0},
+		{"exit(3); 1;", 3},
+		{"let f = fn() { exit(2); 1 }; f(); 5;", 2},
+		{"for (i in range(10)) { if (i == 4) { exit(i) } } 0;", 4},
+		{"try { exit(7) } catch (e) { 1 }; 0;", 7},
+		{"map([1, 2], fn(x) { exit(x * 10) }); 0;", 10},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		exit, ok := evaluated.(*object.Exit)
+		if !ok {
+			t.Errorf("%s: object is not Exit. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if exit.Code != tt.expected {
+			t.Errorf("%s: wrong code. expected=%d, got=%d", tt.input, tt.expected, exit.Code)
+		}
+	}
+
+	testErrorObject(t, testEval(`exit("1")`), "exit: expected INTEGER, got STRING at argument 1")
+}
+
+func TestEvalAndParseBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`eval("1 + 2 * 3")`, "7"},
+		{`let x = 10; eval("x * 2")`, "20"},
+		{`eval("let y = 5;"); y`, "5"},
+		{`let f = fn(a) { eval("a + 1") }; f(41)`, "42"},
+		{`let x = 10; eval("x", true)`, "ERROR: identifier not found: x"},
+		{`eval("let z = 1; len([z])", true)`, "1"},
+		{`eval("fn(n) { n * n }")(4)`, "16"},
+		{`eval("let = 1")`, "ERROR: eval: parse error: 1:5: expected next token to be IDENT, got = instead"},
+		{`eval(1)`, "ERROR: eval: expected STRING, got INTEGER at argument 1"},
+		{`parse("1 + x")["statements"][0]["expression"]`,
+			"{type: InfixExpression, pos: 1:1, left: {type: IntegerLiteral, pos: 1:1, value: 1}, operator: +, right: {type: Identifier, pos: 1:5, value: x}}"},
+		{`parse("let x = 1;")["statements"][0]["type"]`, "LetStatement"},
+		{`parse("return 1;")["statements"][0]["return_value"]["value"]`, "1"},
+		{`parse("{2: 1, 1: 2}")["statements"][0]["expression"]["pairs"][0][0]["value"]`, "2"},
+		{`parse("fn(a, b) { a }")["statements"][0]["expression"]["parameters"][1]["value"]`, "b"},
+		{`parse("fn(a) { a }")["statements"][0]["expression"]["rest"]`, "null"},
+		{`parse("1 +")`, "ERROR: parse: parse error: 1:4: no prefix parse function for EOF found"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHelpBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`help("len")`, "len(x)\n    Return the length of a string (in bytes), array, hash, set or range."},
+		{`help(push)`, "push(arr, value)\n    Return a new array with value appended."},
+		{`help("PI")`, "PI = 3.141592653589793"},
+		{`help("nope")`, "ERROR: no builtin named nope"},
+		{`help(1)`, "ERROR: help: expected STRING or BUILTIN, got INTEGER at argument 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// 全ての組み込み関数に名前と使い方が設定されている
+	for _, name := range Builtins.Names() {
+		obj, _ := Builtins.LookupBuiltin(name)
+		b, ok := obj.(*object.Builtin)
+		if !ok {
+			continue
+		}
+		if b.Name != name || b.Signature == "" || b.Doc == "" {
+			t.Errorf("builtin %s is not documented. got=%+v", name, b)
+		}
+	}
+
+	// 登録簿に登録した関数も一覧に出る
+	registry := NewBuiltinRegistry(Builtins)
+	registry.RegisterValue("double", &object.Builtin{
+		Fn:        func(args ...object.Object) object.Object { return NULL },
+		Signature: "double(x)",
+		Doc:       "Return x * 2.",
+	})
+	registry.Unregister("exec")
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+	text := HelpText(env)
+	if !strings.Contains(text, "double(x)\n    Return x * 2.\n") {
+		t.Errorf("help text does not contain double. got=%q", text)
+	}
+	if strings.Contains(text, "exec(") {
+		t.Errorf("help text contains an unregistered builtin. got=%q", text)
+	}
+}
+
+func TestBuiltinSpec(t *testing.T) {
+	one := object.NewInteger(1)
+	str := object.NewString("a")
+	arr := &object.Array{}
+
+	tests := []struct {
+		params   []Param
+		args     []object.Object
+		expected string
+	}{
+		{args(ARRAY), []object.Object{arr}, ""},
+		{args(ARRAY), []object.Object{}, "spec: wrong number of arguments. got=0, want=1"},
+		{args(ARRAY), []object.Object{one}, "spec: expected ARRAY, got INTEGER at argument 1"},
+		{args(ANY, STRING), []object.Object{one, one}, "spec: expected STRING, got INTEGER at argument 2"},
+		{args(NUMBER, optional(NUMBER)), []object.Object{one}, ""},
+		{args(NUMBER, optional(NUMBER)), []object.Object{one, one, one}, "spec: wrong number of arguments. got=3, want=1 or 2"},
+		{args(INTEGER, optional(INTEGER), optional(INTEGER)), []object.Object{}, "spec: wrong number of arguments. got=0, want=1, 2 or 3"},
+		{args(STRING, variadic(ANY)), []object.Object{str, one, arr}, ""},
+		{args(STRING, variadic(ANY)), []object.Object{}, "spec: wrong number of arguments. got=0, want at least 1"},
+		{args(variadic(STRING)), []object.Object{str, str, one}, "spec: expected STRING, got INTEGER at argument 3"},
+		{args(oneOf(STRING, ARRAY)), []object.Object{arr}, ""},
+		{args(oneOf(STRING, ARRAY)), []object.Object{one}, "spec: expected STRING or ARRAY, got INTEGER at argument 1"},
+		{args(ITERABLE, FUNCTION), []object.Object{str, Builtins.builtins["len"]}, ""},
+		{args(ITERABLE, FUNCTION), []object.Object{arr, one}, "spec: expected FUNCTION, got INTEGER at argument 2"},
+	}
+
+	for i, tt := range tests {
+		err := builtin("spec", tt.params).Check(tt.args)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("tests[%d]: unexpected error %q", i, err.Message)
+			}
+			continue
+		}
+		if err == nil || err.Message != tt.expected {
+			t.Errorf("tests[%d]: wrong error. expected=%q, got=%v", i, tt.expected, err)
+		}
+	}
+
+	double := builtin("double", args(INTEGER)).Fn(func(args ...object.Object) object.Object {
+		return object.NewInteger(args[0].(*object.Integer).Value * 2)
+	})
+	testIntegerObject(t, double.Fn(one), 2)
+	testErrorObject(t, double.Fn(str), "double: expected INTEGER, got STRING at argument 1")
+}
+
+func TestBuiltinModules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`string.upper("abc")`, "ABC"},
+		{`string.split("a,b", ",")`, "[a, b]"},
+		{"math.max(1, 5, 3)", "5"},
+		{"math.PI", "3.141592653589793"},
+		{`json.encode({"a": [1, 2]})`, `{"a":[1,2]}`},
+		{`json.decode("[1, 2]")`, "[1, 2]"},
+		{"os.platform()", runtime.GOOS},
+		{`os.exec("echo", "hi")["out"]`, "hi\n"},
+		{`help("json.encode")`, "json_encode(value, indent?)\n    Encode value as a JSON string, optionally indented."},
+		{`help("http.get")`, "http.get(url, headers?)\n    Send a GET request and return {status, body, headers}."},
+		{"string", "module(string)"},
+		{"let string = 1; string", "1"},
+		{"math.nope", "ERROR: module math has no member nope"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// 組み込み先がモジュールを登録したり、メンバーを置き換えたりできる
+	registry := NewBuiltinRegistry(Builtins)
+	registry.RegisterModule("geo", map[string]object.Object{
+		"origin": &object.Array{Elements: []object.Object{object.NewInteger(0), object.NewInteger(0)}},
+		"twice": builtin("geo.twice", args(INTEGER)).Fn(func(args ...object.Object) object.Object {
+			return object.NewInteger(args[0].(*object.Integer).Value * 2)
+		}),
+	})
+	RegisterExecBuiltins(registry, DenyAllExec)
+	registry.SetModuleMember("math", "answer", object.NewInteger(42))
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+	eval := func(input string) object.Object {
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+
+	if got := eval("[geo.origin, geo.twice(21), math.answer, math.abs(-1)]").Inspect(); got != "[[0, 0], 42, 42, 1]" {
+		t.Errorf("wrong result. got=%q", got)
+	}
+	testErrorObject(t, eval(`os.exec("echo")`), "exec: exec denied: echo")
+	testErrorObject(t, testEval("math.answer"), "module math has no member answer")
+	if got := testEval(`os.exec("echo", "ok")["out"]`).Inspect(); got != "ok\n" {
+		t.Errorf("parent os.exec was changed. got=%q", got)
+	}
+}
+
+func TestHTTPModule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(201)
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, r.Header.Get("X-Token"), body)
+	}))
+	defer server.Close()
+
+	env := object.NewEnvironment()
+	env.Set("url", &object.String{Value: server.URL})
+	eval := func(input string) object.Object {
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let r = http.get(url + "/a"); [r["status"], r["body"], r["headers"]["X-Method"]]`, "[201, /a  , GET]"},
+		{`http.get(url + "/b", {"X-Token": "t"})["body"]`, "/b t "},
+		{`let r = http.post(url + "/c", "data"); [r["body"], r["headers"]["X-Method"]]`, "[/c  data, POST]"},
+	}
+	for _, tt := range tests {
+		if got := eval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+	testErrorObject(t, eval("http.get(1)"), "http.get: expected STRING, got INTEGER at argument 1")
+
+	// ポリシーで拒否したリクエストは送らない
+	denied := NewBuiltinRegistry(Builtins)
+	RegisterHTTPBuiltins(denied, func(method, url string) error {
+		if method == "POST" {
+			return fmt.Errorf("%s %s is not allowed", method, url)
+		}
+		return nil
+	})
+	deniedEnv := object.NewEnvironment()
+	deniedEnv.SetBuiltins(denied)
+	deniedEnv.Set("url", &object.String{Value: server.URL})
+	program := parser.New(lexer.New(`[http.get(url + "/d")["status"], http.post(url + "/e", "")]`)).ParseProgram()
+	testErrorObject(t, Eval(program, deniedEnv), "http: POST "+server.URL+"/e is not allowed")
+	if got := eval(`http.post(url + "/f", "")["status"]`).Inspect(); got != "201" {
+		t.Errorf("parent http module was changed. got=%s", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.SetContext(ctx)
+	evaluated := eval("http.get(url)")
+	if err, ok := evaluated.(*object.Error); !ok || !strings.Contains(err.Message, "context canceled") {
+		t.Errorf("request was not cancelled. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestOutputWriter(t *testing.T) {
+	input := `
+puts("a", 1);
+print("b", [2, 3]);
+print();
+printf(" {}!", "c");
+let f = fn() { puts("inner") };
+f();
+eval("puts(42)", true);
+`
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetOutput(&out)
+	evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+
+	expected := "a\n1\nb [2, 3] c!inner\n42\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. expected=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestStreams(t *testing.T) {
+	input := `
+let name = input("name? ");
+eputs("warning", 1);
+let rest = eval("[input(), input()]", true);
+puts("hello " + name, rest, input());
+`
+	var out, errOut bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetOutput(&out)
+	env.SetErrorOutput(&errOut)
+	env.SetInput(strings.NewReader("monkey\r\nsecond\nlast"))
+	evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+
+	expected := "name? hello monkey\n[second, last]\nnull\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. expected=%q, got=%q", expected, out.String())
+	}
+	if errOut.String() != "warning\n1\n" {
+		t.Errorf("wrong error output. got=%q", errOut.String())
+	}
+	testErrorObject(t, testEval("input(1)"), "input: expected STRING, got INTEGER at argument 1")
+}
+
+func TestCopyBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`copy([1, [2, {"a": [3]}]])`, "[1, [2, {a: [3]}]]"},
+		{`clone({"b": 1, "a": [2]})`, "{b: 1, a: [2]}"},
+		{`keys(copy({"b": 1, "a": 2}))`, "[b, a]"},
+		{"copy(set([3, 1, 2]))", "set{3, 1, 2}"},
+		{"class P(x, y) {}; copy(P(1, [2]))", "P(x: 1, y: [2])"},
+		{`[copy(1), copy("s"), clone(null)]`, "[1, s, null]"},
+		{"copy()", "ERROR: copy: wrong number of arguments. got=0, want=1"},
+		// goのスタックを使い切るほど深い値はコピーしない
+		{"let a = []; for (i in range(20000)) { a = [a] }; copy(a)", "ERROR: copy: value is nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	inner := &object.Array{Elements: []object.Object{object.NewInteger(1)}}
+	outer := &object.Array{Elements: []object.Object{inner, inner}}
+	// 自分自身を含む配列もコピーできる
+	outer.Elements = append(outer.Elements, outer)
+
+	copied, err := deepCopy(outer, make(map[object.Object]object.Object), 0)
+	if err != nil {
+		t.Fatalf("deepCopy returned error: %s", err)
+	}
+	deep := copied.(*object.Array)
+	if deep == outer || deep.Elements[0] == inner {
+		t.Errorf("copy shares arrays with the original")
+	}
+	if deep.Elements[0] != deep.Elements[1] {
+		t.Errorf("copy does not preserve shared references")
+	}
+	if deep.Elements[2] != deep {
+		t.Errorf("copy does not preserve the cycle")
+	}
+
+	shallow := shallowCopy(outer, func(el object.Object) object.Object { return el }).(*object.Array)
+	if shallow == outer || shallow.Elements[0] != inner {
+		t.Errorf("clone does not share elements with the original")
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	// 終わらない再帰はgoのスタックを使い果たさずにエラーになる
+	evaluated := testEval("let f = fn(n) { f(n + 1) }; f(0);")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	expected := fmt.Sprintf("stack overflow: maximum call depth of %d exceeded", MaxCallDepth)
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+	if !strings.Contains(errObj.Inspect(), "more") {
+		t.Errorf("long stack is not elided. got=%q", errObj.Inspect()[:200])
+	}
+
+	// 上限は変更できる。組み込み関数のコールバックも深さに数える
+	defer func(depth int) { MaxCallDepth = depth }(MaxCallDepth)
+	MaxCallDepth = 50
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(49);", 0},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(50);", "stack overflow: maximum call depth of 50 exceeded"},
+		{"let f = fn(n) { map([n], fn(x) { f(x + 1) }) }; f(0);", "stack overflow: maximum call depth of 50 exceeded"},
+		{"let f = fn(n) { eval(\"f(n + 1)\") }; f(0);", "stack overflow: maximum call depth of 50 exceeded"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	tests := []string{
+		"for (i in range(1000000000)) { let x = i; }",
+		"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(100);",
+		"for (i in range(1000000000)) { try { let x = i; } catch (e) { 0 } }",
+		"try { for (i in range(1000000000)) { let x = i; } } catch (e) { for (i in range(1000000000)) { let x = i; } }",
+	}
+
+	for _, input := range tests {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		env := object.NewEnvironment()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		evaluated := EvalContext(ctx, program, env)
+		cancel()
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != "evaluation interrupted: context deadline exceeded" {
+			t.Errorf("wrong error message. got=%q", errObj.Message)
+		}
+		// envのContextは変更しない
+		if env.Context() != context.Background() {
+			t.Errorf("context of env was changed. got=%v", env.Context())
+		}
+	}
+
+	// キャンセル済みのContextでは評価を始めない
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	evaluated := EvalContext(ctx, parser.New(lexer.New("1 + 1")).ParseProgram(), object.NewEnvironment())
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Message != "evaluation interrupted: context canceled" {
+		t.Errorf("wrong result. got=%+v", evaluated)
+	}
+}
+
+// 同じ環境を別のgoroutineで評価しても、それぞれのContextで評価する。
+// 短い評価が終わっても、長い評価のContextが外されて時間切れを見逃すことはない
+func TestEvalContextConcurrent(t *testing.T) {
+	env := object.NewConcurrentEnvironment()
+	Eval(parser.New(lexer.New("let spin = fn() { for (i in range(1000000000)) { i } };")).ParseProgram(), env)
+
+	done := make(chan object.Object)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		done <- EvalContext(ctx, parser.New(lexer.New("spin()")).ParseProgram(), env)
+	}()
+
+	short := parser.New(lexer.New("1 + 1")).ParseProgram()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case result := <-done:
+			if err, ok := result.(*object.Error); !ok || err.Message != "evaluation interrupted: context deadline exceeded" {
+				t.Errorf("wrong result. got=%v", result)
+			}
+			return
+		case <-timeout:
+			t.Fatal("deadline of the long evaluation was ignored")
+		default:
+			EvalContext(context.Background(), short, env)
+		}
+	}
+}
+
+// 関数は定義したときではなく、呼び出したときの評価のContextで実行する。
+// eval(code, true) の新しい環境で定義した関数も同じ
+func TestFunctionUsesCallerContext(t *testing.T) {
+	tests := []string{
+		"let f = fn() { let n = 0; for (i in [1]) { n = n + i }; n };",
+		`let f = eval("fn() { let n = 0; for (i in [1]) { n = n + i }; n }", true);`,
+	}
+
+	for _, input := range tests {
+		env := object.NewEnvironment()
+		ctx, cancel := context.WithCancel(context.Background())
+		EvalContext(ctx, parser.New(lexer.New(input)).ParseProgram(), env)
+		cancel()
+
+		result := EvalContext(context.Background(), parser.New(lexer.New("f()")).ParseProgram(), env)
+		testIntegerObject(t, result, 1)
+	}
+}
+
+func TestCallHooks(t *testing.T) {
+	var calls []string
+	record := CallHook{
+		After: func(ctx context.Context, call *Call, result object.Object, elapsed time.Duration) {
+			calls = append(calls, fmt.Sprintf("%s(%d)=%s builtin=%t", call.Name, len(call.Args), result.Inspect(), call.Builtin))
+			if elapsed < 0 {
+				t.Errorf("negative duration for %s", call.Name)
+			}
+		},
+	}
+	// lenを差し替え、putsは一度しか呼べないようにし、upperの引数を書き換える
+	putsCalls := 0
+	override := CallHook{
+		Before: func(ctx context.Context, call *Call) object.Object {
+			switch call.Name {
+			case "len":
+				return object.NewInteger(99)
+			case "puts":
+				if putsCalls++; putsCalls > 1 {
+					return newError("puts called too often")
+				}
+			case "upper":
+				call.Args = []object.Object{object.NewString("rewritten")}
+			}
+			return nil
+		},
+	}
+
+	input := `
+let add = fn(a, b) { a + b };
+let r = map([1], fn(x) { add(x, len("ab")) });
+puts(upper("x"));
+try { puts(1) } catch (e) { e.message }
+`
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetOutput(&out)
+	ctx := WithCallHooks(WithCallHooks(context.Background(), record), override)
+	evaluated := EvalContext(ctx, parser.New(lexer.New(input)).ParseProgram(), env)
+	testStringObject(t, evaluated, "puts called too often")
+
+	expected := []string{
+		"len(1)=99 builtin=true",
+		"add(2)=100 builtin=false",
+		"<anonymous>(1)=100 builtin=false",
+		"map(2)=[100] builtin=true",
+		"upper(1)=REWRITTEN builtin=true",
+		"puts(1)=null builtin=true",
+		"puts(1)=ERROR: puts called too often builtin=true",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong calls.\nexpected=%q\ngot=%q", expected, calls)
+	}
+	if out.String() != "REWRITTEN\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	// フックを設定していない評価では呼ばれない
+	calls = nil
+	testIntegerObject(t, testEval(`len("ab")`), 2)
+	if len(calls) != 0 {
+		t.Errorf("hooks were called without the context. got=%q", calls)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		max      int64
+		expected interface{}
+	}{
+		{"let sum = 0; for (i in range(10)) { sum = sum + i; }; sum", 10, 45},
+		{"let sum = 0; for (i in range(11)) { sum = sum + i; }; sum", 10, "script exceeded 10 operations"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(9)", 10, 0},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10)", 10, "script exceeded 10 operations"},
+		{"map(range(100), fn(x) { x })", 50, "script exceeded 50 operations"},
+		{"let f = fn() { f() }; f()", 1000, "script exceeded 1000 operations"},
+		// 上限を超えたエラーはcatchできない
+		{"for (i in range(100)) { try { let x = i; } catch (e) { 0 } }", 50, "script exceeded 50 operations"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		ctx := WithStepLimit(context.Background(), tt.max)
+		evaluated := EvalContext(ctx, program, object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+
+	// 操作の数はContextごとに合計される
+	ctx := WithStepLimit(context.Background(), 100)
+	env := object.NewEnvironment()
+	EvalContext(ctx, parser.New(lexer.New("for (i in range(30)) { i }")).ParseProgram(), env)
+	EvalContext(ctx, parser.New(lexer.New("for (i in range(30)) { i }")).ParseProgram(), env)
+	if used := StepsUsed(ctx); used != 60 {
+		t.Errorf("wrong number of steps. want=60, got=%d", used)
+	}
+	if used := StepsUsed(context.Background()); used != 0 {
+		t.Errorf("steps counted without limit. got=%d", used)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		max      int64
+		expected interface{}
+	}{
+		{`let s = "a"; for (i in range(10)) { s = s + "a" }; len(s)`, 100, 11},
+		{`let s = "a"; for (i in range(20)) { s = s + s }; len(s)`, 100000, "script exceeded memory limit of 100000 bytes"},
+		{"let a = []; for (i in range(1000)) { a = push(a, a) }; len(a)", 100000, "script exceeded memory limit of 100000 bytes"},
+		{"let a = [1, 2, 3]; len(a)", 100, 3},
+		// push! は配列全体ではなく、増えた要素の分だけを数える
+		{"let a = []; for (i in range(1000)) { push!(a, i) }; len(a)", 20000, 1000},
+		{"let a = []; for (i in range(1000)) { push!(a, i) }; len(a)", 10000, "script exceeded memory limit of 10000 bytes"},
+		{"let h = {}; for (i in range(100)) { h = merge(h, {i: i}) }; len(h)", 10000, "script exceeded memory limit of 10000 bytes"},
+		// 上限を超えたエラーはcatchできない
+		{"let a = []; for (i in range(1000)) { try { a = push(a, i) } catch (e) { 0 } }; len(a)", 10000, "script exceeded memory limit of 10000 bytes"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		ctx := WithMemoryLimit(context.Background(), tt.max)
+		evaluated := EvalContext(ctx, program, object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+
+	ctx := WithMemoryLimit(context.Background(), 1000)
+	EvalContext(ctx, parser.New(lexer.New(`"abc" + "de"`)).ParseProgram(), object.NewEnvironment())
+	if used := MemoryUsed(ctx); used != 5 {
+		t.Errorf("wrong memory usage. want=5, got=%d", used)
+	}
+}
+
+func TestImportBuiltin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"lib/geometry.monkey": `let _scale = 2; let area = fn(w, h) { w * h * _scale / 2 }; let unit = 1; puts("loaded");`,
+		"lib/uses.monkey":     `let g = import("geometry"); let double_area = fn(n) { g.area(n, n) * 2 };`,
+		"pkg/inner/a.monkey":  `let b = import("b"); let value = b.value + 1;`,
+		"pkg/inner/b.monkey":  `let common = import("../common.monkey"); let value = common.value * 10;`,
+		"pkg/common.monkey":   `let value = 4;`,
+		"a.monkey":            `let b = import("b.monkey");`,
+		"b.monkey":            `let a = import("a.monkey");`,
+		"broken.monkey":       `let x = ;`,
+		"fails.monkey":        `let x = 1 + true;`,
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	importer := NewImporter(dir, filepath.Join(dir, "lib"))
+	registry := NewBuiltinRegistry(Builtins)
+	RegisterImportBuiltin(registry, importer)
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+	env.SetOutput(&out)
+	eval := func(input string) object.Object {
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let geo = import("geometry"); geo.area(3, 4)`, "12"},
+		{`import("lib/geometry.monkey").unit`, "1"},
+		{`import("geometry") == geo`, "true"},
+		{`import("uses").double_area(3)`, "18"},
+		// importしているファイルのディレクトリから先に探す
+		{`import("pkg/inner/a").value`, "41"},
+		{`import("common")`, "ERROR: import: module not found: common"},
+		{`geo._scale`, "ERROR: module geometry has no member _scale"},
+		{`import("missing")`, "ERROR: import: module not found: missing"},
+		{`import("a")`, "ERROR: import cycle: a.monkey -> b.monkey -> a.monkey"},
+		{`import("broken")`, "ERROR: import broken.monkey: parse error: 1:9: no prefix parse function for ; found"},
+		{`import("fails")`, "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{`import(1)`, "ERROR: import: expected STRING, got INTEGER at argument 1"},
+	}
+	for _, tt := range tests {
+		evaluated := eval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// ファイルは一度だけ評価される
+	if out.String() != "loaded\n" {
+		t.Errorf("module was not evaluated exactly once. output=%q", out.String())
+	}
+
+	importer.Policy = DenyAllFiles
+	testErrorObject(t, eval(`import("a")`), "import: file access denied: "+filepath.Join(dir, "a.monkey"))
+}
+
+// 同じImporterを複数のgoroutineから使っても、importの循環は評価ごとに調べる。
+// 読み込み中のファイルは、その評価が終わるのを待って同じモジュールを使う
+func TestImportConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	slow := "for (i in range(20000)) { i };"
+	files := map[string]string{
+		"slow.monkey": slow + `puts("loaded"); let value = 1;`,
+		"x.monkey":    slow + `let y = import("y");`,
+		"y.monkey":    slow + `let x = import("x");`,
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	importer := NewImporter(dir)
+	registry := NewBuiltinRegistry(Builtins)
+	RegisterImportBuiltin(registry, importer)
+	env := object.NewConcurrentEnvironment()
+	env.SetBuiltins(registry)
+	env.SetOutput(&out)
+
+	// 別々のgoroutineから、読み込み中のファイルを待っているファイルをimportしても止まらずに循環のエラーになる
+	inputs := []string{`import("slow").value`, `import("slow").value`, `import("slow").value`, `import("slow").value`, `import("x")`, `import("y")`}
+	results := make([]object.Object, len(inputs))
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, input string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			results[i] = EvalContext(ctx, parser.New(lexer.New(input)).ParseProgram(), env)
+		}(i, input)
+	}
+	wg.Wait()
+
+	for i, result := range results[:4] {
+		testIntegerObject(t, result, 1)
+		if t.Failed() {
+			t.Fatalf("import %d failed: %v", i, result.Inspect())
+		}
+	}
+	for _, result := range results[4:] {
+		if err, ok := result.(*object.Error); !ok || !strings.HasPrefix(err.Message, "import cycle: ") {
+			t.Errorf("expected an import cycle error. got=%v", result.Inspect())
+		}
+	}
+	if out.String() != "loaded\n" {
+		t.Errorf("module was not evaluated exactly once. output=%q", out.String())
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = a * 2;", 10},
+		{"let a = 1; let b = 2; a = b = 3; a + b;", 6},
+		// 関数の中からの代入は、一番近い外側のスコープの束縛を更新する。
+		{"let count = 0; let inc = fn() { count = count + 1 }; inc(); inc(); count;", 2},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c();", 2},
+		// 引数は関数のスコープに束縛されるので、外側の変数は変わらない。
+		{"let x = 1; let f = fn(x) { x = 100 }; f(5); x;", 1},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum = sum + x; } sum;", 6},
+		{"a = 5;", "identifier not found: a"},
+		{"let f = fn() { y = 1 }; f();", "identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"const a = 5; a;", 5},
+		{"const a = 5; let b = a * 2; b;", 10},
+		{"const a = 5; a = 10;", "cannot assign to constant: a"},
+		{"const a = 5; let f = fn() { a = 10 }; f();", "cannot assign to constant: a"},
+		// 内側のスコープでletされた同名の変数はconstではないので代入できる。
+		{"const a = 5; let f = fn(a) { a = a + 1 }; f(1);", 2},
+		{"const a = 5; let f = fn() { let a = 1; a = 2; a }; f();", 2},
+		// 同じスコープのconstはletやconstで宣言し直しても上書きできない。
+		{"const a = 5; let a = 1;", "cannot assign to constant: a"},
+		{"const a = 5; const a = 6;", "cannot assign to constant: a"},
+		{"const x = 5; for (x in [1, 2]) { x }", "cannot assign to constant: x"},
+		{"const a = 5; let [a, b] = [1, 2];", "cannot assign to constant: a"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestConcurrentEnvironment(t *testing.T) {
+	env := object.NewConcurrentEnvironment()
+	Eval(parser.New(lexer.New("let add = fn(a, b) { a + b }; let total = 0;")).ParseProgram(), env)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 識別子に数字は使えないので、変数名はra, rb, ...にする
+			input := fmt.Sprintf("let r%c = add(%d, 1); total = r%c;", 'a'+i, i, 'a'+i)
+			Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("r%c", 'a'+i)
+		obj, ok := env.Get(name)
+		if !ok {
+			t.Errorf("%s is not bound", name)
+			continue
+		}
+		testIntegerObject(t, obj, int64(i+1))
+	}
+}
+
+func TestResolvedLocals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c();", 3},
+		{"let f = fn(x) { fn(y) { fn(z) { x + y + z } } }; f(1)(2)(3);", 6},
+		// 宣言より前に参照した場合は、外側のスコープの同じ名前の変数になる
+		{"let a = 1; let f = fn() { let b = a; let a = 2; b + a }; f();", 3},
+		{"let f = fn(c) { if (c) { let x = 1; } x }; f(false);", "identifier not found: x"},
+		{"let f = fn() { len([1, 2]) }; f();", 2},
+		{"let f = fn() { const k = 1; k = 2; }; f();", "cannot assign to constant: k"},
+		{"let f = fn() { let s = 0; for (i in [1, 2, 3]) { s = s + i; } s }; f();", 6},
+		{"class P(x) { fn add(n) { let g = fn() { self.x + n }; g() } } P(1).add(2);", 3},
+		{"let f = fn(n) { class Q(x) { fn get() { self.x + n } } Q(1).get() }; f(10);", 11},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn add(x, y) { x + y } add(2, 3);", 5},
+		{"fn five() { 5 }; five();", 5},
+		{"fn fact(n) { if (n == 0) { 1 } else { n * fact(n - 1) } } fact(5);", 120},
+		// 相互再帰。呼び出し時にenvから探すので、後に宣言された関数も呼び出せる。
+		{`
+		fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+		fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+		if (isEven(10)) { 1 } else { 0 }
+		`, 1},
+		{"fn(x) { x * 2 }(4);", 8},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (1) { 1 => 10, 2 => 20, _ => 30 }`, 10},
+		{`match (2) { 1 => 10, 2 => 20, _ => 30 }`, 20},
+		{`match (3) { 1 => 10, 2 => 20, _ => 30 }`, 30},
+		{`match ("x") { "y" => 1, "x" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (1 + 1) { 1 + 1 => 2 }`, 2},
+		// 型が違う値にはマッチしない
+		{`match ("1") { 1 => 1, _ => 2 }`, 2},
+		{`match (5) { 1 => 10 }`, nil},
+		{`let x = match (2) { 1 => 10, 2 => { let y = 5; y * 4 } }; x;`, 20},
+		{`let f = fn(n) { match (n) { 0 => { return 100; }, _ => n } ; 1 }; f(0);`, 100},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestMatchPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match ([1, 2]) { [x, y] => x + y }`, 3},
+		// 要素数が違う配列にはマッチしない
+		{`match ([1, 2, 3]) { [x, y] => 0, [x, ...rest] => len(rest) }`, 2},
+		{`match ([1]) { [x, y, ...rest] => 0, _ => -1 }`, -1},
+		{`match ([1, [2, 3]]) { [1, [_, z]] => z }`, 3},
+		{`match ([0, 5]) { [1, x] => x, [0, x] => x * 10 }`, 50},
+		{`match ("a") { [x] => 1, _ => 2 }`, 2},
+		{`match ({"name": "ann", "age": 3}) { {name: "bob"} => 1, {name: n, age: a} => len(n) + a }`, 6},
+		{`match ({"a": 1}) { {b: x} => x, {a: x} => x + 1 }`, 2},
+		{`let k = "a"; match ({"a": 7}) { {[k]: v} => v }`, 7},
+		{`match ({"p": [1, 2]}) { {p: [a, b]} => a + b }`, 3},
+		{`match ([1, 2]) { {a: x} => 0, _ => 1 }`, 1},
+		// 変数名のパターンはどんな値にもマッチする
+		{`match (5) { n => n * 2 }`, 10},
+		{`match (5) { n if n > 10 => 1, n if n > 3 => 2, _ => 3 }`, 2},
+		{`match ([3, 4]) { [x, y] if x > y => x, [x, y] => y }`, 4},
+		{`match (1) { _ if false => 1 }`, nil},
+		// マッチしなかったアームの変数は束縛されない
+		{`let x = 1; match ([5]) { [x, y] => 0, _ => x }`, 1},
+		{`let f = fn(p) { match (p) { [a, b] => a * b, _ => 0 } }; f([3, 4]) + f(1)`, 12},
+		{`let f = fn(xs) { match (xs) { [] => 0, [h, ...t] => h + f(t) } }; f([1, 2, 3, 4])`, 10},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestMatchOnType(t *testing.T) {
+	describe := `let describe = fn(x) {
+		match type(x) {
+			"INTEGER" => "int",
+			"STRING" => "str " + x,
+			"ARRAY" => "array of " + str(len(x)),
+			"INTEGER" => "unreachable",
+			_ => "other",
+		}
+	};
+	`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{describe + `describe(1)`, "int"},
+		{describe + `describe(9223372036854775807 + 1)`, "int"},
+		{describe + `describe("a")`, "str a"},
+		{describe + `describe([1, 2])`, "array of 2"},
+		{describe + `describe(null)`, "other"},
+		{`match type(1.5) { "INTEGER" => 1 }`, nil},
+		// 文字列以外の値は _ のアームにいく
+		{`match (1) { "1" => "one", _ => "other" }`, "other"},
+		{`match ("b") { "a" => "a", _ => "wildcard", "b" => "b" }`, "wildcard"},
+		{`type(fn(x) { x })`, "FUNCTION"},
+		{`type(len)`, "BUILTIN"},
+		{`type({})`, "HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		str, ok := evaluated.(*object.String)
+		if !ok || str.Value != expected {
+			t.Errorf("%s: object is not %q. got=%T (%+v)", tt.input, expected, evaluated, evaluated)
+		}
+	}
+}
+
+func TestMatchTable(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // 表がない場合は空
+	}{
+		{`match type(x) { "INTEGER" => 1, "STRING" => 2, _ => 3 }`, "map[INTEGER:0 STRING:1] 2"},
+		{`match (x) { "a" => 1, "a" => 2 }`, "map[a:0] -1"},
+		{`match (x) { "a" => 1, _ => 2, "b" => 3 }`, "map[a:0] 1"},
+		{`match (x) { "a" => 1, 2 => 2 }`, ""},
+		{`match (x) { "a" if y => 1, _ => 2 }`, ""},
+		{`match (x) { _ => 1 }`, ""},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+		// 二回目は一回目に作った表を返す
+		table := matchTableOf(exp)
+		if again := matchTableOf(exp); again != table {
+			t.Errorf("%s: table was built twice", tt.input)
+		}
+		actual := ""
+		if table != nil {
+			actual = fmt.Sprintf("%v %d", table.arms, table.fallback)
+		}
+		if actual != tt.expected {
+			t.Errorf("%s: table wrong. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestMatchPatternErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match ([1, 2]) { [...a, b] => a }`, "... must be the last element of an array pattern"},
+		{`match ({"a": 1}) { {[[1]]: v} => v }`, "unusable as hash key: ARRAY"},
+		{`match (1) { n if n + true => 1 }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`match (1) { foo(1) => 1 }`, "identifier not found: foo"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%s: wrong error message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestNullLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"null", nil},
+		{"let a = null; a;", nil},
+		{"null == null", true},
+		{"null != null", false},
+		{"[1, 2][5] == null", true},
+		{"1 == null", false},
+		{"!null", true},
+		{`match (null) { null => true, _ => false }`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestNullSafeOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"null ?? 5", 5},
+		{"1 ?? 5", 1},
+		{"false ?? 5", false},
+		{"null ?? null ?? 3", 3},
+		{"[1, 2][10] ?? 7", 7},
+		// 左側がnullでなければ右側は評価されない
+		{"1 ?? undefinedVariable", 1},
+		{"let a = null; a?.[0]", nil},
+		{"let a = [1, 2]; a?.[1]", 2},
+		{`let h = {"name": 1}; h?.name`, 1},
+		{`let h = {"name": 1}; h?.other`, nil},
+		{`let h = null; h?.name?.first`, nil},
+		{`let h = {"a": {"b": 3}}; h?.a?.b`, 3},
+		{`let h = {"a": null}; h?.a?.b ?? 4`, 4},
+		{"let a = null; a?.[undefinedVariable]", nil},
+		{"let a = null; a[0]", "index operator not supported: NULL"},
+		{"5?.name", "property access not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"m.answer", 42},
+		{"m.double(m.answer)", 84},
+		{"m?.answer", 42},
+		{"m", "module(m)"},
+		{"m.missing", "module m has no member missing"},
+		{`let h = {"a": {"b": 3}}; h.a.b`, 3},
+		{"null.a", "property access not supported: NULL"},
+	}
+
+	for _, tt := range tests {
+		exports := object.NewEnvironment()
+		exports.Set("answer", object.NewInteger(42))
+		exports.Set("double", testEval("fn(x) { x * 2 }"))
+		env := object.NewEnvironment()
+		env.Set("m", &object.Module{Name: "m", Env: exports})
+
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if _, ok := evaluated.(*object.Module); ok {
+				if evaluated.Inspect() != expected {
+					t.Errorf("Inspect wrong. expected=%q, got=%q", expected, evaluated.Inspect())
+				}
+				continue
+			}
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	point := `class Point(x, y) {
+  fn sum() { self.x + self.y }
+  fn add(other) { Point(self.x + other.x, self.y + other.y) }
+  fn scaled(k) { self.add(Point(self.x * (k - 1), self.y * (k - 1))) }
+};
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{point + "Point(1, 2).x", 1},
+		{point + "let p = Point(1, 2); p.sum()", 3},
+		{point + "Point(1, 2).add(Point(10, 20)).sum()", 33},
+		{point + "Point(1, 2).scaled(3).y", 6},
+		// メソッドは取り出して後から呼んでも、元のインスタンスがselfになる
+		{point + "let p = Point(4, 5); let f = p.sum; f()", 9},
+		{point + "Point(1, [2, 3])", "Point(x: 1, y: [2, 3])"},
+		{point + "Point", "class Point"},
+		{point + "Point(1)", "wrong number of arguments. got=1, want=2"},
+		{point + "Point(1, 2).z", "Point has no field or method z"},
+		{point + "Point(1, true).sum()", "type mismatch: INTEGER + BOOLEAN"},
+		// メソッドの中から、クラスを宣言したスコープの変数を参照できる
+		{"let base = 100; class C(n) { fn get() { base + self.n } }; C(1).get()", 101},
+		{"class Empty() {}; Empty()", "Empty()"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if _, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, evaluated, expected)
+				continue
+			}
+			if evaluated.Inspect() != expected {
+				t.Errorf("Inspect wrong. expected=%q, got=%q", expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestBoundMethods(t *testing.T) {
+	counter := "class Counter(n) { fn add(k) { Counter(self.n + k) } fn get() { self.n } };"
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{counter + "let add = Counter(5).add; add(3).get()", 8},
+		// 関数に渡してから呼び出してもレシーバは変わらない
+		{counter + "let apply = fn(f, x) { f(x) }; apply(Counter(1).add, 2).n", 3},
+		{counter + "let ms = [Counter(1).get, Counter(2).get]; ms[0]() + ms[1]()", 3},
+		{counter + "Counter(1).get", "bound method Counter.get"},
+		{`"abc".upper()`, "ABC"},
+		{`let up = "Hello".lower; up()`, "hello"},
+		{`"abc".upper`, "bound method STRING.upper"},
+		{`"abc".upper(1)`, "upper: wrong number of arguments. got=2, want=1"},
+		{`"abc".nope`, "STRING has no method nope"},
+		// 組み込み関数をメソッドとして呼び出すと、レシーバが最初の引数になる
+		{`"hello".len()`, 5},
+		{`"a,b,c".split(",").len()`, 3},
+		{`"hello".starts_with("he")`, true},
+		{"[1, 2, 3].push(4).len()", 4},
+		{"let a = [1, 2]; a.push(3); len(a)", 2},
+		{"[1, 2, 3].map(fn(x) { x * 2 }).reduce(0, fn(acc, x) { acc + x })", 12},
+		{`["b", "a"].sort().join(",")`, "a,b"},
+		{`{"a": 1, "b": 2}.keys().len()`, 2},
+		{`{"a": 1}.has_key("a")`, true},
+		{"[1].len", "bound method ARRAY.len"},
+		{"[1].nope", "ARRAY has no method nope"},
+		{"[1].push()", "push: wrong number of arguments. got=1, want=2"},
+		// ハッシュはメソッドより同じ名前のキーの値が優先され、どちらもなければnull
+		{`let h = {"keys": 1}; h.keys`, 1},
+		{`{"a": 1}.nope`, nil},
+		{"true.len()", "property access not supported: BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			switch evaluated.(type) {
+			case *object.String:
+				testStringObject(t, evaluated, expected)
+			case *object.BoundMethod:
+				if evaluated.Inspect() != expected {
+					t.Errorf("Inspect wrong. expected=%q, got=%q", expected, evaluated.Inspect())
+				}
+			default:
+				testErrorObject(t, evaluated, expected)
+			}
+		}
+	}
+
+	// メソッドは呼び出した環境の組み込み関数から探すので、差し替えや削除がメソッドにも効く
+	registry := NewBuiltinRegistry(Builtins)
+	registry.Register("len", func(args ...object.Object) object.Object { return object.NewInteger(42) })
+	registry.Unregister("sort")
+	registry.Unregister("upper")
+	for input, expected := range map[string]string{
+		`"abc".len()`:      "42",
+		"[3, 1, 2].sort()": "ERROR: ARRAY has no method sort",
+		`"abc".upper()`:    "ERROR: STRING has no method upper",
+		`"ABC".lower()`:    "abc",
+	} {
+		env := object.NewEnvironment()
+		env.SetBuiltins(registry)
+		evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+		if strings.HasPrefix(expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", input, expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestVariadicFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(...rest) { len(rest) }; f(1, 2, 3);", 3},
+		{"let f = fn(...rest) { len(rest) }; f();", 0},
+		{"let f = fn(x, ...rest) { x + len(rest) }; f(10, 1, 1);", 12},
+		{"let f = fn(x, ...rest) { rest }; f(1, 2, 3)[1];", 3},
+		{"let f = fn(x, y) { x + y }; let a = [1, 2]; f(...a);", 3},
+		{"let f = fn(x, y, z) { x * y + z }; f(2, ...[3, 4]);", 10},
+		{"let f = fn(...rest) { len(rest) }; f(...[1, 2], ...[3], 4);", 4},
+		{"let a = [2, 3]; let b = [1, ...a, 4]; b[3];", 4},
+		{"let b = [...[], ...[1]]; len(b);", 1},
+		{"let f = fn(x, y) { x + y }; f(1);", "wrong number of arguments. got=1, want=2"},
+		{"let f = fn(x, ...rest) { x }; f();", "wrong number of arguments. got=0, want=1"},
+		{"let f = fn(x) { x }; f(...5);", "cannot spread INTEGER"},
+		{"...[1, 2]", "spread operator not allowed here: ...[1, 2]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLetDestructureStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a + b;", 3},
+		{"let [a, b] = [1]; b;", nil},
+		{"let [a] = [1, 2, 3]; a;", 1},
+		{"let [a, ...rest] = [1, 2, 3]; len(rest);", 2},
+		{"let [a, ...rest] = [1]; len(rest);", 0},
+		{"let pair = fn() { [3, 4] }; let [x, y] = pair(); x * y;", 12},
+		{`let {name, age} = {"name": 1, "age": 2}; name + age;`, 3},
+		{`let {name, age} = {"name": 1}; age;`, nil},
+		{"let [a, b] = 5;", "cannot destructure INTEGER as ARRAY"},
+		{"let {a} = [1];", "cannot destructure ARRAY as HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 5; 1 } catch (e) { e * 2 }", 10},
+		{`try { throw "boom"; } catch (e) { e }`, "boom"},
+		// 組み込みのエラーもcatchできる。変数にはerror()と同じエラーの値が束縛され、e.messageでメッセージを取り出せる。
+		{"try { 1 + true } catch (e) { e.message }", "type mismatch: INTEGER + BOOLEAN"},
+		{"try { undefinedVariable } catch (e) { e.message }", "identifier not found: undefinedVariable"},
+		{"try { 1 + true } catch (e) { type(e) }", "ERROR_VALUE"},
+		// 関数の中でthrowされた例外は呼び出し元まで伝播する。
+		{"let f = fn() { throw 42; }; try { f(); 1 } catch (e) { e }", 42},
+		{"let f = fn(x) { if (x > 1) { throw x } x }; try { f(1) + f(2) } catch (e) { e + 100 }", 102},
+		{"try { for (x in [1, 2, 3]) { if (x == 2) { throw x } } } catch (e) { e }", 2},
+		// ネストしたtry。内側のcatchで受け止めた例外は外側には伝わらない。
+		{"try { try { throw 1 } catch (e) { e + 1 } } catch (e) { 100 }", 2},
+		{"try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e + 10 }", 12},
+		// catchの中のreturnは関数から抜ける。
+		{"let f = fn() { try { throw 1 } catch (e) { return 7 }; 8 }; f();", 7},
+		{"let f = fn() { try { return 3 } catch (e) { 4 }; 5 }; f();", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// error()で作った値は評価を中断させず、変数に入れたり返したりできる。
+		{`let e = error("bad input"); 1`, 1},
+		{`is_error(error("x"))`, true},
+		{`is_error("x")`, false},
+		{`let check = fn(x) { if (x < 0) { return error("negative") } x }; is_error(check(-1))`, true},
+		{`let check = fn(x) { if (x < 0) { return error("negative") } x }; check(5)`, 5},
+		// throwすれば他の値と同じようにcatchできる。
+		{`try { throw error("boom") } catch (e) { is_error(e) }`, true},
+		{`try { throw error("boom") } catch (e) { e.message }`, "boom"},
+		{`error("bad input").message`, "bad input"},
+		// 演算子や添字アクセスのエラーもcatchでき、error()で作ったものと同じ型になる。
+		{`try { 1 + true } catch (e) { is_error(e) }`, true},
+		{`try { [1, 2][true] } catch (e) { e.message }`, "index operator not supported: ARRAY"},
+		{`try { 5[0] } catch (e) { e.message }`, "index operator not supported: INTEGER"},
+		{`try { error("a") + 1 } catch (e) { e.message }`, "type mismatch: ERROR_VALUE + INTEGER"},
+		{`try { 5[0] } catch (e) { type(e) == type(error("x")) }`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testStringObject(t, evaluated, expected)
+		}
+	}
+
+	evaluated := testEval(`error("bad input")`)
+	if evaluated.Inspect() != "error: bad input" {
+		t.Errorf("Inspect wrong. got=%q", evaluated.Inspect())
+	}
+	testErrorObject(t, testEval("error(1)"), "error: expected STRING, got INTEGER at argument 1")
+	testErrorObject(t, testEval(`error("x").code`), "ERROR_VALUE has no member code")
+}
+
+func TestUncaughtException(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"throw 5;", "uncaught exception: 5"},
+		{`let f = fn() { throw "boom" }; f(); 1;`, "uncaught exception: boom"},
+		{"let f = fn() { throw [1, 2] }; let x = f(); x;", "uncaught exception: [1, 2]"},
+	}
+
+	for _, tt := range tests {
+		testErrorObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestHashIdentifierKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let h = {name: 1}; h["name"]`, 1},
+		// キーの変数名は参照されない
+		{`let name = "other"; let h = {name: 1}; h["name"]`, 1},
+		{`let name = "other"; let h = {name: 1}; h["other"]`, nil},
+		{`let name = "other"; let h = {[name]: 1}; h["other"]`, 1},
+		{`let h = {[1 + 1]: 2}; h[2]`, 2},
+		{`let h = {a: {b: 3}}; h?.a?.b`, 3},
+		{`let {name, age} = {name: 4, age: 5}; name + age`, 9},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestRegisterInfixOperator(t *testing.T) {
+	// Builtinsを変えると他のテストにも影響するので、子の登録簿に登録する
+	registry := NewBuiltinRegistry(Builtins)
+	registry.RegisterInfixOperator("**", func(left, right object.Object) object.Object {
+		l, ok1 := left.(*object.Integer)
+		r, ok2 := right.(*object.Integer)
+		if !ok1 || !ok2 {
+			return nil
+		}
+		result := int64(1)
+		for i := int64(0); i < r.Value; i++ {
+			result *= l.Value
+		}
+		return &object.Integer{Value: result}
+	})
+	// 組み込みの演算子に登録しても、組み込みで扱える組み合わせは変わらない
+	registry.RegisterInfixOperator("+", func(left, right object.Object) object.Object {
+		return object.NewInteger(-1)
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"2 ** 10", 1024},
+		{"1 + 2 ** 3 * 2", 17},
+		{`"a" ** 2`, "type mismatch: STRING ** INTEGER"},
+		{`"a" ** "b"`, "unknown operator: STRING ** STRING"},
+		{"1 + 2", 3},
+		{"true + false", -1},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		l.RegisterOperator("**", "**")
+		p := parser.New(l)
+		p.RegisterInfixOperator("**", parser.PRODUCT+1, nil)
+		program := p.ParseProgram()
+		env := object.NewEnvironment()
+		env.SetBuiltins(registry)
+		evaluated := Eval(program, env)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+
+	// 登録簿を設定していない環境からは、追加した演算子は見えない
+	l := lexer.New("2 ** 10")
+	l.RegisterOperator("**", "**")
+	p := parser.New(l)
+	p.RegisterInfixOperator("**", parser.PRODUCT+1, nil)
+	testErrorObject(t, Eval(p.ParseProgram(), object.NewEnvironment()), "unknown operator: INTEGER ** INTEGER")
+}
+
+func TestFloatExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"1 / 4.0", 0.25},
+		{"10 - 0.5 * 2", 9.0},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"5 / 2", 2},
+		{`{1: "one"}[1.0]`, "one"},
+		{`match (2.0) { 2 => "two", _ => "other" }`, "two"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("object is not %q. got=%T (%+v)", expected, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestFloatDivisionMode(t *testing.T) {
+	ctx := WithIntegerDivision(context.Background(), FloatDivision)
+
+	testFloatObject(t, testEvalContext(ctx, "5 / 2"), 2.5)
+	testFloatObject(t, testEvalContext(ctx, "-7 / 2"), -3.5)
+	testFloatObject(t, testEvalContext(ctx, "let half = fn(n) { n / 2 }; half(99999999999999999999)"), 49999999999999999999.5)
+	// 割り切れる場合は整数のまま
+	testIntegerObject(t, testEvalContext(ctx, "6 / 2"), 3)
+	// 設定していないContextでは切り捨てる
+	testIntegerObject(t, testEval("5 / 2"), 2)
+}
+
+func TestStrictConditions(t *testing.T) {
+	ctx := WithStrictConditions(context.Background())
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 }", 20},
+		{"!true", false},
+		{"if (5) { 10 }", "condition must be BOOLEAN, got INTEGER"},
+		{"if (null) { 10 }", "condition must be BOOLEAN, got NULL"},
+		{`if ("") { 10 }`, "condition must be BOOLEAN, got STRING"},
+		{"!5", "operand of ! must be BOOLEAN, got INTEGER"},
+		{"!null", "operand of ! must be BOOLEAN, got NULL"},
+		// 組み込み関数の bool() と filter は今まで通りtruthyで判定する
+		{"bool(5)", true},
+		{"len(filter([0, null, 1], fn(x) { x }))", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalContext(ctx, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("%s: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+
+	// デフォルトでは5はtruthy
+	testIntegerObject(t, testEval("if (5) { 10 }"), 10)
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func TestBigIntArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"99999999999999999999 + 1", "100000000000000000000"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+		{"let fib = fn(n, a, b) { if (n == 0) { a } else { fib(n - 1, b, a + b) } }; fib(100, 0, 1)", "354224848179261915075"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		big, ok := evaluated.(*object.BigInt)
+		if !ok {
+			t.Errorf("object is not BigInt. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if big.Inspect() != tt.expected {
+			t.Errorf("wrong value. expected=%s, got=%s", tt.expected, big.Inspect())
+		}
+	}
+}
+
+func TestBigIntDemotesToInteger(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"-9223372036854775808", -9223372036854775808},
+		{"(9223372036854775807 + 1) / 2", 4611686018427387904},
+		{"9223372036854775807 + 1 > 9223372036854775807", true},
+		{"9223372036854775807 + 1 == 9223372036854775807 + 1", true},
+		{"9223372036854775807 + 1 == 9223372036854775807", false},
+		{"(9223372036854775807 + 1) * 0.5", 4611686018427387904.0},
+		{`let k = 9223372036854775807 + 1; {[k]: "big"}[9223372036854775807 + 1]`, "big"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("object is not %q. got=%T (%+v)", expected, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestModuloOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 % 3", 1},
+		{"-7 % 2", -1},
+		{"6 % 3", 0},
+		{"1 + 10 % 4 * 2", 5},
+		{"(9223372036854775807 + 3) % 10", 0},
+		{"7.5 % 2", 1.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []string{
+		"1 / 0",
+		"1 % 0",
+		"let x = 0; 10 / x",
+		"(9223372036854775807 + 1) / 0",
+		"(9223372036854775807 + 1) % 0",
+		"1.5 / 0",
+		"1 / 0.0",
+		"1.5 % 0",
+		"let f = fn(x) { 100 / x }; f(0); 1",
+	}
+
+	for _, input := range tests {
+		testErrorObject(t, testEval(input), "division by zero")
+	}
+}
+
+func TestStringComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" != "a"`, false},
+		{`"apple" < "banana"`, true},
+		{`"apple" > "banana"`, false},
+		{`"app" < "apple"`, true},
+		{`"Z" < "a"`, true},
+		{`"" < "a"`, true},
+		{`let s = "mon"; s + "key" == "monkey"`, true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestSets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"set()", "set{}"},
+		{"set([1, 2, 2, 3, 1])", "set{1, 2, 3}"},
+		{`set(["a", 1, true, "a"])`, `set{a, 1, true}`},
+		{"len(set([1, 1, 2]))", 2},
+		{"contains(set([1, 2]), 2)", true},
+		{"contains(set([1, 2]), 3)", false},
+		{"contains([1, 2], 2)", true},
+		{`contains({"a": 1}, "a")`, true},
+		{"union(set([1, 2]), set([2, 3]))", "set{1, 2, 3}"},
+		{"intersection(set([1, 2, 3]), set([3, 2, 4]))", "set{2, 3}"},
+		{"difference(set([1, 2, 3]), set([2]))", "set{1, 3}"},
+		{"let sum = 0; for (x in set([1, 2, 2, 3])) { let sum = sum + x; } sum;", 6},
+		{"set(1)", "set: expected ITERABLE, got INTEGER at argument 1"},
+		{"set([fn(x) { x }])", "unusable as set element: FUNCTION"},
+		{"union(set(), [1])", "union: expected SET, got ARRAY at argument 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if set, ok := evaluated.(*object.Set); ok {
+				if set.Inspect() != expected {
+					t.Errorf("wrong set. expected=%q, got=%q", expected, set.Inspect())
+				}
+				continue
+			}
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestErrorLocation(t *testing.T) {
+	tests := []struct {
+		input    string
+		line     int
+		column   int
+		function string
+		inspect  string
+	}{
+		{`let x = 1;
+"a" - "b";`, 2, 1, "", "ERROR: unknown operator: STRING - STRING (at 2:1)"},
+		{"let add = fn(a, b) {\n  a + b\n};\nadd(1, true);", 2, 3, "add", ""},
+		{"fn sub(a, b) { a - b }; sub(1, true);", 1, 16, "sub", ""},
+		{"let f = fn() { g() }; f();", 1, 16, "f", ""},
+		{"map([1], fn(x) { x + true });", 1, 18, "<anonymous>", ""},
+		// 組み込み関数のエラーは呼び出し式の位置で、呼び出した関数の中のエラーになる
+		{"let f = fn() { len(1) }; f();", 1, 16, "f", ""},
+		{"let f = fn(x) { x }; f();", 1, 22, "", ""},
+		{"class Point(x, y) { fn sum() { self.x + true } }; Point(1, 2).sum();", 1, 32, "Point.sum", ""},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.Line != tt.line || errObj.Pos.Column != tt.column {
+			t.Errorf("%s: wrong position. expected=%d:%d, got=%s", tt.input, tt.line, tt.column, errObj.Pos)
+		}
+		if errObj.Function != tt.function {
+			t.Errorf("%s: wrong function. expected=%q, got=%q", tt.input, tt.function, errObj.Function)
+		}
+		if tt.inspect != "" && errObj.Inspect() != tt.inspect {
+			t.Errorf("%s: Inspect wrong. expected=%q, got=%q", tt.input, tt.inspect, errObj.Inspect())
+		}
+	}
+}
+
+// 呼ばれた順にEnterとExitを記録するTracer
+type recordingTracer struct {
+	events []string
+}
+
+func (r *recordingTracer) Enter(node ast.Node, env *object.Environment) {
+	r.events = append(r.events, "enter "+node.String())
+}
+
+func (r *recordingTracer) Exit(node ast.Node, result object.Object) {
+	if result == nil {
+		r.events = append(r.events, "exit "+node.String())
+		return
+	}
+	r.events = append(r.events, "exit "+node.String()+" => "+result.Inspect())
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	testEvalContext(WithTracer(context.Background(), tracer), "1 + 2")
+
+	expected := []string{
+		"enter (1 + 2)",
+		"enter (1 + 2)",
+		"enter (1 + 2)",
+		"enter 1",
+		"exit 1 => 1",
+		"enter 2",
+		"exit 2 => 2",
+		"exit (1 + 2) => 3",
+		"exit (1 + 2) => 3",
+		"exit (1 + 2) => 3",
+	}
+	if strings.Join(tracer.events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong events.\nexpected=%q\ngot=%q", expected, tracer.events)
+	}
+
+	var out bytes.Buffer
+	testEvalContext(WithTracer(context.Background(), NewPrintTracer(&out)), "let x = 1 + 2;")
+	want := `Program 1:1 let x = (1 + 2);
+  LetStatement 1:1 let x = (1 + 2);
+    InfixExpression 1:9 (1 + 2)
+      IntegerLiteral 1:9 1
+      => 1
+      IntegerLiteral 1:13 2
+      => 2
+    => 3
+`
+	if out.String() != want {
+		t.Errorf("wrong trace.\nexpected=%q\ngot=%q", want, out.String())
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	// 時刻を読むたびに1msずつ進める
+	profiler := NewProfiler()
+	var clock time.Time
+	profiler.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	testEvalContext(WithTracer(context.Background(), profiler), `
+let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } };
+fact(2);
+map([1, 2], fn(x) { x });
+`)
+
+	// fact(2)はfact(1)、fact(0)を呼ぶ。外側から順に5ms、3ms、1msかかり、自身の時間は2ms、2ms、1ms
+	// 再帰呼び出しの時間はTotalに二重に数えない
+	expected := []FunctionProfile{
+		{Name: "fact", Calls: 3, Total: 5 * time.Millisecond, Self: 5 * time.Millisecond},
+		{Name: "map", Calls: 1, Total: 5 * time.Millisecond, Self: 3 * time.Millisecond},
+		{Name: "<anonymous> (4:19)", Calls: 2, Total: 2 * time.Millisecond, Self: 2 * time.Millisecond},
+	}
+	profiles := profiler.Profiles()
+	if len(profiles) != len(expected) {
+		t.Fatalf("wrong number of profiles. want=%d, got=%d (%+v)", len(expected), len(profiles), profiles)
+	}
+	for i, want := range expected {
+		if profiles[i] != want {
+			t.Errorf("profiles[%d] wrong. expected=%+v, got=%+v", i, want, profiles[i])
+		}
+	}
+
+	var out bytes.Buffer
+	profiler.Report(&out)
+	if !strings.HasPrefix(out.String(), "function") || !strings.Contains(out.String(), "fact") {
+		t.Errorf("wrong report. got=%q", out.String())
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	input := `let abs = fn(x) {
+  if (x < 0) {
+    return -x;
+  }
+  x
+};
+abs(1); abs(2);
+`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	coverage := NewCoverage("abs.monkey", program)
+	EvalContext(WithTracer(context.Background(), coverage), program, object.NewEnvironment())
+
+	expected := map[int]int{1: 1, 2: 2, 3: 0, 5: 2, 7: 1}
+	lines := coverage.Lines()
+	if len(lines) != len(expected) {
+		t.Fatalf("wrong lines. expected=%v, got=%v", expected, lines)
+	}
+	for line, count := range expected {
+		if lines[line] != count {
+			t.Errorf("line %d: wrong count. expected=%d, got=%d", line, count, lines[line])
+		}
+	}
+	// 7行目には文が二つあるので、6つの文のうち5つが実行された
+	if percent := coverage.Percent(); percent != 500.0/6 {
+		t.Errorf("wrong percent. expected=%v, got=%v", 500.0/6, percent)
+	}
+
+	var lcov bytes.Buffer
+	coverage.WriteLCOV(&lcov)
+	want := "TN:\nSF:abs.monkey\nDA:1,1\nDA:2,2\nDA:3,0\nDA:5,2\nDA:7,1\nLF:5\nLH:4\nend_of_record\n"
+	if lcov.String() != want {
+		t.Errorf("wrong lcov.\nexpected=%q\ngot=%q", want, lcov.String())
+	}
+
+	var annotated bytes.Buffer
+	coverage.WriteAnnotated(&annotated, input)
+	want = `    1: let abs = fn(x) {
+    2:   if (x < 0) {
+#####:     return -x;
+    -:   }
+    2:   x
+    -: };
+    1: abs(1); abs(2);
+`
+	if annotated.String() != want {
+		t.Errorf("wrong annotated source.\nexpected=%q\ngot=%q", want, annotated.String())
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) { x + true };
+let outer = fn(x) { inner(x) };
+outer(1);`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := []struct {
+		function string
+		line     int
+		column   int
+	}{
+		{"inner", 2, 21},
+		{"outer", 3, 1},
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. expected=%d, got=%d (%+v)", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, tt := range expected {
+		frame := errObj.Stack[i]
+		if frame.Function != tt.function || frame.Pos.Line != tt.line || frame.Pos.Column != tt.column {
+			t.Errorf("stack[%d] wrong. expected=%s (%d:%d), got=%s", i, tt.function, tt.line, tt.column, frame)
+		}
+	}
+
+	want := "ERROR: type mismatch: INTEGER + BOOLEAN (at 1:21 in inner)\n\tat inner (2:21)\n\tat outer (3:1)"
+	if errObj.Inspect() != want {
+		t.Errorf("Inspect wrong. expected=%q, got=%q", want, errObj.Inspect())
+	}
+
+	// 無名関数の呼び出しは<anonymous>になる
+	evaluated = testEval("fn() { 1 + true }()")
+	errObj, ok = evaluated.(*object.Error)
+	if !ok || len(errObj.Stack) != 1 || errObj.Stack[0].Function != "<anonymous>" {
+		t.Errorf("anonymous call frame wrong. got=%+v", evaluated)
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", []int64{2, 4, 6}},
+		{"map([], fn(x) { x })", []int64{}},
+		{`map(set([1, 2, 2, 3]), fn(x) { x * x })`, []int64{1, 4, 9}},
+		{`reduce("abc", "", fn(acc, c) { c + acc }) == "cba"`, true},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int64{3, 4}},
+		{"filter([1, 2], fn(x) { false })", []int64{}},
+		{"reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })", 10},
+		{"reduce([], 5, fn(acc, x) { acc + x })", 5},
+		{"let double = fn(x) { x * 2 }; reduce(map([1, 2], double), 1, fn(acc, x) { acc * x })", 8},
+		{"map([1, 2], len)", "len: expected ARRAY or STRING or SET or HASH or RANGE, got INTEGER at argument 1"},
+		{"map(1, fn(x) { x })", "map: expected ITERABLE, got INTEGER at argument 1"},
+		{"filter([1], fn(x) { x + true })", "type mismatch: INTEGER + BOOLEAN"},
+		{"reduce([1], fn(acc, x) { acc })", "reduce: wrong number of arguments. got=2, want=3"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		case []int64:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("obj not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+				continue
+			}
+			for i, el := range expected {
+				testIntegerObject(t, array.Elements[i], el)
+			}
+		}
+	}
+}
+
+func TestMutableArrayBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1, 2]; push!(a, 3); a", "[1, 2, 3]"},
+		{"let a = [1]; push!(push!(a, 2), 3)", "[1, 2, 3]"},
+		{"let a = [1, 2, 3]; [pop!(a), a]", "[3, [1, 2]]"},
+		{"pop!([])", "null"},
+		{"let a = [1, 3]; insert!(a, 1, 2); insert!(a, 0, 0); insert!(a, 4, 4)", "[0, 1, 2, 3, 4]"},
+		{"let a = [1, 2, 3]; [remove!(a, 1), a]", "[2, [1, 3]]"},
+		{"[1, 2].push!(3).pop!()", "3"},
+		// 配列は参照で共有されるので、同じ配列を参照している変数や関数からも変更が見える
+		{"let a = [1]; let b = a; push!(b, 2); a", "[1, 2]"},
+		{"let a = []; let add = fn(x) { push!(a, x) }; add(1); add(2); a", "[1, 2]"},
+		{"let a = [1]; let b = clone(a); push!(b, 2); a", "[1]"},
+		// for-inはループを始めた時点の要素を順に返す
+		{"let a = [1, 2]; for (x in a) { push!(a, x * 10) }; a", "[1, 2, 10, 20]"},
+		{"let a = [1, 2, 3]; let seen = []; for (x in a) { pop!(a); push!(seen, x) }; [seen, a]", "[[1, 2, 3], []]"},
+		{"insert!([1], 2, 0)", "ERROR: insert!: index 2 out of range for array of length 1"},
+		{"remove!([], 0)", "ERROR: remove!: index 0 out of range for array of length 0"},
+		{"push!(1, 2)", "ERROR: push!: expected ARRAY, got INTEGER at argument 1"},
+		{"let a = 1; a!=2", "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestPersistentCollections(t *testing.T) {
+	persistent := WithPersistentCollections(context.Background())
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// pushやmergeは元の値を変えずに、構造を共有した新しい値を作る
+		{"let a = [1, 2]; let b = push(a, 3); [a, b]", "[[1, 2], [1, 2, 3]]"},
+		{"let acc = []; for (i in range(100)) { acc = push(acc, i) }; [len(acc), acc[99], first(acc), last(acc), acc[100]]", "[100, 99, 0, 99, null]"},
+		{`let h = {"a": 1}; let hb = merge(h, {"b": 2}); [h, hb, hb.b, hb["a"], h.nope]`, "[{a: 1}, {a: 1, b: 2}, 2, 1, null]"},
+		{`let h = {"a": 1, "b": 2}; [delete(h, "a"), h, has_key(h, "b"), contains(h, "c")]`, "[{b: 2}, {a: 1, b: 2}, true, false]"},
+		{`keys({"b": 1, "a": 2})`, "[b, a]"},
+		{"let [x, ...rest] = [1, 2, 3]; push(rest, x)", "[2, 3, 1]"},
+		{`let {a} = {"a": 5}; a`, "5"},
+		{"let f = fn(...r) { push(r, 9) }; f(1, 2)", "[1, 2, 9]"},
+		{"[3, 1, 2].sort().map(fn(x) { x * 2 })", "[2, 4, 6]"},
+		{`join(["a", "b"], "-")`, "a-b"},
+		{`json_encode({"k": [1, {"z": null}]})`, `{"k":[1,{"z":null}]}`},
+		{"let a = [1]; for (x in a) { 0 }; contains(a, 1)", "true"},
+		{"push!([1], 2)", "ERROR: push!: expected ARRAY, got VECTOR at argument 1"},
+		{"[1].push!(2)", "ERROR: VECTOR has no method push!"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalContext(persistent, tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// 組み込み関数が返した配列やハッシュも、中に入っているものまでVectorとMapになる
+	decoded := testEvalContext(persistent, `json_decode(json_encode({"a": [1, {"b": []}]}))`)
+	m, ok := decoded.(*object.Map)
+	if !ok {
+		t.Fatalf("json_decode did not return MAP. got=%T", decoded)
+	}
+	pair, _ := m.Get(object.NewString("a").HashKey())
+	if v, ok := pair.Value.(*object.Vector); !ok || v.Len() != 2 {
+		t.Fatalf("nested array is not VECTOR. got=%T", pair.Value)
+	} else if _, ok := v.Get(1).(*object.Map); !ok {
+		t.Errorf("nested hash is not MAP. got=%T", v.Get(1))
+	}
+	if _, ok := testEvalContext(persistent, "[1, 2]").(*object.Vector); !ok {
+		t.Errorf("array literal is not VECTOR")
+	}
+	// 設定していないContextでは今まで通り配列になる
+	if _, ok := testEval("[1, 2]").(*object.Array); !ok {
+		t.Errorf("array literal is not ARRAY without the setting")
+	}
+
+	// pushは配列全体ではなく、増えた要素の分だけを数える
+	program := parser.New(lexer.New("let a = []; for (i in range(1000)) { a = push(a, i) }; len(a)")).ParseProgram()
+	ctx := WithMemoryLimit(persistent, 20000)
+	testIntegerObject(t, EvalContext(ctx, program, object.NewEnvironment()), 1000)
+}
+
+func TestStringBuilder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let sb = string_builder(); for (i in range(3)) { sb.append(i, ",") }; sb.build()`, "0,1,2,"},
+		{`string_builder().append("a").append([1, "b"], null).build()`, "a[1, b]null"},
+		{`let sb = string_builder(); sb.append("héllo"); [sb.len(), sb.build(), sb.append("!").build()]`, "[6, héllo, héllo!]"},
+		{`string_builder().append("x")`, `string_builder("x")`},
+		{"string_builder(1)", "ERROR: string_builder: wrong number of arguments. got=1, want=0"},
+		{"string_builder().build(1)", "ERROR: build: wrong number of arguments. got=2, want=1"},
+		{"string_builder().nope", "ERROR: STRING_BUILDER has no method nope"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// 書き足した分だけを上限に数える
+	program := parser.New(lexer.New(`let sb = string_builder(); for (i in range(1000)) { sb.append("ab") }; sb.build()`)).ParseProgram()
+	ctx := WithMemoryLimit(context.Background(), 5000)
+	testStringObject(t, EvalContext(ctx, program, object.NewEnvironment()), strings.Repeat("ab", 1000))
+	ctx = WithMemoryLimit(context.Background(), 1000)
+	testErrorObject(t, EvalContext(ctx, program, object.NewEnvironment()), "script exceeded memory limit of 1000 bytes")
+}
+
+func TestMemoBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 再帰呼び出しも覚えた結果を使うので、素朴なfibでもすぐに終わる
+		{"let fib = memo(fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }); fib(90)", "2880067194370816120"},
+		{"let calls = 0; let sq = memo(fn(x) { calls = calls + 1; x * x }); [sq(3), sq(3), sq(4), calls]", "[9, 9, 16, 2]"},
+		{`let calls = 0; let f = memo(fn(a, b) { calls = calls + 1; a + b }); [f("a", "b"), f("ab", ""), f("a", "b"), calls]`, "[ab, ab, ab, 2]"},
+		// エラーになった呼び出しは覚えない
+		{"let calls = 0; let f = memo(fn(x) { calls = calls + 1; x + true }); try { f(1) } catch (e) { 0 }; try { f(1) } catch (e) { 0 }; calls", "2"},
+		// 1 と 1.0 は別の引数として覚える
+		{"let h = memo(fn(a, b) { a + b }); [h(1.0, 2), h(1, 2)]", "[3.0, 3]"},
+		{"let t = memo(fn(a) { type(a) }); [t(1.0), t(1)]", "[FLOAT, INTEGER]"},
+		// Hashableでない引数では覚えずに毎回呼び出す
+		{"let calls = 0; let f = memo(fn(xs) { calls = calls + 1; len(xs) }); [f([1, 2]), f([1, 2]), calls]", "[2, 2, 2]"},
+		{"memo(1)", "ERROR: memo: expected FUNCTION, got INTEGER at argument 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSortBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"sort([3, 1, 2])", "[1, 2, 3]"},
+		{"sort([2.5, 1, 3])", "[1, 2.5, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{"sort([])", "[]"},
+		{"sort(set([3, 1]))", "[1, 3]"},
+		{"sort([1, 3, 2], fn(a, b) { a > b })", "[3, 2, 1]"},
+		{"sort([1, 3, 2], fn(a, b) { b - a })", "[3, 2, 1]"},
+		// 安定ソートなので、比較する値が等しい要素は元の順番のまま
+		{`sort([[2, "a"], [1, "b"], [2, "c"], [1, "d"]], fn(a, b) { a[0] < b[0] })`, "[[1, b], [1, d], [2, a], [2, c]]"},
+		{"let a = [2, 1]; sort(a); a", "[2, 1]"},
+		{`sort([1, "a"])`, "ERROR: cannot compare STRING and INTEGER"},
+		{"sort([1, 2], fn(a, b) { null })", "ERROR: comparator of `sort` must return BOOLEAN or INTEGER, got NULL"},
+		{"sort([1, 2], fn(a, b) { a + true })", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"sort(1)", "ERROR: sort: expected ITERABLE, got INTEGER at argument 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`len({})`, "0"},
+		// keysとvaluesはソースコードに書いた順番になる
+		{`keys({"b": 1, "a": 2, 3: 3})`, "[b, a, 3]"},
+		{`values({"b": 1, "a": 2, 3: 3})`, "[1, 2, 3]"},
+		{`keys({})`, "[]"},
+		{`has_key({"a": 1}, "a")`, "true"},
+		{`has_key({"a": 1}, "b")`, "false"},
+		{`delete({"a": 1, "b": 2}, "a")`, "{b: 2}"},
+		{`delete({"a": 1}, "x")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+		{`keys(merge({"a": 1, "b": 2}, {"c": 3, "a": 4}))`, "[a, b, c]"},
+		{`values(merge({"a": 1, "b": 2}, {"c": 3, "a": 4}))`, "[4, 2, 3]"},
+		{`let h = {"a": 1}; merge(h, {"b": 2}); h`, "{a: 1}"},
+		{`let r = []; for (p in {"z": 1, "y": 2}) { r = push(r, p[0]); } r`, "[z, y]"},
+		{`keys([1])`, "ERROR: keys: expected HASH, got ARRAY at argument 1"},
+		{`has_key({}, [1])`, "ERROR: unusable as hash key: ARRAY"},
+		{`delete([], 1)`, "ERROR: delete: expected HASH, got ARRAY at argument 1"},
+		{`merge({}, 1)`, "ERROR: merge: expected HASH, got INTEGER at argument 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("abc", "")`, "[a, b, c]"},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([], "-")`, ""},
+		{`join(split("a b c", " "), ",")`, "a,b,c"},
+		{`trim("  hi  ")`, "hi"},
+		{`upper("abc")`, "ABC"},
+		{`lower("ABC")`, "abc"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("hello", "ell")`, "true"},
+		{`contains("hello", "xyz")`, "false"},
+		{`starts_with("hello", "he")`, "true"},
+		{`ends_with("hello", "he")`, "false"},
+		{`index_of("hello", "l")`, "2"},
+		{`index_of("héllo", "l")`, "2"},
+		{`index_of("hello", "z")`, "-1"},
+		{`index_of([1, "a", 3], "a")`, "1"},
+		{`index_of([1, 2], 3)`, "-1"},
+		{`chars("héy")`, "[h, é, y]"},
+		{`split(1, ",")`, "ERROR: split: expected STRING, got INTEGER at argument 1"},
+		{`join([1], ",")`, "ERROR: join: expected STRING elements, got INTEGER"},
+		{`contains("a", 1)`, "ERROR: contains: expected STRING, got INTEGER at argument 2"},
+		{`replace("a", "b")`, "ERROR: replace: wrong number of arguments. got=2, want=3"},
+		{`index_of(1, 1)`, "ERROR: index_of: expected STRING or ARRAY, got INTEGER at argument 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFormatBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`format("x = {}, y = {}", 1, "two")`, "x = 1, y = two"},
+		{`format("no placeholders")`, "no placeholders"},
+		{`format("{}{}", [1, 2], {"a": true})`, "[1, 2]{a: true}"},
+		{`format("{{}} is {}", "braces")`, "{} is braces"},
+		{`format("é{}", 1.5)`, "é1.5"},
+		{`printf("{}", 1)`, "null"},
+		{`format("{} {}", 1)`, "ERROR: format: not enough values for the format. got=1"},
+		{`format("{}", 1, 2)`, "ERROR: format: too many values for the format. got=2, want=1"},
+		{`format("{ x")`, "ERROR: format: unmatched { in the format"},
+		{`format(1)`, "ERROR: format: expected STRING, got INTEGER at argument 1"},
+		{`printf()`, "ERROR: printf: wrong number of arguments. got=0, want at least 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestConversionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`int(5)`, "5"},
+		{`int(3.9)`, "3"},
+		{`int(-3.9)`, "-3"},
+		{`int(" 42 ")`, "42"},
+		{`int("-7")`, "-7"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{`int(float("1e20"))`, "100000000000000000000"},
+		{`int(true)`, "1"},
+		{`int(false)`, "0"},
+		{`float(2)`, "2.0"},
+		{`float("2.5")`, "2.5"},
+		{`float(true)`, "1.0"},
+		{`str(12)`, "12"},
+		{`str(1.0)`, "1.0"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`str("x") + str(null)`, "xnull"},
+		{`bool(0)`, "true"},
+		{`bool(null)`, "false"},
+		{`bool(false)`, "false"},
+		{`int(str(41)) + 1`, "42"},
+		{`int("abc")`, `ERROR: cannot convert "abc" to INTEGER`},
+		{`int("1.5")`, `ERROR: cannot convert "1.5" to INTEGER`},
+		{`int(null)`, "ERROR: cannot convert NULL to INTEGER"},
+		{`int([1])`, "ERROR: cannot convert ARRAY to INTEGER"},
+		{`float("x")`, `ERROR: cannot convert "x" to FLOAT`},
+		{`float({})`, "ERROR: cannot convert HASH to FLOAT"},
+		{`str()`, "ERROR: str: wrong number of arguments. got=0, want=1"},
+		{"let a = []; for (i in range(20000)) { a = [a] }; str(a)", "ERROR: str: value is nested too deeply"},
+		{"let a = []; for (i in range(20000)) { a = [a] }; puts(a)", "ERROR: puts: value is nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`abs(-5)`, "5"},
+		{`abs(-2.5)`, "2.5"},
+		{`abs(-9223372036854775807 - 1)`, "9223372036854775808"},
+		{`min(3, 1, 2)`, "1"},
+		{`max(3, 1.5, 2)`, "3"},
+		{`min([4, 2.5])`, "2.5"},
+		{`max(["b", "c", "a"])`, "c"},
+		{`pow(2, 10)`, "1024"},
+		{`pow(2, 64)`, "18446744073709551616"},
+		{`pow(2, -1)`, "0.5"},
+		{`pow(4, 0.5)`, "2.0"},
+		{`sqrt(16)`, "4.0"},
+		{`floor(2.7)`, "2"},
+		{`floor(-2.1)`, "-3"},
+		{`ceil(2.1)`, "3"},
+		{`round(2.5)`, "3"},
+		{`round(-2.5)`, "-3"},
+		{`round(7)`, "7"},
+		{`PI > 3.14 && PI < 3.15`, "true"},
+		{`floor(E * 100)`, "271"},
+		{`let PI = 3; PI`, "3"},
+		{`abs("a")`, "ERROR: abs: expected NUMBER, got STRING at argument 1"},
+		{`min()`, "ERROR: min: expected at least one value"},
+		{`min([])`, "ERROR: min: expected at least one value"},
+		{`max(1, "a")`, "ERROR: cannot compare STRING and INTEGER"},
+		{`sqrt(-1)`, "ERROR: sqrt: expected a non-negative NUMBER, got -1"},
+		{`pow(2)`, "ERROR: pow: wrong number of arguments. got=1, want=2"},
+		{`floor(float("inf"))`, "ERROR: cannot convert +Inf to INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`format_time(0, "2006-01-02 15:04:05")`, "1970-01-01 00:00:00"},
+		{`format_time(1600000000123, "2006-01-02T15:04:05.000")`, "2020-09-13T12:26:40.123"},
+		{`now() > 1600000000000`, "true"},
+		{`let t = clock(); sleep(5); clock() - t > 4.9`, "true"},
+		{`sleep(0)`, "null"},
+		{`sleep("1")`, "ERROR: sleep: expected NUMBER, got STRING at argument 1"},
+		{`format_time(1.5, "2006")`, "ERROR: format_time: expected INTEGER, got FLOAT at argument 1"},
+		{`now(1)`, "ERROR: now: wrong number of arguments. got=1, want=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// Contextがキャンセルされたらsleepは待つのをやめる
+	env := object.NewEnvironment()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	env.SetContext(ctx)
+
+	start := time.Now()
+	program := parser.New(lexer.New("let f = fn() { sleep(10000) }; f();")).ParseProgram()
+	testErrorObject(t, Eval(program, env), "sleep interrupted: context deadline exceeded")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sleep was not interrupted. elapsed=%s", elapsed)
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+
+	env := object.NewEnvironment()
+	env.Set("path", object.NewString(path))
+	env.Set("other", object.NewString(filepath.Join(dir, "missing.txt")))
+	eval := func(input string) object.Object {
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`file_exists(path)`, "false"},
+		{`write_file(path, "one")`, "null"},
+		{`append_file(path, "|two")`, "null"},
+		{`read_file(path)`, "one|two"},
+		{`file_exists(path)`, "true"},
+		{`write_file(path, "a" + "|" + "b")`, "null"},
+		{`read_lines(path)`, "[a|b]"},
+		{`read_file(other)`, "ERROR: read_file: open " + filepath.Join(dir, "missing.txt") + ": no such file or directory"},
+		{`write_file(path, 1)`, "ERROR: write_file: expected STRING, got INTEGER at argument 2"},
+		{`read_file(1)`, "ERROR: read_file: expected STRING, got INTEGER at argument 1"},
+	}
+	for _, tt := range tests {
+		evaluated := eval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// Monkeyの文字列には改行を書けないので、goで書いたファイルを行ごとに読む
+	ioutil.WriteFile(path, []byte("x\r\ny\n\nz\n"), 0644)
+	if got := eval(`read_lines(path)`).Inspect(); got != "[x, y, , z]" {
+		t.Errorf("read_lines wrong. got=%q", got)
+	}
+
+	// ポリシーで許可されていないファイルは読み書きできない
+	denied := NewBuiltinRegistry(Builtins)
+	RegisterFileBuiltins(denied, DenyAllFiles)
+	env.SetBuiltins(denied)
+	testErrorObject(t, eval(`read_file(path)`), "read_file: file access denied: "+path)
+
+	limited := NewBuiltinRegistry(Builtins)
+	RegisterFileBuiltins(limited, AllowFilesUnder(dir))
+	env.SetBuiltins(limited)
+	testStringObject(t, eval(`read_file(path)`), "x\r\ny\n\nz\n")
+	testErrorObject(t, eval(`write_file("../outside.txt", "")`), "write_file: file access denied: ../outside.txt")
+}
+
+func TestOSBuiltins(t *testing.T) {
+	ScriptArgs = []string{"a", "b"}
+	defer func() { ScriptArgs = nil }()
+	defer os.Unsetenv("MONKEY_TEST_ENV")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`env("MONKEY_TEST_ENV")`, "null"},
+		{`set_env("MONKEY_TEST_ENV", "x"); env("MONKEY_TEST_ENV")`, "x"},
+		{`args()`, "[a, b]"},
+		{`platform()`, runtime.GOOS},
+		{`env(1)`, "ERROR: env: expected STRING, got INTEGER at argument 1"},
+		{`set_env("", "x")`, "ERROR: set_env: setenv: invalid argument"},
+		{`args(1)`, "ERROR: args: wrong number of arguments. got=1, want=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestExecBuiltin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let r = exec("echo", "hello"); [r["out"], r["code"]]`, "[hello\n, 0]"},
+		{`exec("sh", "-c", "echo oops >&2; exit 3")`, "{out: , err: oops\n, code: 3}"},
+		{`keys(exec("true"))`, "[out, err, code]"},
+		{`exec("monkey-no-such-command")`, `ERROR: exec: exec: "monkey-no-such-command": executable file not found in $PATH`},
+		{`exec()`, "ERROR: exec: wrong number of arguments. got=0, want at least 1"},
+		{`exec("echo", 1)`, "ERROR: exec: expected STRING, got INTEGER at argument 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if strings.HasPrefix(tt.expected, "ERROR: ") {
+			testErrorObject(t, evaluated, strings.TrimPrefix(tt.expected, "ERROR: "))
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	env := object.NewEnvironment()
+	eval := func(input string) object.Object {
+		return Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+	}
+
+	// Contextのタイムアウトでコマンドを止める
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	env.SetContext(ctx)
+	testErrorObject(t, eval(`exec("sleep", "10")`), "exec interrupted: context deadline exceeded")
+
+	denied := NewBuiltinRegistry(Builtins)
+	RegisterExecBuiltins(denied, DenyAllExec)
+	env.SetContext(context.Background())
+	env.SetBuiltins(denied)
+	testErrorObject(t, eval(`exec("echo")`), "exec: exec denied: echo")
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`re_match("^a+b$", "aaab")`, true},
+		{`re_match("^a+b$", "aaabc")`, false},
+		{`let r = regex("[0-9]+"); re_match(r, "abc123")`, true},
+		{`re_find_all("[0-9]+", "a1b22c333")`, []string{"1", "22", "333"}},
+		{`re_find_all("x", "abc")`, []string{}},
+		{`re_replace("(\w+)@(\w+)", "me@home", "$2 at $1")`, "home at me"},
+		{`regex("[0-9]+")`, `regex("[0-9]+")`},
+		{`re_match("(", "a")`, "invalid regex: error parsing regexp: missing closing ): `(`"},
+		{`re_match(1, "a")`, "re_match: expected STRING or REGEX, got INTEGER at argument 1"},
+		{`re_find_all("a", 1)`, "re_find_all: expected STRING, got INTEGER at argument 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case []string:
+			arr, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(arr.Elements) != len(expected) {
+				t.Errorf("wrong number of elements. expected=%d, got=%d", len(expected), len(arr.Elements))
+				continue
+			}
+			for i, el := range expected {
+				testStringObject(t, arr.Elements[i], el)
+			}
+		case string:
+			switch evaluated.(type) {
+			case *object.String:
+				testStringObject(t, evaluated, expected)
+			case *object.Regex:
+				if evaluated.Inspect() != expected {
+					t.Errorf("Inspect wrong. expected=%q, got=%q", expected, evaluated.Inspect())
+				}
+			default:
+				testErrorObject(t, evaluated, expected)
+			}
+		}
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`json_encode({"a": [1, 2.5, null], "b": true})`, `{"a":[1,2.5,null],"b":true}`},
+		{`json_encode({"b": 1, "a": 2})`, `{"b":1,"a":2}`},
+		{`json_decode("[1, 2, 3]")[2]`, 3},
+		// 文字列リテラルには " を書けないので、json_encodeした結果をデコードする。
+		{`json_decode(json_encode({"x": {"y": 7}}))["x"]["y"]`, 7},
+		{`json_decode("null") == null`, true},
+		{`json_decode("true") == true`, true},
+		{`json_encode(fn(x) { x })`, "cannot encode FUNCTION as JSON"},
+		{`json_decode("[1,")`, "invalid JSON: unexpected EOF"},
+		{`json_decode(1)`, "json_decode: expected STRING, got INTEGER at argument 1"},
+		{`json_encode({"a": [1], "b": {}}, "  ")`, "{\n  \"a\": [\n    1\n  ],\n  \"b\": {}\n}"},
+		{`json_encode([1], 2)`, "json_encode: expected STRING, got INTEGER at argument 2"},
+		{"let a = []; for (i in range(20000)) { a = [a] }; json_encode(a)", "value is nested too deeply"},
+		{"let a = [1]; push!(a, a); json_encode(a)", "cannot encode cyclic value as JSON"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if _, ok := evaluated.(*object.String); ok {
+				testStringObject(t, evaluated, expected)
+				continue
+			}
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+type testCounter struct {
+	Name  string
+	Count int
+	Tags  []string
+	Owner testOwner
+	limit int
+}
+
+type testOwner struct {
+	Name string
+}
+
+func (c *testCounter) Add(n int) int {
+	c.Count += n
+	return c.Count
+}
+
+func (c *testCounter) Scale(f float64) float64 { return float64(c.Count) * f }
+
+func (c *testCounter) Tag(i int) string { return c.Tags[i] }
+
+func (c *testCounter) Check() error {
+	if c.Count > c.limit {
+		return fmt.Errorf("count %d exceeds %d", c.Count, c.limit)
+	}
+	return nil
+}
+
+func TestGoValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`c["Name"]`, "counter"},
+		{`c?.Count`, 1},
+		{`c["Add"](2); c["Add"](3)`, 6},
+		{`c["Scale"](1.5)`, 1.5},
+		{`c["Check"]()`, nil},
+		{`c["Add"](10); c["Check"]()`, "count 11 exceeds 5"},
+		{`c["Tags"]`, "go([]string)"},
+		{`c["limit"]`, "cannot access limit of go(*evaluator.testCounter)"},
+		{`c["Missing"]`, "go(*evaluator.testCounter) has no field or method Missing"},
+		{`c["Add"]("x")`, "Add: cannot use STRING as int"},
+		{`c["Add"](1, 2)`, "Add: wrong number of arguments. got=2, want=1"},
+		{`c["Scale"]`, "builtin function"},
+		{`c["Add"](1); d["Add"]`, "cannot access Add of go(*evaluator.testCounter)"},
+		{`c.Add(2); c.Scale(2)`, 6.0},
+		{`c.Count = 10; c.Count`, 10},
+		{`c.Name = "renamed"`, "renamed"},
+		{`c.Owner.Name = "alice"; c.Owner.Name`, "alice"},
+		{`c.Count = 4.5`, "Count: cannot use FLOAT as int"},
+		{`c.limit = 1`, "cannot assign to limit of go(*evaluator.testCounter)"},
+		{`c.Add = 1`, "go(*evaluator.testCounter) has no field Add"},
+		{`d.Count = 2; d.Name = "x"`, "cannot assign to Name of go(*evaluator.testCounter)"},
+		{`v.Count = 2`, "cannot assign to Count of go(evaluator.testCounter)"},
+		{`let h = {}; h.a = 1`, "cannot assign to property a of HASH"},
+		{`c.Tag(0)`, "a"},
+		{`c.Tag(5)`, "Tag: panic: runtime error: index out of range [5] with length 1"},
+	}
+
+	for _, tt := range tests {
+		counter := &testCounter{Name: "counter", Count: 1, Tags: []string{"a"}, limit: 5}
+		env := object.NewEnvironment()
+		env.Set("c", &object.GoValue{Value: counter})
+		// Allowで参照できる名前を絞れる
+		env.Set("d", &object.GoValue{Value: counter, Allow: func(name string) bool { return name == "Count" }})
+		// ポインタでない構造体は参照できるが、代入はできない
+		env.Set("v", &object.GoValue{Value: *counter})
+
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case string:
+			switch evaluated.(type) {
+			case *object.String:
+				testStringObject(t, evaluated, expected)
+			case *object.Error:
+				testErrorObject(t, evaluated, expected)
+			default:
+				if evaluated.Inspect() != expected {
+					t.Errorf("Inspect wrong. expected=%q, got=%q", expected, evaluated.Inspect())
+				}
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+
+	// メソッドの呼び出しは元の値を変更する
+	counter := &testCounter{Count: 1}
+	env := object.NewEnvironment()
+	env.Set("c", &object.GoValue{Value: counter})
+	Eval(parser.New(lexer.New(`c["Add"](41)`)).ParseProgram(), env)
+	if counter.Count != 42 {
+		t.Errorf("counter.Count wrong. expected=42, got=%d", counter.Count)
+	}
+	// フィールドへの代入も元の値を変更する
+	Eval(parser.New(lexer.New(`c.Owner.Name = "bob"; c.Tags = ["x", "y"]`)).ParseProgram(), env)
+	if counter.Owner.Name != "bob" || len(counter.Tags) != 2 || counter.Tags[1] != "y" {
+		t.Errorf("counter wrong. got=%+v", counter)
+	}
+}
+
+func testEval(input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+
+	return Eval(program, env)
+}
+
+// ctxの設定で評価する。
+func testEvalContext(ctx context.Context, input string) object.Object {
+	program := parser.New(lexer.New(input)).ParseProgram()
+	return EvalContext(ctx, program, object.NewEnvironment())
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func testStringObject(t *testing.T, obj object.Object, expected string) bool {
+	result, ok := obj.(*object.String)
+	if !ok {
+		t.Errorf("object is not String. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%v, want=%v",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
+	result, ok := obj.(*object.Boolean)
+	if !ok {
+		t.Errorf("object is not Boolean. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%t, want=%t",
+			result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func testNullObject(t *testing.T, obj object.Object) bool {
+	if obj != NULL {
+		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
+		return false
+	}
+	return true
+}
+
+func testErrorObject(t *testing.T, obj object.Object, expected string) bool {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Errorf("object is not Error. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q",
+			expected, errObj.Message)
+		return false
+	}
+	return true
+}
+
+// ハッシュへの添字アクセスを繰り返すプログラム。文字列のHashKeyのキャッシュが効く。
+func BenchmarkHashIndex(b *testing.B) {
+	program := parser.New(lexer.New(`
+let h = {"alpha": 1, "beta": 2, "gamma": 3};
+let sum = 0;
+for (i in r) { let sum = sum + h["alpha"] + h["beta"] + h["gamma"]; }
+sum;
+`)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		env := object.NewEnvironment()
+		env.Set("r", &object.Range{Start: 0, Stop: 1000, Step: 1})
+		Eval(program, env)
+	}
+}
+
+// 深くネストしたクロージャから、外側の関数のローカル変数を参照する
+func BenchmarkClosureChain(b *testing.B) {
+	program := parser.New(lexer.New(`
+let make = fn(a) { fn(b) { fn(c) { fn(d) { fn() { let s = 0; for (i in r) { s = s + a + b + c + d; } s } } } } };
+make(1)(2)(3)(4)();
+`)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		env := object.NewEnvironment()
+		env.Set("r", &object.Range{Start: 0, Stop: 1000, Step: 1})
+		Eval(program, env)
+	}
+}
+
+// ループで文字列を + でつなげていく。毎回それまでの文字列全体をコピーする
+func BenchmarkStringConcat(b *testing.B) {
+	program := parser.New(lexer.New(`
+let s = "";
+for (i in r) { s = s + "piece"; }
+len(s);
+`)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		env := object.NewEnvironment()
+		env.Set("r", &object.Range{Start: 0, Stop: 5000, Step: 1})
+		Eval(program, env)
+	}
+}
+
+// BenchmarkStringConcatと同じ文字列をstring_builderで作る
+func BenchmarkStringBuilder(b *testing.B) {
+	program := parser.New(lexer.New(`
+let sb = string_builder();
+for (i in r) { sb.append("piece"); }
+len(sb.build());
+`)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		env := object.NewEnvironment()
+		env.Set("r", &object.Range{Start: 0, Stop: 5000, Step: 1})
+		Eval(program, env)
 	}
-	return true
 }
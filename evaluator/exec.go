@@ -0,0 +1,67 @@
+package evaluator
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/object"
+	"os/exec"
+)
+
+// コマンドを実行してよいかを決める。許可しない場合はエラーを返す。
+type ExecPolicy func(name string, args []string) error
+
+// 全てのコマンドを実行できる。Builtinsのexecはこのポリシーで登録される。
+func AllowAllExec(name string, args []string) error {
+	return nil
+}
+
+// コマンドを一切実行させない。
+func DenyAllExec(name string, args []string) error {
+	return fmt.Errorf("exec denied: %s", name)
+}
+
+// execを、policyで許可されたコマンドだけを実行するようにして登録する。os.execとしても使える。
+// RegisterFileBuiltinsと同じく、組み込み先はBuiltinsを親にした登録簿に登録し直すことで実行を制限できる。
+func RegisterExecBuiltins(r *BuiltinRegistry, policy ExecPolicy) {
+	b := execBuiltin(policy)
+	r.RegisterValue("exec", b)
+	r.SetModuleMember("os", "exec", b)
+}
+
+// exec(cmd, args...) でコマンドを実行し、{"out": 標準出力, "err": 標準エラー出力, "code": 終了コード} を返す。
+// 終了コードが0以外でもエラーにはならない。コマンドが見つからない場合などはエラーになる。
+// 環境のContextがキャンセルされた場合はコマンドを止めてエラーを返すので、タイムアウトはContextで設定する。
+func execBuiltin(policy ExecPolicy) *object.Builtin {
+	return builtin("exec", args(STRING, variadic(STRING))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			strs := stringValues(args)
+			name, cmdArgs := strs[0], strs[1:]
+			if err := policy(name, cmdArgs); err != nil {
+				return newError("exec: %s", err)
+			}
+
+			ctx := env.Context()
+			cmd := exec.CommandContext(ctx, name, cmdArgs...)
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+
+			err := cmd.Run()
+			if ctx.Err() != nil {
+				return newError("exec interrupted: %s", ctx.Err())
+			}
+			code := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				return newError("exec: %s", err)
+			}
+
+			result := object.NewHash()
+			result.Set(object.NewString("out"), &object.String{Value: stdout.String()})
+			result.Set(object.NewString("err"), &object.String{Value: stderr.String()})
+			result.Set(object.NewString("code"), object.NewInteger(int64(code)))
+			return result
+		},
+	)
+}
@@ -0,0 +1,145 @@
+package evaluator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ファイルを読み書きしてよいかを決める。許可しない場合はエラーを返す。
+// writeは書き込みの場合にtrueになる。
+type FilePolicy func(path string, write bool) error
+
+// 全てのファイルを読み書きできる。Builtinsのファイルの組み込み関数はこのポリシーで登録される。
+func AllowAllFiles(path string, write bool) error {
+	return nil
+}
+
+// ファイルを一切読み書きさせない。
+func DenyAllFiles(path string, write bool) error {
+	return fmt.Errorf("file access denied: %s", path)
+}
+
+// dirの中のファイルだけを読み書きできるポリシーを作る。
+func AllowFilesUnder(dir string) FilePolicy {
+	root, rootErr := filepath.Abs(dir)
+	return func(path string, write bool) error {
+		if rootErr != nil {
+			return rootErr
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("file access denied: %s", path)
+		}
+		return nil
+	}
+}
+
+// ファイルを扱う組み込み関数を、policyで許可されたファイルだけを扱うようにして登録する。osモジュールのメンバーにもなる。
+// 組み込み先は、Builtinsを親にした登録簿にDenyAllFilesなどで登録し直すことで、ファイルへのアクセスを制限できる。
+func RegisterFileBuiltins(r *BuiltinRegistry, policy FilePolicy) {
+	for name, builtin := range fileBuiltins(policy) {
+		r.RegisterValue(name, builtin)
+		r.SetModuleMember("os", name, builtin)
+	}
+}
+
+func fileBuiltins(policy FilePolicy) map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		// read_file(path) でファイルの中身を文字列で返す。
+		"read_file": builtin("read_file", args(STRING)).Fn(
+			func(args ...object.Object) object.Object {
+				path, err := checkPath("read_file", args[0], policy, false)
+				if err != nil {
+					return err
+				}
+				data, readErr := ioutil.ReadFile(path)
+				if readErr != nil {
+					return newError("read_file: %s", readErr)
+				}
+				return &object.String{Value: string(data)}
+			},
+		),
+		// read_lines(path) でファイルの中身を行ごとの文字列の配列で返す。行末の改行は含まない。
+		"read_lines": builtin("read_lines", args(STRING)).Fn(
+			func(args ...object.Object) object.Object {
+				path, err := checkPath("read_lines", args[0], policy, false)
+				if err != nil {
+					return err
+				}
+				data, readErr := ioutil.ReadFile(path)
+				if readErr != nil {
+					return newError("read_lines: %s", readErr)
+				}
+
+				content := strings.TrimSuffix(string(data), "\n")
+				lines := []object.Object{}
+				if content != "" {
+					for _, line := range strings.Split(content, "\n") {
+						lines = append(lines, &object.String{Value: strings.TrimSuffix(line, "\r")})
+					}
+				}
+				return &object.Array{Elements: lines}
+			},
+		),
+		// write_file(path, data) でファイルを文字列で上書きする。ファイルがなければ作る。
+		"write_file": builtin("write_file", args(STRING, STRING)).Fn(
+			func(args ...object.Object) object.Object {
+				return writeFile("write_file", args, policy, os.O_TRUNC)
+			},
+		),
+		// append_file(path, data) でファイルの末尾に文字列を書き足す。ファイルがなければ作る。
+		"append_file": builtin("append_file", args(STRING, STRING)).Fn(
+			func(args ...object.Object) object.Object {
+				return writeFile("append_file", args, policy, os.O_APPEND)
+			},
+		),
+		"file_exists": builtin("file_exists", args(STRING)).Fn(
+			func(args ...object.Object) object.Object {
+				path, err := checkPath("file_exists", args[0], policy, false)
+				if err != nil {
+					return err
+				}
+				_, statErr := os.Stat(path)
+				return nativeBoolToBooleanObject(statErr == nil)
+			},
+		),
+	}
+}
+
+// ファイルのパスの引数が、policyで許可されているかを確かめる。
+func checkPath(name string, arg object.Object, policy FilePolicy, write bool) (string, *object.Error) {
+	path := arg.(*object.String)
+	if err := policy(path.Value, write); err != nil {
+		return "", newError("%s: %s", name, err)
+	}
+	return path.Value, nil
+}
+
+func writeFile(name string, args []object.Object, policy FilePolicy, mode int) object.Object {
+	path, err := checkPath(name, args[0], policy, true)
+	if err != nil {
+		return err
+	}
+	data := args[1].(*object.String)
+
+	f, openErr := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|mode, 0644)
+	if openErr != nil {
+		return newError("%s: %s", name, openErr)
+	}
+	_, writeErr := f.WriteString(data.Value)
+	if closeErr := f.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		return newError("%s: %s", name, writeErr)
+	}
+	return NULL
+}
@@ -0,0 +1,316 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"monkey/object"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// v["Name"] や v?.Name でGoValueのフィールドかメソッドを参照する。
+// メソッドは組み込み関数として返すので、 v["Add"](1, 2) のようにそのまま呼び出せる。
+// エクスポートされていない名前と、GoValue.Allowで許可されていない名前は参照できない。
+func evalGoValueMember(gv *object.GoValue, name string) object.Object {
+	first, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsUpper(first) || !gv.Allows(name) {
+		return newError("cannot access %s of %s", name, gv.Inspect())
+	}
+
+	v := reflect.ValueOf(gv.Value)
+	if !v.IsValid() {
+		return newError("%s has no field or method %s", gv.Inspect(), name)
+	}
+
+	// ポインタのメソッドも見つかるように、ポインタを辿る前にメソッドを探す。
+	if m := v.MethodByName(name); m.IsValid() {
+		return goMethodToBuiltin(name, m, gv.Allow)
+	}
+
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if f := v.FieldByName(name); f.IsValid() && f.CanInterface() {
+			// 構造体のフィールドはポインタで包み、 v.Inner.Name = x で元の値を変更できるようにする
+			if f.Kind() == reflect.Struct && f.CanAddr() {
+				f = f.Addr()
+			}
+			return goToObject(f, gv.Allow)
+		}
+	}
+
+	return newError("%s has no field or method %s", gv.Inspect(), name)
+}
+
+// v.Name = val でGoValueのフィールドに代入する。参照と同じく、エクスポートされていて、Allowで許可された名前だけ代入できる。
+// 値は構造体のフィールドの型に変換する。ポインタで渡された構造体でなければ、元の値を変更できないのでエラーにする。
+func setGoValueField(gv *object.GoValue, name string, val object.Object) object.Object {
+	first, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsUpper(first) || !gv.Allows(name) {
+		return newError("cannot assign to %s of %s", name, gv.Inspect())
+	}
+
+	v := reflect.ValueOf(gv.Value)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return newError("%s has no field %s", gv.Inspect(), name)
+	}
+	f := v.FieldByName(name)
+	if !f.IsValid() {
+		return newError("%s has no field %s", gv.Inspect(), name)
+	}
+	if !f.CanSet() {
+		return newError("cannot assign to %s of %s", name, gv.Inspect())
+	}
+
+	converted, err := objectToGo(val, f.Type())
+	if err != nil {
+		return newError("%s: %s", name, err)
+	}
+	f.Set(converted)
+	return val
+}
+
+// goのメソッドを、引数と返り値を変換しながら呼び出す組み込み関数にする。
+// 最後の返り値がerrorの場合、nilでなければMonkeyのエラーにする。
+func goMethodToBuiltin(name string, m reflect.Value, allow func(string) bool) *object.Builtin {
+	mt := m.Type()
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			in, err := goCallArgs(mt, nil, args)
+			if err != nil {
+				return newError("%s: %s", name, err)
+			}
+			out, panicked := goCall(name, m, in)
+			if panicked != nil {
+				return panicked
+			}
+			return goCallResults(out, func(v reflect.Value) object.Object {
+				return goToObject(v, allow)
+			})
+		},
+	}
+}
+
+// goの関数fnを、nameという名前の組み込み関数にする。
+// 引数は関数の引数の型に変換し、配列やハッシュはobject.ToGoでスライスやmap、構造体にする。
+// 返り値はobject.FromGoで中身までMonkeyのオブジェクトにし、関数やチャネルのように変換できない値はGoValueで包む。
+// 最後の返り値がerrorの場合、nilでなければMonkeyのエラーにする。fnがpanicした場合もエラーになる。
+// 最初の引数がcontext.Contextなら、実行中のContextを渡す。タイムアウトやキャンセルをgoの関数にも伝えられる。
+func NewGoFunc(name string, fn interface{}) (*object.Builtin, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("%s: %T is not a function", name, fn)
+	}
+
+	ft := f.Type()
+	withContext := ft.NumIn() > 0 && ft.In(0) == contextType
+	return &object.Builtin{
+		Name: name,
+		FnEnv: func(env *object.Environment, args ...object.Object) object.Object {
+			var first []reflect.Value
+			if withContext {
+				first = []reflect.Value{reflect.ValueOf(env.Context())}
+			}
+			in, err := goCallArgs(ft, first, args)
+			if err != nil {
+				return newError("%s: %s", name, err)
+			}
+			out, panicked := goCall(name, f, in)
+			if panicked != nil {
+				return panicked
+			}
+			return goCallResults(out, goResultToObject)
+		},
+	}, nil
+}
+
+// goの関数fnを呼び出す。fnがpanicしても埋め込む側のプログラムまで止まらないように、nameを付けたエラーにして返す。
+func goCall(name string, fn reflect.Value, in []reflect.Value) (out []reflect.Value, panicked *object.Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = newError("%s: panic: %v", name, r)
+		}
+	}()
+	return fn.Call(in), nil
+}
+
+func goResultToObject(v reflect.Value) object.Object {
+	if obj, err := object.FromGo(v.Interface()); err == nil {
+		return obj
+	}
+	return goToObject(v, nil)
+}
+
+// argsを関数の型mtの引数に変換する。firstはMonkeyから渡されない、先頭の引数の値。
+func goCallArgs(mt reflect.Type, first []reflect.Value, args []object.Object) ([]reflect.Value, error) {
+	n := mt.NumIn() - len(first)
+	if mt.IsVariadic() && len(args) < n-1 || !mt.IsVariadic() && len(args) != n {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), n)
+	}
+
+	in := append(make([]reflect.Value, 0, len(first)+len(args)), first...)
+	for i, arg := range args {
+		var t reflect.Type
+		if mt.IsVariadic() && i >= n-1 {
+			t = mt.In(mt.NumIn() - 1).Elem()
+		} else {
+			t = mt.In(len(first) + i)
+		}
+
+		v, err := objectToGo(arg, t)
+		if err != nil {
+			return nil, err
+		}
+		in = append(in, v)
+	}
+	return in, nil
+}
+
+// 返り値を、一つずつconvertでMonkeyのオブジェクトにする。
+func goCallResults(out []reflect.Value, convert func(reflect.Value) object.Object) object.Object {
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err := out[len(out)-1]; !err.IsNil() {
+			return newError("%s", err.Interface())
+		}
+		out = out[:len(out)-1]
+	}
+
+	switch len(out) {
+	case 0:
+		return NULL
+	case 1:
+		return convert(out[0])
+	default:
+		// 返り値が複数ある場合は配列にまとめる。
+		elements := make([]object.Object, len(out))
+		for i, v := range out {
+			elements[i] = convert(v)
+		}
+		return &object.Array{Elements: elements}
+	}
+}
+
+// goの値をMonkeyのオブジェクトにする。
+// 数値、文字列、真偽値は対応するオブジェクトに変換し、nilはnullにする。それ以外はコピーせずにGoValueで包む。
+func goToObject(v reflect.Value, allow func(string) bool) object.Object {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return NULL
+	case reflect.Bool:
+		return nativeBoolToBooleanObject(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object.NewInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &object.BigInt{Value: new(big.Int).SetUint64(v.Uint())}
+		}
+		return object.NewInteger(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}
+	case reflect.String:
+		return &object.String{Value: v.String()}
+	case reflect.Interface:
+		if v.IsNil() {
+			return NULL
+		}
+		return goToObject(v.Elem(), allow)
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return NULL
+		}
+	}
+
+	// Monkeyのオブジェクトを返すメソッドなら、そのまま使う。
+	if obj, ok := v.Interface().(object.Object); ok {
+		return obj
+	}
+	return &object.GoValue{Value: v.Interface(), Allow: allow}
+}
+
+// Monkeyのオブジェクトを、型tのgoの値にする。
+// 整数から小数への変換はするが、小数から整数や、整数から文字列のように値が変わってしまう変換はしない。
+func objectToGo(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	var natural interface{}
+	switch obj := obj.(type) {
+	case *object.Integer:
+		natural = obj.Value
+	case *object.Float:
+		natural = obj.Value
+	case *object.String:
+		natural = obj.Value
+	case *object.Boolean:
+		natural = obj.Value
+	case *object.GoValue:
+		natural = obj.Value
+	case *object.Null:
+		natural = nil
+	default:
+		natural = obj
+	}
+
+	if natural == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+	}
+
+	v := reflect.ValueOf(natural)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+
+	switch {
+	case isGoInt(v.Kind()) && isGoInt(t.Kind()):
+		if reflect.Zero(t).OverflowInt(v.Int()) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", v.Int(), t)
+		}
+		return v.Convert(t), nil
+	case isGoInt(v.Kind()) && isGoUint(t.Kind()):
+		if v.Int() < 0 || reflect.Zero(t).OverflowUint(uint64(v.Int())) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", v.Int(), t)
+		}
+		return v.Convert(t), nil
+	case (isGoInt(v.Kind()) || isGoFloat(v.Kind())) && isGoFloat(t.Kind()),
+		v.Kind() == reflect.String && t.Kind() == reflect.String,
+		v.Kind() == reflect.Bool && t.Kind() == reflect.Bool:
+		return v.Convert(t), nil
+	}
+
+	// 配列やハッシュは、中身まで変換してスライスやmap、構造体にする
+	switch obj.(type) {
+	case *object.Array, *object.Hash, *object.Vector, *object.Map:
+		target := reflect.New(t)
+		if err := object.ToGo(obj, target.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return target.Elem(), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
+
+func isGoInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isGoUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isGoFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
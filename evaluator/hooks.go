@@ -0,0 +1,104 @@
+package evaluator
+
+import (
+	"context"
+	"monkey/object"
+	"sync/atomic"
+	"time"
+)
+
+// 関数の呼び出し。CallHookのBeforeとAfterに渡される。
+type Call struct {
+	// 組み込み関数は登録された名前、ユーザー定義の関数は宣言した名前。無名関数は "<anonymous>"
+	Name     string
+	Function object.Object
+	// 引数。Beforeで書き換えると、書き換えた引数で呼び出す
+	Args []object.Object
+	// goで書かれた組み込み関数ならtrue。RegisterFuncなどで登録したgoの関数も含む
+	Builtin bool
+}
+
+// 関数を呼び出す前後に呼ばれるフック。WithCallHooksでContextに設定する。
+// 組み込み関数もユーザー定義の関数も、mapなどの組み込み関数から呼び出されたコールバックも対象になる。
+// 呼び出しの記録や回数の制限、テストで特定の関数を差し替えるのに、組み込み関数の登録簿を作り直さずに使える。
+type CallHook struct {
+	// 呼び出す前に呼ばれる。nil以外を返すと関数を呼ばずに、それを呼び出しの結果にする。
+	// エラー(*object.Error)を返せば呼び出しがエラーになり、値を返せば関数を差し替えられる。
+	Before func(ctx context.Context, call *Call) object.Object
+	// 呼び出した後に、結果とかかった時間を渡して呼ばれる。Beforeが結果を返した場合も呼ばれる。
+	After func(ctx context.Context, call *Call, result object.Object, elapsed time.Duration)
+}
+
+type callHooksKey struct{}
+
+// 一度でもWithCallHooksが使われたら1になる。使われていなければ、呼び出しのたびにContextを探さずに済む。
+var callHooksUsed int32
+
+// 関数を呼び出す前後にhooksを呼ぶContextを返す。EvalContextやEnvironment.SetContextで使う。
+// ctxに既にフックがあれば、その後にhooksを追加する。Beforeは追加した順に呼び、結果を返したフックがあればそこで止める。
+// Afterは全てのフックで、追加したのと逆の順に呼ぶ。
+func WithCallHooks(ctx context.Context, hooks ...CallHook) context.Context {
+	atomic.StoreInt32(&callHooksUsed, 1)
+	parent, _ := ctx.Value(callHooksKey{}).([]CallHook)
+	all := append(append([]CallHook{}, parent...), hooks...)
+	return context.WithValue(ctx, callHooksKey{}, all)
+}
+
+// envのContextに設定されたフック。なければnil。
+func callHooks(env *object.Environment) []CallHook {
+	if atomic.LoadInt32(&callHooksUsed) == 0 {
+		return nil
+	}
+	hooks, _ := env.Context().Value(callHooksKey{}).([]CallHook)
+	return hooks
+}
+
+// hooksを呼びながらfnを呼び出す。
+func applyWithHooks(env *object.Environment, hooks []CallHook, fn object.Object, args []object.Object) object.Object {
+	ctx := env.Context()
+	call := &Call{Name: hookedName(fn), Function: fn, Args: args}
+	_, call.Builtin = fn.(*object.Builtin)
+
+	start := time.Now()
+	var result object.Object
+	for _, hook := range hooks {
+		if hook.Before == nil {
+			continue
+		}
+		if result = hook.Before(ctx, call); result != nil {
+			break
+		}
+	}
+	if result == nil {
+		result = traceFunction(env, fn, call.Args)
+	}
+	elapsed := time.Since(start)
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].After != nil {
+			hooks[i].After(ctx, call, result, elapsed)
+		}
+	}
+	return result
+}
+
+// フックに渡す関数の名前。
+func hookedName(fn object.Object) string {
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		return fn.Name
+	case *object.Function:
+		return functionName(fn)
+	case *object.Closure:
+		if fn.Fn.Name == "" {
+			return "<anonymous>"
+		}
+		return fn.Fn.Name
+	case *object.BoundMethod:
+		return fn.Name
+	case *object.Class:
+		return fn.Name
+	default:
+		return string(fn.Type())
+	}
+}
@@ -0,0 +1,110 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey/object"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// リクエストを送ってよいかを決める。許可しない場合はエラーを返す。
+type NetworkPolicy func(method, url string) error
+
+// 全てのリクエストを送れる。Builtinsのhttpモジュールはこのポリシーで登録される。
+func AllowAllNetwork(method, url string) error {
+	return nil
+}
+
+// リクエストを一切送らせない。
+func DenyAllNetwork(method, url string) error {
+	return fmt.Errorf("network access denied: %s", url)
+}
+
+// httpモジュールを、policyで許可されたリクエストだけを送るようにして登録する。
+// RegisterFileBuiltinsと同じく、組み込み先はBuiltinsを親にした登録簿に登録し直すことで通信を制限できる。
+func RegisterHTTPBuiltins(r *BuiltinRegistry, policy NetworkPolicy) {
+	r.RegisterModule("http", httpModule(policy))
+}
+
+// httpモジュールのメンバー。
+// リクエストは環境のContextで送るので、sleepやexecと同じくContextでタイムアウトやキャンセルができる。
+func httpModule(policy NetworkPolicy) map[string]object.Object {
+	return map[string]object.Object{
+		// http.get(url) または http.get(url, headers) でGETリクエストを送り、{"status", "body", "headers"} を返す。
+		"get": builtin("http.get", args(STRING, optional(HASH))).FnEnv(
+			func(env *object.Environment, args ...object.Object) object.Object {
+				var headers object.Object
+				if len(args) == 2 {
+					headers = args[1]
+				}
+				return httpRequest(env, policy, "GET", args[0], nil, headers)
+			},
+		),
+		// http.post(url, body) または http.post(url, body, headers) でbodyをPOSTする。
+		"post": builtin("http.post", args(STRING, STRING, optional(HASH))).FnEnv(
+			func(env *object.Environment, args ...object.Object) object.Object {
+				var headers object.Object
+				if len(args) == 3 {
+					headers = args[2]
+				}
+				body := strings.NewReader(args[1].(*object.String).Value)
+				return httpRequest(env, policy, "POST", args[0], body, headers)
+			},
+		),
+	}
+}
+
+// リクエストを送り、レスポンスをハッシュにして返す。ステータスコードが200番台以外でもエラーにはしない。
+func httpRequest(env *object.Environment, policy NetworkPolicy, method string, url object.Object, body io.Reader, headers object.Object) object.Object {
+	if err := policy(method, url.(*object.String).Value); err != nil {
+		return newError("http: %s", err)
+	}
+	req, err := http.NewRequest(method, url.(*object.String).Value, body)
+	if err != nil {
+		return newError("http: %s", err)
+	}
+	req = req.WithContext(env.Context())
+	if headers != nil {
+		for _, pair := range headers.(*object.Hash).OrderedPairs() {
+			req.Header.Set(stringValue(pair.Key), stringValue(pair.Value))
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return newError("http: %s", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return newError("http: %s", err)
+	}
+
+	// ヘッダーは名前の順に、同じ名前が複数ある場合は , でつなげる
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	respHeaders := object.NewHash()
+	for _, name := range names {
+		respHeaders.Set(&object.String{Value: name}, &object.String{Value: strings.Join(resp.Header[name], ", ")})
+	}
+
+	result := object.NewHash()
+	result.Set(object.NewString("status"), object.NewInteger(int64(resp.StatusCode)))
+	result.Set(object.NewString("body"), &object.String{Value: string(data)})
+	result.Set(object.NewString("headers"), respHeaders)
+	return result
+}
+
+// 文字列はそのまま、それ以外はInspectした文字列にする。
+func stringValue(obj object.Object) string {
+	if str, ok := obj.(*object.String); ok {
+		return str.Value
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,217 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// import("path/to/file.monkey") でファイルを読み込み、そのモジュールを返す。
+// 同じファイルは一度だけ評価し、二回目以降は最初に作ったモジュールを返す。
+// 複数のInterpreterやgoroutineから同時に使える。
+type Importer struct {
+	// 相対パスのファイルを探すディレクトリ。前から順に探す。
+	Paths []string
+	// 読み込んでよいファイルかを決める。nilならどのファイルでも読み込める。
+	Policy FilePolicy
+
+	mu      sync.Mutex
+	modules map[string]*object.Module // 絶対パスから、評価済みのモジュールへ
+	loading map[string]*moduleLoad    // 絶対パスから、評価中のファイルへ
+}
+
+// 評価中のファイル。評価しているContextにも持たせ、そのContextで評価しているimportの連なりを辿れるようにする。
+type moduleLoad struct {
+	file    string
+	parent  *moduleLoad   // このファイルをimportしているファイル。トップレベルからのimportならnil
+	done    chan struct{} // 評価が終わったら閉じる
+	waiting *moduleLoad   // 別の評価が読み込み中のファイルを待っている間、そのファイル。Importer.muで守る
+}
+
+type moduleLoadKey struct{}
+
+// ctxで評価しているファイル。ファイルの評価の中でなければnil。
+func loadOf(ctx context.Context) *moduleLoad {
+	load, _ := ctx.Value(moduleLoadKey{}).(*moduleLoad)
+	return load
+}
+
+// pathsからファイルを探すImporterを作る。
+func NewImporter(paths ...string) *Importer {
+	return &Importer{Paths: paths, modules: make(map[string]*object.Module), loading: make(map[string]*moduleLoad)}
+}
+
+// Builtinsのimportが使うImporter。カレントディレクトリから探す。
+var Imports = NewImporter(".")
+
+// importを、importerでファイルを探して読み込むようにして登録する。
+func RegisterImportBuiltin(r *BuiltinRegistry, importer *Importer) {
+	r.RegisterValue("import", builtin("import", args(STRING)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			return importer.Import(env, args[0].(*object.String).Value)
+		},
+	))
+}
+
+// pathのファイルを評価したモジュールを返す。
+// ファイルはトップレベルで束縛した変数をモジュールのメンバーとして公開する。_ から始まる名前は公開しない。
+// 評価にはenvの組み込み関数とContext、入出力を使う。
+// 別の評価が同じファイルを読み込んでいる最中なら、その評価が終わるのを待ってそのモジュールを返す。
+func (im *Importer) Import(env *object.Environment, path string) object.Object {
+	ctx := env.Context()
+	current := loadOf(ctx)
+	file, err := im.find(path, current)
+	if err != nil {
+		return newError("import: %s", err)
+	}
+
+	for {
+		im.mu.Lock()
+		if module, ok := im.modules[file]; ok {
+			im.mu.Unlock()
+			return module
+		}
+		other, ok := im.loading[file]
+		if !ok {
+			break
+		}
+		if cycle := importCycle(current, other); cycle != nil {
+			im.mu.Unlock()
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+		// 待っている間は、このimportの連なりの全てのファイルがotherを待っていることにする。
+		// 待っている先を辿ってこの連なりに戻ってくれば、別の評価との間でimportが循環している
+		for load := current; load != nil; load = load.parent {
+			load.waiting = other
+		}
+		im.mu.Unlock()
+
+		select {
+		case <-other.done:
+		case <-ctx.Done():
+		}
+
+		im.mu.Lock()
+		for load := current; load != nil; load = load.parent {
+			load.waiting = nil
+		}
+		im.mu.Unlock()
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		// 評価がエラーで終わった場合はモジュールがないので、このimportで読み込み直す
+	}
+
+	load := &moduleLoad{file: file, parent: current, done: make(chan struct{})}
+	im.loading[file] = load
+	im.mu.Unlock()
+
+	module := im.load(env.WithContext(context.WithValue(ctx, moduleLoadKey{}, load)), file)
+
+	im.mu.Lock()
+	delete(im.loading, file)
+	if m, ok := module.(*object.Module); ok {
+		im.modules[file] = m
+	}
+	im.mu.Unlock()
+	close(load.done)
+	return module
+}
+
+// currentの評価が、評価中のotherを待つとimportが循環するなら、その循環のファイル名を返す。
+// otherから待っている先を辿って、currentをimportしているファイルのどれかに戻れば循環している。
+// 同じ評価の中の循環なら、otherはcurrentをimportしているファイルのどれか自身になる。
+func importCycle(current, other *moduleLoad) []string {
+	importing := map[*moduleLoad]bool{}
+	for load := current; load != nil; load = load.parent {
+		importing[load] = true
+	}
+
+	var waits []string
+	for load := other; load != nil; load = load.waiting {
+		if !importing[load] {
+			waits = append(waits, filepath.Base(load.file))
+			continue
+		}
+		// loadからcurrentまでのimportの連なりに、待っている先を続けてloadに戻る
+		var chain []string
+		for l := current; l != load; l = l.parent {
+			chain = append([]string{filepath.Base(l.file)}, chain...)
+		}
+		cycle := append([]string{filepath.Base(load.file)}, chain...)
+		cycle = append(cycle, waits...)
+		return append(cycle, filepath.Base(load.file))
+	}
+	return nil
+}
+
+// ファイルを評価してモジュールを作る。評価がエラーで止まった場合はそのエラーを返す。
+func (im *Importer) load(env *object.Environment, file string) object.Object {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return newError("import: %s", err)
+	}
+	program, perr := parseCode("import "+filepath.Base(file), string(src))
+	if perr != nil {
+		return perr
+	}
+
+	// evalと同じく、importした評価のContextはモジュールの環境に残さない。
+	// モジュールの関数は、後で呼び出したときの評価のContextと上限で実行される
+	moduleEnv := object.NewEnvironment()
+	moduleEnv.SetBuiltins(env.Builtins())
+	moduleEnv.InheritStreams(env)
+	moduleEnv.SetCallDepth(env.CallDepth())
+	if result := Eval(program, moduleEnv.WithContext(env.Context())); isError(result) {
+		return result
+	}
+
+	exports := make(map[string]object.Object)
+	for name, value := range moduleEnv.Bindings() {
+		if !strings.HasPrefix(name, "_") {
+			exports[name] = value
+		}
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return NewModule(name, exports)
+}
+
+// pathを絶対パスにする。相対パスの場合は、importしているファイルのディレクトリ、Pathsのディレクトリの順に探す。
+// 拡張子のないパスで見つからなければ、.monkeyを付けて探し直す。
+func (im *Importer) find(path string, from *moduleLoad) (string, error) {
+	candidates := []string{path}
+	if filepath.Ext(path) == "" {
+		candidates = append(candidates, path+".monkey")
+	}
+
+	for _, candidate := range candidates {
+		dirs := im.Paths
+		if from != nil {
+			dirs = append([]string{filepath.Dir(from.file)}, dirs...)
+		}
+		if filepath.IsAbs(candidate) {
+			dirs = []string{""}
+		}
+		for _, dir := range dirs {
+			file, err := filepath.Abs(filepath.Join(dir, candidate))
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(file); err != nil || info.IsDir() {
+				continue
+			}
+			if im.Policy != nil {
+				if err := im.Policy(file, false); err != nil {
+					return "", err
+				}
+			}
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("module not found: %s", path)
+}
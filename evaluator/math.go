@@ -0,0 +1,112 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+	"monkey/object"
+)
+
+// 数学の組み込み関数。Builtinsに登録される。
+// 整数(IntegerとBigInt)と小数のどちらも受け取れる。
+var mathBuiltins = map[string]*object.Builtin{
+	"abs": builtin("abs", args(NUMBER)).Fn(
+		func(args ...object.Object) object.Object {
+			if isInteger(args[0]) {
+				return bigIntToObject(new(big.Int).Abs(toBigInt(args[0])))
+			}
+			return &object.Float{Value: math.Abs(toFloat(args[0]))}
+		},
+	),
+	// min(1, 2, 3) または min([1, 2, 3]) で一番小さい値を返す。 < と同じくobject.Compareの順序で比べる。
+	"min": builtin("min", args(variadic(ANY))).Fn(
+		func(args ...object.Object) object.Object {
+			return extremum("min", args, -1)
+		},
+	),
+	"max": builtin("max", args(variadic(ANY))).Fn(
+		func(args ...object.Object) object.Object {
+			return extremum("max", args, 1)
+		},
+	),
+	// pow(x, y) でxのy乗を返す。どちらも整数でyが0以上なら整数、それ以外は小数になる。
+	"pow": builtin("pow", args(NUMBER, NUMBER)).Fn(
+		func(args ...object.Object) object.Object {
+			x, y := args[0], args[1]
+			if isInteger(x) && isInteger(y) && toBigInt(y).Sign() >= 0 {
+				return bigIntToObject(new(big.Int).Exp(toBigInt(x), toBigInt(y), nil))
+			}
+			return &object.Float{Value: math.Pow(toFloat(x), toFloat(y))}
+		},
+	),
+	"sqrt": builtin("sqrt", args(NUMBER)).Fn(
+		func(args ...object.Object) object.Object {
+			x := toFloat(args[0])
+			if x < 0 {
+				return newError("sqrt: expected a non-negative NUMBER, got %s", args[0].Inspect())
+			}
+			return &object.Float{Value: math.Sqrt(x)}
+		},
+	),
+	// floor、ceil、roundは整数を返す。roundは0.5を0から遠い方に丸める。
+	"floor": builtin("floor", args(NUMBER)).Fn(
+		func(args ...object.Object) object.Object {
+			return roundWith(args[0], math.Floor)
+		},
+	),
+	"ceil": builtin("ceil", args(NUMBER)).Fn(
+		func(args ...object.Object) object.Object {
+			return roundWith(args[0], math.Ceil)
+		},
+	),
+	"round": builtin("round", args(NUMBER)).Fn(
+		func(args ...object.Object) object.Object {
+			return roundWith(args[0], math.Round)
+		},
+	),
+}
+
+// 数学の定数。Builtinsに登録される。
+var mathConstants = map[string]object.Object{
+	"PI": &object.Float{Value: math.Pi},
+	"E":  &object.Float{Value: math.E},
+}
+
+// 一番小さい(sign=-1)か、一番大きい(sign=1)値を返す。
+// 引数が一つだけの場合は、配列などのIterableなオブジェクトの要素から探す。
+func extremum(name string, args []object.Object, sign int) object.Object {
+	values := args
+	if len(args) == 1 {
+		if err := builtin(name, []Param{ITERABLE}).Check(args); err != nil {
+			return err
+		}
+		values = iterableElements(args[0])
+	}
+	if len(values) == 0 {
+		return newError("%s: expected at least one value", name)
+	}
+
+	result := values[0]
+	for _, v := range values[1:] {
+		cmp, ok := object.Compare(v, result)
+		if !ok {
+			return newError("cannot compare %s and %s", v.Type(), result.Type())
+		}
+		if cmp*sign > 0 {
+			result = v
+		}
+	}
+	return result
+}
+
+// 小数をfで丸めて整数にする。整数はそのまま返す。
+func roundWith(arg object.Object, f func(float64) float64) object.Object {
+	if isInteger(arg) {
+		return arg
+	}
+	x := f(toFloat(arg))
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return newError("cannot convert %s to INTEGER", arg.Inspect())
+	}
+	n, _ := big.NewFloat(x).Int(nil)
+	return bigIntToObject(n)
+}
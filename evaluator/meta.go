@@ -0,0 +1,152 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Monkeyのコードを文字列から扱う組み込み関数。Builtinsに登録される。
+var metaBuiltins = map[string]*object.Builtin{
+	// eval(code) でコードを呼び出し元の環境で評価し、その結果を返す。letした変数は呼び出し元からも参照できる。
+	// eval(code, true) の場合は新しい環境で評価するので、呼び出し元の変数は見えず、変更もされない。
+	"eval": builtin("eval", args(STRING, optional(ANY))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			program, err := parseCode("eval", args[0].(*object.String).Value)
+			if err != nil {
+				return err
+			}
+
+			target := env
+			if len(args) == 2 && isTruthy(args[1]) {
+				// 組み込み関数と入出力、呼び出しの深さは呼び出し元のものを引き継ぐ。
+				// Contextは作った環境には残さず、この評価にだけ使う。作った関数は呼び出したときのContextで実行される
+				target = object.NewEnvironment()
+				target.SetBuiltins(env.Builtins())
+				target.InheritStreams(env)
+				target.SetCallDepth(env.CallDepth())
+			}
+			return Eval(program, target.WithContext(env.Context()))
+		},
+	),
+	// parse(code) でコードをパースし、ASTをハッシュで返す。
+	// ノードは {"type": "InfixExpression", "pos": "1:1", "left": ..., "operator": "+", ...} のように、
+	// ノードの型の名前と位置、フィールドをsnake_caseにした名前をキーとして持つ。
+	"parse": builtin("parse", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			program, err := parseCode("parse", args[0].(*object.String).Value)
+			if err != nil {
+				return err
+			}
+			return astToObject(reflect.ValueOf(program))
+		},
+	),
+}
+
+// パースエラーがあれば、全てのエラーを一つにまとめたエラーを返す。
+func parseCode(name, code string) (*ast.Program, *object.Error) {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newError("%s: parse error: %s", name, strings.Join(p.Errors(), "; "))
+	}
+	return program, nil
+}
+
+// ASTのノードのうち、parseの結果に含めないフィールド。
+// トークンは位置(pos)とフィールドの値でわかり、BindingとSlotsは評価のためにresolverが付ける情報なので省く。
+var skippedASTFields = map[string]bool{
+	"Token":    true,
+	"EndToken": true,
+	"Binding":  true,
+	"Slots":    true,
+}
+
+// ASTのノードや値を、reflectで辿ってMonkeyのオブジェクトにする。
+// ノードはハッシュ、スライスは配列、文字列や数値はそのままの値になる。nilのノードはnull。
+func astToObject(v reflect.Value) object.Object {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return NULL
+		}
+		if hl, ok := v.Interface().(*ast.HashLiteral); ok {
+			return hashLiteralToObject(hl)
+		}
+		if v.Kind() == reflect.Interface {
+			return astToObject(v.Elem())
+		}
+		return nodeToObject(v)
+	case reflect.Slice:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			elements[i] = astToObject(v.Index(i))
+		}
+		return &object.Array{Elements: elements}
+	case reflect.String:
+		return &object.String{Value: v.String()}
+	case reflect.Bool:
+		return nativeBoolToBooleanObject(v.Bool())
+	case reflect.Int, reflect.Int64:
+		return object.NewInteger(v.Int())
+	case reflect.Float64:
+		return &object.Float{Value: v.Float()}
+	}
+	return NULL
+}
+
+// 構造体のノードを、型の名前と位置、フィールドを持つハッシュにする。
+func nodeToObject(v reflect.Value) object.Object {
+	hash := object.NewHash()
+	hash.Set(object.NewString("type"), object.NewString(v.Elem().Type().Name()))
+	if node, ok := v.Interface().(ast.Node); ok {
+		hash.Set(object.NewString("pos"), &object.String{Value: node.Pos().String()})
+	}
+
+	elem := v.Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Type().Field(i)
+		if skippedASTFields[field.Name] || field.PkgPath != "" {
+			continue
+		}
+		hash.Set(&object.String{Value: snakeCase(field.Name)}, astToObject(elem.Field(i)))
+	}
+	return hash
+}
+
+// ハッシュリテラルのPairsはmapなので、ソースコードに書かれた順の [キー, バリュー] の配列にする。
+func hashLiteralToObject(hl *ast.HashLiteral) object.Object {
+	hash := object.NewHash()
+	hash.Set(object.NewString("type"), object.NewString("HashLiteral"))
+	hash.Set(object.NewString("pos"), &object.String{Value: hl.Pos().String()})
+
+	keys := ast.SortedHashKeys(hl)
+	pairs := make([]object.Object, len(keys))
+	for i, key := range keys {
+		pairs[i] = &object.Array{Elements: []object.Object{
+			astToObject(reflect.ValueOf(key)),
+			astToObject(reflect.ValueOf(hl.Pairs[key])),
+		}}
+	}
+	hash.Set(object.NewString("pairs"), &object.Array{Elements: pairs})
+	return hash
+}
+
+// ReturnValue のようなgoのフィールド名を return_value にする。
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,141 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"monkey/resolver"
+)
+
+// string_builder()で作ったStringBuilderのメソッド。
+// メソッドは組み込み関数と同じ形で、レシーバが最初の引数として渡される。
+var stringBuilderMethods = map[string]*object.Builtin{
+	// sb.append(values...) で値を末尾に書き足し、sbを返す。文字列以外の値はputsと同じ表示で書き足す。
+	"append": builtin("append", args(STRING_BUILDER, variadic(ANY))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			sb := args[0].(*object.StringBuilder)
+			for _, arg := range args[1:] {
+				s := arg.Inspect()
+				// 書き足した分だけをWithMemoryLimitの上限に数える
+				if err := allocate(env, int64(len(s))); err != nil {
+					return err
+				}
+				sb.Builder.WriteString(s)
+			}
+			return sb
+		},
+	),
+	// sb.build() でそれまでに書き足した文字列を返す。sbはそのまま使い続けられる。
+	"build": builtin("build", args(STRING_BUILDER)).Fn(
+		func(args ...object.Object) object.Object {
+			return object.NewString(args[0].(*object.StringBuilder).Builder.String())
+		},
+	),
+	// sb.len() でそれまでに書き足した文字列のバイト数を返す。
+	"len": builtin("len", args(STRING_BUILDER)).Fn(
+		func(args ...object.Object) object.Object {
+			return object.NewInteger(int64(args[0].(*object.StringBuilder).Builder.Len()))
+		},
+	),
+}
+
+// 組み込みの型に固有のメソッド。 sb.build() のように . で参照すると、レシーバと結びついたBoundMethodになる。
+var typeMethods = map[object.ObjectType]map[string]*object.Builtin{
+	object.STRING_BUILDER_OBJ: stringBuilderMethods,
+}
+
+// 組み込みの型のメソッドとしても呼び出せる組み込み関数。 "hello".len() は len("hello") と、
+// arr.push(4) は push(arr, 4) と同じで、レシーバが最初の引数になる。
+// 関数は呼び出すたびに環境の組み込み関数から名前で探すので、WithBuiltinで差し替えた関数や、Policyで使えなくした関数はメソッドとしても同じように振る舞う。
+var builtinMethods = map[object.ObjectType][]string{
+	object.STRING_OBJ: {"len", "upper", "lower", "split", "trim", "replace", "starts_with", "ends_with", "index_of", "chars", "contains", "format"},
+	object.ARRAY_OBJ:  {"len", "first", "last", "rest", "push", "push!", "pop!", "insert!", "remove!", "join", "contains", "index_of", "enumerate", "map", "filter", "reduce", "sort"},
+	object.HASH_OBJ:   {"len", "keys", "values", "has_key", "delete", "merge", "contains"},
+	object.VECTOR_OBJ: {"len", "first", "last", "rest", "push", "join", "contains", "index_of", "enumerate", "map", "filter", "reduce", "sort"},
+	object.MAP_OBJ:    {"len", "keys", "values", "has_key", "delete", "merge", "contains"},
+}
+
+// builtinMethodsを型とメソッドの名前で引けるようにしたもの。
+var builtinMethodNames = map[object.ObjectType]map[string]bool{}
+
+func init() {
+	for typ, names := range builtinMethods {
+		builtinMethodNames[typ] = make(map[string]bool, len(names))
+		for _, name := range names {
+			builtinMethodNames[typ][name] = true
+		}
+	}
+}
+
+// typ型の値がメソッドを持つか。
+func hasMethods(typ object.ObjectType) bool {
+	return typeMethods[typ] != nil || builtinMethodNames[typ] != nil
+}
+
+// typ型のnameという名前のメソッド。typeMethodsになければ、builtinMethodsに挙がっている名前の組み込み関数をenvから探す。
+func lookupMethod(env *object.Environment, typ object.ObjectType, name string) (*object.Builtin, bool) {
+	if method, ok := typeMethods[typ][name]; ok {
+		return method, true
+	}
+	if !builtinMethodNames[typ][name] {
+		return nil, false
+	}
+	obj, ok := lookupBuiltin(env, name)
+	if !ok {
+		return nil, false
+	}
+	method, ok := obj.(*object.Builtin)
+	return method, ok
+}
+
+// 組み込み関数を呼び出す。FnEnvがあれば呼び出した場所の環境と一緒に渡す。
+func callBuiltin(env *object.Environment, builtin *object.Builtin, args []object.Object) object.Object {
+	args = materializeArgs(builtin, args)
+	var result object.Object
+	if builtin.FnEnv != nil {
+		result = builtin.FnEnv(env, args...)
+	} else {
+		result = builtin.Fn(args...)
+	}
+	// pushやsplitなどが作った値は、WithMemoryLimitの上限に数える。
+	// push! のように引数をそのまま返した場合は新しく作った値ではないので数えない
+	for _, arg := range args {
+		if result == arg {
+			return result
+		}
+	}
+	return persistResult(env, allocated(env, result))
+}
+
+func evalTypeMethod(env *object.Environment, receiver object.Object, name string) object.Object {
+	method, ok := lookupMethod(env, receiver.Type(), name)
+	if !ok {
+		return newError("%s has no method %s", receiver.Type(), name)
+	}
+	return &object.BoundMethod{Receiver: receiver, Method: method, Name: name}
+}
+
+// BoundMethodを呼び出す。
+// クラスのメソッドはselfにレシーバを束縛したスコープで、組み込みの型のメソッドはレシーバを最初の引数にして呼び出す。
+// VMがコンパイルしたクラスのメソッドはClosureなので、selfのスコープを外側に持つClosureにしてから呼び出す。
+func applyBoundMethod(callerEnv *object.Environment, bm *object.BoundMethod, args []object.Object) object.Object {
+	switch method := bm.Method.(type) {
+	case *object.Function:
+		// resolverはメソッドの外側にselfだけのスコープがあるものとして変数の場所を決めている
+		env := object.NewEnclosedSlotEnvironment(method.Env, resolver.SelfScope)
+		env.Set("self", bm.Receiver)
+		// BoundMethodの呼び出しとしてCallTracerに伝えているので、applyFunctionを通さずに呼び出す
+		return callFunction(callerEnv, &object.Function{
+			Parameters: method.Parameters,
+			Rest:       method.Rest,
+			Body:       method.Body,
+			Env:        env,
+			Slots:      method.Slots,
+			Name:       method.Name,
+		}, args)
+	case *object.Closure:
+		return callFunction(callerEnv, MethodClosure(method, bm.Receiver), args)
+	case *object.Builtin:
+		return callBuiltin(callerEnv, method, append([]object.Object{bm.Receiver}, args...))
+	default:
+		return newError("not a function: %s", bm.Method.Type())
+	}
+}
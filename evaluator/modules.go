@@ -0,0 +1,110 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"sort"
+)
+
+// 組み込みのモジュール。モジュールの名前から、メンバーの名前とBuiltinsに登録されている組み込み関数の名前への対応。
+// string.split(s, ",") は split(s, ",") と同じ組み込み関数を呼ぶ。
+// ファイルのread_fileなどとexecは、RegisterFileBuiltinsとRegisterExecBuiltinsがosモジュールに追加する。
+// httpモジュールはRegisterHTTPBuiltinsが登録する。
+var builtinModules = map[string]map[string]string{
+	"string": {
+		"split":       "split",
+		"join":        "join",
+		"trim":        "trim",
+		"upper":       "upper",
+		"lower":       "lower",
+		"replace":     "replace",
+		"starts_with": "starts_with",
+		"ends_with":   "ends_with",
+		"index_of":    "index_of",
+		"chars":       "chars",
+		"format":      "format",
+	},
+	"math": {
+		"abs":   "abs",
+		"min":   "min",
+		"max":   "max",
+		"pow":   "pow",
+		"sqrt":  "sqrt",
+		"floor": "floor",
+		"ceil":  "ceil",
+		"round": "round",
+		"PI":    "PI",
+		"E":     "E",
+	},
+	"json": {
+		"encode": "json_encode",
+		"decode": "json_decode",
+	},
+	"os": {
+		"env":      "env",
+		"set_env":  "set_env",
+		"args":     "args",
+		"platform": "platform",
+		"exit":     "exit",
+	},
+}
+
+// Builtinsに組み込みのモジュールを登録する。モジュールのメンバーはBuiltinsに登録済みの組み込み関数を使う。
+func registerBuiltinModules() {
+	for module, members := range builtinModules {
+		values := make(map[string]object.Object, len(members))
+		for member, name := range members {
+			if value, ok := Builtins.LookupBuiltin(name); ok {
+				values[member] = value
+			}
+		}
+		Builtins.RegisterModule(module, values)
+	}
+}
+
+// membersを公開するモジュールを作る。
+func NewModule(name string, members map[string]object.Object) *object.Module {
+	env := object.NewEnvironment()
+	// メンバーの順番で結果が変わらないように、名前の順に束縛する
+	names := make([]string, 0, len(members))
+	for member := range members {
+		names = append(names, member)
+	}
+	sort.Strings(names)
+	for _, member := range names {
+		if b, ok := members[member].(*object.Builtin); ok {
+			document(name+"."+member, b)
+		}
+		env.Set(member, members[member])
+	}
+	return &object.Module{Name: name, Env: env}
+}
+
+// モジュールを組み込みの名前として登録する。string.split のように . でメンバーを参照できる。
+// 同じ名前のモジュールや組み込み関数があれば置き換える。
+func (r *BuiltinRegistry) RegisterModule(name string, members map[string]object.Object) {
+	r.RegisterValue(name, NewModule(name, members))
+}
+
+// moduleのmemberを設定する。モジュールがなければ作る。
+// 親の登録簿のモジュールは変更せず、親のモジュールを外側に持つモジュールをこの登録簿に登録し直すので、
+// Builtinsを親にした登録簿でメンバーを置き換えても、他のインタプリタには影響しない。
+// メンバーを使えなくするには、valueにNULLを設定する。
+func (r *BuiltinRegistry) SetModuleMember(module, member string, value object.Object) {
+	r.mu.RLock()
+	own, owned := r.builtins[module].(*object.Module)
+	r.mu.RUnlock()
+	if owned {
+		own.Env.Set(member, value)
+		return
+	}
+
+	inherited, ok := r.LookupBuiltin(module)
+	parent, isModule := inherited.(*object.Module)
+	if !ok || !isModule {
+		r.RegisterModule(module, map[string]object.Object{member: value})
+		return
+	}
+	env := object.NewEnclosedEnvironment(parent.Env)
+	env.Set(member, value)
+	r.RegisterValue(module, &object.Module{Name: module, Env: env})
+}
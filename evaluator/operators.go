@@ -0,0 +1,52 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"strings"
+)
+
+// 組み込みでない中置演算子の評価関数。
+// nilを返した場合はその組み合わせを扱わなかったものとして、組み込みの評価（unknown operatorのエラーなど）に任せる。
+type InfixOperatorFn func(left, right object.Object) object.Object
+
+// 中置演算子の評価関数をBuiltinsに登録する。
+// parser.RegisterInfixOperatorで追加した演算子は、ここで評価の仕方を登録しておかないと unknown operator のエラーになる。
+func RegisterInfixOperator(operator string, fn InfixOperatorFn) {
+	Builtins.RegisterInfixOperator(operator, fn)
+}
+
+// 中置演算子の評価関数を登録する。同じ演算子の関数があれば置き換える。
+// 組み込みの演算子（+ など）に登録した場合は、組み込みの評価が unknown operator か type mismatch のエラーになる組み合わせでだけ呼ばれる。
+// なので 1 + 2 の意味は変えられないが、インスタンス同士の + のように組み込みにない組み合わせは追加できる。
+func (r *BuiltinRegistry) RegisterInfixOperator(operator string, fn InfixOperatorFn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operators[operator] = fn
+}
+
+// 登録された中置演算子の評価関数を探す。この登録簿になければ親から探す。
+func (r *BuiltinRegistry) lookupInfixOperator(operator string) (InfixOperatorFn, bool) {
+	r.mu.RLock()
+	fn, ok := r.operators[operator]
+	r.mu.RUnlock()
+
+	if ok || r.parent == nil {
+		return fn, ok
+	}
+	return r.parent.lookupInfixOperator(operator)
+}
+
+// 環境の登録簿から中置演算子の評価関数を探す。環境に登録簿が設定されていなければBuiltinsから探す。
+func lookupInfixOperator(env *object.Environment, operator string) (InfixOperatorFn, bool) {
+	r, ok := env.Builtins().(*BuiltinRegistry)
+	if !ok {
+		r = Builtins
+	}
+	return r.lookupInfixOperator(operator)
+}
+
+// 組み込みの評価が、演算子と値の組み合わせを扱えずにエラーにしたか。
+func unhandledOperator(result object.Object) bool {
+	err, ok := result.(*object.Error)
+	return ok && (strings.HasPrefix(err.Message, "unknown operator: ") || strings.HasPrefix(err.Message, "type mismatch: "))
+}
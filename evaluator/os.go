@@ -0,0 +1,60 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"os"
+	"runtime"
+)
+
+// args()で返すスクリプトの引数。ファイルを実行するときにmainが設定する。
+var ScriptArgs []string
+
+// 環境変数やOSの情報を扱う組み込み関数。Builtinsに登録される。
+var osBuiltins = map[string]*object.Builtin{
+	// env(name) で環境変数の値を返す。設定されていなければnull。
+	"env": builtin("env", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			value, ok := os.LookupEnv(args[0].(*object.String).Value)
+			if !ok {
+				return NULL
+			}
+			return &object.String{Value: value}
+		},
+	),
+	// set_env(name, value) で環境変数を設定する。
+	"set_env": builtin("set_env", args(STRING, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			s := stringValues(args)
+			if err := os.Setenv(s[0], s[1]); err != nil {
+				return newError("set_env: %s", err)
+			}
+			return NULL
+		},
+	),
+	// args() でスクリプトに渡された引数を文字列の配列で返す。スクリプトのファイル名は含まない。
+	"args": builtin("args", args()).Fn(
+		func(args ...object.Object) object.Object {
+			elements := make([]object.Object, len(ScriptArgs))
+			for i, arg := range ScriptArgs {
+				elements[i] = &object.String{Value: arg}
+			}
+			return &object.Array{Elements: elements}
+		},
+	),
+	// exit() または exit(code) で評価を中断してプログラムを終了させる。
+	// ファイルを実行している場合は、codeがプロセスの終了コードになる。codeを省略した場合は0。
+	"exit": builtin("exit", args(optional(INTEGER))).Fn(
+		func(args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return &object.Exit{Code: 0}
+			}
+			return &object.Exit{Code: args[0].(*object.Integer).Value}
+		},
+	),
+	// platform() で "linux" や "darwin" のようなOSの名前を返す。
+	"platform": builtin("platform", args()).Fn(
+		func(args ...object.Object) object.Object {
+			return object.NewString(runtime.GOOS)
+		},
+	),
+}
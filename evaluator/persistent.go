@@ -0,0 +1,157 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"strings"
+)
+
+// VectorとMapをそのまま受け取れる組み込み関数。
+// それ以外の組み込み関数には、VectorとMapを同じ要素の配列とハッシュにして渡す。
+var persistentBuiltinNames = []string{
+	"len", "first", "last", "push", "has_key", "delete", "merge", "contains",
+	"map", "filter", "reduce", "sort", "enumerate", "set",
+}
+
+var persistentBuiltins = map[*object.Builtin]bool{}
+
+// 最初から登録されている組み込み関数を名前で探す。
+func lookupDefaultBuiltin(name string) (*object.Builtin, bool) {
+	for _, table := range []map[string]*object.Builtin{defaultBuiltins, stringBuiltins} {
+		if builtin, ok := table[name]; ok {
+			return builtin, true
+		}
+	}
+	return nil, false
+}
+
+func init() {
+	for _, name := range persistentBuiltinNames {
+		builtin, ok := lookupDefaultBuiltin(name)
+		if !ok {
+			panic("unknown persistent builtin " + name)
+		}
+		persistentBuiltins[builtin] = true
+	}
+}
+
+// VectorとMapも受け取る組み込み関数の引数。エラーメッセージでは配列、ハッシュとして扱う。
+var (
+	ARRAY_LIKE = Param{Name: "ARRAY", Accept: func(obj object.Object) bool {
+		switch obj.(type) {
+		case *object.Array, *object.Vector:
+			return true
+		}
+		return false
+	}}
+	HASH_LIKE = Param{Name: "HASH", Accept: func(obj object.Object) bool {
+		switch obj.(type) {
+		case *object.Hash, *object.Map:
+			return true
+		}
+		return false
+	}}
+)
+
+// 配列リテラルやハッシュリテラルなど、評価器が作った配列とハッシュ。
+// envのContextがWithPersistentCollectionsならVectorとMapにする。要素は既に評価済みの値なので、一番外側だけを変える。
+func literal(env *object.Environment, obj object.Object) object.Object {
+	if !settingsOf(env).persistent {
+		return obj
+	}
+	switch obj := obj.(type) {
+	case *object.Array:
+		return object.NewVector(obj.Elements)
+	case *object.Hash:
+		return hashToMap(obj, func(value object.Object) object.Object { return value })
+	}
+	return obj
+}
+
+// 組み込み関数が返した値を、中に入っている配列やハッシュまでVectorとMapにする。
+// 同じ配列を二か所から参照している場合は、同じ一つのVectorにする。
+func persist(obj object.Object, converted map[object.Object]object.Object) object.Object {
+	if c, ok := converted[obj]; ok {
+		return c
+	}
+	switch obj := obj.(type) {
+	case *object.Array:
+		// 自分自身を含む配列は、変換している途中の自分を空のVectorとして参照する
+		converted[obj] = object.NewVector(nil)
+		elements := make([]object.Object, len(obj.Elements))
+		for i, el := range obj.Elements {
+			elements[i] = persist(el, converted)
+		}
+		v := object.NewVector(elements)
+		converted[obj] = v
+		return v
+	case *object.Hash:
+		converted[obj] = object.NewMap()
+		m := hashToMap(obj, func(value object.Object) object.Object { return persist(value, converted) })
+		converted[obj] = m
+		return m
+	}
+	return obj
+}
+
+func hashToMap(hash *object.Hash, value func(object.Object) object.Object) *object.Map {
+	m := object.NewMap()
+	for _, pair := range hash.OrderedPairs() {
+		m, _ = m.Set(pair.Key, value(pair.Value))
+	}
+	return m
+}
+
+// VectorとMapを、中に入っているものまで同じ要素の配列とハッシュにする。
+// VectorとMapは自分自身を含むことがないので、そのまま辿れる。
+func materialize(obj object.Object) object.Object {
+	switch obj := obj.(type) {
+	case *object.Vector:
+		arr := obj.ToArray()
+		for i, el := range arr.Elements {
+			arr.Elements[i] = materialize(el)
+		}
+		return arr
+	case *object.Map:
+		hash := object.NewHash()
+		for _, pair := range obj.OrderedPairs() {
+			hash.Set(pair.Key, materialize(pair.Value))
+		}
+		return hash
+	}
+	return obj
+}
+
+// VectorとMapを受け取れない組み込み関数のために、引数を配列とハッシュにする。変換する必要がなければargsをそのまま返す。
+// push! のように引数を変更する組み込み関数には、変換した配列を変更しても意味がないのでそのまま渡し、型のエラーにする。
+func materializeArgs(builtin *object.Builtin, args []object.Object) []object.Object {
+	if persistentBuiltins[builtin] || strings.HasSuffix(builtin.Name, "!") {
+		return args
+	}
+	var converted []object.Object
+	for i, arg := range args {
+		switch arg.(type) {
+		case *object.Vector, *object.Map:
+			if converted == nil {
+				converted = make([]object.Object, len(args))
+				copy(converted, args)
+			}
+			converted[i] = materialize(arg)
+		}
+	}
+	if converted == nil {
+		return args
+	}
+	return converted
+}
+
+// 組み込み関数が返した値。envのContextがWithPersistentCollectionsなら、配列やハッシュをVectorとMapにする。
+func persistResult(env *object.Environment, obj object.Object) object.Object {
+	if !settingsOf(env).persistent {
+		return obj
+	}
+	switch obj.(type) {
+	case *object.Array, *object.Hash:
+		return persist(obj, make(map[object.Object]object.Object))
+	}
+	return obj
+}
@@ -0,0 +1,132 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"monkey/resolver"
+)
+
+// ASTを辿らずに、評価済みの値だけで評価器と同じ演算をする関数。
+// バイトコードを実行するvmが、評価器と同じ結果やエラーになるように使う。
+
+// left operator right を計算する。envの登録簿にRegisterInfixOperatorで追加された演算子も使える。
+func Infix(env *object.Environment, operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(env, operator, left, right)
+}
+
+// operator right を計算する。
+func Prefix(env *object.Environment, operator string, right object.Object) object.Object {
+	return evalPrefixExpression(env, operator, right)
+}
+
+// left[index] の値。
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// left.name の値。組み込みの型のメソッドはenvの組み込み関数から探す。
+func Property(env *object.Environment, left object.Object, name string) object.Object {
+	return evalProperty(env, left, name)
+}
+
+// left.name = val を代入して、valを返す。
+func SetProperty(left object.Object, name string, val object.Object) object.Object {
+	return setProperty(left, name, val)
+}
+
+// ifやwhileの条件として真とみなすか。
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// ifの条件として真とみなすか。envのContextがWithStrictConditionsで、条件が true でも false でもなければエラーを返す。
+func Condition(env *object.Environment, obj object.Object) (bool, *object.Error) {
+	return evalCondition(env, obj)
+}
+
+// 評価を中断して呼び出し元に伝えるべき値か。エラーと、throwされた例外、exit()が該当する。
+func IsError(obj object.Object) bool {
+	return isError(obj)
+}
+
+// fnを呼び出す。envは呼び出した場所の環境で、組み込み関数の出力先やContextに使う。
+func Apply(env *object.Environment, fn object.Object, args []object.Object) object.Object {
+	return applyFunction(env, fn, args)
+}
+
+// envのContextにWithCallHooksでフックが設定されているか。
+// 設定されていれば、vmは自分のクロージャも直接フレームを積まずにApplyで呼び出す。
+func HasCallHooks(env *object.Environment) bool {
+	return callHooks(env) != nil
+}
+
+// envで使う組み込み関数や組み込みの定数を名前で探す。
+func LookupBuiltin(env *object.Environment, name string) (object.Object, bool) {
+	return lookupBuiltin(env, name)
+}
+
+// 関数呼び出しやループの一周を一つの操作として数える。
+// envのContextがキャンセルされているか、WithStepLimitの上限を超えていればエラーを返す。
+func Step(env *object.Environment) object.Object {
+	if err := step(env); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 作った値objの大きさをWithMemoryLimitの上限に数える。上限を超えた場合はobjの代わりにエラーを返す。
+func Allocated(env *object.Environment, obj object.Object) object.Object {
+	return allocated(env, obj)
+}
+
+// 配列リテラルやハッシュリテラル、可変長引数の配列として作った値。envのContextがWithPersistentCollectionsならVectorとMapにする。
+func Literal(env *object.Environment, obj object.Object) object.Object {
+	return literal(env, obj)
+}
+
+// tryのブロックの結果resultがcatchできるものなら、catchの変数に束縛する値を返す。
+// throwされた例外ならthrowされた値、エラーならerror()で作るのと同じエラーの値。評価の中断とexit()はcatchできない。
+func Caught(env *object.Environment, result object.Object) (object.Object, bool) {
+	return caught(env, result)
+}
+
+// catchされずにプログラムの外まで伝播した例外を、プログラムのエラーにする。
+func Uncaught(ex *object.Exception) *object.Error {
+	return uncaught(ex)
+}
+
+// ...value で展開する要素。展開できない値ならエラー。
+func Spread(value object.Object) ([]object.Object, *object.Error) {
+	return spreadElements(value)
+}
+
+// let [a, b, ...rest] = val の、変数に束縛する値。先頭からn個の要素と、restなら残りの要素の配列。
+func DestructureArray(env *object.Environment, val object.Object, n int, rest bool) ([]object.Object, *object.Error) {
+	return destructureArray(env, val, n, rest)
+}
+
+// let {a, b} = val の、変数に束縛する値。
+func DestructureHash(val object.Object, keys []string) ([]object.Object, *object.Error) {
+	return destructureHash(val, keys)
+}
+
+// valがmatchの [a, b, ...rest] のパターンの形の配列なら、先頭からn個の要素と、restなら残りの要素の配列を返す。
+func MatchArray(env *object.Environment, val object.Object, n int, rest bool) ([]object.Object, bool) {
+	return matchArray(env, val, n, rest)
+}
+
+// valが、matchの {"a": x} のパターンのキーkeysを全て持つハッシュなら、キーの値を返す。
+func MatchHash(val object.Object, keys []object.Object) ([]object.Object, bool, *object.Error) {
+	return matchHash(val, keys)
+}
+
+// valがmatchのパターンに書かれた値expectedと等しいか。
+func Matches(val, expected object.Object) bool {
+	return objectsEqual(val, expected)
+}
+
+// クラスのメソッドclを、selfにreceiverを束縛したスコープの内側で実行するClosureにする。
+// resolverはメソッドの外側にselfだけのスコープがあるものとしてselfの場所を決めている。
+func MethodClosure(cl *object.Closure, receiver object.Object) *object.Closure {
+	self := &object.Locals{Values: []object.Object{receiver}, Names: resolver.SelfScope, Outer: cl.Outer}
+	return &object.Closure{Fn: cl.Fn, Outer: self, Runner: cl.Runner}
+}
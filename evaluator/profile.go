@@ -0,0 +1,128 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/object"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// 関数ごとの呼び出し回数と時間を数えるTracer。WithTracerでContextに設定してから評価し、Reportで結果を書き出す。
+// 呼び出しの入れ子をスタックで追うので、一つのgoroutineでの評価に使う。
+type Profiler struct {
+	stats  map[string]*FunctionProfile
+	frames []profileFrame
+	active map[string]int // 呼び出し中の関数の数。再帰呼び出しの時間を二重に数えないのに使う
+	now    func() time.Time
+}
+
+// 一つの関数の計測結果。
+// Totalは呼び出してから戻るまでの時間で、Selfはそこから呼び出した関数の時間を除いたもの。
+type FunctionProfile struct {
+	Name  string
+	Calls int
+	Total time.Duration
+	Self  time.Duration
+}
+
+type profileFrame struct {
+	name     string
+	start    time.Time
+	children time.Duration // この呼び出しの中で呼び出した関数の時間の合計
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		stats:  make(map[string]*FunctionProfile),
+		active: make(map[string]int),
+		now:    time.Now,
+	}
+}
+
+// ノードごとのフックは使わない
+func (p *Profiler) Enter(node ast.Node, env *object.Environment) {}
+func (p *Profiler) Exit(node ast.Node, result object.Object)     {}
+
+func (p *Profiler) Call(fn object.Object, args []object.Object) {
+	name := profileName(fn)
+	p.active[name]++
+	p.frames = append(p.frames, profileFrame{name: name, start: p.now()})
+}
+
+func (p *Profiler) Return(fn object.Object, result object.Object) {
+	frame := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+	elapsed := p.now().Sub(frame.start)
+
+	stat, ok := p.stats[frame.name]
+	if !ok {
+		stat = &FunctionProfile{Name: frame.name}
+		p.stats[frame.name] = stat
+	}
+	stat.Calls++
+	stat.Self += elapsed - frame.children
+	// 再帰呼び出しの時間は、一番外側の呼び出しの時間に含まれている
+	p.active[frame.name]--
+	if p.active[frame.name] == 0 {
+		stat.Total += elapsed
+	}
+	if len(p.frames) > 0 {
+		p.frames[len(p.frames)-1].children += elapsed
+	}
+}
+
+// 計測結果を、自身の時間(Self)の長い順に返す。
+func (p *Profiler) Profiles() []FunctionProfile {
+	profiles := make([]FunctionProfile, 0, len(p.stats))
+	for _, stat := range p.stats {
+		profiles = append(profiles, *stat)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Self != profiles[j].Self {
+			return profiles[i].Self > profiles[j].Self
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles
+}
+
+// 計測結果を表にしてwに書き出す。
+//
+//	function  calls  total   self
+//	fib       177    1.2ms   1.1ms
+//	len       10     15µs    15µs
+func (p *Profiler) Report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "function\tcalls\ttotal\tself")
+	for _, prof := range p.Profiles() {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", prof.Name, prof.Calls, prof.Total, prof.Self)
+	}
+	tw.Flush()
+}
+
+// 計測結果に表示する関数の名前。無名関数は区別できるように定義した位置を付ける。
+func profileName(fn object.Object) string {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if fn.Name == "" {
+			return fmt.Sprintf("<anonymous> (%s)", fn.Body.Pos())
+		}
+		return fn.Name
+	case *object.Builtin:
+		if fn.Name == "" {
+			return "<builtin>"
+		}
+		return fn.Name
+	case *object.BoundMethod:
+		if method, ok := fn.Method.(*object.Function); ok {
+			return profileName(method)
+		}
+		return string(fn.Receiver.Type()) + "." + fn.Name
+	case *object.Class:
+		return fn.Name
+	}
+	return string(fn.Type())
+}
@@ -0,0 +1,194 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"sort"
+	"sync"
+)
+
+// 組み込み関数の登録簿。関数の他に、PIのような定数も登録できる。
+// 親の登録簿を持つことができ、自分に登録されていない名前は親から探す。
+// 組み込み先は、Builtinsを親にした登録簿を作って関数を追加・削除し、Environment.SetBuiltinsでその環境だけに使わせることができる。
+type BuiltinRegistry struct {
+	mu       sync.RWMutex
+	parent   *BuiltinRegistry
+	builtins map[string]object.Object
+	removed  map[string]bool // Unregisterで親の組み込み関数を隠した名前
+
+	operators map[string]InfixOperatorFn // RegisterInfixOperatorで追加された中置演算子。キーは演算子の文字列
+	allow     func(name string) bool     // Restrictで設定した、使ってよい名前かどうか。nilなら全て使える
+}
+
+// parentを親にした空の登録簿を作る。親がいらなければnil。
+func NewBuiltinRegistry(parent *BuiltinRegistry) *BuiltinRegistry {
+	return &BuiltinRegistry{
+		parent:    parent,
+		builtins:  make(map[string]object.Object),
+		removed:   make(map[string]bool),
+		operators: make(map[string]InfixOperatorFn),
+	}
+}
+
+// 全てのインタプリタが使う組み込み関数の登録簿。Environment.SetBuiltinsで別の登録簿を設定しない限りこれが使われる。
+var Builtins = NewBuiltinRegistry(nil)
+
+func init() {
+	for _, table := range []map[string]*object.Builtin{defaultBuiltins, stringBuiltins, conversionBuiltins, mathBuiltins, timeBuiltins, osBuiltins, testingBuiltins, metaBuiltins, copyBuiltins} {
+		for name, builtin := range table {
+			Builtins.RegisterValue(name, builtin)
+		}
+	}
+	for name, value := range mathConstants {
+		Builtins.RegisterValue(name, value)
+	}
+	Builtins.RegisterValue("help", helpBuiltin)
+	registerBuiltinModules()
+	RegisterFileBuiltins(Builtins, AllowAllFiles)
+	RegisterExecBuiltins(Builtins, AllowAllExec)
+	RegisterHTTPBuiltins(Builtins, AllowAllNetwork)
+	RegisterTestBuiltins(Builtins, Tests)
+	RegisterImportBuiltin(Builtins, Imports)
+}
+
+// 組み込み関数をBuiltinsに登録する。同じ名前の関数があれば置き換える。
+func RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	Builtins.Register(name, fn)
+}
+
+// 組み込み関数を登録する。同じ名前の関数があれば置き換える。
+func (r *BuiltinRegistry) Register(name string, fn object.BuiltinFunction) {
+	r.RegisterValue(name, &object.Builtin{Fn: fn})
+}
+
+// 関数以外の値を組み込みの名前として登録する。同じ名前のものがあれば置き換える。
+func (r *BuiltinRegistry) RegisterValue(name string, value object.Object) {
+	if b, ok := value.(*object.Builtin); ok {
+		document(name, b)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.builtins[name] = value
+	delete(r.removed, name)
+}
+
+// 組み込み関数を使えなくする。親の登録簿にある関数も、この登録簿からは見えなくなる。
+func (r *BuiltinRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.builtins, name)
+	if r.parent != nil {
+		r.removed[name] = true
+	}
+}
+
+// この登録簿から探せる名前を、allowがtrueを返すものだけにする。親の登録簿の組み込み関数も、この登録簿を通して探す場合は制限される。
+// 組み込みのモジュールのメンバーは、"string.upper" のような名前か、メンバーの組み込み関数の名前 "upper" のどちらかが許可されていれば使える。
+// メソッドも組み込み関数を名前で探すので、"abc".upper() は upper が許可されていなければ呼び出せない。
+// この登録簿を親にした登録簿に登録したものは制限されないので、組み込み先が追加する関数はそちらに登録する。
+func (r *BuiltinRegistry) Restrict(allow func(name string) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allow = allow
+}
+
+// 名前で組み込み関数を探す。object.BuiltinLookupの実装。
+func (r *BuiltinRegistry) LookupBuiltin(name string) (object.Object, bool) {
+	r.mu.RLock()
+	allow := r.allow
+	builtin, ok := r.builtins[name]
+	removed := r.removed[name]
+	r.mu.RUnlock()
+
+	if allow != nil && !allow(name) {
+		return nil, false
+	}
+	if ok {
+		return builtin, true
+	}
+	if removed || r.parent == nil {
+		return nil, false
+	}
+	return r.parent.LookupBuiltin(name)
+}
+
+// 使える組み込み関数の名前を、名前順に返す。
+func (r *BuiltinRegistry) Names() []string {
+	seen := make(map[string]bool)
+	r.collect(seen)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *BuiltinRegistry) collect(seen map[string]bool) {
+	own := make(map[string]bool)
+	r.mu.RLock()
+	allow := r.allow
+	for name := range r.builtins {
+		own[name] = true
+	}
+	removed := make(map[string]bool, len(r.removed))
+	for name := range r.removed {
+		removed[name] = true
+	}
+	r.mu.RUnlock()
+
+	if r.parent != nil {
+		parent := make(map[string]bool)
+		r.parent.collect(parent)
+		for name := range parent {
+			if !removed[name] {
+				own[name] = true
+			}
+		}
+	}
+	for name := range own {
+		if allow == nil || allow(name) {
+			seen[name] = true
+		}
+	}
+}
+
+// 組み込みのモジュールmoduleのmemberを、この登録簿とその親のRestrictの制限の中で使ってよいか。
+func (r *BuiltinRegistry) allowsMember(module, member string, value object.Object) bool {
+	r.mu.RLock()
+	allow := r.allow
+	r.mu.RUnlock()
+
+	if allow != nil && !allow(module+"."+member) {
+		b, ok := value.(*object.Builtin)
+		if !ok || b.Name == "" || !allow(b.Name) {
+			return false
+		}
+	}
+	if r.parent == nil {
+		return true
+	}
+	return r.parent.allowsMember(module, member, value)
+}
+
+// 環境で使う組み込み関数を探す。環境に登録簿が設定されていなければBuiltinsから探す。
+func lookupBuiltin(env *object.Environment, name string) (object.Object, bool) {
+	if lookup := env.Builtins(); lookup != nil {
+		return lookup.LookupBuiltin(name)
+	}
+	return Builtins.LookupBuiltin(name)
+}
+
+// moduleのmemberのvalueを、envで使ってよいか。
+// 組み込みのモジュールのメンバーだけをRestrictの制限に従って調べ、importしたモジュールのメンバーは全て使える。
+func moduleMemberAllowed(env *object.Environment, module *object.Module, member string, value object.Object) bool {
+	builtin, ok := lookupBuiltin(env, module.Name)
+	if !ok || builtin != module {
+		return true
+	}
+	r, ok := env.Builtins().(*BuiltinRegistry)
+	if !ok {
+		r = Builtins
+	}
+	return r.allowsMember(module.Name, member, value)
+}
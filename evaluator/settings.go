@@ -0,0 +1,72 @@
+package evaluator
+
+import (
+	"context"
+	"monkey/object"
+	"sync/atomic"
+)
+
+type settingsKey struct{}
+
+// 評価の仕方を変える設定。WithIntegerDivisionなどでContextに設定する。
+// パッケージの変数ではなくContextに持つので、同じプロセスの中でもInterpreterや実行ごとに違う設定で評価できる。
+type settings struct {
+	division   DivisionMode
+	strict     bool
+	persistent bool
+	tracer     Tracer
+}
+
+// どの設定もしていない場合の設定。
+var defaultSettings = &settings{}
+
+// 一度でも設定のContextが作られたら1になる。使われていなければ、ノードを評価するたびにContextを探さずに済む。
+var settingsUsed int32
+
+// ctxの設定をコピーし、setで変更したものを持つContextを返す。
+func withSettings(ctx context.Context, set func(s *settings)) context.Context {
+	atomic.StoreInt32(&settingsUsed, 1)
+	s := *defaultSettings
+	if parent, ok := ctx.Value(settingsKey{}).(*settings); ok {
+		s = *parent
+	}
+	set(&s)
+	return context.WithValue(ctx, settingsKey{}, &s)
+}
+
+// envのContextの設定。設定されていなければdefaultSettings。
+func settingsOf(env *object.Environment) *settings {
+	if atomic.LoadInt32(&settingsUsed) == 0 {
+		return defaultSettings
+	}
+	if s, ok := env.Context().Value(settingsKey{}).(*settings); ok {
+		return s
+	}
+	return defaultSettings
+}
+
+// 整数同士の割り算をmodeで計算するContextを返す。EvalContextやEnvironment.SetContextで使う。
+func WithIntegerDivision(ctx context.Context, mode DivisionMode) context.Context {
+	return withSettings(ctx, func(s *settings) { s.division = mode })
+}
+
+// ifの条件と ! の右側に true か false しか書けなくするContextを返す。
+// if (5) や !null は、truthyとして扱う代わりに型のエラーになる。
+// 条件に数値や配列をそのまま書いてしまう間違いを見つけやすくなるので、学習用に使える。
+func WithStrictConditions(ctx context.Context) context.Context {
+	return withSettings(ctx, func(s *settings) { s.strict = true })
+}
+
+// 配列リテラルとハッシュリテラルを、変更できないVectorとMapにするContextを返す。
+// push や merge が元の値をコピーせずに構造を共有した新しい値を作るので、
+// 「コピーして一つ追加する」を繰り返す関数型のスタイルのコードが要素数の二乗の時間にならない。
+// 表示や比較、for-inなどの振る舞いは配列、ハッシュと同じ。 push! のように値を変更する組み込み関数は使えなくなる。
+func WithPersistentCollections(ctx context.Context) context.Context {
+	return withSettings(ctx, func(s *settings) { s.persistent = true })
+}
+
+// Evalがノードを評価する前後にtracerを呼ぶContextを返す。nilを渡すと呼ばなくなる。
+// tracerがCallTracerも実装していれば、関数を呼び出す前後にも呼ぶ。
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return withSettings(ctx, func(s *settings) { s.tracer = tracer })
+}
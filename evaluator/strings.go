@@ -0,0 +1,180 @@
+package evaluator
+
+import (
+	"io"
+	"monkey/object"
+	"strings"
+	"unicode/utf8"
+)
+
+// 文字列を扱う組み込み関数。Builtinsに登録される。
+// 文字の位置はchars()やfor-inと同じく、バイトではなく文字(rune)で数える。
+var stringBuiltins = map[string]*object.Builtin{
+	// split("a,b", ",") で区切り文字で分けた文字列の配列を作る。
+	"split": builtin("split", args(STRING, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			s := stringValues(args)
+			parts := strings.Split(s[0], s[1])
+			elements := make([]object.Object, len(parts))
+			for i, part := range parts {
+				elements[i] = object.NewString(part)
+			}
+			return &object.Array{Elements: elements}
+		},
+	),
+	// join(["a", "b"], ",") で文字列の配列を区切り文字でつなげる。
+	"join": builtin("join", args(ARRAY, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			arr := args[0].(*object.Array)
+			parts := make([]string, len(arr.Elements))
+			for i, el := range arr.Elements {
+				str, ok := el.(*object.String)
+				if !ok {
+					return newError("join: expected STRING elements, got %s", el.Type())
+				}
+				parts[i] = str.Value
+			}
+			return &object.String{Value: strings.Join(parts, args[1].(*object.String).Value)}
+		},
+	),
+	// trim(s) で前後の空白を取り除く。
+	"trim": builtin("trim", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			return object.NewString(strings.TrimSpace(args[0].(*object.String).Value))
+		},
+	),
+	"upper": builtin("upper", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.ToUpper(args[0].(*object.String).Value)}
+		},
+	),
+	"lower": builtin("lower", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.String{Value: strings.ToLower(args[0].(*object.String).Value)}
+		},
+	),
+	// replace(s, old, new) でoldを全てnewに置き換える。正規表現を使う場合はre_replace。
+	"replace": builtin("replace", args(STRING, STRING, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			s := stringValues(args)
+			return &object.String{Value: strings.Replace(s[0], s[1], s[2], -1)}
+		},
+	),
+	"starts_with": builtin("starts_with", args(STRING, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			s := stringValues(args)
+			return nativeBoolToBooleanObject(strings.HasPrefix(s[0], s[1]))
+		},
+	),
+	"ends_with": builtin("ends_with", args(STRING, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			s := stringValues(args)
+			return nativeBoolToBooleanObject(strings.HasSuffix(s[0], s[1]))
+		},
+	),
+	// index_of(s, sub) で最初に出てくる位置を、index_of(arr, x) で最初の等しい要素の添字を返す。見つからなければ-1。
+	"index_of": builtin("index_of", args(oneOf(STRING, ARRAY), ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			if str, ok := args[0].(*object.String); ok {
+				sub, ok := args[1].(*object.String)
+				if !ok {
+					return newError("index_of: expected STRING, got %s at argument 2", args[1].Type())
+				}
+				i := strings.Index(str.Value, sub.Value)
+				if i < 0 {
+					return object.NewInteger(-1)
+				}
+				return object.NewInteger(int64(utf8.RuneCountInString(str.Value[:i])))
+			}
+
+			for i, el := range args[0].(*object.Array).Elements {
+				if objectsEqual(el, args[1]) {
+					return object.NewInteger(int64(i))
+				}
+			}
+			return object.NewInteger(-1)
+		},
+	),
+	// chars(s) で一文字ずつの文字列の配列を作る。
+	"chars": builtin("chars", args(STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.Array{Elements: object.Collect(args[0].(*object.String).Iterator())}
+		},
+	),
+	// format("x = {}, y = {}", x, y) で {} を順番に引数の値で置き換えた文字列を作る。
+	// 値はputsと同じ表示になる。{ と } そのものは {{ と }} と書く。
+	"format": builtin("format", args(STRING, variadic(ANY))).Fn(
+		func(args ...object.Object) object.Object {
+			str, err := formatArgs("format", args)
+			if err != nil {
+				return err
+			}
+			return &object.String{Value: str}
+		},
+	),
+	// string_builder() で空のStringBuilderを作る。sb.append(x) で末尾に書き足し、sb.build() でそれまでの文字列を返す。
+	// ループで s = s + piece を繰り返すと全体の長さの二乗の時間がかかるので、代わりにこれを使う。
+	"string_builder": builtin("string_builder", args()).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.StringBuilder{}
+		},
+	),
+	// printf("x = {}", x) でformatした文字列を出力する。putsと違い改行はしない。
+	"printf": builtin("printf", args(STRING, variadic(ANY))).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			str, err := formatArgs("printf", args)
+			if err != nil {
+				return err
+			}
+			io.WriteString(env.Output(), str)
+			return NULL
+		},
+	),
+}
+
+// formatとprintfの引数から文字列を作る。最初の引数が書式で、残りが {} に入る値。引数の型はbuiltinの指定で確かめてある。
+func formatArgs(name string, args []object.Object) (string, *object.Error) {
+	format := args[0].(*object.String)
+	values := args[1:]
+
+	var out strings.Builder
+	used := 0
+	runes := []rune(format.Value)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case (r == '{' && next == '{') || (r == '}' && next == '}'):
+			out.WriteRune(r)
+			i++
+		case r == '{' && next == '}':
+			if used >= len(values) {
+				return "", newError("%s: not enough values for the format. got=%d", name, len(values))
+			}
+			out.WriteString(values[used].Inspect())
+			used++
+			i++
+		case r == '{' || r == '}':
+			return "", newError("%s: unmatched %c in the format", name, r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	if used != len(values) {
+		return "", newError("%s: too many values for the format. got=%d, want=%d", name, len(values), used)
+	}
+	return out.String(), nil
+}
+
+// builtinの指定で文字列だと確かめた引数を、goの文字列にする。
+func stringValues(args []object.Object) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = arg.(*object.String).Value
+	}
+	return values
+}
@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"monkey/object"
+	"strings"
+	"sync"
+)
+
+// Monkeyでテストを書くための組み込み関数。Builtinsに登録される。
+// assertが失敗した場合は評価を中断するエラーになるので、呼び出し元をさかのぼったスタックが積まれる。
+var testingBuiltins = map[string]*object.Builtin{
+	// assert(cond) または assert(cond, msg) で、condがtruthyでなければエラーにする。
+	"assert": builtin("assert", args(ANY, optional(ANY))).Fn(
+		func(args ...object.Object) object.Object {
+			if isTruthy(args[0]) {
+				return NULL
+			}
+			if len(args) == 1 {
+				return newError("assertion failed")
+			}
+			if msg, ok := args[1].(*object.String); ok {
+				return newError("assertion failed: %s", msg.Value)
+			}
+			return newError("assertion failed: %s", args[1].Inspect())
+		},
+	),
+	// assert_eq(actual, expected) で、二つの値が等しくなければエラーにする。
+	// 配列とハッシュは中身を比べる。
+	"assert_eq": builtin("assert_eq", args(ANY, ANY)).Fn(
+		func(args ...object.Object) object.Object {
+			if deepEqual(args[0], args[1]) {
+				return NULL
+			}
+			return newError("assertion failed: %s != %s", args[0].Inspect(), args[1].Inspect())
+		},
+	),
+}
+
+// 配列とハッシュは要素ごとに比べる。それ以外はobjectsEqualと同じ。
+func deepEqual(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Array:
+		b, ok := b.(*object.Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !deepEqual(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b, ok := b.(*object.Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !deepEqual(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return objectsEqual(a, b)
+}
+
+// test("name", fn) で登録されたテスト。
+type TestCase struct {
+	Name string
+	Fn   object.Object
+}
+
+// テストを実行した結果。Errは失敗した場合のエラーで、成功した場合はnil。
+type TestResult struct {
+	Name string
+	Err  object.Object
+}
+
+// test("name", fn) で登録されたテストを集めておき、まとめて実行する。
+// 複数のgoroutineからtestを呼んでもよいように、登録されたテストはmuで守る。
+type TestSuite struct {
+	Out   io.Writer // 結果の出力先。nilの場合はrun_testsを呼んだ環境の出力先(Environment.Output)
+	mu    sync.Mutex
+	cases []TestCase
+}
+
+func NewTestSuite() *TestSuite {
+	return &TestSuite{}
+}
+
+// Builtinsのtestとrun_testsが使うテストの登録先。monkeyコマンドとREPLはこれを使う。
+// interp.Newで作ったインタプリタは、それぞれ自分の登録先を持つ。
+var Tests = NewTestSuite()
+
+func (s *TestSuite) Add(name string, fn object.Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cases = append(s.cases, TestCase{Name: name, Fn: fn})
+}
+
+func (s *TestSuite) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cases)
+}
+
+// 登録されたテストを登録された順に実行し、一つずつ結果を出力する。最後に成功と失敗の数を出力して返す。
+// 実行したテストは登録から取り除かれる。
+func (s *TestSuite) Run(env *object.Environment) (passed, failed int) {
+	out := s.Out
+	if out == nil {
+		out = env.Output()
+	}
+
+	// テストの実行中に登録されたテストは、次のRunで実行する
+	s.mu.Lock()
+	cases := s.cases
+	s.cases = nil
+	s.mu.Unlock()
+	for _, tc := range cases {
+		result := s.runCase(env, tc)
+		if result.Err == nil {
+			passed++
+			fmt.Fprintf(out, "PASS %s\n", result.Name)
+			continue
+		}
+		failed++
+		// エラーのスタックは行を字下げして続ける
+		msg := strings.Replace(result.Err.Inspect(), "\n", "\n    ", -1)
+		fmt.Fprintf(out, "FAIL %s\n    %s\n", result.Name, msg)
+	}
+	fmt.Fprintf(out, "%d passed, %d failed\n", passed, failed)
+	return passed, failed
+}
+
+func (s *TestSuite) runCase(env *object.Environment, tc TestCase) TestResult {
+	result := applyFunction(env, tc.Fn, []object.Object{})
+	if isError(result) {
+		return TestResult{Name: tc.Name, Err: result}
+	}
+	return TestResult{Name: tc.Name}
+}
+
+// testとrun_testsを、suiteにテストを登録するようにして登録する。
+func RegisterTestBuiltins(r *BuiltinRegistry, suite *TestSuite) {
+	// test("name", fn) でテストを登録する。テストはrun_tests()を呼ぶまで実行されない。
+	r.RegisterValue("test", builtin("test", args(STRING, FUNCTION)).Fn(
+		func(args ...object.Object) object.Object {
+			suite.Add(args[0].(*object.String).Value, args[1])
+			return NULL
+		},
+	))
+	// run_tests() で登録されたテストを実行し、{"passed": 成功した数, "failed": 失敗した数} を返す。
+	r.RegisterValue("run_tests", builtin("run_tests", args()).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			passed, failed := suite.Run(env)
+			result := object.NewHash()
+			result.Set(object.NewString("passed"), object.NewInteger(int64(passed)))
+			result.Set(object.NewString("failed"), object.NewInteger(int64(failed)))
+			return result
+		},
+	))
+}
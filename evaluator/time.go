@@ -0,0 +1,54 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"time"
+)
+
+// clock()の起点。goのtime.Timeは単調時計の値も持っているので、time.Sinceはシステムの時刻が変わってもずれない。
+var processStart = time.Now()
+
+// 時刻を扱う組み込み関数。Builtinsに登録される。
+// 時刻はUNIX時間のミリ秒の整数で表す。
+var timeBuiltins = map[string]*object.Builtin{
+	// now() で現在の時刻をUNIX時間のミリ秒で返す。
+	"now": builtin("now", args()).Fn(
+		func(args ...object.Object) object.Object {
+			return object.NewInteger(time.Now().UnixNano() / int64(time.Millisecond))
+		},
+	),
+	// clock() でプロセスが起動してからの経過時間をミリ秒の小数で返す。
+	// nowと違いシステムの時刻の変更の影響を受けないので、処理にかかった時間を測るのに使う。
+	"clock": builtin("clock", args()).Fn(
+		func(args ...object.Object) object.Object {
+			return &object.Float{Value: float64(time.Since(processStart)) / float64(time.Millisecond)}
+		},
+	),
+	// sleep(ms) で指定したミリ秒だけ止まる。
+	// 環境のContextがキャンセルされた場合は、その時点で待つのをやめてエラーを返す。
+	"sleep": builtin("sleep", args(NUMBER)).FnEnv(
+		func(env *object.Environment, args ...object.Object) object.Object {
+			d := time.Duration(toFloat(args[0]) * float64(time.Millisecond))
+			if d <= 0 {
+				return NULL
+			}
+			ctx := env.Context()
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				return NULL
+			case <-ctx.Done():
+				return newError("sleep interrupted: %s", ctx.Err())
+			}
+		},
+	),
+	// format_time(ts, layout) でUNIX時間のミリ秒をUTCの時刻として、goのレイアウト("2006-01-02 15:04:05"など)で文字列にする。
+	"format_time": builtin("format_time", args(INTEGER, STRING)).Fn(
+		func(args ...object.Object) object.Object {
+			ts := args[0].(*object.Integer).Value
+			t := time.Unix(0, ts*int64(time.Millisecond)).UTC()
+			return &object.String{Value: t.Format(args[1].(*object.String).Value)}
+		},
+	),
+}
@@ -0,0 +1,64 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/object"
+	"reflect"
+	"strings"
+)
+
+// 評価の様子を外から見るためのフック。WithTracerでContextに設定すると、Evalがノードを評価する前後に呼ばれる。
+// 評価の可視化やカバレッジ、デバッガなどを、evaluatorを変更せずに作るのに使う。
+type Tracer interface {
+	// nodeをenvで評価する前に呼ばれる
+	Enter(node ast.Node, env *object.Environment)
+	// nodeを評価した後に呼ばれる。resultは評価の結果で、文のように値を返さないノードではnil
+	Exit(node ast.Node, result object.Object)
+}
+
+// TracerがCallTracerも実装していれば、関数を呼び出す前後にCallとReturnが呼ばれる。
+// 組み込み関数やメソッドの呼び出し、mapなどの組み込み関数から呼び出されたコールバックも含む。
+type CallTracer interface {
+	Call(fn object.Object, args []object.Object)
+	Return(fn object.Object, result object.Object)
+}
+
+// 評価したノードとその結果を、入れ子に合わせて字下げしながらOutに書き出すTracer。
+//
+//	InfixExpression 1:1 (1 + 2)
+//	  IntegerLiteral 1:1 1
+//	  => 1
+//	  IntegerLiteral 1:5 2
+//	  => 2
+//	=> 3
+type PrintTracer struct {
+	Out   io.Writer
+	depth int
+}
+
+func NewPrintTracer(out io.Writer) *PrintTracer {
+	return &PrintTracer{Out: out}
+}
+
+func (t *PrintTracer) Enter(node ast.Node, env *object.Environment) {
+	fmt.Fprintf(t.Out, "%s%s %s %s\n", t.indent(), nodeTypeName(node), node.Pos(), node.String())
+	t.depth++
+}
+
+func (t *PrintTracer) Exit(node ast.Node, result object.Object) {
+	t.depth--
+	if result != nil {
+		fmt.Fprintf(t.Out, "%s=> %s\n", t.indent(), result.Inspect())
+	}
+}
+
+func (t *PrintTracer) indent() string {
+	return strings.Repeat("  ", t.depth)
+}
+
+// *ast.InfixExpression なら InfixExpression
+func nodeTypeName(node ast.Node) string {
+	return reflect.TypeOf(node).Elem().Name()
+}
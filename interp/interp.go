@@ -0,0 +1,368 @@
+// interpは、GoのプログラムにMonkeyを組み込むためのパッケージ。
+// 字句解析、パース、評価器やVMの組み立てをInterpreterの中に隠し、文字列やファイルを実行して値をやり取りするだけで使えるようにする。
+//
+//	in, err := interp.New(interp.WithOutput(&buf), interp.WithStepLimit(100000))
+//	if err != nil { ... }
+//	if _, err := in.EvalString(ctx, "let double = fn(x) { x * 2 };"); err != nil { ... }
+//	result, err := in.Call(ctx, "double", object.NewInteger(21)) // 42
+package interp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey/ast"
+	"monkey/engine"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"sync"
+)
+
+// 一つのプログラムの実行環境。トップレベルで束縛した変数は、続けて実行したプログラムやCall、Getから参照できる。
+// 同時に一つのプログラムしか実行しないので、複数のgoroutineから使ってよい。
+type Interpreter struct {
+	mu       sync.Mutex
+	env      *object.Environment
+	engine   engine.Engine
+	registry *evaluator.BuiltinRegistry
+
+	maxSteps  int64
+	maxMemory int64
+	hooks     []evaluator.CallHook
+	settings  []func(context.Context) context.Context // WithStrictConditionsなどで指定した評価の仕方
+}
+
+// Newに渡す設定。
+type Option func(*config)
+
+type config struct {
+	engine      string
+	output      io.Writer
+	errOutput   io.Writer
+	input       io.Reader
+	importPaths []string
+	builtins    map[string]object.Object
+	maxSteps    int64
+	maxMemory   int64
+	policy      *Policy
+	hooks       []evaluator.CallHook
+	settings    []func(context.Context) context.Context
+	err         error // WithFuncに関数ではない値が渡された場合のエラー
+}
+
+// 実行するエンジンを engine.Eval か engine.VM から選ぶ。デフォルトは engine.Eval。
+func WithEngine(name string) Option {
+	return func(c *config) { c.engine = name }
+}
+
+// puts や print の出力先。デフォルトは標準出力。
+func WithOutput(w io.Writer) Option {
+	return func(c *config) { c.output = w }
+}
+
+// eputs の出力先。デフォルトは標準エラー出力。
+func WithErrorOutput(w io.Writer) Option {
+	return func(c *config) { c.errOutput = w }
+}
+
+// input() が読む入力元。デフォルトは標準入力。
+func WithInput(r io.Reader) Option {
+	return func(c *config) { c.input = r }
+}
+
+// import("lib") で相対パスのファイルを探すディレクトリ。前から順に探す。
+// 指定しなければ、コマンドと同じくevaluator.Importsを使う。
+func WithImportPaths(paths ...string) Option {
+	return func(c *config) { c.importPaths = paths }
+}
+
+// このInterpreterだけで使える組み込み関数を追加する。同じ名前の組み込み関数があれば置き換える。
+func WithBuiltin(name string, fn object.BuiltinFunction) Option {
+	return func(c *config) { c.builtins[name] = &object.Builtin{Fn: fn} }
+}
+
+// goの関数fnを、このInterpreterだけで使える組み込み関数として追加する。引数と返り値の変換はRegisterFuncと同じ。
+func WithFunc(name string, fn interface{}) Option {
+	return func(c *config) {
+		builtin, err := evaluator.NewGoFunc(name, fn)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.builtins[name] = builtin
+	}
+}
+
+// 組み込み関数とユーザー定義の関数を呼び出す前後に、hookのBeforeとAfterを呼ぶ。
+// 何度も指定した場合は、指定した順にBeforeを呼ぶ。呼び出しの記録や回数の制限、関数の差し替えに使う。
+//
+//	interp.WithCallHook(evaluator.CallHook{
+//		After: func(ctx context.Context, call *evaluator.Call, result object.Object, elapsed time.Duration) {
+//			log.Printf("%s(%d args) took %s", call.Name, len(call.Args), elapsed)
+//		},
+//	})
+func WithCallHook(hook evaluator.CallHook) Option {
+	return func(c *config) { c.hooks = append(c.hooks, hook) }
+}
+
+// 一回の実行で、関数呼び出しとループの一周の合計がmax回を超えたら中断する。
+func WithStepLimit(max int64) Option {
+	return func(c *config) { c.maxSteps = max }
+}
+
+// 一回の実行で、作った配列や文字列などの合計がおよそmaxバイトを超えたら中断する。
+func WithMemoryLimit(max int64) Option {
+	return func(c *config) { c.maxMemory = max }
+}
+
+// 割り切れない整数同士の割り算を、modeで計算する。evaluator.WithIntegerDivisionと同じ。
+func WithIntegerDivision(mode evaluator.DivisionMode) Option {
+	return func(c *config) {
+		c.settings = append(c.settings, func(ctx context.Context) context.Context {
+			return evaluator.WithIntegerDivision(ctx, mode)
+		})
+	}
+}
+
+// ifの条件と ! の右側に true か false 以外を書くと、型のエラーにする。evaluator.WithStrictConditionsと同じ。
+func WithStrictConditions() Option {
+	return func(c *config) { c.settings = append(c.settings, evaluator.WithStrictConditions) }
+}
+
+// 配列とハッシュを、構造を共有する変更できない値（VectorとMap）にする。evaluator.WithPersistentCollectionsと同じ。
+func WithPersistentCollections() Option {
+	return func(c *config) { c.settings = append(c.settings, evaluator.WithPersistentCollections) }
+}
+
+// 評価したノードごとにtracerを呼ぶ。evaluator.WithTracerと同じ。ノードを辿るのは評価器だけなので、engine.VMではEnterとExitは呼ばれない。
+func WithTracer(tracer evaluator.Tracer) Option {
+	return func(c *config) {
+		c.settings = append(c.settings, func(ctx context.Context) context.Context {
+			return evaluator.WithTracer(ctx, tracer)
+		})
+	}
+}
+
+// 設定に従ってInterpreterを作る。知らないエンジンの名前を指定した場合はエラーを返す。
+func New(opts ...Option) (*Interpreter, error) {
+	c := &config{engine: engine.Eval, builtins: make(map[string]object.Object)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	registry := evaluator.NewBuiltinRegistry(evaluator.Builtins)
+	// testで登録したテストは、他のインタプリタのrun_testsから実行されないようにインタプリタごとに持つ
+	evaluator.RegisterTestBuiltins(registry, evaluator.NewTestSuite())
+	var importer *evaluator.Importer
+	if c.importPaths != nil {
+		importer = evaluator.NewImporter(c.importPaths...)
+	} else if c.policy != nil && !c.policy.AllowFS {
+		// evaluator.Importsはカレントディレクトリを探すので、ファイルを許可しない場合は使わない
+		importer = evaluator.NewImporter()
+	}
+	if importer != nil {
+		evaluator.RegisterImportBuiltin(registry, importer)
+	}
+	if c.policy != nil {
+		c.policy.Apply(registry, importer)
+	}
+	// 組み込み先が追加した組み込み関数は、ポリシーで制限しないように、ポリシーを適用した登録簿の子に登録する
+	registry = evaluator.NewBuiltinRegistry(registry)
+	for name, value := range c.builtins {
+		registry.RegisterValue(name, value)
+	}
+
+	env := object.NewEnvironment()
+	env.SetBuiltins(registry)
+	if c.output != nil {
+		env.SetOutput(c.output)
+	}
+	if c.errOutput != nil {
+		env.SetErrorOutput(c.errOutput)
+	}
+	if c.input != nil {
+		env.SetInput(c.input)
+	}
+
+	eng, err := engine.New(c.engine, env)
+	if err != nil {
+		return nil, err
+	}
+	return &Interpreter{
+		env:       env,
+		engine:    eng,
+		registry:  registry,
+		maxSteps:  c.maxSteps,
+		maxMemory: c.maxMemory,
+		hooks:     c.hooks,
+		settings:  c.settings,
+	}, nil
+}
+
+// スクリプトの実行が、エラーやcatchされなかった例外、exit()で止まったときに返すエラー。
+type Error struct {
+	Value object.Object // *object.Error、*object.Exception、*object.Exit のどれか
+}
+
+func (e *Error) Error() string {
+	return e.Value.Inspect()
+}
+
+// exit(code) で止まった場合は、その終了コードとtrueを返す。
+func (e *Error) ExitCode() (int, bool) {
+	if exit, ok := e.Value.(*object.Exit); ok {
+		return int(exit.Code), true
+	}
+	return 0, false
+}
+
+// srcを実行して、最後の文の値を返す。値のない文で終わった場合はNULLを返す。
+// パースエラーの場合は実行せずにエラーを返し、実行がエラーで止まった場合は*Errorを返す。
+// ctxがキャンセルされると、実行を中断してエラーを返す。
+func (in *Interpreter) EvalString(ctx context.Context, src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, fmt.Errorf("parse error: %s", strings.Join(errs, "; "))
+	}
+	return in.Run(ctx, program)
+}
+
+// ASTを直接実行する。engine.Cacheでパースしたプログラムを使い回す場合などに使う。
+func (in *Interpreter) Run(ctx context.Context, program *ast.Program) (object.Object, error) {
+	return in.run(ctx, func(ctx context.Context) object.Object {
+		return in.engine.Run(ctx, program)
+	})
+}
+
+// pathのファイルを読んで、EvalStringと同じように実行する。
+func (in *Interpreter) EvalFile(ctx context.Context, path string) (object.Object, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := in.EvalString(ctx, string(src))
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return result, err
+}
+
+// トップレベルの変数nameに束縛された関数を、argsを引数にして呼び出す。
+// 組み込み関数の名前を指定すれば、組み込み関数も呼び出せる。
+func (in *Interpreter) Call(ctx context.Context, name string, args ...object.Object) (object.Object, error) {
+	in.mu.Lock()
+	fn, ok := in.engine.Get(name)
+	if !ok {
+		fn, ok = in.registry.LookupBuiltin(name)
+	}
+	in.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", name)
+	}
+
+	return in.run(ctx, func(ctx context.Context) object.Object {
+		return evaluator.Apply(in.env.WithContext(ctx), fn, args)
+	})
+}
+
+// goの関数fnを、nameという名前の組み込み関数として登録する。同じ名前の組み込み関数があれば置き換える。
+//
+//	in.RegisterFunc("distance", func(x, y float64) float64 { return math.Hypot(x, y) })
+//
+// 引数は関数の引数の型に変換し、配列やハッシュはobject.ToGoと同じようにスライスやmap、構造体にする。
+// 返り値はobject.FromGoでMonkeyの値にする。返り値が複数ある場合は配列にまとめる。
+// 最後の返り値がerrorなら、nilでなければスクリプトの側ではエラーになる。
+// 最初の引数がcontext.Contextなら、実行中のContextが渡される。
+// 引数の数や型が合わない呼び出しは、関数を呼ばずにエラーになる。fnが関数でなければエラーを返す。
+func (in *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	builtin, err := evaluator.NewGoFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	in.registry.RegisterValue(name, builtin)
+	return nil
+}
+
+// goの構造体のポインタvを、トップレベルの変数nameに束縛する。
+// スクリプトからは v.Name でエクスポートされたフィールドの参照と代入が、 v.Method(args) でメソッドの呼び出しができる。
+// 値はコピーしないので、スクリプトでの変更はgoの側の値にも反映される。
+// membersを渡した場合は、その名前のフィールドとメソッドだけを使えるようにする。
+//
+//	in.Bind("player", &player, "Name", "HP", "Heal")
+func (in *Interpreter) Bind(name string, v interface{}, members ...string) error {
+	return in.Set(name, object.NewGoValue(v, members...))
+}
+
+// これから実行するプログラムの puts や print の出力先を変える。実行ごとに出力を受け取るのに使う。
+// 実行中のプログラムがあれば、それが終わるのを待ってから変える。
+func (in *Interpreter) SetOutput(w io.Writer) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.env.SetOutput(w)
+}
+
+// これから実行するプログラムの eputs の出力先を変える。
+func (in *Interpreter) SetErrorOutput(w io.Writer) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.env.SetErrorOutput(w)
+}
+
+// これから実行するプログラムの input() が読む入力元を変える。前の入力元で読み残した分は捨てる。
+func (in *Interpreter) SetInput(r io.Reader) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.env.SetInput(r)
+}
+
+// トップレベルの変数nameの値。束縛されていなければfalse。
+func (in *Interpreter) Get(name string) (object.Object, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.engine.Get(name)
+}
+
+// トップレベルの変数nameにvalueを束縛する。スクリプトにGoの側から値を渡すのに使う。
+// constで束縛された変数は上書きせず、エラーを返す。
+func (in *Interpreter) Set(name string, value object.Object) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.engine.Set(name, value)
+}
+
+// 一回の実行の上限をctxに設定し、そのctxでrunを呼ぶ。envのContextは変更しないので、runはctxで評価すること。
+func (in *Interpreter) run(ctx context.Context, run func(ctx context.Context) object.Object) (object.Object, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.maxSteps > 0 {
+		ctx = evaluator.WithStepLimit(ctx, in.maxSteps)
+	}
+	if in.maxMemory > 0 {
+		ctx = evaluator.WithMemoryLimit(ctx, in.maxMemory)
+	}
+	if len(in.hooks) > 0 {
+		ctx = evaluator.WithCallHooks(ctx, in.hooks...)
+	}
+	for _, set := range in.settings {
+		ctx = set(ctx)
+	}
+	result := run(ctx)
+	if result == nil {
+		return object.NULL, nil
+	}
+	if evaluator.IsError(result) {
+		return nil, &Error{Value: result}
+	}
+	return result, nil
+}
@@ -0,0 +1,634 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"monkey/engine"
+	"monkey/evaluator"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newInterpreter(t *testing.T, opts ...Option) *Interpreter {
+	t.Helper()
+	in, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestEvalString(t *testing.T) {
+	for _, name := range engine.Names {
+		var out bytes.Buffer
+		in := newInterpreter(t, WithEngine(name), WithOutput(&out))
+		ctx := context.Background()
+
+		if _, err := in.EvalString(ctx, `let double = fn(x) { x * 2 }; puts("hi");`); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		result, err := in.EvalString(ctx, "double(4) + 1")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result.Inspect() != "9" {
+			t.Errorf("%s: wrong result. got=%s", name, result.Inspect())
+		}
+		if out.String() != "hi\n" {
+			t.Errorf("%s: wrong output. got=%q", name, out.String())
+		}
+
+		// 値のない文で終わった場合はNULL
+		result, err = in.EvalString(ctx, "let y = 1;")
+		if err != nil || result != object.NULL {
+			t.Errorf("%s: expected NULL. got=%v, %v", name, result, err)
+		}
+	}
+}
+
+func TestEvalStringErrors(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"let = 1;", "parse error: "},
+		{"1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"nope", "identifier not found: nope"},
+		{"exit(3)", "exit(3)"},
+	}
+
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name))
+		for _, tt := range tests {
+			_, err := in.EvalString(context.Background(), tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("%s: %s: expected error containing %q. got=%v", name, tt.src, tt.expected, err)
+			}
+		}
+	}
+
+	in := newInterpreter(t)
+	if _, err := in.EvalString(context.Background(), `throw "boom"`); err == nil || !strings.Contains(err.Error(), "uncaught exception: boom") {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	_, err := in.EvalString(context.Background(), "exit(3)")
+	if code, ok := err.(*Error).ExitCode(); !ok || code != 3 {
+		t.Errorf("wrong exit code. got=%d, %t", code, ok)
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name))
+		ctx := context.Background()
+
+		if _, err := in.EvalString(ctx, "let add = fn(a, b) { a + b };"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		result, err := in.Call(ctx, "add", object.NewInteger(2), object.NewInteger(3))
+		if err != nil || result.Inspect() != "5" {
+			t.Errorf("%s: add(2, 3) = %v, %v", name, result, err)
+		}
+
+		// 組み込み関数も呼び出せる
+		result, err = in.Call(ctx, "len", object.NewString("abc"))
+		if err != nil || result.Inspect() != "3" {
+			t.Errorf("%s: len(\"abc\") = %v, %v", name, result, err)
+		}
+
+		if _, err := in.Call(ctx, "add", object.NewInteger(2), object.TRUE); err == nil {
+			t.Errorf("%s: expected an error from add(2, true)", name)
+		}
+		if _, err := in.Call(ctx, "missing"); err == nil || err.Error() != "missing is not defined" {
+			t.Errorf("%s: wrong error. got=%v", name, err)
+		}
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name))
+		ctx := context.Background()
+
+		if err := in.Set("limit", object.NewInteger(10)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := in.EvalString(ctx, "let total = limit * 2; const fixed = 1;"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		total, ok := in.Get("total")
+		if !ok || total.Inspect() != "20" {
+			t.Errorf("%s: total = %v, %t", name, total, ok)
+		}
+		if _, ok := in.Get("undefined"); ok {
+			t.Errorf("%s: undefined is bound", name)
+		}
+		if err := in.Set("fixed", object.NewInteger(2)); err == nil || err.Error() != "cannot assign to constant: fixed" {
+			t.Errorf("%s: wrong error. got=%v", name, err)
+		}
+
+		// 上書きした値が次の実行から見える
+		if err := in.Set("limit", object.NewInteger(1)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		result, err := in.EvalString(ctx, "limit")
+		if err != nil || result.Inspect() != "1" {
+			t.Errorf("%s: limit = %v, %v", name, result, err)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+
+	in := newInterpreter(t, WithBuiltin("answer", func(args ...object.Object) object.Object {
+		return object.NewInteger(42)
+	}), WithStepLimit(100))
+	result, err := in.EvalString(ctx, "answer()")
+	if err != nil || result.Inspect() != "42" {
+		t.Errorf("answer() = %v, %v", result, err)
+	}
+	// 上限は一回の実行ごと
+	for i := 0; i < 2; i++ {
+		_, err = in.EvalString(ctx, "let loop = fn(n) { if (n > 0) { loop(n - 1) } }; loop(60)")
+		if err != nil {
+			t.Fatalf("loop(60): %v", err)
+		}
+	}
+	if _, err = in.EvalString(ctx, "loop(200)"); err == nil || !strings.Contains(err.Error(), "exceeded 100 operations") {
+		t.Errorf("expected the step limit error. got=%v", err)
+	}
+
+	// 差し替えた組み込み関数は、メソッドとして呼び出しても使われる
+	for _, name := range engine.Names {
+		mocked := newInterpreter(t, WithEngine(name), WithBuiltin("len", func(args ...object.Object) object.Object {
+			return object.NewInteger(-1)
+		}))
+		result, err := mocked.EvalString(ctx, `"abc".len()`)
+		if err != nil || result.Inspect() != "-1" {
+			t.Errorf("%s: \"abc\".len() = %v, %v", name, result, err)
+		}
+	}
+
+	// 組み込み関数は、そのInterpreterだけに追加される
+	other := newInterpreter(t)
+	if _, err := other.EvalString(ctx, "answer()"); err == nil {
+		t.Errorf("answer is visible from another interpreter")
+	}
+
+	if _, err := New(WithEngine("jit")); err == nil {
+		t.Errorf("expected an error for an unknown engine")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := in.EvalString(cancelled, "1"); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("expected an interrupted error. got=%v", err)
+	}
+}
+
+func TestEvalFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("lib.mk", "let greet = fn(name) { \"hello \" + name };")
+	main := write("main.mk", `let lib = import("lib.mk"); lib.greet("go")`)
+	broken := write("broken.mk", "let = ;")
+
+	in := newInterpreter(t, WithImportPaths(dir))
+	result, err := in.EvalFile(context.Background(), main)
+	if err != nil || result.Inspect() != "hello go" {
+		t.Errorf("EvalFile = %v, %v", result, err)
+	}
+
+	if _, err := in.EvalFile(context.Background(), broken); err == nil || !strings.HasPrefix(err.Error(), broken+": parse error") {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if _, err := in.EvalFile(context.Background(), filepath.Join(dir, "missing.mk")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+// importしたモジュールの関数は、importした実行ではなく呼び出した実行のContextと上限で実行される
+func TestImportedFunctionUsesCallerContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib := "let sum = fn(n) { let total = 0; for (i in range(n)) { total = total + i }; total };"
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib.mk"), []byte(lib), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name), WithImportPaths(dir), WithStepLimit(100))
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := in.EvalString(ctx, `let lib = import("lib.mk");`); err != nil {
+			t.Fatalf("%s: import failed: %s", name, err)
+		}
+		cancel()
+
+		// 一回の実行の上限には収まる呼び出しを、別々の実行で繰り返す
+		for i := 0; i < 3; i++ {
+			result, err := in.EvalString(context.Background(), "lib.sum(50)")
+			if err != nil || result.Inspect() != "1225" {
+				t.Errorf("%s: call %d = %v, %v", name, i, result, err)
+			}
+		}
+	}
+}
+
+type point struct {
+	X, Y float64
+}
+
+func TestRegisterFunc(t *testing.T) {
+	funcs := map[string]interface{}{
+		"distance": func(x, y float64) float64 { return math.Hypot(x, y) },
+		"divide": func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"divmod": func(a, b int) (int, int) { return a / b, a % b },
+		"sum": func(xs ...int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"centroid": func(ps []point) point {
+			var c point
+			for _, p := range ps {
+				c.X += p.X / float64(len(ps))
+				c.Y += p.Y / float64(len(ps))
+			}
+			return c
+		},
+		"count": func(m map[string][]string) map[string]int {
+			out := map[string]int{}
+			for k, v := range m {
+				out[k] = len(v)
+			}
+			return out
+		},
+		"deadline": func(ctx context.Context) bool {
+			_, ok := ctx.Deadline()
+			return ok
+		},
+		"nothing": func() {},
+		"boom":    func(xs []int) int { return xs[10] },
+	}
+
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"distance(3, 4)", "5.0"},
+		{"divide(7, 2)", "3"},
+		{"divmod(7, 2)", "[3, 1]"},
+		{"sum()", "0"},
+		{"sum(1, 2, 3)", "6"},
+		{`centroid([{"X": 0, "Y": 0}, {"X": 2, "Y": 4}])`, "{X: 1.0, Y: 2.0}"},
+		{`count({"a": ["x", "y"], "b": []})`, "{a: 2, b: 0}"},
+		{"deadline()", "true"},
+		{"nothing()", "null"},
+	}
+
+	errorTests := []struct {
+		src      string
+		expected string
+	}{
+		{"divide(1, 0)", "division by zero"},
+		{"distance(1)", "distance: wrong number of arguments. got=1, want=2"},
+		{`distance("a", 1)`, "distance: cannot use STRING as float64"},
+		{`centroid([{"X": "a"}])`, "centroid: [0].X: cannot use STRING as float64"},
+		// goの関数がpanicしても、Interpreterを使っているプログラムは止まらない
+		{"boom([1])", "boom: panic: runtime error: index out of range"},
+	}
+
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name))
+		for fname, fn := range funcs {
+			if err := in.RegisterFunc(fname, fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		for _, tt := range tests {
+			result, err := in.EvalString(ctx, tt.src)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, tt.src, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: %s: expected=%s, got=%s", name, tt.src, tt.expected, result.Inspect())
+			}
+		}
+		for _, tt := range errorTests {
+			_, err := in.EvalString(ctx, tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("%s: %s: expected error containing %q. got=%v", name, tt.src, tt.expected, err)
+			}
+		}
+		cancel()
+	}
+
+	in := newInterpreter(t)
+	if err := in.RegisterFunc("bad", 42); err == nil || err.Error() != "bad: int is not a function" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if _, err := New(WithFunc("bad", "nope")); err == nil {
+		t.Errorf("expected an error from WithFunc")
+	}
+	withFunc := newInterpreter(t, WithFunc("twice", func(s string) string { return s + s }))
+	if result, err := withFunc.EvalString(context.Background(), `twice("ab")`); err != nil || result.Inspect() != "abab" {
+		t.Errorf("twice = %v, %v", result, err)
+	}
+}
+
+type player struct {
+	Name string
+	HP   int
+	Pos  point
+	key  string
+}
+
+func (p *player) Heal(n int) int {
+	p.HP += n
+	return p.HP
+}
+
+func (p *player) Reset() { p.HP = 0 }
+
+func TestBind(t *testing.T) {
+	for _, name := range engine.Names {
+		p := &player{Name: "alice", HP: 10}
+		in := newInterpreter(t, WithEngine(name))
+		if err := in.Bind("p", p); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := in.Bind("limited", p, "Name", "Heal"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		ctx := context.Background()
+		result, err := in.EvalString(ctx, `p.HP = p.HP + 5; p.Pos.X = 1.5; p.Heal(2); p.Name + " " + str(p.HP)`)
+		if err != nil || result.Inspect() != "alice 17" {
+			t.Errorf("%s: result = %v, %v", name, result, err)
+		}
+		if p.HP != 17 || p.Pos.X != 1.5 {
+			t.Errorf("%s: the Go value was not updated. got=%+v", name, p)
+		}
+		result, err = in.EvalString(ctx, `limited.Name = "bob"; limited.Heal(1)`)
+		if err != nil || result.Inspect() != "18" || p.Name != "bob" {
+			t.Errorf("%s: result = %v, %v, name=%s", name, result, err, p.Name)
+		}
+
+		errorTests := []struct {
+			src      string
+			expected string
+		}{
+			{"limited.HP", "cannot access HP of go(*interp.player)"},
+			{"limited.HP = 1", "cannot assign to HP of go(*interp.player)"},
+			{"limited.Reset()", "cannot access Reset of go(*interp.player)"},
+			{"p.key = 1", "cannot assign to key of go(*interp.player)"},
+			{`p.HP = "full"`, "HP: cannot use STRING as int"},
+		}
+		for _, tt := range errorTests {
+			_, err := in.EvalString(ctx, tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("%s: %s: expected error containing %q. got=%v", name, tt.src, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	libs := filepath.Join(dir, "libs")
+	if err := os.Mkdir(libs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(libs, "lib.mk"), []byte("let answer = 42;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret.mk")
+	if err := ioutil.WriteFile(secret, []byte("let key = 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name), WithPolicy(Untrusted), WithImportPaths(libs))
+		result, err := in.EvalString(ctx, `import("lib.mk").answer`)
+		if err != nil || result.Inspect() != "42" {
+			t.Errorf("%s: import = %v, %v", name, result, err)
+		}
+
+		errorTests := []struct {
+			src      string
+			expected string
+		}{
+			{`read_file("` + secret + `")`, "read_file: file access denied"},
+			{`os.write_file("x.txt", "data")`, "write_file: file access denied"},
+			{`import("` + secret + `")`, "import: file access denied"},
+			{`exec("ls")`, "exec: exec denied: ls"},
+			{`http.get("http://example.com")`, "http: network access denied: http://example.com"},
+			{`env("HOME")`, "env: environment access denied"},
+			{`os.set_env("X", "1")`, "set_env: environment access denied"},
+		}
+		for _, tt := range errorTests {
+			_, err := in.EvalString(ctx, tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("%s: %s: expected error containing %q. got=%v", name, tt.src, tt.expected, err)
+			}
+		}
+	}
+
+	// ファイルを許可しなければ、import先を指定しない限りimportもできない
+	in := newInterpreter(t, WithPolicy(Policy{}))
+	if _, err := in.EvalString(ctx, `import("`+secret+`")`); err == nil || !strings.Contains(err.Error(), "file access denied") {
+		t.Errorf("expected import to be denied. got=%v", err)
+	}
+
+	// 信頼できるスクリプトは全て使える
+	in = newInterpreter(t, WithPolicy(Trusted))
+	result, err := in.EvalString(ctx, `read_file("`+secret+`")`)
+	if err != nil || result.Inspect() != "let key = 1;" {
+		t.Errorf("read_file = %v, %v", result, err)
+	}
+
+	// 使える組み込み関数を絞っても、組み込み先が追加したものは使える
+	in = newInterpreter(t, WithPolicy(Policy{Builtins: []string{"len", "string.upper"}, MaxSteps: 50}),
+		WithFunc("twice", func(n int) int { return n * 2 }))
+	result, err = in.EvalString(ctx, `twice(len(string.upper("ab")))`)
+	if err != nil || result.Inspect() != "4" {
+		t.Errorf("result = %v, %v", result, err)
+	}
+	for _, src := range []string{`upper("a")`, `puts(1)`, `help()`} {
+		if _, err := in.EvalString(ctx, src); err == nil || !strings.Contains(err.Error(), "identifier not found") {
+			t.Errorf("%s: expected identifier not found. got=%v", src, err)
+		}
+	}
+	// 許可していない組み込み関数は、モジュールのメンバーやメソッドとしても使えない
+	for _, name := range engine.Names {
+		in := newInterpreter(t, WithEngine(name), WithPolicy(Policy{Builtins: []string{"puts", "string", "upper", "sort"}}))
+		for _, src := range []string{`string.lower("A")`, `let s = string; s.lower("A")`, `"abc".lower()`, `[1, 2].reverse()`} {
+			if _, err := in.EvalString(ctx, src); err == nil {
+				t.Errorf("%s: %s: expected an error", name, src)
+			}
+		}
+		result, err := in.EvalString(ctx, `[string.upper("a"), [3, 1, 2].sort(), "b".upper()]`)
+		if err != nil || result.Inspect() != "[A, [1, 2, 3], B]" {
+			t.Errorf("%s: result = %v, %v", name, result, err)
+		}
+	}
+	if _, err := in.EvalString(ctx, "let loop = fn(n) { loop(n + 1) }; loop(0)"); err == nil || !strings.Contains(err.Error(), "exceeded 50 operations") {
+		t.Errorf("expected the step limit error. got=%v", err)
+	}
+	// 後から指定したオプションが優先される
+	in = newInterpreter(t, WithPolicy(Policy{MaxSteps: 50}), WithStepLimit(0))
+	if _, err := in.EvalString(ctx, "let loop = fn(n) { if (n < 100) { loop(n + 1) } }; loop(0)"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// 他のInterpreterの組み込み関数は変わらない
+	in = newInterpreter(t)
+	if _, err := in.EvalString(ctx, `env("HOME"); upper("a")`); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// 評価の仕方の設定はInterpreterごとで、他のInterpreterには影響しない
+func TestSettings(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range engine.Names {
+		strict := newInterpreter(t, WithEngine(name), WithStrictConditions(), WithPersistentCollections(),
+			WithIntegerDivision(evaluator.FloatDivision))
+		plain := newInterpreter(t, WithEngine(name))
+
+		if _, err := strict.EvalString(ctx, "if (1) { 2 }"); err == nil || !strings.Contains(err.Error(), "condition must be BOOLEAN") {
+			t.Errorf("%s: expected a condition error. got=%v", name, err)
+		}
+		result, err := strict.EvalString(ctx, "[5 / 2]")
+		if _, ok := result.(*object.Vector); err != nil || !ok || result.Inspect() != "[2.5]" {
+			t.Errorf("%s: result = %v, %v", name, result, err)
+		}
+		result, err = plain.EvalString(ctx, "[if (1) { 2 }, 5 / 2]")
+		if _, ok := result.(*object.Array); err != nil || !ok || result.Inspect() != "[2, 2]" {
+			t.Errorf("%s: result = %v, %v", name, result, err)
+		}
+	}
+
+	tracer := evaluator.NewPrintTracer(new(bytes.Buffer))
+	in := newInterpreter(t, WithTracer(tracer))
+	if _, err := in.EvalString(ctx, "1 + 2"); err != nil {
+		t.Fatal(err)
+	}
+	if out := tracer.Out.(*bytes.Buffer).String(); !strings.Contains(out, "InfixExpression 1:1 (1 + 2)") {
+		t.Errorf("wrong trace. got=%q", out)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range engine.Names {
+		var out, errOut bytes.Buffer
+		in := newInterpreter(t, WithEngine(name), WithOutput(&out), WithErrorOutput(&errOut),
+			WithInput(strings.NewReader("alice\nbob\n")))
+
+		result, err := in.EvalString(ctx, `let a = input("who? "); eputs("read " + a); [a, input(), input()]`)
+		if err != nil || result.Inspect() != "[alice, bob, null]" {
+			t.Errorf("%s: result = %v, %v", name, result, err)
+		}
+		if out.String() != "who? " || errOut.String() != "read alice\n" {
+			t.Errorf("%s: wrong output. out=%q, err=%q", name, out.String(), errOut.String())
+		}
+
+		// 実行ごとに入出力を替えられる
+		var captured bytes.Buffer
+		in.SetOutput(&captured)
+		in.SetInput(strings.NewReader("carol"))
+		if _, err := in.EvalString(ctx, `puts(input())`); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if captured.String() != "carol\n" || out.String() != "who? " {
+			t.Errorf("%s: wrong output. captured=%q, out=%q", name, captured.String(), out.String())
+		}
+	}
+}
+
+// testで登録したテストは、登録したインタプリタのrun_testsだけが実行する
+func TestTestsPerInterpreter(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range engine.Names {
+		a := newInterpreter(t, WithEngine(name), WithOutput(ioutil.Discard))
+		b := newInterpreter(t, WithEngine(name), WithOutput(ioutil.Discard))
+		if _, err := a.EvalString(ctx, `test("a", fn() { assert(true) })`); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		result, err := b.EvalString(ctx, `run_tests()`)
+		if err != nil || result.Inspect() != "{passed: 0, failed: 0}" {
+			t.Errorf("%s: b ran tests of a. got=%v, %v", name, result, err)
+		}
+		result, err = a.EvalString(ctx, `run_tests()`)
+		if err != nil || result.Inspect() != "{passed: 1, failed: 0}" {
+			t.Errorf("%s: wrong result. got=%v, %v", name, result, err)
+		}
+	}
+}
+
+func TestCallHook(t *testing.T) {
+	for _, name := range engine.Names {
+		var calls []string
+		audit := WithCallHook(evaluator.CallHook{
+			After: func(ctx context.Context, call *evaluator.Call, result object.Object, elapsed time.Duration) {
+				calls = append(calls, call.Name+" => "+result.Inspect())
+			},
+		})
+		mock := WithCallHook(evaluator.CallHook{
+			Before: func(ctx context.Context, call *evaluator.Call) object.Object {
+				if call.Name == "now" {
+					return object.NewInteger(1000)
+				}
+				return nil
+			},
+		})
+		in := newInterpreter(t, WithEngine(name), audit, mock)
+
+		result, err := in.EvalString(context.Background(), `let twice = fn(x) { x * 2 }; twice(now())`)
+		if err != nil || result.Inspect() != "2000" {
+			t.Errorf("%s: result = %v, %v", name, result, err)
+		}
+		expected := []string{"now => 1000", "twice => 2000"}
+		if strings.Join(calls, ", ") != strings.Join(expected, ", ") {
+			t.Errorf("%s: wrong calls. got=%q", name, calls)
+		}
+	}
+}
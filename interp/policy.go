@@ -0,0 +1,103 @@
+package interp
+
+import (
+	"fmt"
+	"monkey/evaluator"
+	"monkey/object"
+	"strings"
+)
+
+// スクリプトに許可することをまとめた設定。WithPolicyでInterpreterに設定する。
+// 同じプログラムで、信頼できるスクリプトはTrustedで、ユーザーが送ってきたスクリプトはUntrustedで実行する、といった使い分けができる。
+// 許可しないものの組み込み関数は、呼び出すとエラーを返すものに置き換わる。
+type Policy struct {
+	AllowFS      bool // read_fileなどのファイルの読み書き。許可しなくても、WithImportPathsのディレクトリからはimportできる
+	AllowNetwork bool // httpモジュールでのリクエスト
+	AllowExec    bool // execでのコマンドの実行
+	AllowEnv     bool // envとset_envでの環境変数の読み書き
+
+	MaxSteps  int64 // WithStepLimitと同じ。0なら上限なし
+	MaxMemory int64 // WithMemoryLimitと同じ。0なら上限なし
+
+	// 使ってよい組み込み関数とモジュールの名前。nilなら全て使える。
+	// モジュールのメンバーは "string.upper" のように書くか、メンバーの組み込み関数の名前 "upper" を書くと使える。
+	// "string.upper" を書けばstringモジュール自体も使えるが、"string" だけではメンバーは使えない。
+	// "abc".upper() のようなメソッドも、組み込み関数の名前で許可されていなければ呼び出せない。
+	// WithBuiltinやRegisterFuncで追加した組み込み関数は、ここに含めなくても使える。
+	Builtins []string
+}
+
+// 全てを許可する。Policyを設定しない場合と同じ。
+var Trusted = Policy{AllowFS: true, AllowNetwork: true, AllowExec: true, AllowEnv: true}
+
+// 外の世界に触れることを全て禁止し、実行できる量も制限する。
+var Untrusted = Policy{MaxSteps: 10000000, MaxMemory: 64 << 20}
+
+// 組み込み関数と実行の上限をpolicyに従って制限する。同じ名前のオプションと一緒に使った場合は、後に書いた方が優先される。
+func WithPolicy(policy Policy) Option {
+	return func(c *config) {
+		c.policy = &policy
+		c.maxSteps = policy.MaxSteps
+		c.maxMemory = policy.MaxMemory
+	}
+}
+
+// rの組み込み関数を、pで許可されたことしかできないように置き換える。
+// Builtinsの制限はr.Restrictで設定するので、Apply後にrへ登録したものも制限される。制限したくないものはrを親にした登録簿に登録する。
+// importerを渡した場合は、ファイルを許可しなくてもimporter.Pathsのディレクトリのファイルだけはimportできるようにする。
+// 上限のMaxStepsとMaxMemoryは実行するときのContextに設定するものなので、ここでは扱わない。
+// Interpreterを使わずに、evaluator.Builtinsなどに直接ポリシーを適用するのに使う。
+func (p Policy) Apply(r *evaluator.BuiltinRegistry, importer *evaluator.Importer) {
+	if !p.AllowFS {
+		evaluator.RegisterFileBuiltins(r, evaluator.DenyAllFiles)
+		if importer != nil {
+			importer.Policy = importPathsOnly(importer)
+		}
+	}
+	if !p.AllowNetwork {
+		evaluator.RegisterHTTPBuiltins(r, evaluator.DenyAllNetwork)
+	}
+	if !p.AllowExec {
+		evaluator.RegisterExecBuiltins(r, evaluator.DenyAllExec)
+	}
+	if !p.AllowEnv {
+		for _, name := range []string{"env", "set_env"} {
+			denied := deniedBuiltin(name, "environment access denied")
+			r.RegisterValue(name, denied)
+			r.SetModuleMember("os", name, denied)
+		}
+	}
+
+	if p.Builtins != nil {
+		allowed := make(map[string]bool, len(p.Builtins))
+		for _, name := range p.Builtins {
+			allowed[name] = true
+			// "string.upper" を許可したら、stringモジュール自体も探せるようにする
+			if i := strings.Index(name, "."); i > 0 {
+				allowed[name[:i]] = true
+			}
+		}
+		r.Restrict(func(name string) bool { return allowed[name] })
+	}
+}
+
+// importerの探すディレクトリの中のファイルだけを読み込めるポリシー。Pathsを後から変えても、その時のPathsで判断する。
+func importPathsOnly(importer *evaluator.Importer) evaluator.FilePolicy {
+	return func(path string, write bool) error {
+		for _, dir := range importer.Paths {
+			if evaluator.AllowFilesUnder(dir)(path, write) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("file access denied: %s", path)
+	}
+}
+
+// 呼び出すと "name: message" のエラーを返す組み込み関数。
+func deniedBuiltin(name, message string) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			return &object.Error{Message: fmt.Sprintf("%s: %s", name, message)}
+		},
+	}
+}
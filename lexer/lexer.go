@@ -1,16 +1,23 @@
 package lexer
 
-import "monkey/token"
+import (
+	"monkey/token"
+	"strings"
+)
 
 type Lexer struct {
 	input        string // goのコード
 	position     int    // 入力における現在の位置（現在の文字を指し示す）
 	readPosition int    // これから読み込む位置（現在の文字の次）
 	ch           byte   // 現愛検査中の文字
+	line         int    // 現在の文字の行番号（1始まり）
+	column       int    // 現在の文字の列番号（1始まり）
+
+	operators map[string]token.TokenType // RegisterOperatorで追加された演算子
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
@@ -22,15 +29,32 @@ func (l *Lexer) NextToken() token.Token {
 	// これがあるかないかでspaceに意味を持たせるか持たせないかが決まる。
 	l.skipWhitespace()
 
+	// トークンの先頭の文字の位置を覚えておく。エラーメッセージなどでソースコード上の位置を示すのに使う。
+	line, column, offset := l.line, l.column, l.position
+
+	// 追加された演算子は組み込みのトークンより優先する。 ** を追加すれば * * ではなく ** として読まれる。
+	if literal, t, ok := l.matchOperator(); ok {
+		for i := 0; i < len(literal); i++ {
+			l.readChar()
+		}
+		return token.Token{Type: t, Literal: literal, Line: line, Column: column, Offset: offset, End: l.pos()}
+	}
+
 	switch l.ch {
 	case '=':
-		// = は単体でも使えるし、 == と使われることもある。
+		// = は単体でも使えるし、 == や => と使われることもある。
 		// そのため = が現れたら次の文字を覗き見して == であるかどうかを判定する。
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar() // 次の文字が = だったので、 == としてTokenを用意するためにポジションを読み進める。
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal}
+		} else if l.peekChar() == '>' {
+			// => はmatch式のアームで使う。
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.ARROW, Literal: literal}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -49,10 +73,44 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
+	case '?':
+		// ? は単体では使えない。 ?. （null安全なアクセス）か ?? （null合体演算子）のどちらかとして使う。
+		if l.peekChar() == '.' || l.peekChar() == '?' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			if l.ch == '.' {
+				tok = token.Token{Type: token.QUESTION_DOT, Literal: literal}
+			} else {
+				tok = token.Token{Type: token.NULLISH, Literal: literal}
+			}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '.':
+		// ... は可変長引数と配列の展開で使う。 . 単体はモジュールなどのメンバーへのアクセス。
+		if l.peekChar() == '.' && l.peekCharN(2) == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.DOT, l.ch)
+		}
 	case '/':
+		// // から行末まではコメント。 / 単体は割り算。
+		if l.peekChar() == '/' {
+			tok.Type = token.COMMENT
+			tok.Literal = l.readComment()
+			tok.Line, tok.Column, tok.Offset = line, column, offset
+			tok.End = l.pos()
+			// readCommentの中で行末まで読み進めているので、ここで即returnする。
+			return tok
+		}
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
@@ -92,14 +150,17 @@ func (l *Lexer) NextToken() token.Token {
 			// 読み進めた一塊の英字が予約語かどうか判定。
 			// 予約語だったら、予約語のTokenType、不明な英字ならユーザー定義の文字列のTokenType（IDENT）を返す
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Line, tok.Column, tok.Offset = line, column, offset
+			tok.End = l.pos()
 			// ここで即returnをしているのはreadIdentifierのなかで、すでにreadPositionを進めているから。
 			// switchの後のl.readChar()を呼ぶ必要がない。
 			return tok
 			// 数値だったら
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			// 数値で有る限り、バイトを読み進める。
-			tok.Literal = l.readNumber()
+			// 数値で有る限り、バイトを読み進める。小数点があればFLOAT、なければINT。
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Line, tok.Column, tok.Offset = line, column, offset
+			tok.End = l.pos()
 			// ここで即returnをしているのはreadNumberのなかで、すでにreadPositionを進めているから。
 			// switchの後のl.readChar()を呼ぶ必要がない。
 			return tok
@@ -111,9 +172,46 @@ func (l *Lexer) NextToken() token.Token {
 
 	// readPositionを次に進めておく。
 	l.readChar()
+	tok.Line, tok.Column, tok.Offset = line, column, offset
+	tok.End = l.pos()
+	if tok.Type == token.EOF {
+		// EOFは幅を持たない
+		tok.End = tok.Pos()
+	}
 	return tok
 }
 
+// 演算子を追加する。literalが入力に現れたら、トークンタイプtのトークンとして読む。
+// パーサーは生成時に先頭の2トークンを読んでしまうので、parser.Newより前に呼ぶこと。
+func (l *Lexer) RegisterOperator(literal string, t token.TokenType) {
+	if l.operators == nil {
+		l.operators = make(map[string]token.TokenType)
+	}
+	l.operators[literal] = t
+}
+
+// 現在の位置から始まる追加された演算子を探す。複数当てはまる場合は一番長いものを選ぶ。
+func (l *Lexer) matchOperator() (string, token.TokenType, bool) {
+	var (
+		literal string
+		t       token.TokenType
+	)
+	if l.ch == 0 {
+		return literal, t, false
+	}
+	for op, opType := range l.operators {
+		if len(op) > len(literal) && strings.HasPrefix(l.input[l.position:], op) {
+			literal, t = op, opType
+		}
+	}
+	return literal, t, literal != ""
+}
+
+// 現在の文字の位置。トークンを読み終えた直後に呼べば、そのトークンの直後の位置になる。
+func (l *Lexer) pos() token.Position {
+	return token.Position{Offset: l.position, Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -126,6 +224,13 @@ func (l *Lexer) readChar() {
 	// 	case 0:
 	//		tok.Literal = ""
 	//		tok.Type = token.EOF
+	// 読み終わった文字が改行なら次の行へ。
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -142,15 +247,38 @@ func (l *Lexer) readIdentifier() string {
 	for isLetter(l.ch) {
 		l.readChar()
 	}
+	// push! のように、名前の直後の ! は名前の一部とする。値を変更する組み込み関数の名前に使う。
+	// 名前の後に前置演算子の ! が来ることはないので、!= でなければ区別できる。
+	if l.ch == '!' && l.peekChar() != '=' {
+		l.readChar()
+	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// 行末（改行の手前）か入力の終わりまでをコメントとして読み進める。 // も含めて返す。
+func (l *Lexer) readComment() string {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	return strings.TrimRight(l.input[position:l.position], "\r")
+}
+
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+
+	// 小数点の後に数字が続く場合だけ小数とする。 1. や 1.. は小数ではない。
+	if l.ch != '.' || !isDigit(l.peekChar()) {
+		return l.input[position:l.position], token.INT
+	}
+	l.readChar() // . を読み飛ばす
+	for isDigit(l.ch) {
+		l.readChar()
+	}
+	return l.input[position:l.position], token.FLOAT
 }
 
 // 現在の文字が " （文字列リテラルの終端） か 0 (EOF) に達するまで、一つのSTRINGトークンとして読み進める
@@ -175,6 +303,15 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// n文字先を覗き見する。peekCharN(1)はpeekCharと同じ。
+func (l *Lexer) peekCharN(n int) byte {
+	pos := l.position + n
+	if pos >= len(l.input) {
+		return 0
+	}
+	return l.input[pos]
+}
+
 // letter（英字）
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
@@ -30,6 +30,11 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+for (x in y) {}
+const z = match (x) { 1 => 2, _ => 3 };
+null
+a?.b ?? c
+...rest
 `
 
 	tests := []struct {
@@ -122,6 +127,226 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.IN, "in"},
+		{token.IDENT, "y"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.CONST, "const"},
+		{token.IDENT, "z"},
+		{token.ASSIGN, "="},
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.ARROW, "=>"},
+		{token.INT, "2"},
+		{token.COMMA, ","},
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.INT, "3"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+		{token.NULL, "null"},
+		{token.IDENT, "a"},
+		{token.QUESTION_DOT, "?."},
+		{token.IDENT, "b"},
+		{token.NULLISH, "??"},
+		{token.IDENT, "c"},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "rest"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x == "a b";
+}`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 2, 3},
+		{token.EQ, 2, 5},
+		{token.STRING, 2, 8},
+		{token.SEMICOLON, 2, 13},
+		{token.RBRACE, 3, 1},
+		{token.EOF, 3, 2},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestRegisterOperator(t *testing.T) {
+	input := `2 ** 3 * 4 <=> 5`
+
+	l := New(input)
+	l.RegisterOperator("**", token.TokenType("**"))
+	l.RegisterOperator("<=>", token.TokenType("<=>"))
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "2"},
+		{token.TokenType("**"), "**"},
+		{token.INT, "3"},
+		{token.ASTERISK, "*"},
+		{token.INT, "4"},
+		{token.TokenType("<=>"), "<=>"},
+		{token.INT, "5"},
+		{token.EOF, ""},
+		{token.EOF, ""},
+	}
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenOffsets(t *testing.T) {
+	input := `let x = 5;
+  x == "a b";
+}`
+
+	tests := []struct {
+		expectedOffset    int
+		expectedEndOffset int
+	}{
+		{0, 3},   // let
+		{4, 5},   // x
+		{6, 7},   // =
+		{8, 9},   // 5
+		{9, 10},  // ;
+		{13, 14}, // x
+		{15, 17}, // ==
+		{18, 23}, // "a b" 閉じの " まで含む
+		{23, 24}, // ;
+		{25, 26}, // }
+		{26, 26}, // EOF
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Offset != tt.expectedOffset || tok.End.Offset != tt.expectedEndOffset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d-%d, got=%d-%d",
+				i, tt.expectedOffset, tt.expectedEndOffset, tok.Offset, tok.End.Offset)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// head
+let x = 10 / 2; // tail
+//`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// head"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// tail"},
+		{token.COMMENT, "//"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestFloatTokens(t *testing.T) {
+	input := `3.14 10. 1..2 0.5?.a`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.INT, "10"},
+		{token.DOT, "."},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.DOT, "."},
+		{token.INT, "2"},
+		{token.FLOAT, "0.5"},
+		{token.QUESTION_DOT, "?."},
+		{token.IDENT, "a"},
 		{token.EOF, ""},
 	}
 
@@ -141,3 +366,43 @@ if (5 < 10) {
 		}
 	}
 }
+
+// 名前の直後の ! は名前の一部になるが、 != は演算子のまま
+func TestBangIdentifiers(t *testing.T) {
+	input := `push!(a, 1); a.pop!(); a!=b; !a`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "push!"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.INT, "1"},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.DOT, "."},
+		{token.IDENT, "pop!"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.NOT_EQ, "!="},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+		{token.BANG, "!"},
+		{token.IDENT, "a"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
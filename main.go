@@ -1,13 +1,136 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"monkey/analysis"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/engine"
+	"monkey/evaluator"
+	"monkey/interp"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/vm"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// monkey [-json] [-color] でREPLを起動する。-colorでは評価結果とエラーに色を付ける。
+// monkey script.mk arg1 arg2 のようにファイルを渡すと、そのファイルを実行する。残りの引数はargs()で受け取れる。
+// monkey -test script.mk では、ファイルを実行した後にtest("name", fn)で登録されたテストを実行する。
+// monkey -timeout 5s script.mk では、実行が5秒を超えると中断して終了コード1で終わる。
+// monkey -max-steps 100000 script.mk では、関数呼び出しとループの一周の合計が100000回を超えると中断する。
+// monkey -max-memory 1000000 script.mk では、作った配列や文字列などの合計がおよそ1000000バイトを超えると中断する。
+// monkey -trace script.mk では、評価したノードとその結果を標準エラー出力に書き出す。
+// monkey -profile script.mk では、実行した後に関数ごとの呼び出し回数と時間を標準エラー出力に書き出す。
+// monkey -coverprofile cover.lcov script.mk では、実行した文をlcovの形式でcover.lcovに書き出す。-testと一緒に使う。
+// monkey -engine vm script.mk では、評価器の代わりにバイトコードにコンパイルしてVMで実行する。REPLでも使える。
+// monkey -persistent script.mk では、配列とハッシュが構造を共有する変更できない値（VectorとMap）になる。REPLでも使える。
+// monkey -sandbox script.mk では、interp.Untrustedのポリシーで実行する。ファイル（importは除く）、ネットワーク、コマンド、環境変数を使えず、
+// -max-stepsと-max-memoryを指定しなければ、ポリシーの上限で中断する。ユーザーが送ってきたスクリプトを実行するのに使う。REPLでは組み込み関数だけが制限される。
+// monkey -strict-conditions script.mk では、ifの条件と ! の右側が true でも false でもなければエラーになる。REPLでも使える。
+// monkey -vet script.mk では、ファイルを実行せずに、実行されないコードや使われない変数を標準エラー出力に書き出す。
+// monkey compile -dump script.mk では、ファイルをバイトコードにコンパイルし、定数と命令を読める形で標準出力に書き出す。
+// monkey build script.mk -o script.mkc では、ファイルをコンパイルしたバイトコードをscript.mkcに保存する。
+// monkey run script.mkc arg1 arg2 では、保存したバイトコードをVMで実行する。ソースコードは要らない。
+// import("lib")は、実行するファイルのディレクトリ、環境変数MONKEYPATHのディレクトリ、カレントディレクトリの順に探す。
 func main() {
+	flag.BoolVar(&repl.OutputJSON, "json", false, "print results as JSON")
+	flag.BoolVar(&repl.Color, "color", false, "colorize results and errors in the REPL")
+	flag.StringVar(&repl.Engine, "engine", engine.Eval, "execute with the tree-walking evaluator (eval) or the bytecode VM (vm)")
+	flag.BoolVar(&persistentCollections, "persistent", false, "make arrays and hashes immutable collections that share structure when updated")
+	flag.BoolVar(&strictConditions, "strict-conditions", false, "make if conditions and the operand of ! a type error unless they are booleans")
+	sandbox := flag.Bool("sandbox", false, "deny file, network, exec and environment access and limit steps and memory, for running untrusted code")
+	flag.BoolVar(&runTests, "test", false, "run the tests registered with test() after running the file")
+	trace := flag.Bool("trace", false, "print each evaluated node and its result to stderr")
+	profile := flag.Bool("profile", false, "print the calls and time spent in each function to stderr after running the file")
+	flag.BoolVar(&vetOnly, "vet", false, "report unreachable code and unused variables in the file without running it")
+	flag.StringVar(&coverProfile, "coverprofile", "", "write an lcov coverage report of the file's statements to this path")
+	flag.Int64Var(&maxSteps, "max-steps", 0, "interrupt the file after this many calls and loop iterations (0 means no limit)")
+	flag.Int64Var(&maxMemory, "max-memory", 0, "interrupt the file after allocating about this many bytes (0 means no limit)")
+	flag.DurationVar(&timeout, "timeout", 0, "interrupt the file after this duration (0 means no limit)")
+	flag.Parse()
+
+	// Tracerは一つしか設定できない
+	tracers := 0
+	for _, set := range []bool{*trace, *profile, coverProfile != ""} {
+		if set {
+			tracers++
+		}
+	}
+	if tracers > 1 {
+		fmt.Fprintln(os.Stderr, "only one of -trace, -profile and -coverprofile can be used")
+		os.Exit(2)
+	}
+	if _, err := engine.New(repl.Engine, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// Tracerは評価器がノードを評価するたびに呼ぶので、VMでは使えない
+	if tracers > 0 && repl.Engine != engine.Eval {
+		fmt.Fprintln(os.Stderr, "-trace, -profile and -coverprofile require -engine=eval")
+		os.Exit(2)
+	}
+	if *trace {
+		tracer = evaluator.NewPrintTracer(os.Stderr)
+	}
+	var profiler *evaluator.Profiler
+	if *profile {
+		profiler = evaluator.NewProfiler()
+		tracer = profiler
+	}
+
+	switch flag.Arg(0) {
+	case "compile":
+		os.Exit(runCompile(flag.Args()[1:]))
+	case "build":
+		os.Exit(runBuild(flag.Args()[1:]))
+	}
+
+	if *sandbox {
+		// importは、import("lib")がファイルを探すディレクトリの中からだけできる
+		interp.Untrusted.Apply(evaluator.Builtins, evaluator.Imports)
+		if maxSteps == 0 {
+			maxSteps = interp.Untrusted.MaxSteps
+		}
+		if maxMemory == 0 {
+			maxMemory = interp.Untrusted.MaxMemory
+		}
+	}
+
+	if paths := os.Getenv("MONKEYPATH"); paths != "" {
+		evaluator.Imports.Paths = append(filepath.SplitList(paths), evaluator.Imports.Paths...)
+	}
+	if flag.NArg() > 0 {
+		script, args, run := flag.Arg(0), flag.Args()[1:], runFile
+		if script == "run" {
+			if len(args) == 0 {
+				fmt.Fprintln(os.Stderr, "usage: monkey run <file.mkc> [args...]")
+				os.Exit(2)
+			}
+			if tracers > 0 {
+				fmt.Fprintln(os.Stderr, "-trace, -profile and -coverprofile cannot be used with bytecode files")
+				os.Exit(2)
+			}
+			script, args, run = args[0], args[1:], runBytecodeFile
+		}
+		evaluator.ScriptArgs = args
+		evaluator.Imports.Paths = append([]string{filepath.Dir(script)}, evaluator.Imports.Paths...)
+		code := run(script)
+		if profiler != nil {
+			profiler.Report(os.Stderr)
+		}
+		os.Exit(code)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,5 +138,235 @@ func main() {
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
 		user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Context = withSettings(context.Background())
+	os.Exit(repl.Start(os.Stdin, os.Stdout))
+}
+
+var (
+	runTests     bool
+	vetOnly      bool
+	coverProfile string
+	timeout      time.Duration
+	maxSteps     int64
+	maxMemory    int64
+
+	persistentCollections bool
+	strictConditions      bool
+	tracer                evaluator.Tracer // -trace、-profile、-coverprofileで使うもの
+)
+
+// フラグで指定した評価の仕方をctxに設定する。
+func withSettings(ctx context.Context) context.Context {
+	if persistentCollections {
+		ctx = evaluator.WithPersistentCollections(ctx)
+	}
+	if strictConditions {
+		ctx = evaluator.WithStrictConditions(ctx)
+	}
+	if tracer != nil {
+		ctx = evaluator.WithTracer(ctx, tracer)
+	}
+	return ctx
+}
+
+// ファイルを読んでパースする。読めないかパースエラーがあれば、標準エラー出力に書き出してfalseを返す。
+func parseFile(path string) (*ast.Program, bool) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return nil, false
+	}
+	return program, true
+}
+
+// ファイルを実行して、終了コードを返す。パースエラーの場合は1になり、それ以外はexecuteと同じ。
+func runFile(path string) int {
+	program, ok := parseFile(path)
+	if !ok {
+		return 1
+	}
+
+	if vetOnly {
+		diagnostics := analysis.Analyze(program)
+		for _, d := range diagnostics {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, d)
+		}
+		if len(diagnostics) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// -coverprofileのカバレッジはテストの実行も含めて数え、終わった後に書き出す
+	if coverProfile != "" {
+		coverage := evaluator.NewCoverage(path, program)
+		tracer = coverage
+		defer writeCoverage(coverage)
+	}
+
+	env := object.NewEnvironment()
+	eng, err := engine.New(repl.Engine, env)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return execute(env, func(ctx context.Context) object.Object {
+		return eng.Run(ctx, program)
+	})
+}
+
+// monkey build で保存したバイトコードのファイルをVMで実行して、終了コードを返す。
+func runBytecodeFile(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	bytecode, err := compiler.Decode(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	env := object.NewEnvironment()
+	machine := vm.New(bytecode)
+	machine.SetEnvironment(env)
+	return execute(env, func(ctx context.Context) object.Object {
+		return machine.Run()
+	})
+}
+
+// envでrunを実行して、終了コードを返す。runにはenvに設定したのと同じContextを渡す。
+// エラーで実行が止まった場合は1、exit(code)が呼ばれた場合はcodeになる。
+// -testの場合は、失敗したテストがあった場合も1になる。
+func execute(env *object.Environment, run func(ctx context.Context) object.Object) int {
+	// テストの実行も含めて、-timeoutの時間や-max-stepsの操作の数、-max-memoryのメモリを超えたら中断する
+	ctx := withSettings(context.Background())
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if maxSteps > 0 {
+		ctx = evaluator.WithStepLimit(ctx, maxSteps)
+	}
+	if maxMemory > 0 {
+		ctx = evaluator.WithMemoryLimit(ctx, maxMemory)
+	}
+	env.SetContext(ctx)
+	result := run(ctx)
+	if exit, ok := result.(*object.Exit); ok {
+		return int(exit.Code)
+	}
+	if result != nil && (result.Type() == object.ERROR_OBJ || result.Type() == object.EXCEPTION_OBJ) {
+		fmt.Fprintln(env.ErrorOutput(), result.Inspect())
+		return 1
+	}
+	if runTests {
+		if _, failed := evaluator.Tests.Run(env); failed > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// monkey build file [-o out] でファイルをコンパイルし、バイトコードをoutに保存する。
+// outを指定しなければ、fileの拡張子を.mkcにしたファイルに保存する。
+func runBuild(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flags.String("o", "", "write the bytecode to this path (default: the file with a .mkc extension)")
+	// monkey build file -o out のように、ファイルの後に書かれたフラグも読む
+	flags.Parse(args)
+	var files []string
+	for flags.NArg() > 0 {
+		files = append(files, flags.Arg(0))
+		flags.Parse(flags.Args()[1:])
+	}
+	if len(files) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey build <file> [-o <output>]")
+		return 2
+	}
+	path := files[0]
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+
+	program, ok := parseFile(path)
+	if !ok {
+		return 1
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := compiler.Encode(f, c.Bytecode()); err != nil {
+		f.Close()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// monkey compile [-dump] file でファイルをコンパイルする。コンパイルできなければ1を返す。
+func runCompile(args []string) int {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	dump := flags.Bool("dump", false, "print the constants and instructions the compiler generates")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: monkey compile [-dump] <file>")
+		return 2
+	}
+	path := flags.Arg(0)
+
+	program, ok := parseFile(path)
+	if !ok {
+		return 1
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	if *dump {
+		if err := c.Bytecode().Dump(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func writeCoverage(coverage *evaluator.Coverage) {
+	f, err := os.Create(coverProfile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer f.Close()
+	if err := coverage.WriteLCOV(f); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "coverage: %.1f%% of statements\n", coverage.Percent())
 }
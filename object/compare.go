@@ -0,0 +1,120 @@
+package object
+
+import "math/big"
+
+// 大小を比較できるオブジェクトはComparableインタフェースを満たす。
+// Compareはレシーバがotherより小さければ負の数、等しければ0、大きければ正の数を返す。
+// otherは比較できる種類のオブジェクトでなければならない。種類が分からない場合はパッケージ関数のCompareを使う。
+// < や > 、sort、min、maxはいずれもこの順序に従う。
+type Comparable interface {
+	Compare(other Object) int
+}
+
+// 数値(Integer、BigInt、Float)同士、文字列同士、真偽値同士は比較できる。
+// 比較できない組み合わせの場合はfalseを返す。
+func Compare(a, b Object) (int, bool) {
+	if compareKind(a) == "" || compareKind(a) != compareKind(b) {
+		return 0, false
+	}
+	return a.(Comparable).Compare(b), true
+}
+
+// 比較できるオブジェクトの種類。整数と小数は同じ種類として比べられる。
+func compareKind(obj Object) string {
+	switch obj.(type) {
+	case *Integer, *BigInt, *Float:
+		return "number"
+	case *String:
+		return "string"
+	case *Boolean:
+		return "boolean"
+	default:
+		return ""
+	}
+}
+
+func (i *Integer) Compare(other Object) int {
+	if o, ok := other.(*Integer); ok {
+		switch {
+		case i.Value < o.Value:
+			return -1
+		case i.Value > o.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return compareNumbers(i, other)
+}
+
+func (b *BigInt) Compare(other Object) int { return compareNumbers(b, other) }
+func (f *Float) Compare(other Object) int  { return compareNumbers(f, other) }
+
+// 文字列は辞書順（バイト列としての比較）。
+func (s *String) Compare(other Object) int {
+	o := other.(*String).Value
+	switch {
+	case s.Value < o:
+		return -1
+	case s.Value > o:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// false < true とする。
+func (b *Boolean) Compare(other Object) int {
+	o := other.(*Boolean).Value
+	switch {
+	case b.Value == o:
+		return 0
+	case b.Value:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// 整数同士はbig.Intで正確に比べ、小数が混じる場合はfloat64にして比べる。
+// NaNはどの数とも大小がつかないので0（等しい）を返す。
+func compareNumbers(a, b Object) int {
+	ai, aok := numberToBigInt(a)
+	bi, bok := numberToBigInt(b)
+	if aok && bok {
+		return ai.Cmp(bi)
+	}
+
+	af, bf := numberToFloat(a), numberToFloat(b)
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func numberToBigInt(obj Object) (*big.Int, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), true
+	case *BigInt:
+		return obj.Value, true
+	default:
+		return nil, false
+	}
+}
+
+func numberToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	default:
+		return obj.(*Float).Value
+	}
+}
@@ -0,0 +1,550 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goの値を、中身までMonkeyのオブジェクトにする。
+// 埋め込む側のプログラムが、設定や検索結果などのデータを手で変換せずにスクリプトに渡すのに使う。
+//
+//   - nilとnilのポインタはnull、真偽値、数値、文字列は対応するオブジェクトになる。int64に収まらない整数と*big.IntはBigIntになる
+//   - []byteは文字列、それ以外のスライスと配列は配列になる
+//   - mapはハッシュになる。キーは文字列、整数、真偽値でなければならず、出力が毎回同じになるようにキーの順に並べる
+//   - 構造体はエクスポートされたフィールドを持つハッシュになる。キーはフィールド名か、`monkey:"name"` のタグで決める（fieldsOfを参照）
+//   - ポインタとインターフェースは指している値を変換する。Objectはそのまま使う
+//
+// 関数やチャネルのように変換できない値や、自分自身を含む値の場合はエラーを返す。
+// 値をコピーせずに渡したい場合は、GoValueで包む。
+func FromGo(v interface{}) (Object, error) {
+	return fromGo(reflect.ValueOf(v), "", make(map[uintptr]bool))
+}
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// pathはエラーメッセージに出す、変換している値の場所。 .Items[2] のようになる。
+// visitingは変換している途中のポインタ、map、スライス。自分自身を含む値で止まらなくなるのを防ぐ。
+func fromGo(v reflect.Value, path string, visiting map[uintptr]bool) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	if v.Type().Implements(objectType) {
+		if v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return NULL, nil
+			}
+		}
+		return v.Interface().(Object), nil
+	}
+	if v.Type() == bigIntType {
+		if v.IsNil() {
+			return NULL, nil
+		}
+		n := v.Interface().(*big.Int)
+		if n.IsInt64() {
+			return NewInteger(n.Int64()), nil
+		}
+		return &BigInt{Value: new(big.Int).Set(n)}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &BigInt{Value: new(big.Int).SetUint64(v.Uint())}, nil
+		}
+		return NewInteger(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return NewString(v.String()), nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if v.Kind() == reflect.Ptr {
+			if visiting[v.Pointer()] {
+				return nil, containsItself(path)
+			}
+			visiting[v.Pointer()] = true
+			defer delete(visiting, v.Pointer())
+		}
+		return fromGo(v.Elem(), path, visiting)
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return NULL, nil
+			}
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return NewString(string(v.Bytes())), nil
+			}
+			if visiting[v.Pointer()] && v.Len() > 0 {
+				return nil, containsItself(path)
+			}
+			visiting[v.Pointer()] = true
+			defer delete(visiting, v.Pointer())
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			el, err := fromGo(v.Index(i), fmt.Sprintf("%s[%d]", path, i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if visiting[v.Pointer()] {
+			return nil, containsItself(path)
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+
+		type entry struct {
+			key   Object
+			value reflect.Value
+		}
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromGo(iter.Key(), path, visiting)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := key.(Hashable); !ok {
+				return nil, fmt.Errorf("%scannot use %s as a hash key", pathPrefix(path), key.Type())
+			}
+			entries = append(entries, entry{key, iter.Value()})
+		}
+		// goのmapには順番がないので、キーの順に追加する
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i].key, entries[j].key
+			if c, ok := Compare(a, b); ok {
+				return c < 0
+			}
+			// 比べられない型のキーは、型の名前の順にする
+			return a.Type() < b.Type()
+		})
+
+		hash := NewHash()
+		for _, e := range entries {
+			value, err := fromGo(e.value, path+keyPath(e.key), visiting)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(e.key, value)
+		}
+		return hash, nil
+
+	case reflect.Struct:
+		hash := NewHash()
+		for _, f := range fieldsOf(v.Type()) {
+			field := v.FieldByIndex(f.Index)
+			if f.OmitEmpty && field.IsZero() {
+				continue
+			}
+			value, err := fromGo(field, path+"."+f.Name, visiting)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(NewString(f.Name), value)
+		}
+		return hash, nil
+	}
+
+	return nil, fmt.Errorf("%scannot convert %s to a Monkey value", pathPrefix(path), v.Type())
+}
+
+// ToGoやFromGoで、構造体のフィールドとハッシュのキーを対応させるための情報。
+type structField struct {
+	Name      string // ハッシュのキー
+	Index     []int  // reflect.Value.FieldByIndexで使う、フィールドの場所
+	OmitEmpty bool   // FromGoで、ゼロ値ならキーを作らない
+}
+
+// 構造体の型tの、ハッシュのキーにするフィールドを宣言した順に返す。
+// encoding/jsonと同じように、`monkey:"name"` でキーの名前を、`monkey:"-"` で変換しないことを、
+// `monkey:",omitempty"` でゼロ値のフィールドを省くことを指定できる。タグがなければフィールド名がキーになる。
+// タグのない埋め込みの構造体のフィールドは、外側の構造体のフィールドとして扱う。
+func fieldsOf(t reflect.Type) []structField {
+	var fields []structField
+	seen := make(map[string]bool)
+	collectFields(t, nil, &fields, seen)
+	return fields
+}
+
+func collectFields(t reflect.Type, index []int, fields *[]structField, seen map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, hasTag := sf.Tag.Lookup("monkey")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Struct {
+			collectFields(sf.Type, fieldIndex, fields, seen)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // エクスポートされていない
+		}
+
+		name, options := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, options = tag[:i], tag[i+1:]
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		// 外側の構造体のフィールドが、埋め込んだ構造体の同じ名前のフィールドより優先される
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		*fields = append(*fields, structField{Name: name, Index: fieldIndex, OmitEmpty: options == "omitempty"})
+	}
+}
+
+// Monkeyのオブジェクトobjを、targetが指すgoの変数に変換して入れる。targetはnilではないポインタでなければならない。
+// FromGoの逆の変換で、スクリプトが返した値を埋め込む側の型で受け取るのに使う。
+//
+//   - 整数は整数型と小数型に、小数は小数型に、文字列は文字列型と[]byteに、真偽値はbool型に入る。値が溢れる場合はエラーになる
+//   - 配列（Vectorも）はスライスと配列に、ハッシュ（Mapも）はmapと構造体に入る。構造体のキーの名前はfieldsOfで決まり、知らないキーは無視する
+//   - nullはポインタ、スライス、map、インターフェースならnilになる
+//   - interface{}には、int64、*big.Int、float64、string、bool、nil、[]interface{}、map[string]interface{}のどれかが入る。
+//     文字列以外のキーがあるハッシュはmap[interface{}]interface{}になる
+//   - GoValueは包んでいる値を、Object型の変数にはobjをそのまま入れる
+//
+// 自分自身を含む配列やハッシュは変換できないので、その場所を付けたエラーを返す。
+func ToGo(obj Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("ToGo needs a non-nil pointer, got %T", target)
+	}
+	return toGo(obj, v.Elem(), "", 0, make(map[Object]bool))
+}
+
+// depthはobjが入っている配列とハッシュの数。MaxNestingを超えたらErrTooDeepを返す。
+// visitingは変換している途中の配列とハッシュ。fromGoと同じく、自分自身を含む値で止まらなくなるのを防ぐ。
+func toGo(obj Object, v reflect.Value, path string, depth int, visiting map[Object]bool) error {
+	if depth > MaxNesting {
+		return ErrTooDeep
+	}
+	t := v.Type()
+	mismatch := func() error {
+		return fmt.Errorf("%scannot use %s as %s", pathPrefix(path), obj.Type(), t)
+	}
+
+	if gv, ok := obj.(*GoValue); ok {
+		value := reflect.ValueOf(gv.Value)
+		if value.IsValid() && value.Type().AssignableTo(t) {
+			v.Set(value)
+			return nil
+		}
+		return mismatch()
+	}
+	if t == objectType {
+		v.Set(reflect.ValueOf(&obj).Elem())
+		return nil
+	}
+	switch obj := obj.(type) {
+	case *Vector:
+		return toGo(obj.ToArray(), v, path, depth, visiting)
+	case *Map:
+		return toGo(obj.ToHash(), v, path, depth, visiting)
+	}
+
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return mismatch()
+	}
+
+	if t == bigIntType {
+		switch obj := obj.(type) {
+		case *Integer:
+			v.Set(reflect.ValueOf(big.NewInt(obj.Value)))
+		case *BigInt:
+			v.Set(reflect.ValueOf(new(big.Int).Set(obj.Value)))
+		default:
+			return mismatch()
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return mismatch()
+		}
+		natural, err := naturalGo(obj, path, depth, visiting)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+		return nil
+
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := toGo(obj, elem.Elem(), path, depth, visiting); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b.Value)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := obj.(*Integer)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(n.Value) {
+			return fmt.Errorf("%s%d overflows %s", pathPrefix(path), n.Value, t)
+		}
+		v.SetInt(n.Value)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch n := obj.(type) {
+		case *Integer:
+			if n.Value < 0 || v.OverflowUint(uint64(n.Value)) {
+				return fmt.Errorf("%s%d overflows %s", pathPrefix(path), n.Value, t)
+			}
+			v.SetUint(uint64(n.Value))
+		case *BigInt:
+			if !n.Value.IsUint64() || v.OverflowUint(n.Value.Uint64()) {
+				return fmt.Errorf("%s%s overflows %s", pathPrefix(path), n.Value, t)
+			}
+			v.SetUint(n.Value.Uint64())
+		default:
+			return mismatch()
+		}
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+		case *Float:
+			v.SetFloat(n.Value)
+		default:
+			return mismatch()
+		}
+		return nil
+
+	case reflect.String:
+		s, ok := obj.(*String)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s.Value)
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if s, ok := obj.(*String); ok && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s.Value))
+			return nil
+		}
+		arr, ok := obj.(*Array)
+		if !ok {
+			return mismatch()
+		}
+		if visiting[arr] {
+			return containsItself(path)
+		}
+		visiting[arr] = true
+		defer delete(visiting, arr)
+		if t.Kind() == reflect.Array {
+			if len(arr.Elements) != t.Len() {
+				return fmt.Errorf("%scannot use an array of %d elements as %s", pathPrefix(path), len(arr.Elements), t)
+			}
+		} else {
+			v.Set(reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements)))
+		}
+		for i, el := range arr.Elements {
+			if err := toGo(el, v.Index(i), fmt.Sprintf("%s[%d]", path, i), depth+1, visiting); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return mismatch()
+		}
+		if visiting[hash] {
+			return containsItself(path)
+		}
+		visiting[hash] = true
+		defer delete(visiting, hash)
+		m := reflect.MakeMapWithSize(t, len(hash.Pairs))
+		for _, pair := range hash.OrderedPairs() {
+			key := reflect.New(t.Key()).Elem()
+			if err := toGo(pair.Key, key, path, depth+1, visiting); err != nil {
+				return err
+			}
+			value := reflect.New(t.Elem()).Elem()
+			if err := toGo(pair.Value, value, path+keyPath(pair.Key), depth+1, visiting); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return mismatch()
+		}
+		if visiting[hash] {
+			return containsItself(path)
+		}
+		visiting[hash] = true
+		defer delete(visiting, hash)
+		for _, f := range fieldsOf(t) {
+			pair, ok := hash.Pairs[NewString(f.Name).HashKey()]
+			if !ok {
+				continue
+			}
+			if err := toGo(pair.Value, v.FieldByIndex(f.Index), path+"."+f.Name, depth+1, visiting); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return mismatch()
+}
+
+// interface{}に入れるときの、オブジェクトに対応するgoの値。
+func naturalGo(obj Object, path string, depth int, visiting map[Object]bool) (interface{}, error) {
+	if depth > MaxNesting {
+		return nil, ErrTooDeep
+	}
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *BigInt:
+		return new(big.Int).Set(obj.Value), nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *GoValue:
+		return obj.Value, nil
+	case *Vector:
+		return naturalGo(obj.ToArray(), path, depth, visiting)
+	case *Map:
+		return naturalGo(obj.ToHash(), path, depth, visiting)
+	case *Array, *Hash:
+		if visiting[obj] {
+			return nil, containsItself(path)
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			value, err := naturalGo(el, fmt.Sprintf("%s[%d]", path, i), depth+1, visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *Hash:
+		pairs := obj.OrderedPairs()
+		stringKeys := true
+		for _, pair := range pairs {
+			if _, ok := pair.Key.(*String); !ok {
+				stringKeys = false
+			}
+		}
+		if stringKeys {
+			m := make(map[string]interface{}, len(pairs))
+			for _, pair := range pairs {
+				value, err := naturalGo(pair.Value, path+keyPath(pair.Key), depth+1, visiting)
+				if err != nil {
+					return nil, err
+				}
+				m[pair.Key.(*String).Value] = value
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(pairs))
+		for _, pair := range pairs {
+			key, _ := naturalGo(pair.Key, path, depth+1, visiting)
+			value, err := naturalGo(pair.Value, path+keyPath(pair.Key), depth+1, visiting)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%scannot convert %s to a Go value", pathPrefix(path), obj.Type())
+}
+
+// ハッシュのキーの場所。文字列のキーは ["name"] のように引用符で囲む。
+func keyPath(key Object) string {
+	if s, ok := key.(*String); ok {
+		return "[" + strconv.Quote(s.Value) + "]"
+	}
+	return "[" + key.Inspect() + "]"
+}
+
+// 自分自身を含む値を変換しようとしたときのエラー。
+func containsItself(path string) error {
+	return fmt.Errorf("%scannot convert a value that contains itself", pathPrefix(path))
+}
+
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path, ".") + ": "
+}
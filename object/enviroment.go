@@ -1,22 +1,307 @@
 package object
 
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
 // 現在のenvで、新しいenvを囲い込む。現在のenvが外側のスコープとなるイメージ。
 // 現在のenvは引数で渡されているouter。
 // つまりスコープがネストするごとに内側にenvがネストされていくイメージ。
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.builtins = outer.builtins
+	env.depth = outer.depth
+	env.ctx = outer.Context()
 	return env
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil} // ルートのスコープにはouterスコープはない。
+	c := make(map[string]bool)
+	return &Environment{store: s, consts: c, outer: nil} // ルートのスコープにはouterスコープはない。
+}
+
+// 関数を呼び出したときのスコープを作る。namesはresolverが決めたローカル変数の名前で、
+// その変数はmapではなくスライスのslotに束縛されるので、GetAtでスコープを名前で辿らずに取り出せる。
+// namesにない名前の変数は、NewEnclosedEnvironmentと同じくmapに束縛される。
+// Contextと呼び出しの深さは、呼び出し側がSetContextとSetCallDepthで設定する。
+func NewEnclosedSlotEnvironment(outer *Environment, names []string) *Environment {
+	return &Environment{names: names, slots: make([]Object, len(names)), outer: outer, builtins: outer.builtins}
+}
+
+// 複数のgoroutineから同じ環境を使ってスクリプトを評価できる環境を作る。
+// グローバルな変数を共有する組み込み先のための環境で、読み書きをRWMutexで守る。
+// 関数呼び出しなどで囲い込まれた内側のスコープは呼び出しごとに作られるので、ロックを持たない。
+func NewConcurrentEnvironment() *Environment {
+	env := NewEnvironment()
+	env.mu = &sync.RWMutex{}
+	return env
 }
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	consts map[string]bool // constで束縛された（再代入できない）変数名
+	outer  *Environment
+	mu     *sync.RWMutex // NewConcurrentEnvironmentで作ったときだけnilではない
+	names  []string      // slotに束縛される変数の名前。NewEnclosedSlotEnvironmentで作ったときだけ
+	slots  []Object      // namesと同じ順番の変数の値。まだ束縛されていない変数はnil
+
+	builtins BuiltinLookup   // この環境で使う組み込み関数。nilならevaluatorの標準のものを使う
+	ctx      context.Context // このスコープを評価するときのContext。SetContextかWithContextで設定する
+	out      io.Writer       // SetOutputで設定された出力先
+	errOut   io.Writer       // SetErrorOutputで設定されたエラーの出力先
+	in       *bufio.Reader   // SetInputで設定された入力元
+	depth    int             // 関数呼び出しの深さ。SetCallDepthで設定する
+}
+
+// この環境を評価している関数呼び出しの深さ。トップレベルは0。
+// 関数のスコープの外側は定義した場所のスコープなので、呼び出しの深さは外側から引き継がずに呼び出し側で設定する。
+func (e *Environment) CallDepth() int {
+	return e.depth
+}
+
+func (e *Environment) SetCallDepth(depth int) {
+	e.depth = depth
+}
+
+// この環境で評価するときのContextを設定する。sleepなどはContextがキャンセルされると中断する。
+// NewEnclosedEnvironmentで作った内側のスコープは、作ったときのContextを引き継ぐ。
+// 関数を呼び出したときのスコープは、関数を定義した場所ではなく呼び出し元のContextを設定される。
+// 評価ごとに違うContextを使う場合は、環境を変更せずにWithContextで評価する。
+func (e *Environment) SetContext(ctx context.Context) {
+	e.lock()
+	defer e.unlock()
+	e.ctx = ctx
+}
+
+// この環境で評価するときのContext。設定されていなければcontext.Background()。
+// 外側のスコープは探さない。外側は関数を定義した場所のスコープなので、そこで前に評価したときのContextかもしれない。
+func (e *Environment) Context() context.Context {
+	e.rlock()
+	defer e.runlock()
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// この環境と同じ束縛を共有し、Contextだけをctxにした環境を返す。
+// 返した環境で束縛した変数はこの環境からも参照でき、この環境は変更しない。
+// 同じ環境を複数のgoroutineから、それぞれのContextで評価するのに使う。
+func (e *Environment) WithContext(ctx context.Context) *Environment {
+	e.lock()
+	defer e.unlock()
+	// 束縛を共有するために、必要になるまで作らないmapもここで作っておく
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	view := *e
+	view.ctx = ctx
+	return &view
+}
+
+// putsやprintの出力先を設定する。組み込み先やテストがプログラムの出力を受け取るのに使う。
+func (e *Environment) SetOutput(w io.Writer) {
+	e.lock()
+	defer e.unlock()
+	e.out = w
+}
+
+// この環境の出力先。Contextと同じく内側のスコープから順に探し、どのスコープにも設定されていなければos.Stdout。
+func (e *Environment) Output() io.Writer {
+	for scope := e; scope != nil; scope = scope.outer {
+		scope.rlock()
+		out := scope.out
+		scope.runlock()
+		if out != nil {
+			return out
+		}
+	}
+	return os.Stdout
+}
+
+// eputsの出力先を設定する。REPLなどが、スクリプトの出力と自分の出力を分けるのに使う。
+func (e *Environment) SetErrorOutput(w io.Writer) {
+	e.lock()
+	defer e.unlock()
+	e.errOut = w
+}
+
+// この環境のエラーの出力先。Outputと同じく内側のスコープから順に探し、どのスコープにも設定されていなければos.Stderr。
+func (e *Environment) ErrorOutput() io.Writer {
+	for scope := e; scope != nil; scope = scope.outer {
+		scope.rlock()
+		out := scope.errOut
+		scope.runlock()
+		if out != nil {
+			return out
+		}
+	}
+	return os.Stderr
+}
+
+// inputが読む入力元を設定する。
+// 行の途中までを読んだ残りが次のinputで読めるように、bufio.Readerで包んで持つ。
+// 同じ入力元を他でも読む場合は、*bufio.Readerを渡して共有すること。
+func (e *Environment) SetInput(r io.Reader) {
+	e.lock()
+	defer e.unlock()
+	e.in = bufio.NewReader(r)
+}
+
+// 全ての環境が共有する標準入力。環境ごとにbufio.Readerを作ると、先読みした分が他の環境から読めなくなる。
+var stdin = bufio.NewReader(os.Stdin)
+
+// この環境の入力元。Outputと同じく内側のスコープから順に探し、どのスコープにも設定されていなければ標準入力。
+func (e *Environment) Input() *bufio.Reader {
+	for scope := e; scope != nil; scope = scope.outer {
+		scope.rlock()
+		in := scope.in
+		scope.runlock()
+		if in != nil {
+			return in
+		}
+	}
+	return stdin
+}
+
+// fromの出力先とエラーの出力先、入力元をこの環境に設定する。evalやimportで作った環境が、呼び出し元と同じ入出力を使うのに使う。
+func (e *Environment) InheritStreams(from *Environment) {
+	out, errOut, in := from.Output(), from.ErrorOutput(), from.Input()
+	e.lock()
+	defer e.unlock()
+	e.out, e.errOut, e.in = out, errOut, in
+}
+
+// 組み込み関数や組み込みの定数を名前で探す。evaluatorのBuiltinRegistryが実装する。
+type BuiltinLookup interface {
+	LookupBuiltin(name string) (Object, bool)
+}
+
+// この環境で使う組み込み関数を設定する。インタプリタごとに組み込み関数を追加したり、取り除いたりするのに使う。
+// 内側のスコープは作られたときに外側の設定を引き継ぐので、評価を始める前に設定すること。
+func (e *Environment) SetBuiltins(b BuiltinLookup) {
+	e.builtins = b
+}
+
+// この環境で使う組み込み関数。設定されていなければnil。
+func (e *Environment) Builtins() BuiltinLookup {
+	return e.builtins
+}
+
+// slotに束縛される変数なら、その番号を返す。そうでなければ-1。
+// 関数のローカル変数の数は少ないので、mapにせずに順番に探す。
+func (e *Environment) slotIndex(name string) int {
+	for i, n := range e.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 現在のスコープの束縛を、変数名から値へのmapで返す。外側のスコープの束縛は含まれない。
+// 返したmapを変更しても環境には影響しない。
+func (e *Environment) Bindings() map[string]Object {
+	e.rlock()
+	defer e.runlock()
+	return e.bindings()
+}
+
+func (e *Environment) bindings() map[string]Object {
+	bindings := make(map[string]Object, len(e.store)+len(e.slots))
+	for name, val := range e.store {
+		bindings[name] = val
+	}
+	for i, val := range e.slots {
+		if val != nil {
+			bindings[e.names[i]] = val
+		}
+	}
+	return bindings
+}
+
+// 現在のスコープで変数を探す。slotの変数は束縛されている場合だけ見つかる。
+func (e *Environment) lookup(name string) (Object, bool) {
+	if i := e.slotIndex(name); i >= 0 {
+		return e.slots[i], e.slots[i] != nil
+	}
+	obj, ok := e.store[name]
+	return obj, ok
+}
+
+// 現在のスコープに束縛する。mapはNewEnclosedSlotEnvironmentで作ったときには必要になるまで作らない。
+func (e *Environment) bind(name string, val Object) {
+	if i := e.slotIndex(name); i >= 0 {
+		e.slots[i] = val
+		return
+	}
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
+	e.store[name] = val
+}
+
+// depth個外側のスコープ
+func (e *Environment) scopeAt(depth int) *Environment {
+	scope := e
+	for i := 0; i < depth && scope != nil; i++ {
+		scope = scope.outer
+	}
+	return scope
+}
+
+// resolverが決めた場所(ast.Binding)にある変数を取り出す。
+// 場所のスコープがslotを持たない場合や、まだ束縛されていない場合はfalseを返すので、Getで名前で探し直すこと。
+func (e *Environment) GetAt(depth, slot int) (Object, bool) {
+	scope := e.scopeAt(depth)
+	if scope == nil || slot >= len(scope.slots) || scope.slots[slot] == nil {
+		return nil, false
+	}
+	return scope.slots[slot], true
+}
+
+// resolverが決めた場所(ast.Binding)にある変数の値を更新する。
+// 束縛されていない場合やconstの場合はfalseを返す。
+func (e *Environment) AssignAt(depth, slot int, val Object) (Object, bool) {
+	scope := e.scopeAt(depth)
+	if scope == nil || slot >= len(scope.slots) || scope.slots[slot] == nil || scope.consts[scope.names[slot]] {
+		return nil, false
+	}
+	scope.slots[slot] = val
+	return val, true
+}
+
+func (e *Environment) lock() {
+	if e.mu != nil {
+		e.mu.Lock()
+	}
+}
+
+func (e *Environment) unlock() {
+	if e.mu != nil {
+		e.mu.Unlock()
+	}
+}
+
+func (e *Environment) rlock() {
+	if e.mu != nil {
+		e.mu.RLock()
+	}
+}
+
+func (e *Environment) runlock() {
+	if e.mu != nil {
+		e.mu.RUnlock()
+	}
 }
 
 // 内側のスコープで見つからないなら外側のスコープで探す。それを再帰的に行う。
@@ -26,17 +311,80 @@ func (e *Environment) Get(name string) (Object, bool) {
 	//j, _ := json.MarshalIndent(e.store, "", " ")
 	//fmt.Printf("現在のstore内容=================\n%v\n", string(j))
 
-	obj, ok := e.store[name]
+	e.rlock()
+	obj, ok := e.lookup(name)
+	e.runlock()
 	if !ok && e.outer != nil {
 		obj, ok = e.outer.Get(name)
 	}
 	return obj, ok
 }
 
+// 現在のスコープに値を束縛する。
+// 同じスコープにconstで束縛された変数がある場合は上書きせず、Errorオブジェクトを返す。
+// 外側のスコープのconstと同じ名前の変数は、内側のスコープでなら束縛できる。
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	e.lock()
+	defer e.unlock()
+	if e.consts[name] {
+		return constantError(name)
+	}
+	e.bind(name, val)
 
 	//j, _ := json.MarshalIndent(e.store, "", " ")
 	//fmt.Printf("store結果=================\n%v\n", string(j))
 	return val
 }
+
+// Setと同じく現在のスコープに値を束縛し、その束縛を再代入できないものとして記録する。
+// 組み込み関数の名前などを上書きされないようにするのにも使える。
+func (e *Environment) SetConst(name string, val Object) Object {
+	e.lock()
+	defer e.unlock()
+	if e.consts[name] {
+		return constantError(name)
+	}
+	e.bind(name, val)
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.consts[name] = true
+	return val
+}
+
+func constantError(name string) *Error {
+	return &Error{Message: "cannot assign to constant: " + name}
+}
+
+// 変数がconstで束縛されているかどうか。Getと同じく内側のスコープから順に探す。
+func (e *Environment) IsConst(name string) bool {
+	e.rlock()
+	_, ok := e.lookup(name)
+	isConst := e.consts[name]
+	e.runlock()
+	if ok {
+		return isConst
+	}
+	return e.outer != nil && e.outer.IsConst(name)
+}
+
+// 既に束縛されている変数の値を更新する。
+// Setと違い、現在のスコープに変数がなければ外側のスコープを辿り、最初に見つかったスコープの束縛を更新する。
+// どのスコープにも束縛が見つからなかった場合、またはconstで束縛されていた場合はfalseを返す。
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	e.lock()
+	if _, ok := e.lookup(name); ok {
+		defer e.unlock()
+		if e.consts[name] {
+			return nil, false
+		}
+		e.bind(name, val)
+		return val, true
+	}
+	e.unlock()
+
+	if e.outer == nil {
+		return nil, false
+	}
+	return e.outer.Assign(name, val)
+}
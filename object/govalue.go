@@ -0,0 +1,34 @@
+package object
+
+import "fmt"
+
+// 埋め込む側のgoのプログラムから渡された任意の値をそのまま包むオブジェクト。
+// 値をコピーせずに持つので、Monkeyからメソッドを呼べば元の値が変更される。
+// Monkeyからは v["Name"] や v.Name でフィールドやメソッドを参照でき、 v.Name = x でフィールドに代入できる。（評価器がreflectで解決する）
+type GoValue struct {
+	Value interface{}
+	// Monkeyから参照、代入してよいフィールド、メソッドの名前ならtrueを返す。
+	// nilの場合はエクスポートされているもの全てを参照できる。
+	Allow func(name string) bool
+}
+
+// vを包むGoValueを作る。membersを渡した場合は、その名前のフィールドとメソッドだけをMonkeyから使えるようにする。
+func NewGoValue(v interface{}, members ...string) *GoValue {
+	gv := &GoValue{Value: v}
+	if len(members) > 0 {
+		allowed := make(map[string]bool, len(members))
+		for _, name := range members {
+			allowed[name] = true
+		}
+		gv.Allow = func(name string) bool { return allowed[name] }
+	}
+	return gv
+}
+
+func (gv *GoValue) Type() ObjectType { return GO_VALUE_OBJ }
+func (gv *GoValue) Inspect() string  { return fmt.Sprintf("go(%T)", gv.Value) }
+
+// nameのフィールドやメソッドをMonkeyから参照してよいかどうか。
+func (gv *GoValue) Allows(name string) bool {
+	return gv.Allow == nil || gv.Allow(name)
+}
@@ -0,0 +1,131 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 配列、ハッシュ、セット、インスタンスを入れ子にしてよい深さ。
+// Inspect、ToJSON、ToGoなどはgoの関数を再帰して値を辿るので、これより深い値は辿らずにエラーにして、スタックを使い切らないようにする。
+const MaxNesting = 10000
+
+// 入れ子がMaxNestingより深い値を辿ろうとしたときのエラー。
+var ErrTooDeep = errors.New("value is nested too deeply")
+
+// InspectWithで配列、ハッシュ、セットをどう表示するか。ゼロ値は一行で全て表示する（Inspectと同じ）。
+type InspectOptions struct {
+	Indent      string // 空でなければ要素ごとに改行し、ネストの深さだけこの文字列でインデントする
+	MaxDepth    int    // これより深くネストした配列などは [...] と省略する。0なら制限なし
+	MaxElements int    // 配列などの要素をこの数だけ表示し、残りは ...(n more) と省略する。0なら制限なし
+}
+
+// オブジェクトをoptsに従って文字列にする。
+// 配列などが自分自身を含んでいても無限に再帰しないように、表示中のものが再び現れたら [...] と表示する。
+// MaxNestingより深いところも [...] と省略する。
+func InspectWith(obj Object, opts InspectOptions) string {
+	in := &inspector{opts: opts, visiting: make(map[Object]bool)}
+	in.inspect(obj, 0)
+	return in.out.String()
+}
+
+// Inspectと同じだが、入れ子がMaxNestingより深い値は省略せずにErrTooDeepを返す。
+// putsやstrのように、スクリプトの値を表示する組み込み関数で使う。
+func InspectChecked(obj Object) (string, error) {
+	in := &inspector{visiting: make(map[Object]bool)}
+	in.inspect(obj, 0)
+	if in.tooDeep {
+		return "", ErrTooDeep
+	}
+	return in.out.String(), nil
+}
+
+type inspector struct {
+	opts     InspectOptions
+	out      bytes.Buffer
+	visiting map[Object]bool // 今表示している途中の配列、ハッシュ、セット、インスタンス
+	tooDeep  bool            // MaxNestingより深いところを省略したらtrue
+}
+
+func (in *inspector) inspect(obj Object, depth int) {
+	switch obj := obj.(type) {
+	case *Array:
+		in.container(obj, "[", "]", len(obj.Elements), depth, func(i int) {
+			in.inspect(obj.Elements[i], depth+1)
+		})
+	case *Hash:
+		// keysやfor-inと同じく、追加された順番に表示する。
+		pairs := obj.OrderedPairs()
+		in.container(obj, "{", "}", len(pairs), depth, func(i int) {
+			in.inspect(pairs[i].Key, depth+1)
+			in.out.WriteString(": ")
+			in.inspect(pairs[i].Value, depth+1)
+		})
+	case *Set:
+		in.container(obj, "set{", "}", len(obj.Keys), depth, func(i int) {
+			in.inspect(obj.Elements[obj.Keys[i]], depth+1)
+		})
+	case *Instance:
+		in.container(obj, obj.Class.Name+"(", ")", len(obj.Class.Fields), depth, func(i int) {
+			name := obj.Class.Fields[i]
+			in.out.WriteString(name + ": ")
+			in.inspect(obj.Fields[name], depth+1)
+		})
+	// 変更できない配列とハッシュは、同じ要素の配列、ハッシュと同じように表示する
+	case *Vector:
+		in.inspect(obj.ToArray(), depth)
+	case *Map:
+		in.inspect(obj.ToHash(), depth)
+	default:
+		in.out.WriteString(obj.Inspect())
+	}
+}
+
+// 要素がn個ある入れ物を open と close で囲んで表示する。i番目の要素の表示はitemに任せる。
+func (in *inspector) container(obj Object, open, close string, n, depth int, item func(i int)) {
+	if n > 0 && depth >= MaxNesting {
+		in.tooDeep = true
+		in.out.WriteString(open + "..." + close)
+		return
+	}
+	if n > 0 && (in.visiting[obj] || in.opts.MaxDepth > 0 && depth >= in.opts.MaxDepth) {
+		in.out.WriteString(open + "..." + close)
+		return
+	}
+	in.visiting[obj] = true
+	defer delete(in.visiting, obj)
+
+	shown := n
+	if in.opts.MaxElements > 0 && n > in.opts.MaxElements {
+		shown = in.opts.MaxElements
+	}
+
+	in.out.WriteString(open)
+	for i := 0; i < shown; i++ {
+		in.separator(i, depth)
+		item(i)
+	}
+	if shown < n {
+		in.separator(shown, depth)
+		in.out.WriteString(fmt.Sprintf("...(%d more)", n-shown))
+	}
+	if in.opts.Indent != "" && n > 0 {
+		in.out.WriteString("\n" + strings.Repeat(in.opts.Indent, depth))
+	}
+	in.out.WriteString(close)
+}
+
+// i番目の要素の前に置く区切り。
+func (in *inspector) separator(i, depth int) {
+	if in.opts.Indent != "" {
+		if i > 0 {
+			in.out.WriteString(",")
+		}
+		in.out.WriteString("\n" + strings.Repeat(in.opts.Indent, depth+1))
+		return
+	}
+	if i > 0 {
+		in.out.WriteString(", ")
+	}
+}
@@ -0,0 +1,63 @@
+package object
+
+import "sync"
+
+// よく使われる小さな整数は、あらかじめオブジェクトを作っておいて使い回す。
+// ループのカウンタや添字などで、評価のたびにIntegerを作らずに済む。
+// 使い回すので、IntegerやStringのValueを後から書き換えてはいけない。
+const (
+	MinCachedInteger = -128
+	MaxCachedInteger = 1024
+)
+
+var smallIntegers [MaxCachedInteger - MinCachedInteger + 1]*Integer
+
+func init() {
+	for i := range smallIntegers {
+		smallIntegers[i] = &Integer{Value: int64(i + MinCachedInteger)}
+	}
+}
+
+// 整数のオブジェクトを返す。MinCachedInteger から MaxCachedInteger の範囲なら作っておいたものを返す。
+func NewInteger(value int64) *Integer {
+	if value >= MinCachedInteger && value <= MaxCachedInteger {
+		return smallIntegers[value-MinCachedInteger]
+	}
+	return &Integer{Value: value}
+}
+
+// 短い文字列は一度作ったオブジェクトを覚えておいて使い回す（インターン）。
+// メモリを使い続けないように、文字列の長さと覚えておく数には上限を設けている。
+const (
+	MaxInternedStringLength = 32
+	MaxInternedStrings      = 4096
+)
+
+var (
+	internedMu      sync.RWMutex
+	internedStrings = make(map[string]*String)
+)
+
+// 文字列のオブジェクトを返す。短い文字列なら以前に作ったものを返す。
+func NewString(value string) *String {
+	if len(value) > MaxInternedStringLength {
+		return &String{Value: value}
+	}
+
+	internedMu.RLock()
+	s, ok := internedStrings[value]
+	internedMu.RUnlock()
+	if ok {
+		return s
+	}
+
+	s = &String{Value: value}
+	internedMu.Lock()
+	if existing, ok := internedStrings[value]; ok {
+		s = existing
+	} else if len(internedStrings) < MaxInternedStrings {
+		internedStrings[value] = s
+	}
+	internedMu.Unlock()
+	return s
+}
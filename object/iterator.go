@@ -0,0 +1,163 @@
+package object
+
+import "fmt"
+
+// for-inなどで要素を一つずつ取り出せるオブジェクトはIterableインタフェースを満たす。
+// Iterator()は呼ばれるたびに先頭から数え直す新しいIteratorを返す。
+type Iterable interface {
+	Iterator() Iterator
+}
+
+// Nextは次の要素を返す。要素を全て取り出し終わった後はfalseを返す。
+type Iterator interface {
+	Next() (Object, bool)
+}
+
+// Iteratorから残りの要素を全て取り出して配列にする。
+// 配列の展開や、Iterableを受け取る組み込み関数で使う。
+func Collect(it Iterator) []Object {
+	var elements []Object
+	for {
+		el, ok := it.Next()
+		if !ok {
+			return elements
+		}
+		elements = append(elements, el)
+	}
+}
+
+type arrayIterator struct {
+	elements []Object
+	index    int
+}
+
+func (it *arrayIterator) Next() (Object, bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
+	}
+	el := it.elements[it.index]
+	it.index++
+	return el, true
+}
+
+// 配列は要素を先頭から順に返す。
+// ループの中で push! などで配列を変更しても影響を受けないように、作った時点の要素をコピーしておく。
+func (ao *Array) Iterator() Iterator {
+	elements := make([]Object, len(ao.Elements))
+	copy(elements, ao.Elements)
+	return &arrayIterator{elements: elements}
+}
+
+// ハッシュは [キー, バリュー] の配列を要素として返す。
+// goのmapをそのまま使っているので、取り出される順番は保証されない。
+func (h *Hash) Iterator() Iterator {
+	pairs := make([]Object, 0, len(h.Pairs))
+	for _, pair := range h.OrderedPairs() {
+		pairs = append(pairs, &Array{Elements: []Object{pair.Key, pair.Value}})
+	}
+	return &arrayIterator{elements: pairs}
+}
+
+// 文字列は一文字ずつ、長さ1の文字列を要素として返す。
+// lenはバイト数を返すが、ここではマルチバイト文字を壊さないようにrune単位で区切る。
+func (s *String) Iterator() Iterator {
+	runes := []rune(s.Value)
+	elements := make([]Object, len(runes))
+	for i, r := range runes {
+		elements[i] = NewString(string(r))
+	}
+	return &arrayIterator{elements: elements}
+}
+
+// セットは要素を追加された順に返す。
+func (s *Set) Iterator() Iterator {
+	elements := make([]Object, 0, len(s.Keys))
+	for _, key := range s.Keys {
+		elements = append(elements, s.Elements[key])
+	}
+	return &arrayIterator{elements: elements}
+}
+
+// Start から Stop の手前まで、Step ずつ増えていく整数の並び。
+// 要素を配列として持たず、取り出すたびに計算するので巨大な範囲でもメモリを消費しない。
+type Range struct {
+	Start int64
+	Stop  int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+type rangeIterator struct {
+	r       *Range
+	current int64
+}
+
+func (it *rangeIterator) Next() (Object, bool) {
+	// Stepが負の場合は減っていく方向に数える。Stepが0の場合は要素なしとして扱う（無限ループ防止）。
+	switch {
+	case it.r.Step > 0 && it.current >= it.r.Stop:
+		return nil, false
+	case it.r.Step < 0 && it.current <= it.r.Stop:
+		return nil, false
+	case it.r.Step == 0:
+		return nil, false
+	}
+	v := it.current
+	it.current += it.r.Step
+	return NewInteger(v), true
+}
+
+func (r *Range) Iterator() Iterator {
+	return &rangeIterator{r: r, current: r.Start}
+}
+
+// 要素の数。要素を取り出さずに計算する。
+func (r *Range) Len() int64 {
+	switch {
+	case r.Step > 0 && r.Start < r.Stop:
+		return (r.Stop - r.Start + r.Step - 1) / r.Step
+	case r.Step < 0 && r.Start > r.Stop:
+		return (r.Start - r.Stop - r.Step - 1) / -r.Step
+	}
+	return 0
+}
+
+// 元のIterableの要素を、先頭からの番号と組にした [番号, 要素] の配列として返す。
+// Rangeと同じく、要素は取り出すたびに元のIterableから一つずつ取り出す。
+type Enumerate struct {
+	Source Iterable
+}
+
+func (e *Enumerate) Type() ObjectType { return ENUMERATE_OBJ }
+func (e *Enumerate) Inspect() string {
+	if source, ok := e.Source.(Object); ok {
+		return fmt.Sprintf("enumerate(%s)", source.Inspect())
+	}
+	return "enumerate(...)"
+}
+
+type enumerateIterator struct {
+	source Iterator
+	index  int64
+}
+
+func (it *enumerateIterator) Next() (Object, bool) {
+	el, ok := it.source.Next()
+	if !ok {
+		return nil, false
+	}
+	pair := &Array{Elements: []Object{NewInteger(it.index), el}}
+	it.index++
+	return pair, true
+}
+
+func (e *Enumerate) Iterator() Iterator {
+	return &enumerateIterator{source: e.Source.Iterator()}
+}
@@ -0,0 +1,179 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// オブジェクトをJSONにする。
+// 整数、小数、文字列、真偽値、null、配列、ハッシュ（変更できないVectorとMapも）を変換できる。ハッシュのキーは文字列でなければならない。
+// 出力が毎回同じになるように、ハッシュはキーの順番に並べる。
+// 自分自身を含む配列やハッシュはJSONにできないので、エラーを返す。
+func ToJSON(obj Object) ([]byte, error) {
+	var out bytes.Buffer
+	if err := writeJSON(&out, obj, 0, make(map[Object]bool)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// depthはobjが入っている配列とハッシュの数。MaxNestingを超えたらErrTooDeepを返す。
+// visitingは書き出している途中の配列とハッシュで、これが再び現れたら循環している。
+func writeJSON(out *bytes.Buffer, obj Object, depth int, visiting map[Object]bool) error {
+	if depth > MaxNesting {
+		return ErrTooDeep
+	}
+	switch obj.(type) {
+	case *Array, *Hash:
+		if visiting[obj] {
+			return fmt.Errorf("cannot encode cyclic value as JSON")
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+	}
+	switch obj := obj.(type) {
+	case *Null:
+		out.WriteString("null")
+	case *Boolean:
+		out.WriteString(obj.Inspect())
+	case *Integer:
+		out.WriteString(obj.Inspect())
+	case *BigInt:
+		out.WriteString(obj.Inspect())
+	case *Float:
+		// JSONには無限大とNaNがない
+		if math.IsInf(obj.Value, 0) || math.IsNaN(obj.Value) {
+			return fmt.Errorf("cannot encode %s as JSON", obj.Inspect())
+		}
+		// 2.0が2になると読み戻したときに整数になってしまうので、小数点を残す
+		b, _ := json.Marshal(obj.Value)
+		out.Write(b)
+		if !bytes.ContainsAny(b, ".eE") {
+			out.WriteString(".0")
+		}
+	case *String:
+		b, _ := json.Marshal(obj.Value)
+		out.Write(b)
+	case *Array:
+		out.WriteString("[")
+		for i, el := range obj.Elements {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			if err := writeJSON(out, el, depth+1, visiting); err != nil {
+				return err
+			}
+		}
+		out.WriteString("]")
+	case *Hash:
+		// キーは追加された順番に書き出す
+		pairs := obj.OrderedPairs()
+		for _, pair := range pairs {
+			if _, ok := pair.Key.(*String); !ok {
+				return fmt.Errorf("cannot encode hash key %s as JSON", pair.Key.Type())
+			}
+		}
+
+		out.WriteString("{")
+		for i, pair := range pairs {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			b, _ := json.Marshal(pair.Key.(*String).Value)
+			out.Write(b)
+			out.WriteString(":")
+			if err := writeJSON(out, pair.Value, depth+1, visiting); err != nil {
+				return err
+			}
+		}
+		out.WriteString("}")
+	case *Vector:
+		return writeJSON(out, obj.ToArray(), depth, visiting)
+	case *Map:
+		return writeJSON(out, obj.ToHash(), depth, visiting)
+	default:
+		return fmt.Errorf("cannot encode %s as JSON", obj.Type())
+	}
+	return nil
+}
+
+// JSONをオブジェクトにする。
+// 数値は小数点や指数がなければ整数（int64に収まらなければBigInt）、あれば小数になる。オブジェクトはハッシュになる。
+func FromJSON(data []byte) (Object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	// 値の後ろに余計なものが続いていたらエラー
+	if dec.More() {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return fromJSONValue(v)
+}
+
+func fromJSONValue(v interface{}) (Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return NULL, nil
+	case bool:
+		if v {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case json.Number:
+		s := v.String()
+		if !strings.ContainsAny(s, ".eE") {
+			n, ok := new(big.Int).SetString(s, 10)
+			if ok && n.IsInt64() {
+				return NewInteger(n.Int64()), nil
+			}
+			if ok {
+				return &BigInt{Value: n}, nil
+			}
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return &Float{Value: f}, nil
+	case string:
+		return &String{Value: v}, nil
+	case []interface{}:
+		elements := make([]Object, len(v))
+		for i, el := range v {
+			obj, err := fromJSONValue(el)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = obj
+		}
+		return &Array{Elements: elements}, nil
+	case map[string]interface{}:
+		// goのmapになった時点で元の順番は分からないので、キーの順に追加する
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		hash := NewHash()
+		for _, k := range keys {
+			value, err := fromJSONValue(v[k])
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&String{Value: k}, value)
+		}
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("unexpected JSON value %T", v)
+	}
+}
@@ -4,28 +4,61 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
 	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 type BuiltinFunction func(args ...Object) Object
+
+// 呼び出した場所の環境が必要な組み込み関数。sleepが環境のContextを見るのに使う。
+type EnvBuiltinFunction func(env *Environment, args ...Object) Object
 type ObjectType string
 
 const (
-	NULL_OBJ  = "NULL"
-	ERROR_OBJ = "ERROR"
+	NULL_OBJ        = "NULL"
+	ERROR_OBJ       = "ERROR"
+	ERROR_VALUE_OBJ = "ERROR_VALUE"
 
 	INTEGER_OBJ = "INTEGER"
+	FLOAT_OBJ   = "FLOAT"
+	BIGINT_OBJ  = "BIGINT"
 	BOOLEAN_OBJ = "BOOLEAN"
 	STRING_OBJ  = "STRING"
 
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
-
-	FUNCTION_OBJ = "FUNCTION"
-	BUILTIN_OBJ  = "BUILTIN"
-
-	ARRAY_OBJ = "ARRAY"
-	HASH_OBJ  = "HASH"
+	EXCEPTION_OBJ    = "EXCEPTION"
+	EXIT_OBJ         = "EXIT"
+
+	FUNCTION_OBJ          = "FUNCTION"
+	BUILTIN_OBJ           = "BUILTIN"
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+
+	ARRAY_OBJ     = "ARRAY"
+	HASH_OBJ      = "HASH"
+	RANGE_OBJ     = "RANGE"
+	ENUMERATE_OBJ = "ENUMERATE"
+	SET_OBJ       = "SET"
+	VECTOR_OBJ    = "VECTOR"
+	MAP_OBJ       = "MAP"
+	REGEX_OBJ     = "REGEX"
+
+	STRING_BUILDER_OBJ = "STRING_BUILDER"
+
+	GO_VALUE_OBJ = "GO_VALUE"
+	MODULE_OBJ   = "MODULE"
+
+	CLASS_OBJ        = "CLASS"
+	INSTANCE_OBJ     = "INSTANCE"
+	BOUND_METHOD_OBJ = "BOUND_METHOD"
 )
 
 type HashKey struct {
@@ -52,6 +85,48 @@ func (i *Integer) HashKey() HashKey { // Integerをhashのキーとして使う
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// int64に収まらない整数。整数の計算がオーバーフローした時にだけ作られる。
+// 計算結果がint64に収まる場合はIntegerに戻すので、BigIntと同じ値のIntegerが同時に存在することはない。
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+func (b *BigInt) HashKey() HashKey { // BigIntをhashのキーとして使う場合、この関数を用いる
+	h := fnv.New64a()
+	h.Write([]byte(b.Value.String()))
+
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// 整数と区別がつくように、 2.0 のような値でも小数点をつける。
+func (f *Float) Inspect() string {
+	if math.IsInf(f.Value, 0) || math.IsNaN(f.Value) {
+		return strconv.FormatFloat(f.Value, 'f', -1, 64)
+	}
+	s := strconv.FormatFloat(f.Value, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// Floatをhashのキーとして使う場合、この関数を用いる。
+// 1 == 1.0 なので、整数と同じ値の小数は整数と同じキーにする。 {1: "a"}[1.0] は "a" になる。
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && math.Abs(f.Value) < 1<<63 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -70,6 +145,13 @@ func (b *Boolean) HashKey() HashKey { // Booleanをhashのキーとして使う
 	return HashKey{Type: b.Type(), Value: value}
 }
 
+// null、true、falseは一つずつしか作らない。評価器はポインタで比較するので、値を作るときは必ずこれらを使うこと。
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -82,18 +164,87 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
-// もし字句解析器がエラー発生時、行やカラムの番号をトークンに付与するようになっていれば、ここにはそのプロパティが追加されるだろう
+// throwされた値をtry/catchまで運ぶためのラッパー。
+// ReturnValueと同じく、評価を中断して呼び出し元へ伝播していく。catchされなければプログラムのエラーになる。
+type Exception struct {
+	Value Object
+	Pos   token.Position // throwした位置。catchされなかった場合のエラーの位置になる
+}
+
+func (ex *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (ex *Exception) Inspect() string  { return "EXCEPTION: " + ex.Value.Inspect() }
+
+// exit(code)が呼ばれたことを表す。エラーと同じく評価を中断してプログラムの外まで伝播し、tryでもcatchされない。
+type Exit struct {
+	Code int64
+}
+
+func (e *Exit) Type() ObjectType { return EXIT_OBJ }
+func (e *Exit) Inspect() string  { return fmt.Sprintf("exit(%d)", e.Code) }
+
+// ErrorのInspectで表示する呼び出しの履歴の数
+const maxInspectedFrames = 20
+
 type Error struct {
-	Message string
+	Message  string
+	Stack    []StackFrame   // エラーが発生した関数から順に、呼び出し元へさかのぼった呼び出しの履歴
+	Pos      token.Position // エラーが発生したノードの位置。分からない場合はLineが0
+	Function string         // エラーが発生した場所を囲んでいる関数の名前。トップレベルなら空文字
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString("ERROR: " + e.Message)
+	if e.Pos.Line > 0 {
+		out.WriteString(" (at " + e.Pos.String())
+		if e.Function != "" {
+			out.WriteString(" in " + e.Function)
+		}
+		out.WriteString(")")
+	}
+	for i, frame := range e.Stack {
+		// 無限の再帰などでスタックが長い場合は、先頭と末尾だけを表示する
+		if len(e.Stack) > maxInspectedFrames && i == maxInspectedFrames/2 {
+			out.WriteString(fmt.Sprintf("\n\t... %d more", len(e.Stack)-maxInspectedFrames))
+		}
+		if len(e.Stack) > maxInspectedFrames && i >= maxInspectedFrames/2 && i < len(e.Stack)-maxInspectedFrames/2 {
+			continue
+		}
+		out.WriteString("\n\tat " + frame.String())
+	}
+
+	return out.String()
+}
+
+// スクリプトから扱えるエラーの値。error("...")で作るか、tryでcatchした組み込みのエラーがこれになる。
+// Errorと違って評価を中断させないので、変数に入れたり関数から返したりできる。throwすれば例外として投げられる。
+// スクリプトからは e.message でメッセージを取り出せる。
+type ErrorValue struct {
+	Err *Error // 元になったエラー。error("...")で作った場合は位置を持たない
+}
+
+func (ev *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (ev *ErrorValue) Inspect() string  { return "error: " + ev.Err.Message }
+
+// 関数呼び出し一回分の情報。エラーが関数呼び出しを抜けるたびに、評価器が呼び出し式の情報を積んでいく。
+type StackFrame struct {
+	Function string         // 呼び出された関数の名前。無名関数の場合は<anonymous>
+	Pos      token.Position // 呼び出し式の位置
+}
+
+func (f StackFrame) String() string {
+	return fmt.Sprintf("%s (%s)", f.Function, f.Pos)
+}
 
 type Function struct {
 	Parameters []*ast.Identifier   // 引数
+	Rest       *ast.Identifier     // 可変長引数。ない場合はnil
 	Body       *ast.BlockStatement // 処理内容
 	Env        *Environment
+	Slots      []string // ローカル変数の名前。呼び出したときのスコープのslotになる
+	Name       string   // 宣言した名前。fn name() {} や let name = fn() {} で作った関数でなければ空文字
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -104,6 +255,9 @@ func (f *Function) Inspect() string {
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
 
 	out.WriteString("fn")
 	out.WriteString("(")
@@ -115,45 +269,109 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// compilerが関数リテラルをコンパイルしたもの。定数プールに入り、VMがクロージャにして呼び出す。
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int            // 引数を含むローカル変数の数
+	NumParameters int            // 可変長引数を含まない引数の数
+	Rest          bool           // 可変長引数を受け取るか。受け取る場合は、NumParameters番目のローカル変数に残りの引数の配列が入る
+	Name          string         // 宣言した名前。無名関数なら空文字
+	Locals        []string       // ローカル変数の名前。ローカル変数の番号の順に並ぶ
+	Sources       code.SourceMap // 命令のソースコード上の位置。実行時のエラーの位置に使う
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// VMで関数を呼び出したときのローカル変数。
+// 関数から返った後もクロージャから参照できるように、呼び出しごとにスタックの外に作る。
+type Locals struct {
+	Values []Object
+	Names  []string // 変数の名前。Valuesと同じ順に並ぶ
+	Outer  *Locals  // 関数を作った場所のローカル変数。トップレベルで作った関数ならnil
+}
+
+// クロージャを実行するもの。vm.VMが実装する。
+type ClosureRunner interface {
+	RunClosure(env *Environment, cl *Closure, args []Object) Object
+}
+
+// VMが関数リテラルを評価して作る関数の値。作った場所のローカル変数を参照し続ける。
+type Closure struct {
+	Fn     *CompiledFunction
+	Outer  *Locals
+	Runner ClosureRunner // クロージャを作ったVM。組み込み関数から呼び出されたときにこれで実行する
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+func (c *Closure) Call(env *Environment, args []Object) Object {
+	return c.Runner.RunClosure(env, c, args)
+}
+
+// 評価器の外で実行される関数。mapなどの組み込み関数は、これを満たす値も関数として呼び出す。
+// envは呼び出し元の環境で、そのContextで実行する。
+type Callable interface {
+	Object
+	Call(env *Environment, args []Object) Object
+}
+
 type String struct {
+	// HashKeyの計算結果。hashedが1なら計算済み。
+	// インターンされた文字列は複数のgoroutineから使われることがあるので、atomicで読み書きする。
+	// 32bit環境でもatomicで読み書きできるように、uint64のフィールドを先頭に置いている。
+	hash   uint64
+	hashed uint32
+
 	Value string
 }
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
-func (s *String) HashKey() HashKey { // Stringをhashのキーとして使う場合、この関数を用いる
+
+// Stringをhashのキーとして使う場合、この関数を用いる。
+// ハッシュへのアクセスのたびにFNVを計算し直さないよう、一度計算したキーはオブジェクトに覚えておく。
+// Valueは書き換えない前提なので、覚えたキーが古くなることはない。
+func (s *String) HashKey() HashKey {
+	if atomic.LoadUint32(&s.hashed) == 1 {
+		return HashKey{Type: s.Type(), Value: atomic.LoadUint64(&s.hash)}
+	}
+
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
+	value := h.Sum64()
 
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+	atomic.StoreUint64(&s.hash, value)
+	atomic.StoreUint32(&s.hashed, 1)
+	return HashKey{Type: s.Type(), Value: value}
 }
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn    BuiltinFunction
+	FnEnv EnvBuiltinFunction // nilでなければFnの代わりに呼ばれる
+
+	// help()で表示する情報。登録簿に登録されるときに設定される
+	Name      string // 登録された名前
+	Signature string // "len(x)" のような呼び出し方
+	Doc       string // 一行の説明
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// 配列は参照で共有される。 let b = a; の後で push!(a, 1) のように配列を変更すると、bからも変更が見える。
+// 変更しても他の配列が変わらないように、Elementsのスライスは配列ごとに作り、他の配列と共有しない。
 type Array struct {
 	Elements []Object
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
-	var out bytes.Buffer
-
-	var elements []string
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
-	}
-
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
-	return out.String()
-}
+func (ao *Array) Inspect() string  { return InspectWith(ao, InspectOptions{}) }
 
 type HashPair struct {
 	Key   Object
@@ -172,23 +390,181 @@ type HashPair struct {
 // なのでHash.Pairsはキーもバリューも構造体になっているmap。
 // 実際に評価する際はこんな感じのコードになる。
 // pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+//
+// goのmapだけだと順番が決まらないので、Setと同じく追加された順番をKeysに持っておく。keys、values、for-inはこの順番になる。
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	Keys  []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
 
-	var pairs []string
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+// キーと値の組を追加する。すでに同じキーがある場合は値を置き換え、順番はそのまま。
+// キーがHashableでなければ何もせずfalseを返す。
+func (h *Hash) Set(key, value Object) bool {
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return false
+	}
+	if h.Pairs == nil {
+		h.Pairs = make(map[HashKey]HashPair)
 	}
+	hashed := hashable.HashKey()
+	if _, exists := h.Pairs[hashed]; !exists {
+		h.Keys = append(h.Keys, hashed)
+	}
+	h.Pairs[hashed] = HashPair{Key: key, Value: value}
+	return true
+}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+// キーを取り除く。
+func (h *Hash) Delete(key HashKey) {
+	if _, exists := h.Pairs[key]; !exists {
+		return
+	}
+	delete(h.Pairs, key)
+	for i, k := range h.Keys {
+		if k == key {
+			h.Keys = append(h.Keys[:i:i], h.Keys[i+1:]...)
+			break
+		}
+	}
+}
 
-	return out.String()
+// 追加された順番にペアを返す。
+// Keysを使わずにPairsだけで作られたハッシュでは、Keysにないペアをキーの表示の順に後ろに並べる。
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	seen := make(map[HashKey]bool, len(h.Keys))
+	for _, k := range h.Keys {
+		if pair, ok := h.Pairs[k]; ok && !seen[k] {
+			pairs = append(pairs, pair)
+			seen[k] = true
+		}
+	}
+	if len(pairs) == len(h.Pairs) {
+		return pairs
+	}
+
+	var rest []HashPair
+	for k, pair := range h.Pairs {
+		if !seen[k] {
+			rest = append(rest, pair)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i].Key.Inspect() < rest[j].Key.Inspect()
+	})
+	return append(pairs, rest...)
+}
+
+// ペアは追加された順番に並べる。
+func (h *Hash) Inspect() string { return InspectWith(h, InspectOptions{}) }
+
+// 重複のない値の集まり。要素になれるのはハッシュのキーと同じく、Hashableなオブジェクトだけ。
+// goのmapだけだと順番が決まらないので、追加された順番をKeysに持っておく。Inspectやfor-inはこの順番になる。
+type Set struct {
+	Elements map[HashKey]Object
+	Keys     []HashKey
 }
+
+func NewSet() *Set {
+	return &Set{Elements: make(map[HashKey]Object)}
+}
+
+func (s *Set) Type() ObjectType { return SET_OBJ }
+func (s *Set) Inspect() string  { return InspectWith(s, InspectOptions{}) }
+
+// 要素を追加する。すでに同じ値の要素がある場合は何もしない。
+func (s *Set) Add(obj Object) bool {
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return false
+	}
+	key := hashable.HashKey()
+	if _, exists := s.Elements[key]; !exists {
+		s.Elements[key] = obj
+		s.Keys = append(s.Keys, key)
+	}
+	return true
+}
+
+func (s *Set) Contains(obj Object) bool {
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return false
+	}
+	_, exists := s.Elements[hashable.HashKey()]
+	return exists
+}
+
+// モジュール（名前空間）。Envにはモジュールが外に公開する束縛だけが入っている。
+// math.sqrt のように . でEnvの束縛を参照する。
+type Module struct {
+	Name string
+	Env  *Environment
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "module(" + m.Name + ")" }
+
+// class文で宣言したクラス。関数のように呼び出すとインスタンスを作る。
+type Class struct {
+	Name    string
+	Fields  []string          // フィールド名。インスタンスを作る時の引数の順番
+	Methods map[string]Object // メソッド名とメソッド。評価器ではクラスを宣言した場所をEnvに持つFunction、VMではClosure
+}
+
+func (c *Class) Type() ObjectType { return CLASS_OBJ }
+func (c *Class) Inspect() string  { return "class " + c.Name }
+
+// クラスのインスタンス。フィールドの値を持ち、メソッドはクラスから探す。
+type Instance struct {
+	Class  *Class
+	Fields map[string]Object
+}
+
+func (i *Instance) Type() ObjectType { return INSTANCE_OBJ }
+func (i *Instance) Inspect() string  { return InspectWith(i, InspectOptions{}) }
+
+// レシーバと結びついたメソッド。 p.sum や "abc".upper を評価するとできる。
+// 変数に入れたり関数に渡したりした後で呼び出しても、レシーバは取り出した時のものになる。
+// MethodはFunction（クラスのメソッド。selfにレシーバが束縛される）か、
+// Builtin（組み込みの型のメソッド。レシーバが最初の引数として渡される）のどちらか。
+type BoundMethod struct {
+	Receiver Object
+	Method   Object
+	Name     string
+}
+
+func (bm *BoundMethod) Type() ObjectType { return BOUND_METHOD_OBJ }
+func (bm *BoundMethod) Inspect() string {
+	receiver := string(bm.Receiver.Type())
+	if instance, ok := bm.Receiver.(*Instance); ok {
+		receiver = instance.Class.Name
+	}
+	return "bound method " + receiver + "." + bm.Name
+}
+
+// string_builder()で作る、文字列を少しずつ組み立てる入れ物。
+// s = s + piece を繰り返すと毎回それまでの文字列全体をコピーするが、StringBuilderは書き足した分だけをコピーする。
+type StringBuilder struct {
+	Builder strings.Builder
+}
+
+func (b *StringBuilder) Type() ObjectType { return STRING_BUILDER_OBJ }
+func (b *StringBuilder) Inspect() string {
+	return "string_builder(" + strconv.Quote(b.Builder.String()) + ")"
+}
+
+// regex("...")で作るコンパイル済みの正規表現。構文はgoのregexpパッケージと同じ。
+type Regex struct {
+	Value *regexp.Regexp
+}
+
+func (r *Regex) Type() ObjectType { return REGEX_OBJ }
+func (r *Regex) Inspect() string  { return "regex(" + strconv.Quote(r.Value.String()) + ")" }
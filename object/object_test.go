@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
 
 // ハッシュのキーには文字列、数値、booleanが使えるようにしている。ここで注意するところがある。
 // 下記のコードで出てくる、二つの"name"は、Valueこそ一緒だが異なるStringオブジェクトとして生成されており、挿しているポインタは別物
@@ -80,3 +85,734 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	half1 := &Float{Value: 0.5}
+	half2 := &Float{Value: 0.5}
+	quarter := &Float{Value: 0.25}
+
+	if half1.HashKey() != half2.HashKey() {
+		t.Errorf("floats with same content have different hash keys")
+	}
+
+	if half1.HashKey() == quarter.HashKey() {
+		t.Errorf("floats with different content have same hash keys")
+	}
+
+	// 整数と同じ値の小数は、整数と同じキーになる
+	if (&Float{Value: 2.0}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("2.0 and 2 have different hash keys")
+	}
+	if (&Float{Value: -3.0}).HashKey() != (&Integer{Value: -3}).HashKey() {
+		t.Errorf("-3.0 and -3 have different hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1000000000000000000000.0"},
+		{math.Inf(1), "+Inf"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("Inspect wrong. expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestIterators(t *testing.T) {
+	set := NewSet()
+	set.Add(&Integer{Value: 1})
+	set.Add(&Integer{Value: 2})
+	set.Add(&Integer{Value: 1})
+
+	tests := []struct {
+		iterable Iterable
+		expected []string
+	}{
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, []string{"1", "a"}},
+		{&String{Value: "héy"}, []string{"h", "é", "y"}},
+		{&Range{Start: 0, Stop: 3, Step: 1}, []string{"0", "1", "2"}},
+		{&Range{Start: 3, Stop: 0, Step: -2}, []string{"3", "1"}},
+		{set, []string{"1", "2"}},
+		{&Array{}, nil},
+	}
+
+	for _, tt := range tests {
+		elements := Collect(tt.iterable.Iterator())
+		if len(elements) != len(tt.expected) {
+			t.Errorf("wrong number of elements for %s. expected=%d, got=%d",
+				tt.iterable.(Object).Inspect(), len(tt.expected), len(elements))
+			continue
+		}
+		for i, el := range elements {
+			if el.Inspect() != tt.expected[i] {
+				t.Errorf("elements[%d] wrong. expected=%q, got=%q", i, tt.expected[i], el.Inspect())
+			}
+		}
+	}
+
+	// Iterator()は呼ぶたびに先頭から数え直す
+	arr := &Array{Elements: []Object{&Integer{Value: 1}}}
+	Collect(arr.Iterator())
+	if len(Collect(arr.Iterator())) != 1 {
+		t.Errorf("Iterator() does not restart from the beginning")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	big1 := &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 70)}
+
+	tests := []struct {
+		a, b     Object
+		expected int
+		ok       bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1, true},
+		{&Integer{Value: 2}, &Integer{Value: 2}, 0, true},
+		{&Integer{Value: 3}, &Float{Value: 2.5}, 1, true},
+		{&Float{Value: 1.0}, &Integer{Value: 1}, 0, true},
+		{&Integer{Value: math.MaxInt64}, big1, -1, true},
+		{big1, &Float{Value: 1.5}, 1, true},
+		{&String{Value: "abc"}, &String{Value: "abd"}, -1, true},
+		{&String{Value: "b"}, &String{Value: "a"}, 1, true},
+		{&Boolean{Value: false}, &Boolean{Value: true}, -1, true},
+		{&Boolean{Value: true}, &Boolean{Value: true}, 0, true},
+		{&Integer{Value: 1}, &String{Value: "1"}, 0, false},
+		{&Null{}, &Null{}, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := Compare(tt.a, tt.b)
+		if ok != tt.ok || got != tt.expected {
+			t.Errorf("Compare(%s, %s) wrong. expected=(%d, %t), got=(%d, %t)",
+				tt.a.Inspect(), tt.b.Inspect(), tt.expected, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	// キーは追加された順番に書き出す
+	hash := NewHash()
+	for _, k := range []string{"b", "a"} {
+		hash.Set(&String{Value: k}, &Integer{Value: int64(len(hash.Pairs))})
+	}
+	intKey := &Integer{Value: 1}
+	cyclic := &Array{Elements: testIntegers(1)}
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+	shared := &Array{Elements: testIntegers(1)}
+
+	tests := []struct {
+		obj      Object
+		expected string
+		err      string
+	}{
+		{NULL, "null", ""},
+		{TRUE, "true", ""},
+		{&Integer{Value: -5}, "-5", ""},
+		{&Float{Value: 2.5}, "2.5", ""},
+		{&Float{Value: 2}, "2.0", ""},
+		{&String{Value: "a\"b\n"}, `"a\"b\n"`, ""},
+		{&Array{Elements: []Object{&Integer{Value: 1}, NULL, &Array{}}}, "[1,null,[]]", ""},
+		{hash, `{"b":0,"a":1}`, ""},
+		{&Float{Value: math.Inf(1)}, "", "cannot encode +Inf as JSON"},
+		{&Hash{Pairs: map[HashKey]HashPair{intKey.HashKey(): {Key: intKey, Value: NULL}}}, "", "cannot encode hash key INTEGER as JSON"},
+		{&Function{}, "", "cannot encode FUNCTION as JSON"},
+		{cyclic, "", "cannot encode cyclic value as JSON"},
+		// 同じ配列が二回出てくるだけなら循環ではない
+		{&Array{Elements: []Object{shared, shared}}, "[[1],[1]]", ""},
+	}
+
+	for _, tt := range tests {
+		data, err := ToJSON(tt.obj)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("wrong error. expected=%q, got=%v", tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if string(data) != tt.expected {
+			t.Errorf("ToJSON wrong. expected=%q, got=%q", tt.expected, string(data))
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"null", "null"},
+		{"true", "true"},
+		{"42", "42"},
+		{"4.5", "4.5"},
+		{"1e2", "100.0"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{`"hi"`, "hi"},
+		{`[1, "a", [false]]`, "[1, a, [false]]"},
+		{`{"k": {"n": null}}`, "{k: {n: null}}"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromJSON([]byte(tt.input))
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromJSON(%q) wrong. expected=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	if obj, _ := FromJSON([]byte("null")); obj != NULL {
+		t.Errorf("null is not the NULL singleton")
+	}
+	if obj, _ := FromJSON([]byte("123456789012345678901234567890")); obj.Type() != BIGINT_OBJ {
+		t.Errorf("large integer is not BIGINT. got=%s", obj.Type())
+	}
+	for _, input := range []string{"", "[1,", "1 2"} {
+		if _, err := FromJSON([]byte(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	for _, v := range []int64{MinCachedInteger, 0, 1, MaxCachedInteger} {
+		if NewInteger(v) != NewInteger(v) {
+			t.Errorf("NewInteger(%d) is not cached", v)
+		}
+		if NewInteger(v).Value != v {
+			t.Errorf("NewInteger(%d) has wrong value %d", v, NewInteger(v).Value)
+		}
+	}
+	for _, v := range []int64{MinCachedInteger - 1, MaxCachedInteger + 1, math.MaxInt64} {
+		if NewInteger(v) == NewInteger(v) {
+			t.Errorf("NewInteger(%d) should not be cached", v)
+		}
+		if NewInteger(v).Value != v {
+			t.Errorf("NewInteger(%d) has wrong value %d", v, NewInteger(v).Value)
+		}
+	}
+}
+
+func TestNewString(t *testing.T) {
+	if NewString("hello") != NewString("hello") {
+		t.Errorf("short strings are not interned")
+	}
+	if NewString("hello") == NewString("world") {
+		t.Errorf("different strings share an object")
+	}
+
+	long := strings.Repeat("a", MaxInternedStringLength+1)
+	if NewString(long) == NewString(long) {
+		t.Errorf("long strings should not be interned")
+	}
+	if NewString(long).Value != long {
+		t.Errorf("NewString has wrong value")
+	}
+}
+
+func TestStringHashKeyCached(t *testing.T) {
+	s := &String{Value: "cached"}
+	first := s.HashKey()
+	if second := s.HashKey(); second != first {
+		t.Errorf("cached hash key differs. first=%+v, second=%+v", first, second)
+	}
+	if fresh := (&String{Value: "cached"}).HashKey(); fresh != first {
+		t.Errorf("cached hash key differs from a fresh one. cached=%+v, fresh=%+v", first, fresh)
+	}
+}
+
+var benchmarkKey = strings.Repeat("key", 10)
+
+// 同じStringオブジェクトのHashKeyを何度も呼ぶ。キャッシュが効く場合。
+func BenchmarkStringHashKey(b *testing.B) {
+	s := &String{Value: benchmarkKey}
+	for i := 0; i < b.N; i++ {
+		s.HashKey()
+	}
+}
+
+// 毎回新しいStringオブジェクトのHashKeyを呼ぶ。キャッシュがない場合と同じ。
+func BenchmarkStringHashKeyUncached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		(&String{Value: benchmarkKey}).HashKey()
+	}
+}
+
+func TestInspectWith(t *testing.T) {
+	key := NewString("k")
+	hash := &Hash{Pairs: map[HashKey]HashPair{
+		key.HashKey(): {Key: key, Value: &Array{Elements: testIntegers(1, 2)}},
+	}}
+	nested := &Array{Elements: []Object{NewInteger(1), &Array{Elements: []Object{&Array{Elements: testIntegers(2)}}}}}
+
+	tests := []struct {
+		obj      Object
+		opts     InspectOptions
+		expected string
+	}{
+		{&Array{Elements: testIntegers(1, 2, 3)}, InspectOptions{}, "[1, 2, 3]"},
+		{&Array{}, InspectOptions{Indent: "  "}, "[]"},
+		{&Array{Elements: testIntegers(1, 2)}, InspectOptions{Indent: "  "}, "[\n  1,\n  2\n]"},
+		{hash, InspectOptions{Indent: "  "}, "{\n  k: [\n    1,\n    2\n  ]\n}"},
+		{&Array{Elements: testIntegers(1, 2, 3, 4, 5)}, InspectOptions{MaxElements: 2}, "[1, 2, ...(3 more)]"},
+		{nested, InspectOptions{MaxDepth: 1}, "[1, [...]]"},
+		{nested, InspectOptions{MaxDepth: 2}, "[1, [[...]]]"},
+		{nested, InspectOptions{}, "[1, [[2]]]"},
+	}
+
+	for _, tt := range tests {
+		if got := InspectWith(tt.obj, tt.opts); got != tt.expected {
+			t.Errorf("InspectWith wrong. expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestInspectCycle(t *testing.T) {
+	arr := &Array{Elements: testIntegers(1, 2, 3)}
+	arr.Elements = append(arr.Elements, arr)
+	if got := arr.Inspect(); got != "[1, 2, 3, [...]]" {
+		t.Errorf("self-referential array wrong. got=%q", got)
+	}
+
+	key := NewString("self")
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: &Array{Elements: []Object{hash}}}
+	if got := hash.Inspect(); got != "{self: [{...}]}" {
+		t.Errorf("self-referential hash wrong. got=%q", got)
+	}
+
+	// 同じ配列が二回出てくるだけなら循環ではない
+	inner := &Array{Elements: testIntegers(1, 2, 3)}
+	twice := &Array{Elements: []Object{inner, inner}}
+	if got := twice.Inspect(); got != "[[1, 2, 3], [1, 2, 3]]" {
+		t.Errorf("shared array wrong. got=%q", got)
+	}
+}
+
+func TestNesting(t *testing.T) {
+	nested := func(n int) Object {
+		var obj Object = &Array{}
+		for i := 0; i < n; i++ {
+			obj = &Array{Elements: []Object{obj}}
+		}
+		return obj
+	}
+
+	// MaxNestingより深い値は、goのスタックを使い切る前にエラーにする
+	deep := nested(MaxNesting * 2)
+	if got := deep.Inspect(); !strings.HasSuffix(got, "[...]"+strings.Repeat("]", MaxNesting)) {
+		t.Errorf("deep array not abbreviated. got=...%q", got[len(got)-20:])
+	}
+	if _, err := InspectChecked(deep); err != ErrTooDeep {
+		t.Errorf("InspectChecked: expected ErrTooDeep. got=%v", err)
+	}
+	if _, err := ToJSON(deep); err != ErrTooDeep {
+		t.Errorf("ToJSON: expected ErrTooDeep. got=%v", err)
+	}
+	var v interface{}
+	if err := ToGo(deep, &v); err != ErrTooDeep {
+		t.Errorf("ToGo: expected ErrTooDeep. got=%v", err)
+	}
+
+	shallow := nested(MaxNesting / 2)
+	if _, err := InspectChecked(shallow); err != nil {
+		t.Errorf("InspectChecked: unexpected error %v", err)
+	}
+	if _, err := ToJSON(shallow); err != nil {
+		t.Errorf("ToJSON: unexpected error %v", err)
+	}
+	if err := ToGo(shallow, &v); err != nil {
+		t.Errorf("ToGo: unexpected error %v", err)
+	}
+}
+
+func testIntegers(values ...int64) []Object {
+	elements := make([]Object, len(values))
+	for i, v := range values {
+		elements[i] = NewInteger(v)
+	}
+	return elements
+}
+
+func TestEnvironmentSnapshot(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("a", NewInteger(1))
+	env.Set("f", &Float{Value: 2})
+	env.Set("xs", &Array{Elements: []Object{NewString("x"), TRUE, NULL}})
+	env.SetConst("c", NewString("const"))
+	env.Set("fn", &Function{})
+	cyclic := &Array{Elements: testIntegers(1)}
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+	env.Set("cyclic", cyclic)
+
+	data, skipped, err := env.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(skipped) != 2 || skipped[0] != "cyclic" || skipped[1] != "fn" {
+		t.Errorf("skipped wrong. got=%v", skipped)
+	}
+
+	restored := NewEnvironment()
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for name, expected := range map[string]string{"a": "1", "f": "2.0", "xs": "[x, true, null]", "c": "const"} {
+		obj, ok := restored.Get(name)
+		if !ok {
+			t.Errorf("%s is not restored", name)
+			continue
+		}
+		if obj.Inspect() != expected {
+			t.Errorf("%s wrong. expected=%q, got=%q", name, expected, obj.Inspect())
+		}
+	}
+	if obj, _ := restored.Get("f"); obj.Type() != FLOAT_OBJ {
+		t.Errorf("f is not FLOAT. got=%s", obj.Type())
+	}
+	if !restored.IsConst("c") || restored.IsConst("a") {
+		t.Errorf("consts are not restored")
+	}
+	if _, ok := restored.Get("fn"); ok {
+		t.Errorf("fn should not be restored")
+	}
+
+	// constと名前が重なるとエラーになり、何も束縛されない
+	conflict := NewEnvironment()
+	conflict.SetConst("a", NewInteger(0))
+	if err := conflict.Restore(data); err == nil || err.Error() != "cannot restore a: cannot assign to constant: a" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if _, ok := conflict.Get("xs"); ok {
+		t.Errorf("xs should not be restored after an error")
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+	for _, k := range []string{"c", "a", "b"} {
+		hash.Set(NewString(k), NewString(k))
+	}
+	hash.Set(NewString("a"), NewInteger(1)) // 値を置き換えても順番は変わらない
+	hash.Delete(NewString("c").HashKey())
+	if ok := hash.Set(&Array{}, NULL); ok {
+		t.Errorf("array should not be usable as hash key")
+	}
+
+	var keys []string
+	for _, pair := range hash.OrderedPairs() {
+		keys = append(keys, pair.Key.Inspect()+"="+pair.Value.Inspect())
+	}
+	if strings.Join(keys, ",") != "a=1,b=b" {
+		t.Errorf("OrderedPairs wrong. got=%v", keys)
+	}
+
+	// Keysを持たないハッシュはキーの表示の順になる
+	unordered := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, k := range []string{"y", "x"} {
+		key := NewString(k)
+		unordered.Pairs[key.HashKey()] = HashPair{Key: key, Value: NULL}
+	}
+	pairs := unordered.OrderedPairs()
+	if len(pairs) != 2 || pairs[0].Key.Inspect() != "x" || pairs[1].Key.Inspect() != "y" {
+		t.Errorf("OrderedPairs of unordered hash wrong. got=%v", pairs)
+	}
+}
+
+// 要素を追加したり置き換えたりしても、元のVectorは変わらない
+func TestVector(t *testing.T) {
+	// 葉一つ、根一段、根が二段になる大きさをそれぞれ超える
+	for _, n := range []int{0, 1, 31, 32, 33, 1055, 1056, 1057, 40000} {
+		versions := []*Vector{NewVector(nil)}
+		for i := 0; i < n; i++ {
+			versions = append(versions, versions[i].Push(NewInteger(int64(i))))
+		}
+		for length, v := range []*Vector{versions[n], NewVector(testRange(n))} {
+			if v.Len() != n {
+				t.Fatalf("n=%d (%d): wrong length. got=%d", n, length, v.Len())
+			}
+			for i := 0; i < n; i++ {
+				if got := v.Get(i).(*Integer).Value; got != int64(i) {
+					t.Fatalf("n=%d: Get(%d) wrong. got=%d", n, i, got)
+				}
+			}
+			if elements := Collect(v.Iterator()); len(elements) != n {
+				t.Fatalf("n=%d: iterator returned %d elements", n, len(elements))
+			}
+		}
+		// 途中の版も、作ったときの長さと要素のまま
+		for length := 0; length <= n; length += 1 + n/7 {
+			v := versions[length]
+			if v.Len() != length || length > 0 && v.Get(length-1).(*Integer).Value != int64(length-1) {
+				t.Fatalf("n=%d: version %d changed", n, length)
+			}
+		}
+
+		if n == 0 {
+			continue
+		}
+		v := versions[n]
+		for _, i := range []int{0, n / 2, n - 1} {
+			updated := v.Set(i, NewString("x"))
+			if updated.Get(i).Inspect() != "x" || v.Get(i).Inspect() == "x" {
+				t.Errorf("n=%d: Set(%d) wrong", n, i)
+			}
+		}
+	}
+
+	if s := NewVector(testRange(3)).Inspect(); s != "[0, 1, 2]" {
+		t.Errorf("Inspect wrong. got=%q", s)
+	}
+}
+
+func testRange(n int) []Object {
+	elements := make([]Object, n)
+	for i := range elements {
+		elements[i] = NewInteger(int64(i))
+	}
+	return elements
+}
+
+func TestMap(t *testing.T) {
+	const n = 5000
+	m := NewMap()
+	versions := []*Map{m}
+	for i := 0; i < n; i++ {
+		m, _ = m.Set(NewInteger(int64(i)), NewInteger(int64(i*2)))
+		versions = append(versions, m)
+	}
+	m, _ = m.Set(NewString("a"), TRUE)
+	m, _ = m.Set(NewInteger(3), NULL) // 値を置き換えても数と順番は変わらない
+	if m.Len() != n+1 {
+		t.Fatalf("wrong length. got=%d", m.Len())
+	}
+	for i := 0; i < n; i++ {
+		pair, ok := m.Get(NewInteger(int64(i)).HashKey())
+		if !ok || i != 3 && pair.Value.(*Integer).Value != int64(i*2) {
+			t.Fatalf("Get(%d) wrong. got=%v, %v", i, pair, ok)
+		}
+	}
+	if pair, _ := m.Get(NewInteger(3).HashKey()); pair.Value != NULL {
+		t.Errorf("value was not replaced")
+	}
+	if _, ok := versions[10].Get(NewInteger(10).HashKey()); ok {
+		t.Errorf("old version changed")
+	}
+
+	pairs := m.OrderedPairs()
+	if len(pairs) != n+1 || pairs[3].Key.Inspect() != "3" || pairs[n].Key.Inspect() != "a" {
+		t.Errorf("OrderedPairs wrong")
+	}
+
+	for i := 0; i < n; i += 2 {
+		m = m.Delete(NewInteger(int64(i)).HashKey())
+	}
+	m = m.Delete(NewString("missing").HashKey())
+	if m.Len() != n/2+1 {
+		t.Errorf("wrong length after delete. got=%d", m.Len())
+	}
+	if _, ok := m.Get(NewInteger(4).HashKey()); ok {
+		t.Errorf("deleted key found")
+	}
+	if _, ok := versions[n].Get(NewInteger(4).HashKey()); !ok {
+		t.Errorf("delete changed old version")
+	}
+	if _, ok := m.Set(&Array{}, NULL); ok {
+		t.Errorf("array should not be usable as map key")
+	}
+}
+
+// ハッシュの64ビットが全て同じキーも区別できる
+func TestMapCollisions(t *testing.T) {
+	root := &mapNode{}
+	var keys []HashKey
+	for i := 0; i < 3; i++ {
+		key := HashKey{Type: INTEGER_OBJ, Value: uint64(i)}
+		keys = append(keys, key)
+		root, _ = root.insert(0, &mapEntry{key: key, hash: 42, pair: HashPair{Key: NewInteger(int64(i)), Value: NULL}, seq: uint64(i)})
+	}
+	m := &Map{count: 3, root: root, nextSeq: 3}
+	for _, key := range keys {
+		var found bool
+		m.root.each(func(e *mapEntry) { found = found || e.key == key })
+		if !found {
+			t.Errorf("key %v not found", key)
+		}
+	}
+	root, removed := root.remove(0, 42, keys[1])
+	if !removed {
+		t.Fatalf("key was not removed")
+	}
+	var left []string
+	root.each(func(e *mapEntry) { left = append(left, e.pair.Key.Inspect()) })
+	if strings.Join(left, ",") != "0,2" {
+		t.Errorf("wrong keys after remove. got=%v", left)
+	}
+}
+
+type testAddress struct {
+	City string `monkey:"city"`
+	Zip  string `monkey:"zip,omitempty"`
+}
+
+type testBase struct {
+	ID int `monkey:"id"`
+}
+
+type testUser struct {
+	testBase
+	Name    string           `monkey:"name"`
+	Tags    []string         `monkey:"tags"`
+	Address *testAddress     `monkey:"address"`
+	Scores  map[string]uint8 `monkey:"scores"`
+	Secret  string           `monkey:"-"`
+	Extra   interface{}      `monkey:"extra"`
+	Meta    map[int]bool     `monkey:"meta"`
+	Raw     []byte           `monkey:"raw"`
+	Plain   float64
+	hidden  int
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{big.NewInt(7), "7"},
+		{2.5, "2.5"},
+		{"hi", "hi"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[string]int{"b": 2, "a": 1}, "{a: 1, b: 2}"},
+		{(*testAddress)(nil), "null"},
+		{[]interface{}{1, "a", nil, NewInteger(3)}, "[1, a, null, 3]"},
+		{
+			testUser{testBase: testBase{ID: 1}, Name: "ann", Tags: []string{"x"}, Address: &testAddress{City: "Tokyo"},
+				Scores: map[string]uint8{"go": 9}, Secret: "s", Extra: []int{1}, Meta: map[int]bool{2: true, 1: false},
+				Raw: []byte("ab"), Plain: 1, hidden: 5},
+			"{id: 1, name: ann, tags: [x], address: {city: Tokyo}, scores: {go: 9}, extra: [1], meta: {1: false, 2: true}, raw: ab, Plain: 1.0}",
+		},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) failed: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%#v) wrong.\nexpected=%s\ngot=%s", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	type node struct{ Next *node }
+	loop := &node{}
+	loop.Next = loop
+	errorTests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{func() {}, "cannot convert func() to a Monkey value"},
+		{map[string]interface{}{"f": []interface{}{make(chan int)}}, `["f"][0]: cannot convert chan int to a Monkey value`},
+		{loop, "Next: cannot convert a value that contains itself"},
+		{map[[1]int]int{{1}: 1}, "cannot use ARRAY as a hash key"},
+	}
+	for _, tt := range errorTests {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("FromGo(%T): expected error %q. got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	obj, err := FromJSON([]byte(`{"id": 3, "name": "bob", "tags": ["a", "b"], "address": {"city": "Osaka", "zip": "530"},
+		"scores": {"x": 200}, "extra": {"n": [1, 2.5, null]}, "Plain": 2, "unknown": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user testUser
+	if err := ToGo(obj, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 3 || user.Name != "bob" || strings.Join(user.Tags, ",") != "a,b" || user.Address.City != "Osaka" ||
+		user.Address.Zip != "530" || user.Scores["x"] != 200 || user.Plain != 2 {
+		t.Errorf("wrong user. got=%+v", user)
+	}
+	extra, ok := user.Extra.(map[string]interface{})
+	if !ok || len(extra["n"].([]interface{})) != 3 || extra["n"].([]interface{})[0] != int64(1) || extra["n"].([]interface{})[2] != nil {
+		t.Errorf("wrong extra. got=%#v", user.Extra)
+	}
+
+	// FromGoの結果を戻すと元の値になる
+	var back testUser
+	roundTrip, _ := FromGo(user)
+	if err := ToGo(roundTrip, &back); err != nil || back.Name != user.Name || back.Address.City != user.Address.City {
+		t.Errorf("round trip failed: %+v, %v", back, err)
+	}
+
+	var vec []int
+	if err := ToGo(NewVector([]Object{NewInteger(1), NewInteger(2)}), &vec); err != nil || len(vec) != 2 || vec[1] != 2 {
+		t.Errorf("vector to []int: %v, %v", vec, err)
+	}
+	var anything interface{}
+	if err := ToGo(&BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 70)}, &anything); err != nil {
+		t.Errorf("bigint to interface{}: %v", err)
+	} else if n, ok := anything.(*big.Int); !ok || n.BitLen() != 71 {
+		t.Errorf("wrong bigint. got=%v", anything)
+	}
+	var keep Object
+	if err := ToGo(TRUE, &keep); err != nil || keep != TRUE {
+		t.Errorf("Object target: %v, %v", keep, err)
+	}
+
+	cyclic := &Array{Elements: testIntegers(1)}
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+	cyclicHash := NewHash()
+	cyclicHash.Set(NewString("self"), cyclicHash)
+
+	errorTests := []struct {
+		obj      Object
+		target   interface{}
+		expected string
+	}{
+		{NewInteger(300), new(uint8), "300 overflows uint8"},
+		{NewInteger(-1), new(uint), "-1 overflows uint"},
+		{&Float{Value: 1.5}, new(int), "cannot use FLOAT as int"},
+		{NULL, new(int), "cannot use NULL as int"},
+		{&Array{Elements: []Object{NewInteger(1), NewString("x")}}, new([]int), "[1]: cannot use STRING as int"},
+		{&Array{Elements: []Object{NewInteger(1)}}, new([2]int), "cannot use an array of 1 elements as [2]int"},
+		{obj, new(struct {
+			Address struct {
+				City int `monkey:"city"`
+			} `monkey:"address"`
+		}), "address.city: cannot use STRING as int"},
+		{NewInteger(1), 5, "ToGo needs a non-nil pointer, got int"},
+		{cyclic, new(interface{}), "[1]: cannot convert a value that contains itself"},
+		{cyclic, new([]interface{}), "[1]: cannot convert a value that contains itself"},
+		{cyclicHash, new(map[string]interface{}), `["self"]: cannot convert a value that contains itself`},
+	}
+	for _, tt := range errorTests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("ToGo(%s, %T): expected error %q. got=%v", tt.obj.Inspect(), tt.target, tt.expected, err)
+		}
+	}
+}
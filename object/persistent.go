@@ -0,0 +1,440 @@
+package object
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// 変更できない配列とハッシュ。要素を追加したり置き換えたりすると、元の値はそのままで新しい値を返す。
+// 新しい値は変わらなかった部分を元の値と共有するので、push や merge で毎回全体をコピーする配列やハッシュと違い、
+// 要素数nに対してO(log n)で作れる。値として扱っても安全なので、関数型のスタイルで「コピー」を繰り返すコードに向く。
+//
+// Vectorは32分木（persistent vector）、Mapはハッシュの値で枝分かれする32分木（HAMT）で作っている。
+// 表示やJSONは配列、ハッシュと同じになる。
+
+const (
+	persistentBits  = 5
+	persistentWidth = 1 << persistentBits
+	persistentMask  = persistentWidth - 1
+)
+
+// 要素を32個ずつ葉に入れ、添字の5ビットずつで枝を辿る木。最後の32個以下の要素は木に入れずtailに持つ。
+// 最後への追加は、ほとんどの場合tailをコピーするだけで済む。
+type Vector struct {
+	count int
+	shift uint // 根の枝を選ぶのに使う添字のビットの位置
+	root  *vectorNode
+	tail  []Object
+}
+
+// 枝ならchildren、葉ならleafを使う。
+type vectorNode struct {
+	children []*vectorNode
+	leaf     []Object
+}
+
+var emptyVector = &Vector{shift: persistentBits, root: &vectorNode{}}
+
+// elementsを要素にしたVectorを作る。elementsはコピーするので、後で変更してもVectorは変わらない。
+func NewVector(elements []Object) *Vector {
+	v := emptyVector
+	for start := 0; start < len(elements); start += persistentWidth {
+		end := start + persistentWidth
+		if end > len(elements) {
+			end = len(elements)
+		}
+		chunk := make([]Object, end-start)
+		copy(chunk, elements[start:end])
+
+		root, shift := v.root, v.shift
+		if start > 0 {
+			root, shift = v.pushTail()
+		}
+		v = &Vector{count: end, shift: shift, root: root, tail: chunk}
+	}
+	return v
+}
+
+func (v *Vector) Type() ObjectType { return VECTOR_OBJ }
+func (v *Vector) Inspect() string  { return InspectWith(v, InspectOptions{}) }
+
+func (v *Vector) Len() int { return v.count }
+
+// i番目の要素。iは0以上Len()未満でなければならない。
+func (v *Vector) Get(i int) Object {
+	return v.leafFor(i)[i&persistentMask]
+}
+
+// 木に入っている要素の数。これ以降の要素はtailにある。
+func (v *Vector) tailOffset() int {
+	if v.count < persistentWidth {
+		return 0
+	}
+	return ((v.count - 1) >> persistentBits) << persistentBits
+}
+
+// i番目の要素が入っている葉。
+func (v *Vector) leafFor(i int) []Object {
+	if i >= v.tailOffset() {
+		return v.tail
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= persistentBits {
+		node = node.children[(i>>level)&persistentMask]
+	}
+	return node.leaf
+}
+
+// 最後にobjを追加したVectorを返す。
+func (v *Vector) Push(obj Object) *Vector {
+	if len(v.tail) < persistentWidth {
+		tail := make([]Object, len(v.tail)+1)
+		copy(tail, v.tail)
+		tail[len(v.tail)] = obj
+		return &Vector{count: v.count + 1, shift: v.shift, root: v.root, tail: tail}
+	}
+	root, shift := v.pushTail()
+	return &Vector{count: v.count + 1, shift: shift, root: root, tail: []Object{obj}}
+}
+
+// 一杯になったtailを葉として木に入れた、新しい根を返す。根が一杯なら一段高くする。
+func (v *Vector) pushTail() (*vectorNode, uint) {
+	leaf := &vectorNode{leaf: v.tail}
+	if (v.count >> persistentBits) > (1 << v.shift) {
+		return &vectorNode{children: []*vectorNode{v.root, newVectorPath(v.shift, leaf)}}, v.shift + persistentBits
+	}
+	return pushVectorLeaf(v.count, v.shift, v.root, leaf), v.shift
+}
+
+func pushVectorLeaf(count int, level uint, parent, leaf *vectorNode) *vectorNode {
+	index := ((count - 1) >> level) & persistentMask
+	node := &vectorNode{children: make([]*vectorNode, len(parent.children), index+1)}
+	copy(node.children, parent.children)
+	if index == len(node.children) {
+		node.children = append(node.children, nil)
+	}
+
+	switch {
+	case level == persistentBits:
+		node.children[index] = leaf
+	case node.children[index] != nil:
+		node.children[index] = pushVectorLeaf(count, level-persistentBits, node.children[index], leaf)
+	default:
+		node.children[index] = newVectorPath(level-persistentBits, leaf)
+	}
+	return node
+}
+
+// levelの高さから葉までの、枝が一本だけの道を作る。
+func newVectorPath(level uint, leaf *vectorNode) *vectorNode {
+	if level == 0 {
+		return leaf
+	}
+	return &vectorNode{children: []*vectorNode{newVectorPath(level-persistentBits, leaf)}}
+}
+
+// i番目の要素をobjに置き換えたVectorを返す。iは0以上Len()未満でなければならない。
+func (v *Vector) Set(i int, obj Object) *Vector {
+	if i >= v.tailOffset() {
+		tail := make([]Object, len(v.tail))
+		copy(tail, v.tail)
+		tail[i&persistentMask] = obj
+		return &Vector{count: v.count, shift: v.shift, root: v.root, tail: tail}
+	}
+	return &Vector{count: v.count, shift: v.shift, root: setVectorElement(v.root, v.shift, i, obj), tail: v.tail}
+}
+
+func setVectorElement(node *vectorNode, level uint, i int, obj Object) *vectorNode {
+	if level == 0 {
+		leaf := make([]Object, len(node.leaf))
+		copy(leaf, node.leaf)
+		leaf[i&persistentMask] = obj
+		return &vectorNode{leaf: leaf}
+	}
+	children := make([]*vectorNode, len(node.children))
+	copy(children, node.children)
+	index := (i >> level) & persistentMask
+	children[index] = setVectorElement(children[index], level-persistentBits, i, obj)
+	return &vectorNode{children: children}
+}
+
+// 要素を順に並べた配列を作る。
+func (v *Vector) ToArray() *Array {
+	elements := make([]Object, 0, v.count)
+	for i := 0; i < v.count; i += persistentWidth {
+		elements = append(elements, v.leafFor(i)...)
+	}
+	return &Array{Elements: elements}
+}
+
+// 要素を先頭から順に返す。Vectorは変更されないので、コピーせずに葉を辿る。
+func (v *Vector) Iterator() Iterator {
+	return &vectorIterator{vector: v}
+}
+
+type vectorIterator struct {
+	vector *Vector
+	index  int
+	leaf   []Object
+}
+
+func (it *vectorIterator) Next() (Object, bool) {
+	if it.index >= it.vector.count {
+		return nil, false
+	}
+	if it.index&persistentMask == 0 {
+		it.leaf = it.vector.leafFor(it.index)
+	}
+	el := it.leaf[it.index&persistentMask]
+	it.index++
+	return el, true
+}
+
+// キーのHashKeyから作った64ビットの値を5ビットずつ使って枝を辿る木。
+// 64ビット全てが同じになったキーは、一番深いところでcollisionsに並べる。
+// Hashと同じく追加された順番を覚えておき、keys、values、for-inはこの順番になる。
+type Map struct {
+	count   int
+	root    *mapNode
+	nextSeq uint64 // 次に追加するキーの順番
+}
+
+type mapNode struct {
+	bitmap     uint32 // 枝がある位置のビットが立っている
+	items      []mapItem
+	collisions []*mapEntry
+}
+
+// entryかnodeのどちらか一方を持つ。
+type mapItem struct {
+	entry *mapEntry
+	node  *mapNode
+}
+
+type mapEntry struct {
+	key  HashKey
+	hash uint64
+	pair HashPair
+	seq  uint64 // 追加された順番
+}
+
+var emptyMap = &Map{root: &mapNode{}}
+
+// 空のMapを返す。
+func NewMap() *Map {
+	return emptyMap
+}
+
+func (m *Map) Type() ObjectType { return MAP_OBJ }
+func (m *Map) Inspect() string  { return InspectWith(m, InspectOptions{}) }
+
+func (m *Map) Len() int { return m.count }
+
+// HashKeyの値を木の枝に使えるように混ぜる。整数のキーは値がそのままHashKeyになるので、
+// 混ぜないと小さな整数ばかりのときに同じ枝に偏ってしまう。
+func mapHash(key HashKey) uint64 {
+	h := key.Value
+	for i := 0; i < len(key.Type); i++ {
+		h = h*31 + uint64(key.Type[i])
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// keyのペアを探す。
+func (m *Map) Get(key HashKey) (HashPair, bool) {
+	h := mapHash(key)
+	node := m.root
+	for shift := uint(0); ; shift += persistentBits {
+		if shift >= 64 {
+			for _, e := range node.collisions {
+				if e.key == key {
+					return e.pair, true
+				}
+			}
+			return HashPair{}, false
+		}
+		bit := uint32(1) << ((h >> shift) & persistentMask)
+		if node.bitmap&bit == 0 {
+			return HashPair{}, false
+		}
+		item := node.items[bits.OnesCount32(node.bitmap&(bit-1))]
+		if item.entry != nil {
+			if item.entry.key == key {
+				return item.entry.pair, true
+			}
+			return HashPair{}, false
+		}
+		node = item.node
+	}
+}
+
+// キーと値の組を追加したMapを返す。すでに同じキーがある場合は値を置き換え、順番はそのまま。
+// キーがHashableでなければ何もせずfalseを返す。
+func (m *Map) Set(key, value Object) (*Map, bool) {
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return m, false
+	}
+	hashed := hashable.HashKey()
+	entry := &mapEntry{key: hashed, hash: mapHash(hashed), pair: HashPair{Key: key, Value: value}, seq: m.nextSeq}
+	root, added := m.root.insert(0, entry)
+	result := &Map{count: m.count, root: root, nextSeq: m.nextSeq}
+	if added {
+		result.count++
+		result.nextSeq++
+	}
+	return result, true
+}
+
+// entryを入れたノードを新しく作って返す。同じキーがあった場合は、元の順番を引き継いで置き換える。
+func (n *mapNode) insert(shift uint, entry *mapEntry) (*mapNode, bool) {
+	if shift >= 64 {
+		collisions := make([]*mapEntry, len(n.collisions), len(n.collisions)+1)
+		copy(collisions, n.collisions)
+		for i, e := range collisions {
+			if e.key == entry.key {
+				collisions[i] = entry.withSeq(e.seq)
+				return &mapNode{collisions: collisions}, false
+			}
+		}
+		return &mapNode{collisions: append(collisions, entry)}, true
+	}
+
+	bit := uint32(1) << ((entry.hash >> shift) & persistentMask)
+	index := bits.OnesCount32(n.bitmap & (bit - 1))
+	if n.bitmap&bit == 0 {
+		items := make([]mapItem, len(n.items)+1)
+		copy(items, n.items[:index])
+		items[index] = mapItem{entry: entry}
+		copy(items[index+1:], n.items[index:])
+		return &mapNode{bitmap: n.bitmap | bit, items: items}, true
+	}
+
+	items := make([]mapItem, len(n.items))
+	copy(items, n.items)
+	added := false
+	switch item := items[index]; {
+	case item.node != nil:
+		items[index].node, added = item.node.insert(shift+persistentBits, entry)
+	case item.entry.key == entry.key:
+		items[index].entry = entry.withSeq(item.entry.seq)
+	default:
+		// 同じ枝に別のキーがあれば、一段深いノードに二つとも入れる
+		child, _ := (&mapNode{}).insert(shift+persistentBits, item.entry)
+		items[index] = mapItem{}
+		items[index].node, added = child.insert(shift+persistentBits, entry)
+	}
+	return &mapNode{bitmap: n.bitmap, items: items}, added
+}
+
+func (e *mapEntry) withSeq(seq uint64) *mapEntry {
+	copied := *e
+	copied.seq = seq
+	return &copied
+}
+
+// keyを取り除いたMapを返す。keyがなければ同じMapを返す。
+func (m *Map) Delete(key HashKey) *Map {
+	root, removed := m.root.remove(0, mapHash(key), key)
+	if !removed {
+		return m
+	}
+	if root == nil {
+		root = &mapNode{}
+	}
+	return &Map{count: m.count - 1, root: root, nextSeq: m.nextSeq}
+}
+
+// keyを取り除いたノードを新しく作って返す。空になったノードはnilにする。
+func (n *mapNode) remove(shift uint, h uint64, key HashKey) (*mapNode, bool) {
+	if shift >= 64 {
+		for i, e := range n.collisions {
+			if e.key == key {
+				collisions := make([]*mapEntry, 0, len(n.collisions)-1)
+				collisions = append(collisions, n.collisions[:i]...)
+				collisions = append(collisions, n.collisions[i+1:]...)
+				if len(collisions) == 0 {
+					return nil, true
+				}
+				return &mapNode{collisions: collisions}, true
+			}
+		}
+		return n, false
+	}
+
+	bit := uint32(1) << ((h >> shift) & persistentMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	index := bits.OnesCount32(n.bitmap & (bit - 1))
+	item := n.items[index]
+
+	var child *mapNode
+	if item.node != nil {
+		var removed bool
+		if child, removed = item.node.remove(shift+persistentBits, h, key); !removed {
+			return n, false
+		}
+	} else if item.entry.key != key {
+		return n, false
+	}
+
+	if child != nil {
+		items := make([]mapItem, len(n.items))
+		copy(items, n.items)
+		items[index] = mapItem{node: child}
+		return &mapNode{bitmap: n.bitmap, items: items}, true
+	}
+	if len(n.items) == 1 {
+		return nil, true
+	}
+	items := make([]mapItem, 0, len(n.items)-1)
+	items = append(items, n.items[:index]...)
+	items = append(items, n.items[index+1:]...)
+	return &mapNode{bitmap: n.bitmap &^ bit, items: items}, true
+}
+
+// 追加された順番にペアを返す。
+func (m *Map) OrderedPairs() []HashPair {
+	entries := make([]*mapEntry, 0, m.count)
+	m.root.each(func(e *mapEntry) { entries = append(entries, e) })
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	pairs := make([]HashPair, len(entries))
+	for i, e := range entries {
+		pairs[i] = e.pair
+	}
+	return pairs
+}
+
+func (n *mapNode) each(fn func(*mapEntry)) {
+	for _, e := range n.collisions {
+		fn(e)
+	}
+	for _, item := range n.items {
+		if item.entry != nil {
+			fn(item.entry)
+		} else {
+			item.node.each(fn)
+		}
+	}
+}
+
+// 同じペアを同じ順番で持つハッシュを作る。
+func (m *Map) ToHash() *Hash {
+	hash := NewHash()
+	for _, pair := range m.OrderedPairs() {
+		hash.Set(pair.Key, pair.Value)
+	}
+	return hash
+}
+
+// Hashと同じく、[キー, バリュー] の配列を追加された順番に返す。
+func (m *Map) Iterator() Iterator {
+	return m.ToHash().Iterator()
+}
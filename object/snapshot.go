@@ -0,0 +1,87 @@
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// スナップショットのJSONの形。
+// 値はToJSONで書き出すので、JSONにできる値（整数、小数、文字列、真偽値、null、配列、ハッシュ）だけが保存される。
+type snapshot struct {
+	Bindings map[string]json.RawMessage `json:"bindings"`
+	Consts   []string                   `json:"consts,omitempty"`
+}
+
+// 現在のスコープの束縛をJSONにする。REPLのセッションを保存したり、テストで状態を取っておくのに使う。
+// 関数や組み込み関数、自分自身を含む配列などJSONにできない値は保存せず、その変数名をskippedとして返す。
+// 外側のスコープの束縛は含まれない。
+func (e *Environment) Snapshot() (data []byte, skipped []string, err error) {
+	e.rlock()
+	defer e.runlock()
+
+	s := snapshot{Bindings: make(map[string]json.RawMessage)}
+	for name, val := range e.bindings() {
+		b, err := ToJSON(val)
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		s.Bindings[name] = b
+		if e.consts[name] {
+			s.Consts = append(s.Consts, name)
+		}
+	}
+	sort.Strings(skipped)
+	sort.Strings(s.Consts)
+
+	data, err = json.Marshal(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, skipped, nil
+}
+
+// Snapshotで作ったJSONを読み込み、現在のスコープに束縛し直す。
+// constだった変数はconstとして束縛する。既にconstで束縛されている変数と名前が重なるとエラーになる。
+func (e *Environment) Restore(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	consts := make(map[string]bool, len(s.Consts))
+	for _, name := range s.Consts {
+		consts[name] = true
+	}
+
+	// エラーになったときに途中まで束縛された状態にならないよう、先に全部の値を読んでおく
+	names := make([]string, 0, len(s.Bindings))
+	values := make(map[string]Object, len(s.Bindings))
+	for name, raw := range s.Bindings {
+		val, err := FromJSON(raw)
+		if err != nil {
+			return fmt.Errorf("cannot restore %s: %s", name, err)
+		}
+		if e.isLocalConst(name) {
+			return fmt.Errorf("cannot restore %s: %s", name, constantError(name).Message)
+		}
+		names = append(names, name)
+		values[name] = val
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if consts[name] {
+			e.SetConst(name, values[name])
+		} else {
+			e.Set(name, values[name])
+		}
+	}
+	return nil
+}
+
+func (e *Environment) isLocalConst(name string) bool {
+	e.rlock()
+	defer e.runlock()
+	return e.consts[name]
+}
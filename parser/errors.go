@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"fmt"
+
+	"monkey/token"
+)
+
+// 構文エラーの種類。LSPやリンターなどのツールがエラーをメッセージの文字列ではなく種類で判別できるようにする。
+type ErrorCode int
+
+const (
+	_                      ErrorCode = iota
+	ErrUnexpectedToken               // 期待したトークンと違うトークンが現れた。ExpectedとGotに両方のトークンの種類が入る
+	ErrNoPrefixParseFn               // 式の先頭に置けないトークンが現れた
+	ErrInvalidInteger                // 整数リテラルを数値に変換できなかった
+	ErrInvalidAssignTarget           // 変数やメンバー以外への代入
+	ErrUnexpectedEOF                 // ブロックなどが閉じられる前に入力が終わった。Tokenには開始のトークンが入る
+	ErrInvalidFloat                  // 小数リテラルをfloat64に変換できなかった
+	ErrTooDeeplyNested               // 式の入れ子がMaxNestingDepthより深い
+)
+
+var errorCodeNames = map[ErrorCode]string{
+	ErrUnexpectedToken:     "UnexpectedToken",
+	ErrNoPrefixParseFn:     "NoPrefixParseFn",
+	ErrInvalidInteger:      "InvalidInteger",
+	ErrInvalidAssignTarget: "InvalidAssignTarget",
+	ErrUnexpectedEOF:       "UnexpectedEOF",
+	ErrInvalidFloat:        "InvalidFloat",
+	ErrTooDeeplyNested:     "TooDeeplyNested",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorCode(%d)", int(c))
+}
+
+// 構文エラー一つ分の情報。
+type Error struct {
+	Code     ErrorCode
+	Token    token.Token     // エラーの原因となったトークン。位置はToken.Line、Token.Columnで分かる
+	Expected token.TokenType // ErrUnexpectedTokenの場合に期待していたトークンの種類
+	Got      token.TokenType // ErrUnexpectedTokenの場合に実際に現れたトークンの種類
+	Message  string          // 位置を含まないエラーメッセージ
+}
+
+func (e Error) Line() int   { return e.Token.Line }
+func (e Error) Column() int { return e.Token.Column }
+
+// 位置(行:列)を先頭につけたエラーメッセージ。Parser.Errors()はこの形式の文字列を返す。
+func (e Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Token.Line, e.Token.Column, e.Message)
+}
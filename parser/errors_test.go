@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"monkey/lexer"
+	"monkey/token"
+)
+
+func TestParseErrors(t *testing.T) {
+	input := `let = 5;
+let x = );
+1 = 2;`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	tests := []struct {
+		code     ErrorCode
+		line     int
+		column   int
+		expected token.TokenType
+		got      token.TokenType
+		message  string
+	}{
+		{ErrUnexpectedToken, 1, 5, token.IDENT, token.ASSIGN, "expected next token to be IDENT, got = instead"},
+		{ErrNoPrefixParseFn, 2, 9, "", "", "no prefix parse function for ) found"},
+		{ErrInvalidAssignTarget, 3, 3, "", "", "cannot assign to 1"},
+	}
+
+	errors := p.ParseErrors()
+	if len(errors) != len(tests) {
+		t.Fatalf("wrong number of errors. want=%d, got=%d (%q)",
+			len(tests), len(errors), p.Errors())
+	}
+
+	for i, tt := range tests {
+		err := errors[i]
+		if err.Code != tt.code {
+			t.Errorf("errors[%d] - code wrong. expected=%s, got=%s", i, tt.code, err.Code)
+		}
+		if err.Line() != tt.line || err.Column() != tt.column {
+			t.Errorf("errors[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.line, tt.column, err.Line(), err.Column())
+		}
+		if err.Expected != tt.expected || err.Got != tt.got {
+			t.Errorf("errors[%d] - tokens wrong. expected=%q/%q, got=%q/%q",
+				i, tt.expected, tt.got, err.Expected, err.Got)
+		}
+		if err.Message != tt.message {
+			t.Errorf("errors[%d] - message wrong. expected=%q, got=%q", i, tt.message, err.Message)
+		}
+
+		// Errors()は位置を先頭につけたメッセージを返す
+		if p.Errors()[i] != err.Error() {
+			t.Errorf("errors[%d] - Errors() and Error() differ. %q != %q",
+				i, p.Errors()[i], err.Error())
+		}
+	}
+}
+
+func TestUnclosedBlockError(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   int
+		column int
+	}{
+		{"if (x) { 1", 1, 8},
+		{"let f = fn(x) {\n  x + 1;\n", 1, 15},
+		{"if (x) { 1 } else {\n  if (y) { 2 }", 1, 19},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.ParseErrors()
+		if len(errors) != 1 {
+			t.Fatalf("wrong number of errors for %q. want=1, got=%d (%q)",
+				tt.input, len(errors), p.Errors())
+		}
+
+		err := errors[0]
+		if err.Code != ErrUnexpectedEOF {
+			t.Errorf("code wrong. expected=%s, got=%s", ErrUnexpectedEOF, err.Code)
+		}
+		if err.Line() != tt.line || err.Column() != tt.column {
+			t.Errorf("position wrong. expected=%d:%d, got=%d:%d",
+				tt.line, tt.column, err.Line(), err.Column())
+		}
+		if err.Message != "unexpected EOF, expected }" {
+			t.Errorf("message wrong. got=%q", err.Message)
+		}
+	}
+}
+
+func TestTooDeeplyNestedExpression(t *testing.T) {
+	depth := MaxNestingDepth + 10
+	input := strings.Repeat("(", depth) + "1" + strings.Repeat(")", depth)
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	errors := p.ParseErrors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%d", len(errors))
+	}
+	if errors[0].Code != ErrTooDeeplyNested {
+		t.Errorf("code wrong. expected=%s, got=%s", ErrTooDeeplyNested, errors[0].Code)
+	}
+
+	// 上限までの入れ子なら解析できる
+	input = strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100)
+	p = New(lexer.New(input))
+	p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Errorf("unexpected errors: %q", p.Errors())
+	}
+}
+
+func TestNestedErrorReportedOnce(t *testing.T) {
+	// 内側の括弧でのエラーは、外側の括弧の数だけ繰り返さない
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"puts(f(g(a b)));", []string{"1:12: expected next token to be ), got IDENT instead"}},
+		{"[1, [2 3]]", []string{"1:8: expected next token to be ], got INT instead"}},
+		{"let x = );\nlet y = );", []string{"1:9: no prefix parse function for ) found", "2:9: no prefix parse function for ) found"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if strings.Join(errors, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: wrong errors. expected=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
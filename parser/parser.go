@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/resolver"
 	"monkey/token"
 	"strconv"
 )
@@ -11,6 +14,8 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // =
+	NULLISH     // ??
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -22,16 +27,36 @@ const (
 
 // 優先順位。下に行くほど優先順位高。
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,     // + と、
-	token.MINUS:    SUM,     // - は同じ優先順位。
-	token.SLASH:    PRODUCT, // 割り算と、
-	token.ASTERISK: PRODUCT, // 掛け算は同じ優先順位。かつ、+や-より優先度が高い。
-	token.LPAREN:   CALL,    // 関数呼び出し。
-	token.LBRACKET: INDEX,   // 配列の添字。関数呼び出しより優先度が高い。add(1 + myArr[1]) という式の場合、 [1] が木の中で一番深い階層になる。
+	token.ASSIGN:       ASSIGN,  // 代入。演算子の中で一番優先順位が低い。
+	token.NULLISH:      NULLISH, // null合体。比較より優先順位が低いので、 a ?? b == c は a ?? (b == c) になる。
+	token.EQ:           EQUALS,
+	token.NOT_EQ:       EQUALS,
+	token.LT:           LESSGREATER,
+	token.GT:           LESSGREATER,
+	token.PLUS:         SUM,     // + と、
+	token.MINUS:        SUM,     // - は同じ優先順位。
+	token.SLASH:        PRODUCT, // 割り算と、
+	token.ASTERISK:     PRODUCT, // 掛け算は同じ優先順位。かつ、+や-より優先度が高い。
+	token.PERCENT:      PRODUCT, // 余りも掛け算、割り算と同じ優先順位。
+	token.LPAREN:       CALL,    // 関数呼び出し。
+	token.LBRACKET:     INDEX,   // 配列の添字。関数呼び出しより優先度が高い。add(1 + myArr[1]) という式の場合、 [1] が木の中で一番深い階層になる。
+	token.QUESTION_DOT: INDEX,   // null安全なアクセス。添字と同じ優先度。
+	token.DOT:          INDEX,   // メンバーへのアクセス。添字と同じ優先度。
+}
+
+// 結合性。同じ優先順位の演算子が並んだ時に、左右どちらから結合するかを表す。
+// 1 - 2 - 3 は左結合なので ((1 - 2) - 3)、 a = b = c は右結合なので (a = (b = c)) になる。
+type Associativity int
+
+const (
+	LeftAssoc Associativity = iota // デフォルトはこちら
+	RightAssoc
+)
+
+// 右結合の演算子。ここにないものは全て左結合として扱う。
+var rightAssociative = map[token.TokenType]bool{
+	token.ASSIGN:  true, // a = b = 5 は a = (b = 5)
+	token.NULLISH: true, // a ?? b ?? c は a ?? (b ?? c)
 }
 
 type (
@@ -41,19 +66,40 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []Error
 
 	curToken  token.Token
 	peekToken token.Token
 
+	comments []*ast.Comment // 読み飛ばしたコメント。ParseProgramでProgramに渡す
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+	precedences    map[token.TokenType]int // RegisterInfixOperatorで追加できるように、パーサーごとに持つ
+	associativity  map[token.TokenType]Associativity
+
+	depth     int  // parseExpressionの入れ子の深さ
+	tooDeep   bool // 入れ子が深すぎるエラーを報告済みか
+	panicking bool // 文のエラーを報告してから、synchronizeで次の文まで読み飛ばすまでの間か
+	braces    int  // curTokenより前の { の数から } の数を引いたもの。synchronizeが文の外の } を見分けるのに使う
 }
 
+// 式の入れ子の深さの上限。パーサーも評価器も入れ子をgoの再帰で処理するので、
+// 上限を超える式はgoのスタックを使い果たす前に構文エラーにする。
+var MaxNestingDepth = 10000
+
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		errors:      []Error{},
+		precedences: make(map[token.TokenType]int, len(precedences)),
+	}
+	for t, precedence := range precedences {
+		p.precedences[t] = precedence
+	}
+	p.associativity = make(map[token.TokenType]Associativity, len(rightAssociative))
+	for t := range rightAssociative {
+		p.associativity[t] = RightAssoc
 	}
 
 	// -----初期処理として全てのトークンの解析関数を登録しておく------
@@ -63,16 +109,21 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)  // !
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression) // -
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression) // (
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
-	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral) // [ 配列リテラルの始まり
-	p.registerPrefix(token.LBRACE, p.parseHashLiteral)    // { ハッシュリテラルの始まり
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)     // [ 配列リテラルの始まり
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)        // { ハッシュリテラルの始まり
+	p.registerPrefix(token.ELLIPSIS, p.parseSpreadExpression) // ...arr 関数呼び出しの引数、配列リテラルの中で使う
 
 	// 中置（前置の後に登場することができるトークンたち）
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
@@ -80,15 +131,21 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression) // 再代入 x = 5
+	p.registerInfix(token.NULLISH, p.parseInfixExpression) // a ?? b
 
 	// 関数呼び出しのための ( に対する中置解析関数の登録
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	// 配列の添字 [ のための中置解析関数の登録
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	// null安全なアクセス ?. のための中置解析関数の登録
+	p.registerInfix(token.QUESTION_DOT, p.parseOptionalAccessExpression)
+	p.registerInfix(token.DOT, p.parsePropertyExpression)
 
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
@@ -98,8 +155,20 @@ func New(l *lexer.Lexer) *Parser {
 }
 
 func (p *Parser) nextToken() {
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braces++
+	case token.RBRACE:
+		p.braces--
+	}
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken() // ここでlexerとparserが繋がる
+
+	// コメントは構文に関係ないので、解析関数にはわたさずに覚えておくだけにする。
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, &ast.Comment{Token: p.peekToken, Text: p.peekToken.Literal})
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -122,19 +191,66 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
+// エラーメッセージの一覧。各メッセージの先頭にはエラーの位置(行:列)がつく。
+// エラーの種類や位置をプログラムから扱いたい場合はParseErrorsを使う。
 func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
+// 構造化されたエラーの一覧。
+func (p *Parser) ParseErrors() []Error {
 	return p.errors
 }
 
+func (p *Parser) addError(err Error) {
+	// 入れ子が深すぎた後は、閉じ括弧が足りないなどのエラーが入れ子の数だけ続くので報告しない
+	if p.tooDeep {
+		return
+	}
+	// 一つの文で最初のエラーの後に見つかるエラーは、括弧が閉じていないなど最初のエラーから続いて起きたものなので、
+	// synchronizeで次の文まで読み飛ばすまでは報告しない
+	if p.panicking {
+		return
+	}
+	p.errors = append(p.errors, err)
+	p.panicking = true
+}
+
+// エラーの原因となったトークンと種類を添えてエラーを記録する。
+func (p *Parser) errorAt(code ErrorCode, tok token.Token, format string, a ...interface{}) {
+	p.addError(Error{
+		Code:    code,
+		Token:   tok,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// 期待したトークンの種類tと違うトークンtokが現れた場合のエラー。
+func (p *Parser) unexpectedTokenError(t token.TokenType, tok token.Token, format string) {
+	p.addError(Error{
+		Code:     ErrUnexpectedToken,
+		Token:    tok,
+		Expected: t,
+		Got:      tok.Type,
+		Message:  fmt.Sprintf(format, t, tok.Type),
+	})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.unexpectedTokenError(t, p.peekToken, "expected next token to be %s, got %s instead")
+}
+
+// expectPeekと違い、現在のトークンが期待したものでなかった場合のエラー。
+func (p *Parser) curError(t token.TokenType) {
+	p.unexpectedTokenError(t, p.curToken, "expected token to be %s, got %s instead")
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(ErrNoPrefixParseFn, p.curToken, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -142,29 +258,75 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
+		errCount, braces := len(p.errors), p.braces
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if len(p.errors) > errCount {
+			// エラーがあった文は捨てて、次の文の区切りまで読み飛ばす。
+			p.synchronize(braces)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	program.Comments = p.comments
+
+	// エラーがなければ、ローカル変数の場所を決めておく
+	if len(p.errors) == 0 {
+		resolver.Resolve(program)
+	}
 
 	return program
 }
 
+// パニックモードのエラー回復。
+// 文の解析中にエラーが見つかった場合、そのまま次のトークンから解析を続けると
+// 壊れた文の残りのトークンを新しい文として解析してしまい、意味のないエラーが連鎖する。
+// そこで、文の区切りである ; か、ブロックの終わりである } までトークンを読み飛ばしてから解析を再開する。
+// bracesは文を読み始めたときのp.braces。エラーは文の途中で見つかるので、その時点で既に開いている
+// ハッシュリテラルやブロックの { があるかもしれない。その中の ; や } では止まらず、文と同じ深さのものだけで止まる。
+// 止まった時、curTokenは ; か } か EOF になっている。次の文からはまたエラーを報告する。
+func (p *Parser) synchronize(braces int) {
+	defer func() { p.panicking = false }()
+	for !p.curTokenIs(token.EOF) {
+		if (p.curTokenIs(token.SEMICOLON) || p.curTokenIs(token.RBRACE)) && p.braces <= braces {
+			return
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
+	case token.CONST:
+		return p.parseConstStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.FOR:
+		return p.parseForInStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.CLASS:
+		return p.parseClassStatement()
+	case token.FUNCTION:
+		// fn の直後に関数名(IDENT)があれば関数宣言、なければ関数リテラルの式として解析する。
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionDeclaration()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
 // let <identifier> = <expression>;
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
+	// let の次が [ か { なら分割代入として解析する。
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		return p.parseLetDestructureStatement()
+	}
+
 	// まずLETのstatementを用意
 	stmt := &ast.LetStatement{Token: p.curToken}
 
@@ -196,6 +358,124 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// let [<identifier>, ...] = <expression>;
+// let {<identifier>, ...} = <expression>;
+func (p *Parser) parseLetDestructureStatement() ast.Statement {
+	stmt := &ast.LetDestructureStatement{Token: p.curToken}
+
+	p.nextToken() // [ か { にトークンを進める。
+	if p.curTokenIs(token.LBRACKET) {
+		stmt.Pattern = p.parseArrayPattern()
+	} else {
+		stmt.Pattern = p.parseHashPattern()
+	}
+	if stmt.Pattern == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// [<identifier>, <identifier>, ...<identifier>]
+// curTokenが [ の状態で呼ばれ、 ] にトークンを進めて終わる。
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		// ... がついた変数は残りの要素を受け取る。最後の要素でないといけない。
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.curTokenIs(token.IDENT) {
+			p.curError(token.IDENT)
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements,
+			&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	pattern.EndToken = p.curToken
+
+	return pattern
+}
+
+// {<identifier>, <identifier>, ...}
+// curTokenが { の状態で呼ばれ、 } にトークンを進めて終わる。
+func (p *Parser) parseHashPattern() ast.Expression {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys,
+			&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	pattern.EndToken = p.curToken
+
+	return pattern
+}
+
+// const <identifier> = <expression>;
+// 構文はletと全く同じ。
+func (p *Parser) parseConstStatement() ast.Statement {
+	stmt := &ast.ConstStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 // return <expression>;
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
@@ -214,6 +494,65 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+// for (<identifier> in <expression>) <block statement>
+func (p *Parser) parseForInStatement() ast.Statement {
+	stmt := &ast.ForInStatement{Token: p.curToken}
+
+	// for の次は ( であること
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	// ( の次は要素を束縛する変数名(IDENT)であること
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// 変数名の次は in であること
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	// in の右側の式にトークンを進めて解析する。
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	// ループの本体の解析。
+	stmt.Body = p.parseBlockStatement()
+
+	// } の後の ; は省略可能。
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// throw <expression>;
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	// throwの右側の式にトークンを進める。
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	//defer untrace(trace("parseExpressionStatement"))
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
@@ -230,6 +569,18 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	//defer untrace(trace("parseExpression"))
 
+	if p.depth >= MaxNestingDepth {
+		p.errorAt(ErrTooDeeplyNested, p.curToken, "expression nested too deeply (max %d)", MaxNestingDepth)
+		p.tooDeep = true
+		// 残りの入力は読み飛ばす
+		for !p.peekTokenIs(token.EOF) {
+			p.nextToken()
+		}
+		return nil
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
 	// ---------前置演算子の解析---------
 	// 現在のトークンに前置解析関数があるか
 	prefix := p.prefixParseFns[p.curToken.Type]
@@ -311,7 +662,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 // 次のトークンの優先順位を確認。なければ最低の優先順位をデフォで返す。
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
+	if p, ok := p.precedences[p.peekToken.Type]; ok {
 		return p
 	}
 
@@ -320,13 +671,25 @@ func (p *Parser) peekPrecedence() int {
 
 // 現在のトークンの優先順位を確認。なければ最低の優先順位をデフォで返す。
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
+	if p, ok := p.precedences[p.curToken.Type]; ok {
 		return p
 	}
 
 	return LOWEST
 }
 
+// 中置演算子の右側の式を解析する時に使う優先順位。curTokenが中置演算子にある状態で呼ぶ。
+// 左結合の場合は演算子自身の優先順位を使う。同じ優先順位の演算子が続くとparseExpressionのループが止まるので、
+// 1 - 2 - 3 は ((1 - 2) - 3) になる。
+// 右結合の場合は優先順位を一つ下げる。同じ優先順位の演算子も右側に取り込まれるので、 a ?? b ?? c は (a ?? (b ?? c)) になる。
+func (p *Parser) rightPrecedence() int {
+	precedence := p.curPrecedence()
+	if p.associativity[p.curToken.Type] == RightAssoc {
+		return precedence - 1
+	}
+	return precedence
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -337,9 +700,29 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// int64に収まらない整数はBigIntのリテラルにする。
+		// -9223372036854775808 も 9223372036854775808 に - を付けた式なので、ここを通る
+		if n, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			return &ast.BigIntLiteral{Token: p.curToken, Value: n}
+		}
+	}
+	if err != nil {
+		p.errorAt(ErrInvalidInteger, p.curToken, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(ErrInvalidFloat, p.curToken, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -372,6 +755,18 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
+// ...<expression>
+// 配列を展開して、関数呼び出しの引数や配列リテラルの要素として渡す。
+func (p *Parser) parseSpreadExpression() ast.Expression {
+	expression := &ast.SpreadExpression{Token: p.curToken}
+
+	p.nextToken()
+	// 関数呼び出しや添字は ... より強く結合する。 ...f(x) は ...(f(x)) になる。
+	expression.Value = p.parseExpression(PREFIX)
+
+	return expression
+}
+
 // 中置演算子の式のparse。curTokenが中置の演算子にまで進んだ状態で呼ばれる。
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	//defer untrace(trace("parseInfixExpression"))
@@ -381,16 +776,46 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 
-	precedence := p.curPrecedence()                  // 中置演算子の優先順位の確認。
+	precedence := p.rightPrecedence()                // 右側の式を解析する時に使う優先順位。結合性によって変わる。
 	p.nextToken()                                    // tokenを右側のexpressionにまで進める。1 + 2 なら 2 にtokenを進める感じ。
-	expression.Right = p.parseExpression(precedence) // 右側の式を解析する。引数に中置演算子優先順位を渡す。
+	expression.Right = p.parseExpression(precedence) // 右側の式を解析する。
 
 	return expression
 }
 
+// <identifier> = <expression>
+// letで宣言済みの変数への再代入。curTokenが = にまで進んだ状態で呼ばれる。
+// <expression>.<identifier> = <expression> はメンバーへの代入になる。
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	if prop, ok := left.(*ast.PropertyExpression); ok && !prop.Optional {
+		exp := &ast.PropertyAssignExpression{Token: p.curToken, Target: prop}
+		precedence := p.rightPrecedence()
+		p.nextToken()
+		exp.Value = p.parseExpression(precedence)
+		return exp
+	}
+
+	// 代入できるのは変数とメンバーだけ。 1 = 2 や f() = 2 のような式はエラーにする。
+	name, ok := left.(*ast.Identifier)
+	if !ok {
+		p.errorAt(ErrInvalidAssignTarget, p.curToken, "cannot assign to %s", left.String())
+		return nil
+	}
+
+	exp := &ast.AssignExpression{Token: p.curToken, Name: name}
+
+	precedence := p.rightPrecedence()
+	p.nextToken() // = の右側の式にトークンを進める。
+	// = は右結合なので、 a = b = 5 は a = (b = 5) と右から順に結合される。
+	exp.Value = p.parseExpression(precedence)
+
+	return exp
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function} // ( 関数呼び出しの括弧
 	exp.Arguments = p.parseExpressionList(token.RPAREN)               // ) がくるまでカンマ区切りの引数をパースする。
+	exp.EndToken = p.curToken                                         // 閉じの )
 	return exp
 }
 
@@ -436,6 +861,10 @@ func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
 // ユーザーが書いた括弧の優先度を高くする魔法の関数
 // ( が現れたらこの関数が実行される。
 // ===================== ex: 1 + (2 + 3) =====================
@@ -518,12 +947,124 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// try <block statement> catch (<identifier>) <block statement>
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Block = p.parseBlockStatement()
+
+	// tryのブロックの後にはcatchがくるはず。
+	if !p.expectPeek(token.CATCH) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Handler = p.parseBlockStatement()
+
+	return expression
+}
+
+// match (<subject>) { <pattern> => <expression>, ... }
+// match <subject> { ... } のように ( ) を省略してもいい。 ( ) で囲んだ場合も、式のグループとして同じようにパースされる。
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+	if expression.Subject == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	// 次のtokenが } ではない間は、アームをパースし続ける。
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken() // パターンにトークンを進める
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+
+		// 1つのアームが終わった後は、 } もしくは , がくるはず。
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	expression.EndToken = p.curToken
+
+	return expression
+}
+
+// <pattern> => <expression>
+// <pattern> => { <statements> }
+// <pattern> if <guard> => <expression>
+// パターンは式としてパースし、変数名や配列、ハッシュの扱いは評価するときに決める。
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	pattern := p.parseExpression(LOWEST)
+
+	var guard ast.Expression
+	if p.peekTokenIs(token.IF) {
+		p.nextToken() // if にトークンを進める
+		p.nextToken() // 条件の式にトークンを進める
+		guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	arm := &ast.MatchArm{Token: p.curToken, Pattern: pattern, Guard: guard}
+
+	// => の次が { ならブロックとして解析する。
+	// そうでなければ、式を一つだけ持つブロックとして組み立てる。
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		arm.Body = p.parseBlockStatement()
+		return arm
+	}
+
+	p.nextToken()
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+	arm.Body = &ast.BlockStatement{Token: arm.Token, Statements: []ast.Statement{stmt}}
+
+	return arm
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	// [ をTokenとしてArrayLiteralのノードを作成
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	// curTokenが配列の終端である ] になるまで、パースを続ける。
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.EndToken = p.curToken
 
 	return array
 }
@@ -542,6 +1083,48 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.EndToken = p.curToken
+
+	return exp
+}
+
+// <expression>?.[<expression>]
+// <expression>?.<identifier>
+// curTokenが ?. にまで進んだ状態で呼ばれる。
+func (p *Parser) parseOptionalAccessExpression(left ast.Expression) ast.Expression {
+	// ?.[ なら添字アクセス。 [ にトークンを進めて、通常の添字アクセスと同じように解析する。
+	if p.peekTokenIs(token.LBRACKET) {
+		p.nextToken()
+		exp := p.parseIndexExpression(left)
+		if exp == nil {
+			return nil
+		}
+		index := exp.(*ast.IndexExpression)
+		index.Optional = true
+		return index
+	}
+
+	exp := &ast.PropertyExpression{Token: p.curToken, Left: left, Optional: true}
+
+	// ?. の次はプロパティ名(IDENT)であること
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	return exp
+}
+
+// <expression>.<identifier>
+// ?. と違い、左側がnullならエラーになる。
+func (p *Parser) parsePropertyExpression(left ast.Expression) ast.Expression {
+	exp := &ast.PropertyExpression{Token: p.curToken, Left: left}
+
+	// . の次はプロパティ名(IDENT)であること
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	return exp
 }
@@ -562,6 +1145,10 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	// , がある限り、パースし続ける。
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken() // , にトークンを進める
+		// [1, 2, 3,] のように最後の要素の後の , は許容する。
+		if p.peekTokenIs(end) {
+			break
+		}
 		p.nextToken() // 次の配列の要素にトークンを進める
 		list = append(list, p.parseExpression(LOWEST))
 	}
@@ -584,8 +1171,11 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 	// 次のtokenが } ではない間は、ハッシュの中身をパースし続ける。
 	for !p.peekTokenIs(token.RBRACE) {
-		p.nextToken()                    // ハッシュの中身にトークンを進める
-		key := p.parseExpression(LOWEST) // キーの式をパースする
+		p.nextToken()           // ハッシュの中身にトークンを進める
+		key := p.parseHashKey() // キーの式をパースする
+		if key == nil {
+			return nil
+		}
 
 		// 次のトークンが : なら、トークンを : に進める。（キーの後は : がくるはず）
 		if !p.expectPeek(token.COLON) {
@@ -599,6 +1189,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		// 1組のキーバリューが終わった後は、 } もしくは , がくるはず。
 		// そうではない場合は、hashの構文としておかしいのでnilを返す。
+		// , の次が } の場合（最後のペアの後の , ）はループの条件で抜けるので、 {"a": 1,} も許容される。
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -609,10 +1200,32 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.EndToken = p.curToken
 
 	return hash
 }
 
+// ハッシュのキーの解析。
+// {name: 1} のようにキーが変数名だけの場合は、変数を参照するのではなく文字列の "name" をキーとする。
+// 変数の値や式の評価結果をキーにしたい場合は {[name]: 1} のように [ ] で囲む。
+// それ以外（文字列、整数、真偽値のリテラルなど）は今まで通り式としてパースする。
+func (p *Parser) parseHashKey() ast.Expression {
+	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.COLON) {
+		return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.curTokenIs(token.LBRACKET) {
+		p.nextToken() // [ の中の式にトークンを進める
+		key := p.parseExpression(LOWEST)
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return key
+	}
+
+	return p.parseExpression(LOWEST)
+}
+
 // fn <parameters> <block statement>
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken} // fn トークン
@@ -623,7 +1236,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	// 引数の解析
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.Rest = p.parseFunctionParameters()
 
 	// 引数が終われば ) があるはず。正しければトークンを ) に進める。
 	if !p.expectPeek(token.LBRACE) {
@@ -636,47 +1249,172 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-// 引数の解析。以下の3つのバリエーションに対応する。
+// fn <identifier> <parameters> <block statement>
+// 関数宣言は let <identifier> = fn <parameters> <block statement>; の糖衣構文。
+// なので、LetStatementとして組み立てる。
+func (p *Parser) parseFunctionDeclaration() ast.Statement {
+	lit, name := p.parseNamedFunction()
+	if lit == nil {
+		return nil
+	}
+
+	// fn name() {} は let name = fn() {} として扱うので、letのトークンを作る。位置は fn のものを使う。
+	letToken := lit.Token
+	letToken.Type, letToken.Literal = token.LET, "let"
+
+	return &ast.LetStatement{
+		Token: letToken,
+		Name:  name,
+		Value: lit,
+	}
+}
+
+// fn <identifier> <parameters> <block statement>
+// 関数宣言とクラスのメソッドで使う。現在のトークンは fn で、次のトークンが名前(IDENT)であること。
+func (p *Parser) parseNamedFunction() (*ast.FunctionLiteral, *ast.Identifier) {
+	lit := &ast.FunctionLiteral{Token: p.curToken} // fn トークン
+
+	// fn の次の関数名にトークンを進める。
+	if !p.expectPeek(token.IDENT) {
+		return nil, nil
+	}
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	lit.Name = name.Value
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil, nil
+	}
+
+	lit.Parameters, lit.Rest = p.parseFunctionParameters()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil, nil
+	}
+
+	lit.Body = p.parseBlockStatement()
+
+	// } の後の ; は省略可能。
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return lit, name
+}
+
+// class <identifier>(<field>, ...) { fn <identifier>(<parameters>) <block statement> ... }
+// クラスの本体に書けるのはメソッドの宣言だけ。
+func (p *Parser) parseClassStatement() ast.Statement {
+	stmt := &ast.ClassStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// フィールドは関数の引数と同じ形で書く。ただし可変長にはできない。
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	fields, rest := p.parseFunctionParameters()
+	if fields == nil {
+		return nil
+	}
+	if rest != nil {
+		p.errorAt(ErrUnexpectedToken, rest.Token, "class fields cannot be variadic: ...%s", rest.Value)
+		return nil
+	}
+	stmt.Fields = fields
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.FUNCTION) {
+			return nil
+		}
+		method, _ := p.parseNamedFunction()
+		if method == nil {
+			return nil
+		}
+		stmt.Methods = append(stmt.Methods, method)
+	}
+	p.nextToken()
+	stmt.EndToken = p.curToken
+
+	// } の後の ; は省略可能。
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// 引数の解析。以下のバリエーションに対応する。
 // (<IDENT>, <IDENT>, <IDENT>, ...)
 // (<IDENT>)
 // ()
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// (<IDENT>, ...<IDENT>)
+// 最後の引数に ... がついていれば可変長引数。残りの引数を配列にまとめて受け取る。二つ目の戻り値で返す。
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, *ast.Identifier) {
 	identifiers := []*ast.Identifier{}
 
 	// 引数が何もない場合。( の次のトークンが ) だった場合
 	if p.peekTokenIs(token.RPAREN) {
 		// ) にトークンを進める。
 		p.nextToken()
-		return identifiers
+		return identifiers, nil
 	}
 
 	// -------ここからは引数が一つでもあった場合-------
 	// 一つ目の引数(IDENT)にトークンを進める。
 	p.nextToken()
 
-	// Identノードを作成
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	// 冒頭で用意した引数配列に一つ目の引数を詰める。
-	identifiers = append(identifiers, ident)
+	for {
+		// ... が現れたら可変長引数。可変長引数の後には ) がくるはずなので、ループを抜ける。
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil, nil
+			}
+			rest := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+			if !p.expectPeek(token.RPAREN) {
+				return nil, nil
+			}
+			return identifiers, rest
+		}
+
+		// 引数は変数名(IDENT)でないといけない。
+		if !p.curTokenIs(token.IDENT) {
+			p.curError(token.IDENT)
+			return nil, nil
+		}
+
+		// Identノードを作成し、引数配列に詰める。
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		identifiers = append(identifiers, ident)
+
+		// 引数の後に , が現れなければ引数はもうない。
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 
-	// 一つ目の引数の後に , が現れた場合。つまり複数の引数がある場合はこのforループに入る。
-	for p.peekTokenIs(token.COMMA) {
 		// , にトークンを進める。
 		p.nextToken()
+		// fn(x, y,) のように最後の引数の後の , は許容する。
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
 		// 次の引数にトークンを進める。
 		p.nextToken()
-		// 次の引数のIdentノードを作成。
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		// 作成したIdentノードを引数配列に詰める
-		identifiers = append(identifiers, ident)
 	}
 
 	// 引数の終わりには ) があるはず。正しければ ) にトークンを進める。
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
 	}
 
-	return identifiers
+	return identifiers, nil
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
@@ -687,15 +1425,36 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	// } が出てくる、もしくはEOFが出てくるまではブロックの中を解析し続ける。
-	// EOFの時はstmtがnilになり、現在まで解析したものをblock.Statementsにつめて終了？？？（ちょっと自信ない）
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		errCount, braces := len(p.errors), p.braces
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if len(p.errors) > errCount {
+			// ParseProgramと同じく、エラーがあった文は捨てて次の文まで読み飛ばす。
+			// } で止まった場合はこのブロックの終わりなので、トークンを進めずにループを抜ける。
+			p.synchronize(braces)
+			if p.curTokenIs(token.RBRACE) {
+				break
+			}
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
 
+	// } が出てくる前にEOFに達した場合は、ブロックが閉じられていないのでエラー。
+	// どこから始まったブロックなのかが分かるように、 { の位置をエラーの位置とする。
+	if p.curTokenIs(token.EOF) {
+		p.addError(Error{
+			Code:     ErrUnexpectedEOF,
+			Token:    block.Token,
+			Expected: token.RBRACE,
+			Got:      token.EOF,
+			Message:  "unexpected EOF, expected }",
+		})
+	} else {
+		block.EndToken = p.curToken
+	}
+
 	return block
 }
 
@@ -708,3 +1467,21 @@ func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
+
+// 中置演算子の結合性を設定する。RegisterInfixOperatorで追加した演算子を右結合にしたい場合に使う。
+// ex: p.SetAssociativity("**", RightAssoc) とすれば 2 ** 3 ** 2 は (2 ** (3 ** 2)) になる。
+func (p *Parser) SetAssociativity(tokenType token.TokenType, assoc Associativity) {
+	p.associativity[tokenType] = assoc
+}
+
+// 中置演算子を追加する。パッケージに手を入れずに、埋め込む側が新しい演算子や優先順位を足せるようにするためのもの。
+// precedenceには LOWEST や SUM などの定数を使う。 SUM + 1 のように既存の優先順位の間に新しい優先順位を作ってもいい。
+// fnがnilの場合は + などと同じ二項演算子（*ast.InfixExpression）として解析する。
+// 演算子のトークンはlexerが読めないといけないので、新しい記号の場合は先に lexer.RegisterOperator で登録しておく。
+func (p *Parser) RegisterInfixOperator(tokenType token.TokenType, precedence int, fn func(left ast.Expression) ast.Expression) {
+	if fn == nil {
+		fn = p.parseInfixExpression
+	}
+	p.precedences[tokenType] = precedence
+	p.registerInfix(tokenType, fn)
+}
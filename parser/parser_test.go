@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"strings"
 	"testing"
 )
 
@@ -171,6 +172,35 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// int64に収まらない整数はBigIntのリテラルになる
+		{"99999999999999999999", "99999999999999999999"},
+		{"-9223372036854775808", "(- 9223372036854775808)"},
+		{"9223372036854775807", "9223372036854775807"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgramForTest(t, tt.input)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if got := ast.Sexpr(stmt.Expression); got != tt.expected {
+			t.Errorf("%s: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	program := parseProgramForTest(t, "99999999999999999999")
+	literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.BigIntLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.BigIntLiteral. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	if literal.TokenLiteral() != "99999999999999999999" {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", "99999999999999999999", literal.TokenLiteral())
+	}
+}
+
 // <prefix operator> <expression>
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
@@ -306,6 +336,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b - c",
 			"((a + b) - c)",
 		},
+		{
+			"a % b * c + d",
+			"(((a % b) * c) + d)",
+		},
 		{
 			"a * b * c",
 			"((a * b) * c)",
@@ -402,6 +436,38 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		// 代入は一番優先度が低く、右から順に結合される
+		{
+			"a = b + c * d",
+			"(a = (b + (c * d)))",
+		},
+		{
+			"a = b = 5",
+			"(a = (b = 5))",
+		},
+		// ?? は比較より優先度が低い
+		{
+			"a ?? b == c",
+			"(a ?? (b == c))",
+		},
+		{
+			"a ?? b + c",
+			"(a ?? (b + c))",
+		},
+		// ?. は添字と同じ優先度
+		{
+			"a?.b?.[1] + c",
+			"(((a?.b)?.[1]) + c)",
+		},
+		{
+			"x = a?.b ?? 1",
+			"(x = ((a?.b) ?? 1))",
+		},
+		// . も添字と同じ優先度
+		{
+			"math.sqrt(2) * a.b.c",
+			"((math.sqrt)(2) * ((a.b).c))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -627,10 +693,13 @@ func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
 		expectedParams []string
+		expectedRest   string
 	}{
 		{input: "fn() {};", expectedParams: []string{}},
 		{input: "fn(x) {};", expectedParams: []string{"x"}},
 		{input: "fn(x, y, z) {};", expectedParams: []string{"x", "y", "z"}},
+		{input: "fn(...rest) {};", expectedParams: []string{}, expectedRest: "rest"},
+		{input: "fn(x, ...rest) {};", expectedParams: []string{"x"}, expectedRest: "rest"},
 	}
 
 	for _, tt := range tests {
@@ -654,6 +723,50 @@ func TestFunctionParameterParsing(t *testing.T) {
 		for i, ident := range tt.expectedParams {
 			testLiteralExpression(t, function.Parameters[i], ident)
 		}
+
+		// 可変長引数が正しいこと
+		if tt.expectedRest == "" {
+			if function.Rest != nil {
+				t.Errorf("function.Rest is not nil. got=%s", function.Rest)
+			}
+		} else {
+			testLiteralExpression(t, function.Rest, tt.expectedRest)
+		}
+	}
+}
+
+// 可変長引数は最後の引数でないといけない
+func TestRestParameterMustBeLast(t *testing.T) {
+	l := lexer.New("fn(...rest, x) {};")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+}
+
+func TestSpreadExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(...a)", "f(...a)"},
+		{"f(1, ...a, 2)", "f(1, ...a, 2)"},
+		{"[...a, ...b]", "[...a, ...b]"},
+		{"[...f(x)[0]]", "[...(f(x)[0])]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
 	}
 }
 
@@ -952,111 +1065,1172 @@ func TestParsingHashLiteralsIntegerKeys(t *testing.T) {
 	}
 }
 
-func testIntegerLiteral(t *testing.T, il ast.Expression, value int64) bool {
-	integ, ok := il.(*ast.IntegerLiteral)
+func TestForInStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { x }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
 	if !ok {
-		t.Errorf("il not *ast.IntegerLiteral. got=%T", il)
-		return false
+		t.Fatalf("program.Statements[0] is not ast.ForInStatement. got=%T",
+			program.Statements[0])
 	}
 
-	if integ.Value != value {
-		t.Errorf("integ.Value not %d. got=%d", value, integ.Value)
-		return false
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
 	}
 
-	if integ.TokenLiteral() != fmt.Sprintf("%d", value) {
-		t.Errorf("integ.TokenLiteral not %d. got=%s", value,
-			integ.TokenLiteral())
-		return false
+	array, ok := stmt.Iterable.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("stmt.Iterable is not ast.ArrayLiteral. got=%T", stmt.Iterable)
+	}
+	if len(array.Elements) != 2 {
+		t.Fatalf("len(array.Elements) not 2. got=%d", len(array.Elements))
 	}
 
-	return true
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	body, ok := stmt.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("body stmt is not ast.ExpressionStatement. got=%T",
+			stmt.Body.Statements[0])
+	}
+
+	testIdentifier(t, body.Expression, "x")
 }
 
-func testInfixExpression(t *testing.T, exp ast.Expression, left interface{},
-	operator string, right interface{}) bool {
+func TestAssignExpressionParsing(t *testing.T) {
+	input := "x = 5;"
 
-	opExp, ok := exp.(*ast.InfixExpression)
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.AssignExpression)
 	if !ok {
-		t.Errorf("exp is not ast.InfixExpression. got=%T(%s)", exp, exp)
-		return false
+		t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T",
+			stmt.Expression)
 	}
 
-	if !testLiteralExpression(t, opExp.Left, left) {
-		return false
+	if !testIdentifier(t, exp.Name, "x") {
+		return
 	}
+	testIntegerLiteral(t, exp.Value, 5)
+}
 
-	if opExp.Operator != operator {
-		t.Errorf("exp.Operator is not '%s'. got=%q", operator, opExp.Operator)
-		return false
+func TestPropertyAssignExpressionParsing(t *testing.T) {
+	program := parseProgramForTest(t, "p.pos.x = y = 5;")
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.PropertyAssignExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.PropertyAssignExpression. got=%T",
+			stmt.Expression)
+	}
+	if exp.Target.String() != "((p.pos).x)" {
+		t.Errorf("exp.Target wrong. got=%s", exp.Target)
+	}
+	if _, ok := exp.Value.(*ast.AssignExpression); !ok {
+		t.Errorf("exp.Value is not ast.AssignExpression. got=%T", exp.Value)
 	}
 
-	if !testLiteralExpression(t, opExp.Right, right) {
-		return false
+	// ASTのツールもメンバーへの代入を扱える
+	if !ast.Equal(program, ast.Clone(program)) {
+		t.Errorf("cloned program differs")
+	}
+	data, err := ast.Encode(program)
+	if err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	decoded, err := ast.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if !ast.Equal(program, decoded) {
+		t.Errorf("decoded program differs.\nexpected=%s\ngot=%s", ast.Sexpr(program), ast.Sexpr(decoded))
 	}
+}
 
-	return true
+// 変数以外への代入はパースエラーになること
+func TestClassStatement(t *testing.T) {
+	input := `class Point(x, y) {
+  fn sum() { self.x + self.y }
+  fn add(other) { Point(self.x + other.x, self.y + other.y) };
+};`
+
+	program := parseProgramForTest(t, input)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ClassStatement)
+	if !ok {
+		t.Fatalf("stmt is not *ast.ClassStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Name.Value != "Point" {
+		t.Errorf("stmt.Name wrong. got=%q", stmt.Name.Value)
+	}
+	if len(stmt.Fields) != 2 || stmt.Fields[0].Value != "x" || stmt.Fields[1].Value != "y" {
+		t.Errorf("stmt.Fields wrong. got=%v", stmt.Fields)
+	}
+	if len(stmt.Methods) != 2 || stmt.Methods[0].Name != "sum" || stmt.Methods[1].Name != "add" {
+		t.Fatalf("stmt.Methods wrong. got=%v", stmt.Methods)
+	}
+	if len(stmt.Methods[1].Parameters) != 1 || stmt.Methods[1].Parameters[0].Value != "other" {
+		t.Errorf("method parameters wrong. got=%v", stmt.Methods[1].Parameters)
+	}
+	if stmt.End().Line != 4 || stmt.End().Column != 2 {
+		t.Errorf("stmt.End() wrong. got=%s", stmt.End())
+	}
+
+	// ASTのツールもクラスを扱える
+	if !ast.Equal(program, ast.Clone(program)) {
+		t.Errorf("cloned program differs")
+	}
+	data, err := ast.Encode(program)
+	if err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	decoded, err := ast.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if !ast.Equal(program, decoded) {
+		t.Errorf("decoded program differs.\nexpected=%s\ngot=%s", ast.Sexpr(program), ast.Sexpr(decoded))
+	}
 }
 
-func testLiteralExpression(
-	t *testing.T,
-	exp ast.Expression,
-	expected interface{},
-) bool {
-	switch v := expected.(type) {
-	case int:
-		return testIntegerLiteral(t, exp, int64(v))
-	case int64:
-		return testIntegerLiteral(t, exp, v)
-	case string:
-		return testIdentifier(t, exp, v)
-	case bool:
-		return testBooleanLiteral(t, exp, v)
+func TestInvalidClassStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"class (x) {}", "1:7: expected next token to be IDENT, got ( instead"},
+		{"class P(...xs) {}", "1:12: class fields cannot be variadic: ...xs"},
+		{"class P() { let x = 1; }", "1:13: expected next token to be FUNCTION, got LET instead"},
+		{"class P() { fn () {} }", "1:16: expected next token to be IDENT, got ( instead"},
+		{"class P() { fn f() {}", "1:22: expected next token to be FUNCTION, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser error for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errors[0])
+		}
 	}
-	t.Errorf("type of exp not handled. got=%T", exp)
-	return false
 }
 
-func testIdentifier(t *testing.T, exp ast.Expression, value string) bool {
-	ident, ok := exp.(*ast.Identifier)
-	if !ok {
-		t.Errorf("exp not *ast.Identifier. got=%T", exp)
-		return false
+func TestInvalidAssignTarget(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 = 2;", "1:3: cannot assign to 1"},
+		{"f() = 2;", "1:5: cannot assign to f()"},
+		{"1 + a = 2;", "1:7: cannot assign to (1 + a)"},
+		{"a?.b = 2;", "1:6: cannot assign to (a?.b)"},
 	}
 
-	if ident.Value != value {
-		t.Errorf("ident.Value not %s. got=%s", value, ident.Value)
-		return false
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser error for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expected, errors[0])
+		}
 	}
+}
 
-	if ident.TokenLiteral() != value {
-		t.Errorf("ident.TokenLiteral not %s. got=%s", value,
-			ident.TokenLiteral())
-		return false
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedIdentifier string
+		expectedValue      interface{}
+	}{
+		{"const x = 5;", "x", 5},
+		{"const y = true;", "y", true},
+		{"const foobar = y;", "foobar", "y"},
 	}
 
-	return true
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ConstStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ConstStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if !testIdentifier(t, stmt.Name, tt.expectedIdentifier) {
+			return
+		}
+
+		if !testLiteralExpression(t, stmt.Value, tt.expectedValue) {
+			return
+		}
+	}
 }
 
-func testBooleanLiteral(t *testing.T, exp ast.Expression, value bool) bool {
-	bo, ok := exp.(*ast.Boolean)
+// fn <identifier>() {} は let <identifier> = fn() {}; としてパースされること
+func TestFunctionDeclarationParsing(t *testing.T) {
+	input := `fn add(x, y) { x + y; } add(1, 2);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt := program.Statements[0]
+	if !testLetStatement(t, stmt, "add") {
+		return
+	}
+
+	function, ok := stmt.(*ast.LetStatement).Value.(*ast.FunctionLiteral)
 	if !ok {
-		t.Errorf("exp not *ast.Boolean. got=%T", exp)
-		return false
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T",
+			stmt.(*ast.LetStatement).Value)
 	}
 
-	if bo.Value != value {
-		t.Errorf("bo.Value not %t. got=%t", value, bo.Value)
-		return false
+	if function.Name != "add" {
+		t.Errorf("function.Name is not %q. got=%q", "add", function.Name)
 	}
 
-	if bo.TokenLiteral() != fmt.Sprintf("%t", value) {
-		t.Errorf("bo.TokenLiteral not %t. got=%s",
-			value, bo.TokenLiteral())
-		return false
+	if len(function.Parameters) != 2 {
+		t.Fatalf("function literal parameters wrong. want 2, got=%d\n",
+			len(function.Parameters))
 	}
 
-	return true
+	testLiteralExpression(t, function.Parameters[0], "x")
+	testLiteralExpression(t, function.Parameters[1], "y")
+
+	if len(function.Body.Statements) != 1 {
+		t.Fatalf("function.Body.Statements has not 1 statements. got=%d\n",
+			len(function.Body.Statements))
+	}
+
+	// fn の後に関数名がなければ今まで通り関数リテラルの式になる
+	if _, ok := program.Statements[1].(*ast.ExpressionStatement); !ok {
+		t.Fatalf("program.Statements[1] is not ast.ExpressionStatement. got=%T",
+			program.Statements[1])
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `match (x) { 1 => a, "two" => { b; c }, _ => d, }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if !testIdentifier(t, exp.Subject, "x") {
+		return
+	}
+
+	if len(exp.Arms) != 3 {
+		t.Fatalf("exp.Arms does not contain 3 arms. got=%d", len(exp.Arms))
+	}
+
+	testIntegerLiteral(t, exp.Arms[0].Pattern, 1)
+	if len(exp.Arms[0].Body.Statements) != 1 {
+		t.Errorf("arm 0 body is not 1 statements. got=%d",
+			len(exp.Arms[0].Body.Statements))
+	}
+
+	pattern, ok := exp.Arms[1].Pattern.(*ast.StringLiteral)
+	if !ok || pattern.Value != "two" {
+		t.Errorf("arm 1 pattern is not \"two\". got=%T (%+v)",
+			exp.Arms[1].Pattern, exp.Arms[1].Pattern)
+	}
+	if len(exp.Arms[1].Body.Statements) != 2 {
+		t.Errorf("arm 1 body is not 2 statements. got=%d",
+			len(exp.Arms[1].Body.Statements))
+	}
+
+	if !exp.Arms[2].IsWildcard() {
+		t.Errorf("arm 2 is not wildcard. got=%s", exp.Arms[2].Pattern)
+	}
+}
+
+func TestMatchPatternsAndGuards(t *testing.T) {
+	input := `match (p) { [x, ...rest] if x > 0 => x, {name: n} => n, _ if ok => 1 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	exp := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	if len(exp.Arms) != 3 {
+		t.Fatalf("exp.Arms does not contain 3 arms. got=%d", len(exp.Arms))
+	}
+
+	if _, ok := exp.Arms[0].Pattern.(*ast.ArrayLiteral); !ok {
+		t.Errorf("arm 0 pattern is not ast.ArrayLiteral. got=%T", exp.Arms[0].Pattern)
+	}
+	if !testInfixExpression(t, exp.Arms[0].Guard, "x", ">", 0) {
+		return
+	}
+	if exp.Arms[1].Guard != nil {
+		t.Errorf("arm 1 has a guard. got=%s", exp.Arms[1].Guard)
+	}
+	// ガードのある _ はデフォルトのアームではない
+	if exp.Arms[2].IsWildcard() {
+		t.Errorf("arm 2 with a guard is wildcard")
+	}
+
+	var names []string
+	for _, arm := range exp.Arms {
+		for _, ident := range arm.Bindings() {
+			names = append(names, ident.Value)
+		}
+	}
+	if strings.Join(names, ",") != "x,rest,n" {
+		t.Errorf("bindings wrong. got=%v", names)
+	}
+
+	expected := "match (p) { [x, ...rest] if (x > 0) => x, {name:n} => n, _ if ok => 1 }"
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. expected=%q, got=%q", expected, exp.String())
+	}
+}
+
+func TestNullLiteralExpression(t *testing.T) {
+	input := "null;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.NullLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.NullLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.TokenLiteral() != "null" {
+		t.Errorf("literal.TokenLiteral not %q. got=%q", "null", literal.TokenLiteral())
+	}
+}
+
+func TestLetDestructureStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = pair;", "let [a, b] = pair;"},
+		{"let [a, ...rest] = [1, 2, 3];", "let [a, ...rest] = [1, 2, 3];"},
+		{"let [] = x;", "let [] = x;"},
+		{"let {name, age} = person;", "let {name, age} = person;"},
+		{"let {} = person;", "let {} = person;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.LetDestructureStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.LetDestructureStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestInvalidLetDestructure(t *testing.T) {
+	tests := []string{
+		"let [1, b] = pair;",
+		"let [...rest, a] = pair;",
+		`let {"name"} = person;`,
+		"let [a, b];",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q, got none", input)
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := `throw "boom";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got=%T",
+			program.Statements[0])
+	}
+
+	literal, ok := stmt.Value.(*ast.StringLiteral)
+	if !ok || literal.Value != "boom" {
+		t.Errorf("stmt.Value is not \"boom\". got=%T (%+v)", stmt.Value, stmt.Value)
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	input := `try { x } catch (e) { y; z }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if len(exp.Block.Statements) != 1 {
+		t.Errorf("try block is not 1 statements. got=%d", len(exp.Block.Statements))
+	}
+
+	if !testIdentifier(t, exp.Parameter, "e") {
+		return
+	}
+
+	if len(exp.Handler.Statements) != 2 {
+		t.Errorf("catch block is not 2 statements. got=%d", len(exp.Handler.Statements))
+	}
+}
+
+// 一つの文でエラーが見つかっても、次の文から解析を再開して独立したエラーを全て集めること。
+// 壊れた文の残りのトークンから連鎖的なエラーが出ないこと。
+func TestParseErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`let = 5;
+let y = 10;
+let 838383;`,
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"3:5: expected next token to be IDENT, got INT instead",
+			},
+		},
+		{
+			`let x 5 + 5 * 10;
+return;
+let y = (1 + 2;
+y;`,
+			[]string{
+				"1:7: expected next token to be =, got INT instead",
+				"2:7: no prefix parse function for ; found",
+				"3:15: expected next token to be ), got ; instead",
+			},
+		},
+		// ブロックの中のエラーはブロックの終わりで回復し、ブロックの外の解析は続けられる。
+		{
+			`let f = fn() { let = 1; let y = ; y };
+let z = );`,
+			[]string{
+				"1:20: expected next token to be IDENT, got = instead",
+				"1:33: no prefix parse function for ; found",
+				"2:9: no prefix parse function for ) found",
+			},
+		},
+		{
+			`if (x) { let a 1 } else { b }; c;`,
+			[]string{
+				"1:16: expected next token to be =, got INT instead",
+			},
+		},
+		// 最初のエラーから続いて起きるエラーは報告しない
+		{
+			"let w = (1 + ;\nlet ok = 1;",
+			[]string{
+				"1:14: no prefix parse function for ; found",
+			},
+		},
+		{
+			`let h = {1: 2, 3};`,
+			[]string{
+				"1:17: expected next token to be :, got } instead",
+			},
+		},
+		{
+			`puts({,})`,
+			[]string{
+				"1:7: no prefix parse function for , found",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%d (%q)",
+				tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+		for i, msg := range tt.expected {
+			if errors[i] != msg {
+				t.Errorf("wrong error message. expected=%q, got=%q", msg, errors[i])
+			}
+		}
+	}
+}
+
+// エラーがなかった文は、エラーのあった文の前後どちらにあっても捨てられないこと
+func TestParseErrorRecoveryKeepsValidStatements(t *testing.T) {
+	input := `let a = 1; let = 2; let b = 3; c)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 2 {
+		t.Fatalf("expected 2 errors. got=%q", p.Errors())
+	}
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d (%s)",
+			len(program.Statements), program.String())
+	}
+
+	testLetStatement(t, program.Statements[0], "a")
+	testLetStatement(t, program.Statements[1], "b")
+}
+
+func TestTrailingCommas(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2, 3,]", "[1, 2, 3]"},
+		{"[1,]", "[1]"},
+		{"add(1, 2,)", "add(1, 2)"},
+		{"fn(x, y,) { x }", "fn(x, y) x"},
+		{"[\n  1,\n  2,\n]", "[1, 2]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	// ハッシュのペアの順番はmapなので保証されない。ペアの数で確認する。
+	l := lexer.New(`{"a": 1, "b": 2,}`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+	if len(hash.Pairs) != 2 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+}
+
+// , だけの要素や連続した , はエラーになること
+func TestInvalidTrailingCommas(t *testing.T) {
+	tests := []string{
+		"[,]",
+		"[1,,]",
+		"add(,)",
+		"fn(,) { x }",
+		`{,}`,
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q, got none", input)
+		}
+	}
+}
+
+// キーが変数名だけの場合は文字列のキーとしてパースされること
+func TestParsingHashLiteralsIdentifierKeys(t *testing.T) {
+	input := `{name: "bob", [age]: 1, "x": 2}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	var stringKeys, identKeys []string
+	for key := range hash.Pairs {
+		switch key := key.(type) {
+		case *ast.StringLiteral:
+			stringKeys = append(stringKeys, key.Value)
+		case *ast.Identifier:
+			identKeys = append(identKeys, key.Value)
+		default:
+			t.Errorf("key is not ast.StringLiteral or ast.Identifier. got=%T", key)
+		}
+	}
+
+	if len(stringKeys) != 2 {
+		t.Errorf("wrong number of string keys. got=%v", stringKeys)
+	}
+	// [age] は変数を参照する式のまま
+	if len(identKeys) != 1 || identKeys[0] != "age" {
+		t.Errorf("computed key is not identifier age. got=%v", identKeys)
+	}
+}
+
+func testIntegerLiteral(t *testing.T, il ast.Expression, value int64) bool {
+	integ, ok := il.(*ast.IntegerLiteral)
+	if !ok {
+		t.Errorf("il not *ast.IntegerLiteral. got=%T", il)
+		return false
+	}
+
+	if integ.Value != value {
+		t.Errorf("integ.Value not %d. got=%d", value, integ.Value)
+		return false
+	}
+
+	if integ.TokenLiteral() != fmt.Sprintf("%d", value) {
+		t.Errorf("integ.TokenLiteral not %d. got=%s", value,
+			integ.TokenLiteral())
+		return false
+	}
+
+	return true
+}
+
+func testInfixExpression(t *testing.T, exp ast.Expression, left interface{},
+	operator string, right interface{}) bool {
+
+	opExp, ok := exp.(*ast.InfixExpression)
+	if !ok {
+		t.Errorf("exp is not ast.InfixExpression. got=%T(%s)", exp, exp)
+		return false
+	}
+
+	if !testLiteralExpression(t, opExp.Left, left) {
+		return false
+	}
+
+	if opExp.Operator != operator {
+		t.Errorf("exp.Operator is not '%s'. got=%q", operator, opExp.Operator)
+		return false
+	}
+
+	if !testLiteralExpression(t, opExp.Right, right) {
+		return false
+	}
+
+	return true
+}
+
+func testLiteralExpression(
+	t *testing.T,
+	exp ast.Expression,
+	expected interface{},
+) bool {
+	switch v := expected.(type) {
+	case int:
+		return testIntegerLiteral(t, exp, int64(v))
+	case int64:
+		return testIntegerLiteral(t, exp, v)
+	case string:
+		return testIdentifier(t, exp, v)
+	case bool:
+		return testBooleanLiteral(t, exp, v)
+	}
+	t.Errorf("type of exp not handled. got=%T", exp)
+	return false
+}
+
+func testIdentifier(t *testing.T, exp ast.Expression, value string) bool {
+	ident, ok := exp.(*ast.Identifier)
+	if !ok {
+		t.Errorf("exp not *ast.Identifier. got=%T", exp)
+		return false
+	}
+
+	if ident.Value != value {
+		t.Errorf("ident.Value not %s. got=%s", value, ident.Value)
+		return false
+	}
+
+	if ident.TokenLiteral() != value {
+		t.Errorf("ident.TokenLiteral not %s. got=%s", value,
+			ident.TokenLiteral())
+		return false
+	}
+
+	return true
+}
+
+func testBooleanLiteral(t *testing.T, exp ast.Expression, value bool) bool {
+	bo, ok := exp.(*ast.Boolean)
+	if !ok {
+		t.Errorf("exp not *ast.Boolean. got=%T", exp)
+		return false
+	}
+
+	if bo.Value != value {
+		t.Errorf("bo.Value not %t. got=%t", value, bo.Value)
+		return false
+	}
+
+	if bo.TokenLiteral() != fmt.Sprintf("%t", value) {
+		t.Errorf("bo.TokenLiteral not %t. got=%s",
+			value, bo.TokenLiteral())
+		return false
+	}
+
+	return true
+}
+
+func TestRegisterInfixOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2 ** 3", "(2 ** 3)"},
+		{"1 + 2 ** 3 * 4", "(1 + ((2 ** 3) * 4))"},
+		{"-2 ** 2", "((-2) ** 2)"},
+		{"a ** b ** c", "((a ** b) ** c)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		l.RegisterOperator("**", "**")
+		p := New(l)
+		p.RegisterInfixOperator("**", PRODUCT+1, nil)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	// 別のパーサーには影響しない
+	p := New(lexer.New("a ** b"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected parse errors for unregistered operator")
+	}
+}
+
+func TestOperatorAssociativity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"a ?? b ?? c", "(a ?? (b ?? c))"},
+		{"a = b = c", "(a = (b = c))"},
+		{"a ?? b == c ?? d", "(a ?? ((b == c) ?? d))"},
+		{"2 ** 3 ** 2", "(2 ** (3 ** 2))"},
+		{"2 ** 3 ** 2 * 4", "((2 ** (3 ** 2)) * 4)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		l.RegisterOperator("**", "**")
+		p := New(l)
+		p.RegisterInfixOperator("**", PRODUCT+1, nil)
+		p.SetAssociativity("**", RightAssoc)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let x = 1 + 2 * y;
+add(x, [1, 2])[0];
+if (x) { x } else { "no" }
+fn f(a) { return a; }
+{"a": 1}?.a;
+match (x) { 1 => 2, _ => 3 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	source := func(n ast.Node) string {
+		return input[n.Pos().Offset:n.End().Offset]
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	ifExp := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	fnDecl := program.Statements[3].(*ast.LetStatement)
+	prop := program.Statements[4].(*ast.ExpressionStatement).Expression.(*ast.PropertyExpression)
+	match := program.Statements[5].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{let, "let x = 1 + 2 * y"},
+		{let.Value, "1 + 2 * y"},
+		{let.Value.(*ast.InfixExpression).Right, "2 * y"},
+		{call, `add(x, [1, 2])[0]`},
+		{call.Left, `add(x, [1, 2])`},
+		{call.Left.(*ast.CallExpression).Arguments[1], "[1, 2]"},
+		{ifExp, `if (x) { x } else { "no" }`},
+		{ifExp.Consequence, "{ x }"},
+		{ifExp.Alternative.Statements[0], `"no"`},
+		{fnDecl, "fn f(a) { return a; }"},
+		{fnDecl.Value.(*ast.FunctionLiteral).Body.Statements[0], "return a"},
+		{prop, `{"a": 1}?.a`},
+		{match, "match (x) { 1 => 2, _ => 3 }"},
+		{match.Arms[1].Body, "3"},
+		{program, input},
+	}
+
+	for i, tt := range tests {
+		if got := source(tt.node); got != tt.expected {
+			t.Errorf("tests[%d] - source wrong. expected=%q, got=%q", i, tt.expected, got)
+		}
+	}
+
+	// 行と列
+	pos := ifExp.Alternative.Statements[0].Pos()
+	if pos.Line != 3 || pos.Column != 21 {
+		t.Errorf("position wrong. expected=3:21, got=%s", pos)
+	}
+	end := match.End()
+	if end.Line != 6 || end.Column != 29 {
+		t.Errorf("end position wrong. expected=6:29, got=%s", end)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let x=1+2*3;let y = (1 + 2) * 3",
+			"let x = 1 + 2 * 3;\nlet y = (1 + 2) * 3;\n",
+		},
+		{
+			"1 - (2 - 3); (1 - 2) - 3; a ?? (b ?? c); (a ?? b) ?? c; -(a + b); (-a)[0]; a = b = c",
+			"1 - (2 - 3);\n1 - 2 - 3;\na ?? b ?? c;\n(a ?? b) ?? c;\n-(a + b);\n(-a)[0];\na = b = c;\n",
+		},
+		{
+			"p.x=p.y=1; f().z = (a = 2)",
+			"p.x = p.y = 1;\nf().z = a = 2;\n",
+		},
+		{
+			"fn add(x, ...rest) { if (x > 1) { return x; } else { rest } }",
+			"fn add(x, ...rest) {\n  if (x > 1) {\n    return x;\n  } else {\n    rest;\n  }\n}\n",
+		},
+		{
+			"let f = fn() {}; f();\n\n\n\nlet h = {\"a\": 1, b: [x], [c]: null}; h?.b?.[0]",
+			"let f = fn() {};\nf();\n\nlet h = {\"a\": 1, b: [x], [c]: null};\nh?.b?.[0];\n",
+		},
+		{
+			"match (x) { 1 => 2, 2 => { let y = 1; y }, _ => try { throw 1 } catch (e) { e } }",
+			"match (x) {\n  1 => 2,\n  2 => {\n    let y = 1;\n    y;\n  },\n  _ => try {\n    throw 1;\n  } catch (e) {\n    e;\n  },\n}\n",
+		},
+		{
+			"match type(x) { \"INTEGER\" => 1, _ => 2 }",
+			"match (type(x)) {\n  \"INTEGER\" => 1,\n  _ => 2,\n}\n",
+		},
+		{
+			"match (p) { [x, ...r] if x > 0 => x, {k: v} => v }",
+			"match (p) {\n  [x, ...r] if x > 0 => x,\n  {k: v} => v,\n}\n",
+		},
+		{
+			"for (x in [1, 2]) { puts(x) }; let [a, ...b] = xs; let {c} = h; const d = ...e",
+			"for (x in [1, 2]) {\n  puts(x);\n}\nlet [a, ...b] = xs;\nlet {c} = h;\nconst d = ...e;\n",
+		},
+		{
+			"if (x) { 1 }; [1, 2]",
+			"if (x) {\n  1;\n};\n[1, 2];\n",
+		},
+		{
+			"class P(x, y) { fn sum() { self.x + self.y } fn scale(k) { P(self.x * k, self.y * k) } }; class E() {}",
+			"class P(x, y) {\n  fn sum() {\n    self.x + self.y;\n  }\n  fn scale(k) {\n    P(self.x * k, self.y * k);\n  }\n}\nclass E() {}\n",
+		},
+		// リテラルの中のコメントは、リテラルの外に出さずに要素のところに残す
+		{
+			"let z = [\n  1, // one\n  2,\n];\nlet w = 3;",
+			"let z = [\n  1, // one\n  2,\n];\nlet w = 3;\n",
+		},
+		{
+			"let h = {\n  // first\n  a: 1, // one\n  b: [2, // two\n 3], // three\n  // end\n}; puts(h, [4, 5])",
+			"let h = {\n  // first\n  a: 1, // one\n  b: [\n    2, // two\n    3,\n  ], // three\n  // end\n};\nputs(h, [4, 5]);\n",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parseProgramForTest(t, tt.input)
+
+		actual := ast.Format(program)
+		if actual != tt.expected {
+			t.Errorf("format wrong.\nexpected=%q\ngot=%q", tt.expected, actual)
+		}
+
+		// 整形した結果をパースし直すと同じASTになり、もう一度整形しても変わらない
+		reparsed := parseProgramForTest(t, actual)
+		if !ast.Equal(program, reparsed) {
+			t.Errorf("reparsed program differs.\nexpected=%s\ngot=%s", ast.Sexpr(program), ast.Sexpr(reparsed))
+		}
+		if again := ast.Format(reparsed); again != actual {
+			t.Errorf("formatting is not stable.\nexpected=%q\ngot=%q", actual, again)
+		}
+	}
+}
+
+func TestSexpr(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1 + 2 * 3;", "(let x (+ 1 (* 2 3)))"},
+		{"-a * b", "(* (- a) b)"},
+		{"a + b - c", "(- (+ a b) c)"},
+		{"a = b = c", "(= a (= b c))"},
+		{"p.x = 1", "(= (. p x) 1)"},
+		{`add(1, "two", [3, ...xs])[0]`, `(index (call add 1 "two" (array 3 (... xs))) 0)`},
+		{"if (x < y) { return x; } else { y }", "(if (< x y) (block (return x)) (block y))"},
+		{"fn(a, ...b) { a }", "(fn (a (... b)) (block a))"},
+		{"fn f() { null }", "(let f (fn f () (block null)))"},
+		{"h?.a?.[1] ?? true", "(?? (?.index (?. h a) 1) true)"},
+		{"m.f(1)", "(call (. m f) 1)"},
+		{`{"a": 1, b: 2}`, `(hash ("a" 1) ("b" 2))`},
+		{"let [a, ...b] = xs; let {c} = h", "(let (array-pattern a (... b)) xs)\n(let (hash-pattern c) h)"},
+		{"match (x) { 1 => 2, _ => { 3 } }", "(match x (=> 1 2) (=> _ (block 3)))"},
+		{"match (x) { [a] if a => a }", "(match x (=> (array a) (if a) a))"},
+		{"try { throw 1; } catch (e) { e }", "(try (block (throw 1)) e (block e))"},
+		{"for (x in xs) { puts(x) }", "(for x xs (block (call puts x)))"},
+		{"class P(x) { fn get() { self.x } }", "(class P (x) ((fn get () (block (. self x)))))"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgramForTest(t, tt.input)
+
+		actual := ast.Sexpr(program)
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// 足し算
+let add = fn(x, y) { // 二つの引数
+  // 足して返す
+  x + y;
+};
+
+add(1, 2); // 呼び出し
+// おわり`
+
+	program := parseProgramForTest(t, input)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	expected := []struct {
+		text string
+		line int
+	}{
+		{"// 足し算", 1},
+		{"// 二つの引数", 2},
+		{"// 足して返す", 3},
+		{"// 呼び出し", 7},
+		{"// おわり", 8},
+	}
+
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expected), len(program.Comments))
+	}
+	for i, e := range expected {
+		c := program.Comments[i]
+		if c.Text != e.text || c.Pos().Line != e.line {
+			t.Errorf("comments[%d] wrong. expected=%q at line %d, got=%q at line %d",
+				i, e.text, e.line, c.Text, c.Pos().Line)
+		}
+	}
+
+	formatted := ast.Format(program)
+	expectedFormat := `// 足し算
+let add = fn(x, y) {
+  // 二つの引数
+  // 足して返す
+  x + y;
+};
+
+add(1, 2); // 呼び出し
+// おわり
+`
+	if formatted != expectedFormat {
+		t.Errorf("format wrong.\nexpected=%q\ngot=%q", expectedFormat, formatted)
+	}
+
+	// 整形し直してもコメントは消えない
+	if again := ast.Format(parseProgramForTest(t, formatted)); again != formatted {
+		t.Errorf("formatting is not stable.\nexpected=%q\ngot=%q", formatted, again)
+	}
+}
+
+func TestCommentsInBlocks(t *testing.T) {
+	input := `if (x) {
+  // まだ何もしない
+} else {
+  1 // いち
+  // 最後
+}`
+
+	expected := `if (x) {
+  // まだ何もしない
+} else {
+  1; // いち
+  // 最後
+}
+`
+
+	program := parseProgramForTest(t, input)
+	if formatted := ast.Format(program); formatted != expected {
+		t.Errorf("format wrong.\nexpected=%q\ngot=%q", expected, formatted)
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+		sexpr    string
+	}{
+		{"3.14;", 3.14, "3.14"},
+		{"0.5", 0.5, "0.5"},
+		{"2.0", 2, "2.0"},
+	}
+
+	for _, tt := range tests {
+		program := parseProgramForTest(t, tt.input)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+		if got := ast.Sexpr(program); got != tt.sexpr {
+			t.Errorf("sexpr wrong. expected=%q, got=%q", tt.sexpr, got)
+		}
+	}
+
+	program := parseProgramForTest(t, "1 + 2.5 * -0.5")
+	if got := ast.Sexpr(program); got != "(+ 1 (* 2.5 (- 0.5)))" {
+		t.Errorf("sexpr wrong. got=%q", got)
+	}
+}
+
+func parseProgramForTest(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	return program
 }
 
 func checkParserErrors(t *testing.T, p *Parser) {
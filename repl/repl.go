@@ -2,28 +2,66 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"monkey/ast"
+	"monkey/engine"
 	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"os/signal"
+	"strings"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// trueにすると、評価結果をInspectではなくJSONで出力する。エラーやJSONにできない値はInspectのまま出力する。
+var OutputJSON = false
+
+// 入力を実行するエンジンの名前。engine.Evalかengine.VM。
+var Engine = engine.Eval
+
+// trueにすると、評価結果とエラーを色付きで出力する。putsなどのスクリプトの出力には色を付けない。
+var Color = false
+
+// 入力を評価するときのContextの元。evaluator.WithStrictConditionsなどで評価の仕方を設定するのに使う。
+var Context = context.Background()
+
+const (
+	resultColor = "\x1b[36m" // シアン
+	errorColor  = "\x1b[31m" // 赤
+	resetColor  = "\x1b[0m"
+)
+
+// 入力が終わるまで一行ずつ評価して結果を出力する。
+// exit(code)が呼ばれた場合はそこで終了し、codeを返す。入力が終わった場合は0を返す。
+func Start(in io.Reader, out io.Writer) int {
+	reader := bufio.NewReader(in)
 	env := object.NewEnvironment()
+	env.SetOutput(out)
+	// input()で読む行をREPLが先に読んでしまわないように、同じReaderを使わせる
+	env.SetInput(reader)
+	eng, err := engine.New(Engine, env)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
 
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return 0
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if runCommand(out, line, env) {
+			continue
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line)
 		p := parser.New(l)
 
@@ -36,14 +74,120 @@ func Start(in io.Reader, out io.Writer) {
 		//io.WriteString(out, program.String())
 		//io.WriteString(out, "\n")
 
-		evaluated := evaluator.Eval(program, env)
+		evaluated := evalInterruptible(eng, program)
+		if exit, ok := evaluated.(*object.Exit); ok {
+			return int(exit.Code)
+		}
 		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
+			color := resultColor
+			if evaluated.Type() == object.ERROR_OBJ || evaluated.Type() == object.EXCEPTION_OBJ {
+				color = errorColor
+			}
+			io.WriteString(out, colorize(inspect(evaluated), color))
 			io.WriteString(out, "\n")
 		}
 	}
 }
 
+// Ctrl-Cで評価中のプログラムを中断できるように、評価している間だけSIGINTを受け取ってContextをキャンセルする。
+// 評価していない間のCtrl-Cは、これまで通りREPLを終了させる。
+func evalInterruptible(eng engine.Engine, program *ast.Program) object.Object {
+	ctx, cancel := context.WithCancel(Context)
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return eng.Run(ctx, program)
+}
+
+// 「:save ファイル名」で現在の変数をファイルに保存し、「:load ファイル名」で読み込む。
+// 「:help」で組み込み関数の一覧を、「:help 名前」でその組み込み関数の使い方を表示する。
+// コマンドとして処理した場合はtrueを返す。
+func runCommand(out io.Writer, line string, env *object.Environment) bool {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == ":help" {
+		runHelp(out, fields[1:], env)
+		return true
+	}
+	if len(fields) == 0 || (fields[0] != ":save" && fields[0] != ":load") {
+		return false
+	}
+	if len(fields) != 2 {
+		fmt.Fprintf(out, "usage: %s <file>\n", fields[0])
+		return true
+	}
+	// VMでは変数がenvではなくVMのグローバル変数に束縛されるので、保存できない
+	if Engine != engine.Eval {
+		fmt.Fprintf(out, "%s is not supported by the %s engine\n", fields[0], Engine)
+		return true
+	}
+
+	switch fields[0] {
+	case ":save":
+		data, skipped, err := env.Snapshot()
+		if err == nil {
+			err = ioutil.WriteFile(fields[1], data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "cannot save session: %s\n", err)
+			return true
+		}
+		if len(skipped) > 0 {
+			fmt.Fprintf(out, "not saved: %s\n", strings.Join(skipped, ", "))
+		}
+	case ":load":
+		data, err := ioutil.ReadFile(fields[1])
+		if err == nil {
+			err = env.Restore(data)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "cannot load session: %s\n", err)
+		}
+	}
+	return true
+}
+
+func runHelp(out io.Writer, names []string, env *object.Environment) {
+	if len(names) == 0 {
+		io.WriteString(out, evaluator.HelpText(env))
+		return
+	}
+	for _, name := range names {
+		text, ok := evaluator.DescribeBuiltin(env, name)
+		if !ok {
+			fmt.Fprintf(out, "no builtin named %s\n", name)
+			continue
+		}
+		fmt.Fprintln(out, text)
+	}
+}
+
+// Colorがtrueなら、sをcolorで囲む。
+func colorize(s, color string) string {
+	if !Color {
+		return s
+	}
+	return color + s + resetColor
+}
+
+func inspect(obj object.Object) string {
+	if OutputJSON && obj.Type() != object.ERROR_OBJ {
+		if data, err := object.ToJSON(obj); err == nil {
+			return string(data)
+		}
+	}
+	return obj.Inspect()
+}
+
 const MONKEY_FACE = `            __,__
    .--.  .-"     "-.  .--.
   / .. \/  .-. .-.  \/ .. \
@@ -62,6 +206,6 @@ func printParserErrors(out io.Writer, errors []string) {
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
 	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+		io.WriteString(out, "\t"+colorize(msg, errorColor)+"\n")
 	}
 }
@@ -0,0 +1,143 @@
+package resolver
+
+import "monkey/ast"
+
+// クラスのメソッドは、selfだけを束縛したスコープの内側で呼び出される。
+// 評価器はこの名前の並びでselfのスコープを作る。
+var SelfScope = []string{"self"}
+
+// 関数一つ分のスコープ。束縛される変数の名前を、slotの順番に持つ。
+type scope struct {
+	names []string
+	slots map[string]int
+}
+
+func newScope(names ...string) *scope {
+	s := &scope{slots: make(map[string]int)}
+	for _, name := range names {
+		s.declare(name)
+	}
+	return s
+}
+
+func (s *scope) declare(name string) {
+	if _, ok := s.slots[name]; ok {
+		return
+	}
+	s.slots[name] = len(s.names)
+	s.names = append(s.names, name)
+}
+
+type resolver struct {
+	scopes []*scope // 内側のスコープほど後ろに積まれる
+}
+
+// プログラム中の識別子が、どの関数のスコープの何番目の変数を指すかを決め、ast.Identifier.Bindingに書き込む。
+// 関数リテラルには、そのスコープに束縛される変数の名前をast.FunctionLiteral.Slotsに書き込む。
+// 評価器はこれを使って、ローカル変数をスコープを名前で辿らずにスライスから取り出す。
+//
+// スコープを作るのは関数だけで、ブロックやfor、try、matchのアームはそれを囲む関数のスコープに束縛する。
+// どの関数のスコープにも宣言されていない変数（グローバル変数や組み込み関数）は解決しないので、Bindingはnilのまま。
+func Resolve(program *ast.Program) {
+	r := &resolver{}
+	for _, stmt := range program.Statements {
+		r.resolve(stmt)
+	}
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Identifier:
+			n.Binding = r.lookup(n.Value)
+		case *ast.FunctionLiteral:
+			r.resolveFunction(n)
+			return false
+		case *ast.ClassStatement:
+			n.Name.Binding = r.lookup(n.Name.Value)
+			// フィールドは変数ではないので解決しない
+			r.scopes = append(r.scopes, newScope(SelfScope...))
+			for _, m := range n.Methods {
+				r.resolveFunction(m)
+			}
+			r.scopes = r.scopes[:len(r.scopes)-1]
+			return false
+		}
+		return true
+	})
+}
+
+func (r *resolver) resolveFunction(fl *ast.FunctionLiteral) {
+	s := newScope()
+	for _, p := range fl.Parameters {
+		s.declare(p.Value)
+	}
+	if fl.Rest != nil {
+		s.declare(fl.Rest.Value)
+	}
+	// 関数の中で後から宣言される変数も、先に宣言しておく。
+	// 宣言より前に参照された場合は、評価器が名前で外側のスコープを探す。
+	declareLocals(s, fl.Body)
+	fl.Slots = s.names
+
+	r.scopes = append(r.scopes, s)
+	for _, p := range fl.Parameters {
+		r.resolve(p)
+	}
+	if fl.Rest != nil {
+		r.resolve(fl.Rest)
+	}
+	r.resolve(fl.Body)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// 関数の本体で束縛される変数を集める。内側の関数とクラスのメソッドは別のスコープなので辿らない。
+func declareLocals(s *scope, body *ast.BlockStatement) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			s.declare(n.Name.Value)
+		case *ast.ConstStatement:
+			s.declare(n.Name.Value)
+		case *ast.ClassStatement:
+			s.declare(n.Name.Value)
+			return false
+		case *ast.ForInStatement:
+			s.declare(n.Variable.Value)
+		case *ast.TryExpression:
+			if n.Parameter != nil {
+				s.declare(n.Parameter.Value)
+			}
+		case *ast.MatchExpression:
+			for _, arm := range n.Arms {
+				for _, ident := range arm.Bindings() {
+					s.declare(ident.Value)
+				}
+			}
+		case *ast.ArrayPattern:
+			for _, el := range n.Elements {
+				s.declare(el.Value)
+			}
+			if n.Rest != nil {
+				s.declare(n.Rest.Value)
+			}
+		case *ast.HashPattern:
+			for _, k := range n.Keys {
+				s.declare(k.Value)
+			}
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+// 内側のスコープから順に変数を探す。どのスコープにもなければnil。
+func (r *resolver) lookup(name string) *ast.Binding {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if slot, ok := r.scopes[i].slots[name]; ok {
+			return &ast.Binding{Depth: len(r.scopes) - 1 - i, Slot: slot}
+		}
+	}
+	return nil
+}
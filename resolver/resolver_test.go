@@ -0,0 +1,81 @@
+package resolver_test
+
+// parserがresolverを使うので、このテストは外部のテストパッケージにしている。
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // ソースコード上の順番で、識別子とその場所(depth/slot)を並べたもの。解決されない場合は -
+	}{
+		{"let a = 1; a;", "a:- a:-"},
+		{"fn(x, y) { x + y }", "x:0/0 y:0/1 x:0/0 y:0/1"},
+		{"fn(x) { fn(y) { x + y + z } }", "x:0/0 y:0/0 x:1/0 y:0/0 z:-"},
+		{"fn(...rest) { let n = len(rest); n }", "rest:0/0 n:0/1 len:- rest:0/0 n:0/1"},
+		// 後から宣言される変数も、関数のスコープのslotになる
+		{"fn() { f(); fn f() { 1 } }", "f:0/0 f:0/0"},
+		// ブロック、for、try、分割代入はそれを囲む関数のスコープに束縛する
+		{"fn(xs) { for (x in xs) { let y = x; } y }", "xs:0/0 x:0/1 xs:0/0 y:0/2 x:0/1 y:0/2"},
+		{"fn() { try { 1 } catch (e) { e } }", "e:0/0 e:0/0"},
+		{"fn() { let [a, ...b] = c; let {d} = b; }", "a:0/0 b:0/1 c:- d:0/2 b:0/1"},
+		// メソッドの外側にはselfだけのスコープがある
+		{"fn(n) { class P(x) { fn get(k) { self.x + k + n } } }",
+			"n:0/0 P:0/1 x:- k:0/0 self:1/0 x:- k:0/0 n:2/0"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		if got := bindings(program); got != tt.expected {
+			t.Errorf("bindings of %q wrong.\nexpected=%q\ngot=     %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestResolveSlots(t *testing.T) {
+	p := parser.New(lexer.New("fn(a, b) { let c = a; if (b) { let d = c; } fn() { let e = 1; } match (a) { [x, ...r] if x > 0 => x, {k: [y, _]} => y } }"))
+	program := p.ParseProgram()
+
+	var slots []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		if fl, ok := n.(*ast.FunctionLiteral); ok {
+			slots = append(slots, strings.Join(fl.Slots, ","))
+		}
+		return true
+	})
+
+	expected := []string{"a,b,c,d,x,r,y", "e"}
+	if fmt.Sprint(slots) != fmt.Sprint(expected) {
+		t.Errorf("slots wrong. expected=%v, got=%v", expected, slots)
+	}
+}
+
+func bindings(program *ast.Program) string {
+	var out []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Identifier)
+		if !ok {
+			return true
+		}
+		if ident.Binding == nil {
+			out = append(out, ident.Value+":-")
+		} else {
+			out = append(out, fmt.Sprintf("%s:%d/%d", ident.Value, ident.Binding.Depth, ident.Binding.Slot))
+		}
+		return true
+	})
+	return strings.Join(out, " ")
+}
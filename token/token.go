@@ -1,14 +1,18 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // // から行末まで
 
 	// Identifiers + literals
 	IDENT  = "IDENT"  // add, foobar, x, y, ...
 	INT    = "INT"    // 1343456
+	FLOAT  = "FLOAT"  // 3.14
 	STRING = "STRING" // "foobar"
 
 	// Operators
@@ -18,6 +22,7 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 
 	LT = "<"
 	GT = ">"
@@ -25,6 +30,14 @@ const (
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	ARROW = "=>"
+
+	QUESTION_DOT = "?."
+	NULLISH      = "??"
+
+	ELLIPSIS = "..."
+	DOT      = "."
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -40,26 +53,64 @@ const (
 	// Keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	NULL     = "NULL"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	FOR      = "FOR"
+	IN       = "IN"
+	MATCH    = "MATCH"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	THROW    = "THROW"
+	CLASS    = "CLASS"
 )
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int      // トークンの先頭の文字の行番号（1始まり）
+	Column  int      // トークンの先頭の文字の列番号（1始まり）
+	Offset  int      // トークンの先頭の文字の入力全体でのバイト位置（0始まり）
+	End     Position // トークンの直後の位置。 "foo" のような文字列の場合は閉じの " の次になる
+}
+
+// ソースコード上の位置。
+type Position struct {
+	Offset int // 入力全体でのバイト位置（0始まり）
+	Line   int // 行番号（1始まり）
+	Column int // 列番号（1始まり）
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// トークンの先頭の位置
+func (t Token) Pos() Position {
+	return Position{Offset: t.Offset, Line: t.Line, Column: t.Column}
 }
 
 var keywords = map[string]TokenType{
 	"fn":     FUNCTION,
 	"let":    LET,
+	"const":  CONST,
 	"true":   TRUE,
 	"false":  FALSE,
+	"null":   NULL,
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"for":    FOR,
+	"in":     IN,
+	"match":  MATCH,
+	"try":    TRY,
+	"catch":  CATCH,
+	"throw":  THROW,
+	"class":  CLASS,
 }
 
 func LookupIdent(ident string) TokenType {
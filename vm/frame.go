@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+)
+
+// 実行中の関数呼び出し一つ分。
+type Frame struct {
+	cl           *object.Closure // トップレベルのフレームではnil
+	instructions code.Instructions
+	ip           int            // 次に実行する命令の位置
+	locals       *object.Locals // この呼び出しのローカル変数。トップレベルのフレームではnil
+	basePointer  int            // 呼び出された関数が置かれていたスタックの位置。戻るときにここまでスタックを戻す
+	sources      code.SourceMap // 命令のソースコード上の位置
+	handlers     []handler      // 実行中のtryのハンドラ。内側のtryほど後ろに積まれる
+}
+
+// OpTryで積むtryのハンドラ。
+type handler struct {
+	catch int // catchのブロックの位置
+	sp    int // OpTryを実行したときのスタックの位置。catchするときにここまでスタックを戻す
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:           cl,
+		instructions: cl.Fn.Instructions,
+		locals:       &object.Locals{Values: make([]object.Object, cl.Fn.NumLocals), Names: cl.Fn.Locals, Outer: cl.Outer},
+		basePointer:  basePointer,
+		sources:      cl.Fn.Sources,
+	}
+}
+
+// depth個外側の関数のローカル変数。
+func (f *Frame) scopeAt(depth int) *object.Locals {
+	scope := f.locals
+	for i := 0; i < depth && scope != nil; i++ {
+		scope = scope.Outer
+	}
+	return scope
+}
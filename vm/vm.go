@@ -0,0 +1,699 @@
+package vm
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// 値のスタックに積める値の数。
+const StackSize = 1 << 16
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+)
+
+// compilerが作ったバイトコードを実行するスタックマシン。
+// 演算や組み込み関数の呼び出しは評価器と同じ関数を使うので、評価器と同じ結果、同じエラーになる。
+type VM struct {
+	constants    []object.Object
+	instructions code.Instructions
+	sources      code.SourceMap // トップレベルの命令のソースコード上の位置
+
+	globals     []object.Object
+	globalNames []string // エラーメッセージに使うグローバル変数の名前
+
+	env *object.Environment // 組み込み関数に渡す環境。組み込み関数の探索と出力先、Contextに使う
+
+	stack []object.Object
+	sp    int // 次に積む位置。スタックの一番上は stack[sp-1]
+
+	frames []*Frame
+
+	last object.Object // トップレベルで最後に式文の値として捨てた値。プログラムの値になる
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, nil)
+}
+
+// 前回の実行のグローバル変数を引き継いで実行する。REPLで一行ずつ実行するのに使う。
+// globalsにはGlobalsで取り出したものを渡す。
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	if len(globals) < len(bytecode.Globals) {
+		globals = append(globals, make([]object.Object, len(bytecode.Globals)-len(globals))...)
+	}
+	return &VM{
+		constants:    bytecode.Constants,
+		instructions: bytecode.Instructions,
+		sources:      bytecode.Sources,
+		globals:      globals,
+		globalNames:  bytecode.Globals,
+		env:          object.NewEnvironment(),
+		stack:        make([]object.Object, StackSize),
+	}
+}
+
+// 組み込み関数に渡す環境を設定する。SetBuiltinsやSetOutputをした環境を渡すと、評価器と同じように使われる。
+func (vm *VM) SetEnvironment(env *object.Environment) {
+	vm.env = env
+}
+
+// グローバル変数の値。番号はBytecode.Globalsの順。
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// プログラムを実行し、評価器のEvalと同じくプログラムの値を返す。
+// 最後の文の値か、トップレベルのreturnの値、実行を止めたエラーのどれか。最後の文がletなどで値がなければnil。
+func (vm *VM) Run() object.Object {
+	vm.sp = 0
+	vm.last = nil
+	vm.frames = []*Frame{{instructions: vm.instructions, sources: vm.sources}}
+
+	result := vm.run(0)
+	// 最後までcatchされなかった例外はエラーとしてプログラムを終了させる。
+	if exception, ok := result.(*object.Exception); ok {
+		return evaluator.Uncaught(exception)
+	}
+	return result
+}
+
+// mapなどの組み込み関数からクロージャを呼び出す。実行中のスタックの上で、そのクロージャから戻るまで実行する。
+// 前の実行で作ったクロージャも、呼び出し元のenvのContextで実行する。
+func (vm *VM) RunClosure(env *object.Environment, cl *object.Closure, args []object.Object) object.Object {
+	prev := vm.env
+	vm.env = env
+	defer func() { vm.env = prev }()
+
+	depth := len(vm.frames)
+	if err := vm.pushFrame(cl, args, vm.sp); err != nil {
+		return err
+	}
+	return vm.run(depth)
+}
+
+// フレームの数がdepthに戻るまで実行し、最後に戻った関数の値を返す。
+// エラーになった場合は、depthより上のフレームのtryでcatchされなければdepthまでのフレームを捨てて、エラーを返す。
+func (vm *VM) run(depth int) object.Object {
+	for {
+		frame := vm.frames[len(vm.frames)-1]
+		if frame.ip >= len(frame.instructions) {
+			// トップレベルの命令を最後まで実行した。関数は必ずOpReturnValueかOpReturnで終わる
+			vm.frames = vm.frames[:depth]
+			return vm.last
+		}
+
+		ip := frame.ip
+		ins := frame.instructions
+		op := code.Opcode(ins[ip])
+		frame.ip++
+
+		var err object.Object
+		switch op {
+		case code.OpConstant:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.push(vm.constants[idx])
+
+		case code.OpPop:
+			val := vm.pop()
+			if frame.cl == nil {
+				vm.last = val
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Allocated(vm.env, evaluator.Infix(vm.env, infixOperators[op], left, right)))
+
+		case code.OpInfix:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			right := vm.pop()
+			left := vm.pop()
+			operator := vm.constants[idx].(*object.String).Value
+			err = vm.pushResult(evaluator.Allocated(vm.env, evaluator.Infix(vm.env, operator, left, right)))
+
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.Prefix(vm.env, "-", vm.pop()))
+
+		case code.OpBang:
+			err = vm.pushResult(evaluator.Prefix(vm.env, "!", vm.pop()))
+
+		case code.OpTrue:
+			err = vm.push(TRUE)
+
+		case code.OpFalse:
+			err = vm.push(FALSE)
+
+		case code.OpNull:
+			err = vm.push(NULL)
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[ip+1:]))
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			truthy, cerr := evaluator.Condition(vm.env, vm.pop())
+			if cerr != nil {
+				err = cerr
+			} else if !truthy {
+				frame.ip = pos
+			}
+
+		case code.OpJumpNotNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if vm.stack[vm.sp-1] != NULL {
+				frame.ip = pos
+			} else {
+				vm.pop()
+			}
+
+		case code.OpJumpNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if vm.stack[vm.sp-1] == NULL {
+				frame.ip = pos
+			}
+
+		case code.OpGetGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			val := vm.globals[idx]
+			if val == nil {
+				// まだ束縛されていなければ、評価器と同じく組み込み関数を探す
+				err = vm.pushBuiltin(vm.globalNames[idx])
+				break
+			}
+			err = vm.push(val)
+
+		case code.OpSetGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.globals[idx] = vm.pop()
+			// 評価器と同じく、letの文には値がない
+			if frame.cl == nil {
+				vm.last = nil
+			}
+
+		case code.OpGetLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			val := frame.locals.Values[idx]
+			if val == nil {
+				err = vm.pushUnbound(frame.locals, frame.locals.Names[idx])
+				break
+			}
+			err = vm.push(val)
+
+		case code.OpSetLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			frame.locals.Values[idx] = vm.pop()
+
+		case code.OpGetFree:
+			depth := int(code.ReadUint8(ins[ip+1:]))
+			idx := int(code.ReadUint8(ins[ip+2:]))
+			frame.ip += 2
+			scope := frame.scopeAt(depth)
+			val := scope.Values[idx]
+			if val == nil {
+				err = vm.pushUnbound(scope, scope.Names[idx])
+				break
+			}
+			err = vm.push(val)
+
+		case code.OpSetFree:
+			depth := int(code.ReadUint8(ins[ip+1:]))
+			idx := int(code.ReadUint8(ins[ip+2:]))
+			frame.ip += 2
+			frame.scopeAt(depth).Values[idx] = vm.pop()
+
+		case code.OpGetBuiltin:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.pushBuiltin(vm.constants[idx].(*object.String).Value)
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			err = vm.buildArray(numElements)
+
+		case code.OpSpreadArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if numElements, err = vm.expandSpread(numElements); err == nil {
+				err = vm.buildArray(numElements)
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			hash, herr := vm.buildHash(vm.sp-numElements, vm.sp)
+			vm.sp -= numElements
+			if herr != nil {
+				err = herr
+				break
+			}
+			err = vm.pushResult(evaluator.Literal(vm.env, evaluator.Allocated(vm.env, hash)))
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.Index(left, index))
+
+		case code.OpGetProperty:
+			idx := code.ReadUint16(ins[ip+1:])
+			optional := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+			left := vm.pop()
+			if optional && left == NULL {
+				err = vm.push(NULL)
+				break
+			}
+			err = vm.pushResult(evaluator.Property(vm.env, left, vm.constants[idx].(*object.String).Value))
+
+		case code.OpSetProperty:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			val := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.SetProperty(left, vm.constants[idx].(*object.String).Value, val))
+
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			err = vm.callValue(numArgs)
+
+		case code.OpSpreadCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			if numArgs, err = vm.expandSpread(numArgs); err == nil {
+				err = vm.callValue(numArgs)
+			}
+
+		case code.OpSpread:
+			elements, serr := evaluator.Spread(vm.pop())
+			if serr != nil {
+				err = serr
+				break
+			}
+			err = vm.push(&spread{elements})
+
+		case code.OpReturnValue, code.OpReturn:
+			var returnValue object.Object = NULL
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
+			}
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.sp = frame.basePointer
+			if len(vm.frames) == depth {
+				return returnValue
+			}
+			err = vm.push(returnValue)
+
+		case code.OpClosure:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			fn := vm.constants[idx].(*object.CompiledFunction)
+			err = vm.push(&object.Closure{Fn: fn, Outer: frame.locals, Runner: vm})
+
+		case code.OpGetIter:
+			val := vm.pop()
+			iterable, ok := val.(object.Iterable)
+			if !ok {
+				err = newError("not iterable: %s", val.Type())
+				break
+			}
+			err = vm.push(&iterator{iterable.Iterator()})
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			element, ok := vm.stack[vm.sp-1].(*iterator).Next()
+			if !ok {
+				vm.pop()
+				frame.ip = pos
+				break
+			}
+			// 一周ごとに、Contextがキャンセルされていないか、WithStepLimitの上限を超えていないか調べる
+			if err = evaluator.Step(vm.env); err != nil {
+				break
+			}
+			err = vm.push(element)
+
+		case code.OpThrow:
+			err = &object.Exception{Value: vm.pop(), Pos: frame.sources.Lookup(ip).Pos}
+
+		case code.OpTry:
+			catch := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			frame.handlers = append(frame.handlers, handler{catch: catch, sp: vm.sp})
+
+		case code.OpEndTry:
+			frame.handlers = frame.handlers[:len(frame.handlers)-1]
+
+		case code.OpDestructureArray:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+			values, derr := evaluator.DestructureArray(vm.env, vm.pop(), n, rest)
+			if derr != nil {
+				err = derr
+				break
+			}
+			err = vm.pushReversed(values)
+
+		case code.OpDestructureHash:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			keys := make([]string, n)
+			for i := range keys {
+				keys[i] = vm.stack[vm.sp-n+i].(*object.String).Value
+			}
+			vm.sp -= n
+			values, derr := evaluator.DestructureHash(vm.pop(), keys)
+			if derr != nil {
+				err = derr
+				break
+			}
+			err = vm.pushReversed(values)
+
+		case code.OpMatchArray:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			fail := int(code.ReadUint16(ins[ip+4:]))
+			frame.ip += 5
+			values, ok := evaluator.MatchArray(vm.env, vm.pop(), n, rest)
+			if !ok {
+				frame.ip = fail
+				break
+			}
+			err = vm.pushReversed(values)
+
+		case code.OpMatchHash:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			fail := int(code.ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+			keys := make([]object.Object, n)
+			copy(keys, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			values, ok, merr := evaluator.MatchHash(vm.pop(), keys)
+			if merr != nil {
+				err = merr
+				break
+			}
+			if !ok {
+				frame.ip = fail
+				break
+			}
+			err = vm.pushReversed(values)
+
+		case code.OpMatchValue:
+			fail := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			expected := vm.pop()
+			if !evaluator.Matches(vm.pop(), expected) {
+				frame.ip = fail
+			}
+
+		case code.OpClass:
+			idx := code.ReadUint16(ins[ip+1:])
+			numFields := int(code.ReadUint8(ins[ip+3:]))
+			numMethods := int(code.ReadUint8(ins[ip+4:]))
+			frame.ip += 4
+			err = vm.push(vm.buildClass(vm.constants[idx].(*object.String).Value, numFields, numMethods))
+
+		default:
+			err = newError("unknown opcode: %d", op)
+		}
+
+		if err != nil {
+			if err = vm.throw(err, ip, depth); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpMod:         "%",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// 実行中のフレームのipの位置の命令で起きたエラーか例外errを、呼び出し元へ伝える。
+// 評価器と同じく、エラーにはエラーの位置と、エラーの起きた関数の名前、呼び出しの履歴を付ける。
+// 呼び出し元をdepthのフレームまでさかのぼる間にtryのハンドラがあれば、そのcatchへ移ってnilを返す。
+// なければdepthまでのフレームを捨てて、errを返す。
+func (vm *VM) throw(err object.Object, ip, depth int) object.Object {
+	e, _ := err.(*object.Error)
+	if e != nil {
+		frame := vm.frames[len(vm.frames)-1]
+		source := frame.sources.Lookup(ip)
+		if e.Pos.Line == 0 {
+			e.Pos = source.Pos
+		}
+		if e.Function == "" && frame.cl != nil {
+			e.Function = closureName(frame.cl)
+		}
+		// 呼び出しそのものがエラーになった場合も、評価器と同じく呼び出した関数を履歴に積む
+		if source.Offset == ip && source.Call != "" {
+			e.Stack = append(e.Stack, object.StackFrame{Function: source.Call, Pos: source.Pos})
+		}
+	}
+
+	for {
+		frame := vm.frames[len(vm.frames)-1]
+		if n := len(frame.handlers); n > 0 {
+			if value, ok := evaluator.Caught(vm.env, err); ok {
+				h := frame.handlers[n-1]
+				frame.handlers = frame.handlers[:n-1]
+				frame.ip = h.catch
+				vm.sp = h.sp
+				return vm.push(value)
+			}
+		}
+		if len(vm.frames)-1 == depth {
+			break
+		}
+
+		vm.frames = vm.frames[:len(vm.frames)-1]
+		if e != nil {
+			// 呼び出し元のフレームは、呼び出しの命令の次の位置で止まっている
+			caller := vm.frames[len(vm.frames)-1]
+			source := caller.sources.Lookup(caller.ip - 1)
+			e.Stack = append(e.Stack, object.StackFrame{Function: source.Call, Pos: source.Pos})
+		}
+	}
+
+	vm.sp = vm.frames[depth].basePointer
+	vm.frames = vm.frames[:depth]
+	return err
+}
+
+func closureName(cl *object.Closure) string {
+	if cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return cl.Fn.Name
+}
+
+// スタックの一番上にあるnumArgs個の引数と、その下の関数を取り出して呼び出す。
+// このVMのクロージャならフレームを積み、それ以外の関数は評価器に呼び出してもらう。
+func (vm *VM) callValue(numArgs int) object.Object {
+	basePointer := vm.sp - numArgs - 1
+	callee := vm.stack[basePointer]
+	// 呼び出しのフックがあれば、フックを呼べるようにクロージャもevaluator.Applyで呼び出す
+	if !evaluator.HasCallHooks(vm.env) {
+		cl, ok := callee.(*object.Closure)
+		// クラスのメソッドは、selfを束縛したスコープを外側に持つクロージャにして呼び出す
+		if bm, isMethod := callee.(*object.BoundMethod); isMethod {
+			if method, isClosure := bm.Method.(*object.Closure); isClosure {
+				cl, ok = evaluator.MethodClosure(method, bm.Receiver), true
+			}
+		}
+		if ok && cl.Runner == vm {
+			return vm.pushFrame(cl, vm.stack[basePointer+1:vm.sp], basePointer)
+		}
+	}
+
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[basePointer+1:vm.sp])
+	vm.sp = basePointer
+	return vm.pushResult(evaluator.Apply(vm.env, callee, args))
+}
+
+// クロージャの呼び出しのフレームを積む。引数はフレームのローカル変数に移し、スタックはbasePointerまで戻す。
+func (vm *VM) pushFrame(cl *object.Closure, args []object.Object, basePointer int) object.Object {
+	fn := cl.Fn
+	// 評価器と同じく、引数が足りない場合はエラー。可変長引数のない関数に余分に渡された引数は無視する。
+	if len(args) < fn.NumParameters {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), fn.NumParameters)
+	}
+	// 評価器と同じく、Contextがキャンセルされているか、WithStepLimitの上限を超えていれば呼び出す前に中断する
+	if err := evaluator.Step(vm.env); err != nil {
+		return err
+	}
+	if len(vm.frames) > evaluator.MaxCallDepth {
+		return newError("stack overflow: maximum call depth of %d exceeded", evaluator.MaxCallDepth)
+	}
+
+	frame := NewFrame(cl, basePointer)
+	copy(frame.locals.Values, args[:fn.NumParameters])
+	if fn.Rest {
+		rest := make([]object.Object, len(args)-fn.NumParameters)
+		copy(rest, args[fn.NumParameters:])
+		frame.locals.Values[fn.NumParameters] = evaluator.Literal(vm.env, &object.Array{Elements: rest})
+	}
+
+	vm.sp = basePointer
+	vm.frames = append(vm.frames, frame)
+	return nil
+}
+
+// スタックの上からn個の要素を取り出し、配列を作って積む。
+func (vm *VM) buildArray(n int) object.Object {
+	elements := make([]object.Object, n)
+	copy(elements, vm.stack[vm.sp-n:vm.sp])
+	vm.sp -= n
+	return vm.pushResult(evaluator.Literal(vm.env, evaluator.Allocated(vm.env, &object.Array{Elements: elements})))
+}
+
+// スタックの上のn個の値のうち、OpSpreadで積んだ値を要素に展開して積み直し、展開した後の値の数を返す。
+func (vm *VM) expandSpread(n int) (int, object.Object) {
+	values := make([]object.Object, 0, n)
+	for _, v := range vm.stack[vm.sp-n : vm.sp] {
+		if s, ok := v.(*spread); ok {
+			values = append(values, s.elements...)
+		} else {
+			values = append(values, v)
+		}
+	}
+	vm.sp -= n
+	if len(values) > StackSize-vm.sp {
+		return 0, newError("stack overflow: too many values on the stack")
+	}
+	vm.sp += copy(vm.stack[vm.sp:], values)
+	return len(values), nil
+}
+
+// valuesを、最初の値が一番上になるように積む。
+func (vm *VM) pushReversed(values []object.Object) object.Object {
+	for i := len(values) - 1; i >= 0; i-- {
+		if err := vm.push(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// スタックの上からnumFields個のフィールド名と、numMethods個のメソッドの名前とクロージャの組を取り出して、クラスを作る。
+func (vm *VM) buildClass(name string, numFields, numMethods int) *object.Class {
+	class := &object.Class{Name: name, Methods: make(map[string]object.Object, numMethods)}
+	start := vm.sp - numFields - numMethods*2
+	for _, field := range vm.stack[start : start+numFields] {
+		class.Fields = append(class.Fields, field.(*object.String).Value)
+	}
+	for i := start + numFields; i < vm.sp; i += 2 {
+		class.Methods[vm.stack[i].(*object.String).Value] = vm.stack[i+1]
+	}
+	vm.sp = start
+	return class
+}
+
+// stack[start:end]にキー、値の順に並んだ要素からハッシュを作る。
+func (vm *VM) buildHash(start, end int) (object.Object, object.Object) {
+	hash := object.NewHash()
+	for i := start; i < end; i += 2 {
+		key := vm.stack[i]
+		if _, ok := key.(object.Hashable); !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+		hash.Set(key, vm.stack[i+1])
+	}
+	return hash, nil
+}
+
+// ローカル変数がまだletで束縛されていなければ、評価器と同じく名前で探し直す。
+// 関数の中で let b = a; let a = 2 と書いた場合、一つ目のaは外側のスコープのaになる。
+// scopeはその変数のあるスコープで、その外側のローカル変数、グローバル変数、組み込み関数の順に探す。
+func (vm *VM) pushUnbound(scope *object.Locals, name string) object.Object {
+	for outer := scope.Outer; outer != nil; outer = outer.Outer {
+		for i, n := range outer.Names {
+			if n == name && outer.Values[i] != nil {
+				return vm.push(outer.Values[i])
+			}
+		}
+	}
+	for i, n := range vm.globalNames {
+		if n == name && i < len(vm.globals) && vm.globals[i] != nil {
+			return vm.push(vm.globals[i])
+		}
+	}
+	return vm.pushBuiltin(name)
+}
+
+func (vm *VM) pushBuiltin(name string) object.Object {
+	if builtin, ok := evaluator.LookupBuiltin(vm.env, name); ok {
+		return vm.push(builtin)
+	}
+	return newError("identifier not found: %s", name)
+}
+
+func (vm *VM) push(o object.Object) object.Object {
+	if vm.sp >= StackSize {
+		return newError("stack overflow: too many values on the stack")
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+// 評価器の関数の結果を積む。エラーなら積まずにそのまま返す。
+func (vm *VM) pushResult(o object.Object) object.Object {
+	if evaluator.IsError(o) {
+		return o
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// for-inで回している途中のイテレータ。スタックに積めるように、Objectにする。
+type iterator struct {
+	object.Iterator
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// ...arr を展開する要素。OpSpreadで積み、OpSpreadArrayとOpSpreadCallが展開する。
+type spread struct {
+	elements []object.Object
+}
+
+func (s *spread) Type() object.ObjectType { return "SPREAD" }
+func (s *spread) Inspect() string         { return "spread" }
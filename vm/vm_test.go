@@ -0,0 +1,476 @@
+package vm
+
+import (
+	"bytes"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+// 評価器とVMで同じ結果になるかを調べる。expectedは評価器の結果のInspect。
+// エラーはメッセージだけを比べる。位置と呼び出しの履歴はTestErrorPositionsで調べる。
+type vmTestCase struct {
+	input    string
+	expected string
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", "1"},
+		{"1 + 2", "3"},
+		{"1 - 2", "-1"},
+		{"4 / 2", "2"},
+		{"50 / 2 * 2 + 10 - 5", "55"},
+		{"5 * (2 + 10)", "60"},
+		{"-5 + 10", "5"},
+		{"7 % 3", "1"},
+		{"-50 + 100 + -50", "0"},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"1.5 + 1", "2.5"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", "true"},
+		{"false", "false"},
+		{"1 < 2", "true"},
+		{"1 > 2", "false"},
+		{"1 == 1", "true"},
+		{"1 != 1", "false"},
+		{"true == false", "false"},
+		{"(1 < 2) == true", "true"},
+		{`"a" < "b"`, "true"},
+		{"!true", "false"},
+		{"!!5", "true"},
+		{"!null", "true"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", "10"},
+		{"if (true) { 10 } else { 20 }", "10"},
+		{"if (false) { 10 } else { 20 } ", "20"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", "20"},
+		{"if (true) { let x = 1; }", "null"},
+		{"null ?? 5", "5"},
+		{"3 ?? 5", "3"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", "1"},
+		{"let one = 1; let two = 2; one + two", "3"},
+		{"let one = 1; let two = one + one; one + two", "3"},
+		{"let x = 1; x = x + 1; x", "2"},
+		{"const x = 1; x", "1"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayAndHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", "[]"},
+		{"[1 + 2, 3 * 4, 5 + 6]", "[3, 12, 11]"},
+		{"{}", "{}"},
+		{"{1: 2, 2: 3}", "{1: 2, 2: 3}"},
+		{`{"b": 1 + 1, "a": 2 * 2}`, `{b: 2, a: 4}`},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", "2"},
+		{"[[1, 1, 1]][0][0]", "1"},
+		{"[][0]", "null"},
+		{"[1, 2, 3][99]", "null"},
+		{"{1: 1, 2: 2}[1]", "1"},
+		{"{1: 1}[0]", "null"},
+		{`{"a": {"b": 1}}.a.b`, "1"},
+		{"let h = null; h?.a", "null"},
+		{"let a = null; a?.[0]", "null"},
+		// 左辺がnullなら添字は評価しない
+		{"let n = 0; let f = fn() { n = n + 1; 0 }; let a = null; a?.[f()]; a?.[missing]; n", "0"},
+		{"let a = [5]; a?.[0]", "5"},
+		{`"abc".upper()`, "ABC"},
+		{`"hello".len()`, "5"},
+		{"[3, 1, 2].sort().map(fn(x) { x * 2 })", "[2, 4, 6]"},
+		{`{"a": 1, "b": 2}.keys()`, "[a, b]"},
+		{"let a = [1]; let b = a; push!(b, 2); [a, pop!(a), a]", "[[1], 2, [1]]"},
+		{"let a = [1, 2]; for (x in a) { push!(a, x * 10) }; a", "[1, 2, 10, 20]"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", "15"},
+		{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", "3"},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", "99"},
+		{"let noReturn = fn() { }; noReturn();", "null"},
+		{"let identity = fn(a) { a; }; identity(4);", "4"},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", "10"},
+		{"fn(a, b) { a }(1, 2, 3)", "1"},
+		{"fn(a, ...rest) { rest }(1, 2, 3)", "[2, 3]"},
+		{"fn add(a, b) { a + b }; add(1, 2)", "3"},
+		{"let f = fn() { if (true) { return 1; } 2 }; f()", "1"},
+		{"return 10; 9;", "10"},
+		// letより前では、同じ名前の外側の変数を参照する
+		{"let a = 1; let f = fn() { let b = a; let a = 2; b + a }; f();", "3"},
+		{"let a = 1; let f = fn() { let g = fn() { a }; let r = g(); let a = 2; [r, g()] }; f();", "[1, 2]"},
+		{"let f = fn(x) { fn() { let y = x; let x = 3; y + x } }; f(10)()", "13"},
+		{"let f = fn() { let l = len; let len = 1; l([1, 2]) + len }; f()", "3"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let newClosure = fn(a) { fn() { a; }; };
+			let closure = newClosure(99);
+			closure();`,
+			"99",
+		},
+		{
+			`let newAdder = fn(a, b) { fn(c) { a + b + c }; };
+			let adder = newAdder(1, 2);
+			adder(8);`,
+			"11",
+		},
+		{
+			`let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2)
+			let adder = newAdderInner(3);
+			adder(8);`,
+			"14",
+		},
+		{
+			// クロージャは外側の変数を値ではなく参照で持つ
+			`let counter = fn() {
+				let count = 0;
+				let inc = fn() { count = count + 1 };
+				inc(); inc();
+				count
+			};
+			counter();`,
+			"2",
+		},
+		{
+			`let fibonacci = fn(x) {
+				if (x == 0) { return 0; }
+				if (x == 1) { return 1; }
+				fibonacci(x - 1) + fibonacci(x - 2);
+			};
+			fibonacci(15);`,
+			"610",
+		},
+		{
+			`let wrapper = fn() {
+				let countDown = fn(x) { if (x == 0) { return 0; } countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();`,
+			"0",
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, "0"},
+		{`len("hello world")`, "11"},
+		{`len([1, 2, 3])`, "3"},
+		{`push([], 1)`, "[1]"},
+		{`first([1, 2, 3])`, "1"},
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, "[2, 4]"},
+		{`let base = 10; reduce([1, 2, 3], 0, fn(acc, x) { acc + x + base })`, "36"},
+		{`sort([3, 1, 2], fn(a, b) { a < b })`, "[1, 2, 3]"},
+		{`let len = fn(x) { 0 }; len([1])`, "0"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForIn(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum = sum + x }; sum", "6"},
+		{"for (x in [1, 2, 3]) { x }", "null"},
+		{"let f = fn() { for (x in range(10)) { if (x == 3) { return x } } }; f()", "3"},
+		{"let f = fn(arr) { let n = 0; for (x in arr) { n = n + x }; n }; f([4, 5])", "9"},
+		{"let last = 0; for (x in range(3)) { last = x }; x", "2"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true;", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"5 + true; 5;", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ERROR: unknown operator: -BOOLEAN"},
+		{`"Hello" - "World"`, "ERROR: unknown operator: STRING - STRING"},
+		{"foobar", "ERROR: identifier not found: foobar"},
+		{`{"name": "Monkey"}[fn(x) { x }];`, "ERROR: unusable as hash key: CLOSURE"},
+		{"let f = fn(a, b) { a + b }; f(1)", "ERROR: wrong number of arguments. got=1, want=2"},
+		{"1(2)", "ERROR: not a function: INTEGER"},
+		{"for (x in 1) { x }", "ERROR: not iterable: INTEGER"},
+		{`len(1)`, "ERROR: len: expected ARRAY or STRING or SET or HASH or RANGE, got INTEGER at argument 1"},
+		{"let f = fn() { 1 / 0 }; map([1], fn(x) { f() }); 2", "ERROR: division by zero"},
+	}
+
+	for _, tt := range tests {
+		result := runVM(t, tt.input)
+		err, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected an error. got=%T (%+v)", tt.input, result, result)
+			continue
+		}
+		if "ERROR: "+err.Message != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, err.Message)
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	tests := []vmTestCase{
+		{"class P(x, y) { fn sum() { self.x + self.y } }; let p = P(1, 2); p.sum()", "3"},
+		{"class C(n) { fn inc() { C(self.n + 1) } }; C(1).inc().inc().n", "3"},
+		{"class C(n) { fn get() { self.n } }; let g = C(5).get; g()", "5"},
+		{"class C(n) { fn add(m) { fn(k) { self.n + m + k } } }; C(1).add(2)(3)", "6"},
+		{"class C(n) {}; C(1, 2)", "ERROR: wrong number of arguments. got=2, want=1"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a + b", "3"},
+		{"let [a, ...rest] = [1, 2, 3]; rest", "[2, 3]"},
+		{`let {x, y} = {"x": 1, "y": 2}; x * 10 + y`, "12"},
+		{"let f = fn(p) { let [a, b] = p; a - b }; f([5, 3])", "2"},
+		{"let [a, b] = 5", "ERROR: cannot destructure INTEGER as ARRAY"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []vmTestCase{
+		{"match (2) { 1 => \"one\", 2 => \"two\", _ => \"many\" }", "two"},
+		{"match (5) { 1 => \"one\" }", "null"},
+		{"match ([1, 2, 3]) { [x] => x, [x, ...xs] => xs }", "[2, 3]"},
+		{`match ({"k": 1}) { {"k": v} => v + 1, _ => 0 }`, "2"},
+		{"let f = fn(xs) { match (xs) { [] => 0, [x, ...rest] => x + f(rest) } }; f([1, 2, 3])", "6"},
+		{"match ([[1, 2], 3]) { [[a, b], c] => a + b + c }", "6"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryAndThrow(t *testing.T) {
+	tests := []vmTestCase{
+		{"try { throw 1 } catch (e) { e + 1 }", "2"},
+		{"try { 1 / 0 } catch (e) { e.message }", "division by zero"},
+		{"let f = fn() { throw \"x\" }; try { f(); 1 } catch (e) { e }", "x"},
+		{"let f = fn(n) { try { n / 0 } catch (e) { -1 } }; f(1) + f(2)", "-2"},
+		{"try { 1 } catch (e) { 2 }", "1"},
+		{"throw 42", "ERROR: uncaught exception: 42"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSpread(t *testing.T) {
+	tests := []vmTestCase{
+		{"[0, ...[1, 2], 3]", "[0, 1, 2, 3]"},
+		{"let add = fn(a, b, c) { a + b + c }; let xs = [1, 2]; add(...xs, 3)", "6"},
+		{"let f = fn(a, b) { a * b }; f(...[2, 3])", "6"},
+		{"len(...[[1, 2]])", "2"},
+		{"[...1]", "ERROR: cannot spread INTEGER"},
+	}
+
+	runVmTests(t, tests)
+}
+
+// VMのエラーにも、評価器と同じ位置と呼び出しの履歴が付く
+func TestErrorPositions(t *testing.T) {
+	tests := []string{
+		"1 + true",
+		"let f = fn() { 1 + true }; f()",
+		"let g = fn(x) { x / 0 };\nlet f = fn() { g(1) };\nf()",
+		"let f = fn(n) { if (n == 0) { throw \"done\" }; f(n - 1) }; f(3)",
+		"class C() { fn m() { self.x + 1 } }; C().m()",
+		"map([1], fn(x) { x + null })",
+	}
+
+	for _, input := range tests {
+		want := evaluator.Eval(parse(input), object.NewEnvironment()).Inspect()
+		if got := runVM(t, input).Inspect(); got != want {
+			t.Errorf("%q: wrong error.\nwant:\n%s\ngot:\n%s", input, want, got)
+		}
+	}
+}
+
+// エラーになった関数の名前は、評価器と同じくエラーに付けられる
+func TestErrorFunctionName(t *testing.T) {
+	result := runVM(t, "let f = fn() { 1 + true }; f()")
+	err, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error. got=%T (%+v)", result, result)
+	}
+	if err.Function != "f" {
+		t.Errorf("wrong function name. got=%q", err.Function)
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	defer func(max int) { evaluator.MaxCallDepth = max }(evaluator.MaxCallDepth)
+	evaluator.MaxCallDepth = 100
+
+	result := runVM(t, "let f = fn(n) { f(n + 1) }; f(0)")
+	err, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error. got=%T (%+v)", result, result)
+	}
+	if err.Message != "stack overflow: maximum call depth of 100 exceeded" {
+		t.Errorf("wrong error. got=%q", err.Message)
+	}
+}
+
+// REPLのように、前の実行のグローバル変数を引き継いで続きを実行できる
+func TestGlobalsAcrossRuns(t *testing.T) {
+	symbols := compiler.NewSymbolTable()
+	var constants []object.Object
+	var globals []object.Object
+
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1;", ""},
+		{"let add = fn(a) { a + x };", ""},
+		{"x = 10; add(5)", "15"},
+	}
+
+	for _, tt := range inputs {
+		c := compiler.NewWithState(symbols, constants)
+		if err := c.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobals(bytecode, globals)
+		result := machine.Run()
+		globals = machine.Globals()
+
+		if got := inspect(result); got != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEnvironmentOutput(t *testing.T) {
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetOutput(&out)
+
+	c := compiler.New()
+	if err := c.Compile(parse(`puts("hello"); map([1, 2], fn(x) { puts(x) })`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(c.Bytecode())
+	machine.SetEnvironment(env)
+	machine.Run()
+
+	if out.String() != "hello\n1\n2\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		if got := inspect(runVM(t, tt.input)); got != tt.expected {
+			t.Errorf("vm: %q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+		// 評価器でも同じ結果になる。評価器は値のないブロックをnilにするが、VMではnullになる
+		evaluated := evaluator.Eval(parse(tt.input), object.NewEnvironment())
+		if evaluated == nil {
+			evaluated = NULL
+		}
+		if got := inspect(evaluated); got != tt.expected {
+			t.Errorf("evaluator: %q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func runVM(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	c := compiler.New()
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(c.Bytecode()).Run()
+}
+
+// 値がなければ空文字。エラーは位置を除いたメッセージにする。
+func inspect(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil:
+		return ""
+	case *object.Error:
+		return "ERROR: " + obj.Message
+	case *object.String:
+		return obj.Value
+	}
+	return obj.Inspect()
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}